@@ -0,0 +1,19 @@
+CREATE TABLE refresh_tokens (
+    id                CHAR(32)     NOT NULL,
+    family_id         CHAR(32)     NOT NULL,
+    token_hash        CHAR(64)     NOT NULL,
+    subject           VARCHAR(255) NOT NULL,
+    client_id         VARCHAR(255) NOT NULL DEFAULT '',
+    scope             TEXT         NOT NULL,
+    amr               VARCHAR(255) NOT NULL DEFAULT '',
+    auth_time         DATETIME     NOT NULL,
+    status            VARCHAR(16)  NOT NULL,
+    created_at        DATETIME     NOT NULL,
+    expires_at        DATETIME     NOT NULL,
+    family_expires_at DATETIME     NOT NULL,
+    rotated_at        DATETIME     NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_refresh_tokens_token_hash (token_hash),
+    KEY idx_refresh_tokens_family_id (family_id),
+    KEY idx_refresh_tokens_subject (subject)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,7 @@
+CREATE TABLE oauth_clients (
+    id          VARCHAR(255) NOT NULL,
+    secret_hash CHAR(64)     NOT NULL DEFAULT '',
+    name        VARCHAR(255) NOT NULL,
+    created_at  DATETIME     NOT NULL,
+    PRIMARY KEY (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS authorization_codes;
+
+ALTER TABLE oauth_clients
+    DROP COLUMN redirect_uris,
+    DROP COLUMN grant_types,
+    DROP COLUMN scopes;
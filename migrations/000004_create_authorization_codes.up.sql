@@ -0,0 +1,22 @@
+ALTER TABLE oauth_clients
+    ADD COLUMN redirect_uris TEXT NOT NULL AFTER name,
+    ADD COLUMN grant_types   TEXT NOT NULL AFTER redirect_uris,
+    ADD COLUMN scopes        TEXT NOT NULL AFTER grant_types;
+
+CREATE TABLE authorization_codes (
+    code_hash             CHAR(64)     NOT NULL,
+    client_id             VARCHAR(255) NOT NULL,
+    subject               VARCHAR(255) NOT NULL,
+    redirect_uri          TEXT         NOT NULL,
+    scope                 TEXT         NOT NULL,
+    code_challenge        VARCHAR(128) NOT NULL,
+    code_challenge_method VARCHAR(16)  NOT NULL,
+    auth_time             DATETIME     NOT NULL,
+    amr                   VARCHAR(255) NOT NULL DEFAULT '',
+    refresh_family_id     CHAR(32)     NOT NULL DEFAULT '',
+    created_at            DATETIME     NOT NULL,
+    expires_at            DATETIME     NOT NULL,
+    used_at               DATETIME     NULL,
+    PRIMARY KEY (code_hash),
+    KEY idx_authorization_codes_expires_at (expires_at)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
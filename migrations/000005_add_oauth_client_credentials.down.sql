@@ -0,0 +1,5 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN token_endpoint_auth_method,
+    DROP COLUMN jwks,
+    DROP COLUMN audiences,
+    DROP COLUMN access_token_lifetime;
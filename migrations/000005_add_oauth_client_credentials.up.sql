@@ -0,0 +1,5 @@
+ALTER TABLE oauth_clients
+    ADD COLUMN token_endpoint_auth_method VARCHAR(32) NOT NULL DEFAULT '' AFTER scopes,
+    ADD COLUMN jwks                       TEXT        NOT NULL AFTER token_endpoint_auth_method,
+    ADD COLUMN audiences                  TEXT        NOT NULL AFTER jwks,
+    ADD COLUMN access_token_lifetime      BIGINT      NOT NULL DEFAULT 0 AFTER audiences;
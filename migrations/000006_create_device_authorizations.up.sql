@@ -0,0 +1,17 @@
+CREATE TABLE device_authorizations (
+    device_code_hash CHAR(64)     NOT NULL,
+    user_code_hash   CHAR(64)     NOT NULL,
+    client_id        VARCHAR(255) NOT NULL,
+    scope            TEXT         NOT NULL,
+    status           VARCHAR(16)  NOT NULL,
+    subject          VARCHAR(255) NOT NULL DEFAULT '',
+    auth_time        DATETIME     NULL,
+    amr              VARCHAR(255) NOT NULL DEFAULT '',
+    poll_interval    INT          NOT NULL,
+    last_polled_at   DATETIME     NULL,
+    created_at       DATETIME     NOT NULL,
+    expires_at       DATETIME     NOT NULL,
+    PRIMARY KEY (device_code_hash),
+    UNIQUE KEY uniq_device_authorizations_user_code_hash (user_code_hash),
+    KEY idx_device_authorizations_expires_at (expires_at)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,3 @@
+ALTER TABLE authorization_codes
+    ADD COLUMN acr   VARCHAR(255) NOT NULL DEFAULT '' AFTER amr,
+    ADD COLUMN nonce VARCHAR(255) NOT NULL DEFAULT '' AFTER acr;
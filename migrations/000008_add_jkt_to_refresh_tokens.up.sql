@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens
+    ADD COLUMN jkt VARCHAR(64) NOT NULL DEFAULT '' AFTER auth_time;
@@ -0,0 +1,3 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN tls_client_auth_subject_dn,
+    DROP COLUMN tls_client_auth_san_dns;
@@ -0,0 +1,3 @@
+ALTER TABLE oauth_clients
+    ADD COLUMN tls_client_auth_subject_dn VARCHAR(1024) NOT NULL DEFAULT '' AFTER jwks,
+    ADD COLUMN tls_client_auth_san_dns    VARCHAR(255)  NOT NULL DEFAULT '' AFTER tls_client_auth_subject_dn;
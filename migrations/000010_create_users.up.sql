@@ -0,0 +1,27 @@
+CREATE TABLE users (
+    id                  CHAR(32)     NOT NULL,
+    email               VARCHAR(320) NOT NULL,
+    normalized_email    VARCHAR(320) NOT NULL,
+    username            VARCHAR(32)  NOT NULL DEFAULT '',
+    normalized_username VARCHAR(32)  NULL,
+    name                VARCHAR(255) NOT NULL DEFAULT '',
+    status              VARCHAR(16)  NOT NULL,
+    created_at          DATETIME     NOT NULL,
+    updated_at          DATETIME     NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_users_normalized_email (normalized_email),
+    UNIQUE KEY uk_users_normalized_username (normalized_username),
+    KEY idx_users_status_created_at (status, created_at)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE user_credentials (
+    id         CHAR(32)     NOT NULL,
+    user_id    CHAR(32)     NOT NULL,
+    type       VARCHAR(32)  NOT NULL,
+    secret     TEXT         NOT NULL,
+    created_at DATETIME     NOT NULL,
+    updated_at DATETIME     NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_user_credentials_user_id_type (user_id, type),
+    CONSTRAINT fk_user_credentials_user_id FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,9 @@
+CREATE TABLE login_throttles (
+    key_hash        CHAR(64)    NOT NULL,
+    scope           VARCHAR(16) NOT NULL,
+    failures        INT         NOT NULL,
+    last_failure_at DATETIME    NOT NULL,
+    locked_until    DATETIME    NULL,
+    PRIMARY KEY (key_hash),
+    KEY idx_login_throttles_last_failure_at (last_failure_at)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,9 @@
+CREATE TABLE user_password_history (
+    id         CHAR(32) NOT NULL,
+    user_id    CHAR(32) NOT NULL,
+    secret     TEXT     NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    KEY idx_user_password_history_user_id_created_at (user_id, created_at),
+    CONSTRAINT fk_user_password_history_user_id FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
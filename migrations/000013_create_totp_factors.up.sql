@@ -0,0 +1,11 @@
+CREATE TABLE totp_factors (
+    id             CHAR(32)       NOT NULL,
+    user_id        CHAR(32)       NOT NULL,
+    secret         VARBINARY(128) NOT NULL,
+    confirmed_at   DATETIME       NULL,
+    last_used_step BIGINT         NOT NULL DEFAULT 0,
+    created_at     DATETIME       NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_totp_factors_user_id (user_id),
+    CONSTRAINT fk_totp_factors_user_id FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,19 @@
+CREATE TABLE webauthn_credentials (
+    id               CHAR(32)        NOT NULL,
+    user_id          CHAR(32)        NOT NULL,
+    credential_id    VARBINARY(1023) NOT NULL,
+    public_key       BLOB            NOT NULL,
+    sign_count       INT UNSIGNED    NOT NULL DEFAULT 0,
+    aaguid           BINARY(16)      NOT NULL,
+    attestation_type VARCHAR(16)     NOT NULL,
+    discoverable     BOOLEAN         NOT NULL,
+    backup_eligible  BOOLEAN         NOT NULL,
+    backed_up        BOOLEAN         NOT NULL,
+    name             VARCHAR(255)    NOT NULL,
+    created_at       DATETIME        NOT NULL,
+    last_used_at     DATETIME        NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_webauthn_credentials_credential_id (credential_id),
+    KEY idx_webauthn_credentials_user_id (user_id),
+    CONSTRAINT fk_webauthn_credentials_user_id FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,10 @@
+CREATE TABLE recovery_codes (
+    id         CHAR(32) NOT NULL,
+    user_id    CHAR(32) NOT NULL,
+    hash       CHAR(64) NOT NULL,
+    used_at    DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_recovery_codes_user_id_hash (user_id, hash),
+    CONSTRAINT fk_recovery_codes_user_id FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,17 @@
+ALTER TABLE users
+    ADD COLUMN email_verified_at DATETIME NULL AFTER normalized_email;
+
+CREATE TABLE email_verification_tokens (
+    id         CHAR(32)     NOT NULL,
+    user_id    CHAR(32)     NOT NULL,
+    purpose    VARCHAR(16)  NOT NULL,
+    email      VARCHAR(320) NOT NULL,
+    token_hash CHAR(64)     NOT NULL,
+    expires_at DATETIME     NOT NULL,
+    used_at    DATETIME     NULL,
+    created_at DATETIME     NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_email_verification_tokens_token_hash (token_hash),
+    KEY idx_email_verification_tokens_user_id_purpose (user_id, purpose),
+    CONSTRAINT fk_email_verification_tokens_user_id FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,5 @@
+CREATE TABLE revoked_subjects (
+    subject       VARCHAR(255) NOT NULL,
+    issued_before DATETIME     NOT NULL,
+    PRIMARY KEY (subject)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
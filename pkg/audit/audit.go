@@ -0,0 +1,92 @@
+// Package audit records security-relevant events, such as authentication attempts, apart from
+// the operational logs.
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/logger"
+)
+
+// Outcomes of audited events. OutcomeChallenged is a step that succeeded, but requires another
+// one, e.g. a password verified before a second factor.
+const (
+	OutcomeSuccess    = "success"
+	OutcomeFailure    = "failure"
+	OutcomeChallenged = "challenged"
+)
+
+// Event is an audited event.
+type Event struct {
+	// Type names what happened, e.g. login.password.
+	Type    string
+	Outcome string
+	// Reason explains a failure, e.g. invalid_credentials.
+	Reason string
+	// Subject is the user the event is about, when known.
+	Subject string
+	// Login is the identifier the user was looked up with, which may not exist.
+	Login     string
+	IP        string
+	UserAgent string
+	Time      time.Time
+}
+
+// Logger records audited events.
+type Logger interface {
+	// Log records event.
+	Log(ctx context.Context, event Event) error
+}
+
+type logrusLogger struct {
+	logger *logger.Logger
+}
+
+// NewLogrusLogger instantiates a Logger writing events through logger, with an audit field so
+// they can be routed apart from operational logs.
+func NewLogrusLogger(logger *logger.Logger) Logger {
+	return &logrusLogger{logger: logger}
+}
+
+func (l *logrusLogger) Log(_ context.Context, event Event) error {
+	l.logger.WithFields(map[string]interface{}{
+		"audit":       true,
+		"type":        event.Type,
+		"outcome":     event.Outcome,
+		"reason":      event.Reason,
+		"subject":     event.Subject,
+		"login":       event.Login,
+		"ip":          event.IP,
+		"user_agent":  event.UserAgent,
+		"occurred_at": event.Time,
+	}).Info(event.Type)
+	return nil
+}
+
+// MemoryLogger is a Logger keeping events in memory, meant for tests.
+type MemoryLogger struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewMemoryLogger instantiates a MemoryLogger.
+func NewMemoryLogger() *MemoryLogger {
+	return &MemoryLogger{}
+}
+
+// Log records event.
+func (l *MemoryLogger) Log(_ context.Context, event Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	return nil
+}
+
+// Events returns the recorded events, oldest first.
+func (l *MemoryLogger) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event(nil), l.events...)
+}
@@ -0,0 +1,256 @@
+// Package emailverification lets users prove they own their email address, with a single-use
+// link sent to it, when they register and when they change it.
+package emailverification
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/linktoken"
+	"github.com/code-and-chill/auth-api/pkg/mailer"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound indicates a token is not found.
+	ErrNotFound = errors.New("email verification token is not found")
+	// ErrInvalidToken indicates a token which is unknown, expired or used, or whose email is
+	// not the one of its user anymore.
+	ErrInvalidToken = errors.New("email verification token is invalid")
+	// ErrAlreadyVerified indicates the email of the user is verified already.
+	ErrAlreadyVerified = errors.New("email is already verified")
+)
+
+// Purpose tells what confirming a token does.
+type Purpose string
+
+const (
+	// PurposeVerify verifies the current email of the user.
+	PurposeVerify = Purpose("verify")
+	// PurposeChange replaces the email of the user by the email of the token.
+	PurposeChange = Purpose("change")
+)
+
+// Token is an email verification token, sent in a link to Email.
+type Token struct {
+	linktoken.Token
+	Purpose Purpose `db:"purpose"`
+	Email   string  `db:"email"`
+}
+
+// Store persists tokens.
+type Store interface {
+	// Create stores a new token.
+	Create(ctx context.Context, token *Token) error
+
+	// FindByHash finds a token by the hash of its value, or returns ErrNotFound.
+	FindByHash(ctx context.Context, tokenHash string) (*Token, error)
+
+	// Use records the link of token id was confirmed at usedAt. It returns false when it was
+	// confirmed already, so an email is verified or changed once per link even when the link
+	// is opened twice.
+	Use(ctx context.Context, id string, usedAt time.Time) (bool, error)
+
+	// UseByUserID records the pending links of a user for purpose as confirmed at usedAt, so
+	// only the last one sent works and none works once the email is changed.
+	UseByUserID(ctx context.Context, userID string, purpose Purpose, usedAt time.Time) error
+}
+
+// Config provides configs for the Service.
+type Config struct {
+	// TokenLifetime is how long a verification link may be used.
+	TokenLifetime time.Duration
+	// VerifyURL is the page of the front end confirming tokens. Verification links add the
+	// token to its query as the token parameter.
+	VerifyURL string
+}
+
+// Service verifies emails.
+type Service interface {
+	// SendVerification sends a link verifying the email of u to it. Only the last link sent
+	// works. It returns ErrAlreadyVerified when the email is verified already.
+	SendVerification(ctx context.Context, u *user.User) error
+
+	// RequestChange sends a link replacing the email of a user by email to email, once password
+	// is verified, or returns user.ErrInvalidCredentials. The email is only replaced once the
+	// link is confirmed. It returns user.ErrInvalidEmail or user.ErrEmailTaken when email
+	// cannot be used.
+	RequestChange(ctx context.Context, userID, password, email string) error
+
+	// Confirm verifies the email of the token, or replaces the email of its user by it and
+	// notifies the previous address. It returns the updated user, ErrInvalidToken when the token
+	// is unknown, expired or used, and user.ErrEmailTaken when another user registered with the
+	// new email meanwhile.
+	Confirm(ctx context.Context, token string) (*user.User, error)
+}
+
+type service struct {
+	store   Store
+	users   user.Service
+	mailer  mailer.Mailer
+	timegen timegenerator.TimeGenerator
+	config  Config
+}
+
+// NewService instantiates a new Service.
+func NewService(store Store, users user.Service, mailer mailer.Mailer, timegen timegenerator.TimeGenerator,
+	config Config) Service {
+	return &service{
+		store:   store,
+		users:   users,
+		mailer:  mailer,
+		timegen: timegen,
+		config:  config,
+	}
+}
+
+func (s *service) SendVerification(ctx context.Context, u *user.User) error {
+	if u.EmailVerified() {
+		return errors.WithStack(ErrAlreadyVerified)
+	}
+	value, err := s.issue(ctx, u.ID, PurposeVerify, u.Email)
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(s.mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("To verify the email address of your account, open\n\n%s\n\nwithin %s.\n",
+			linktoken.Link(s.config.VerifyURL, value), s.config.TokenLifetime),
+	}))
+}
+
+func (s *service) RequestChange(ctx context.Context, userID, password, email string) error {
+	ok, err := s.users.VerifyPassword(ctx, userID, password)
+	if errors.Is(err, user.ErrNotFound) {
+		return errors.WithStack(user.ErrInvalidCredentials)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !ok {
+		return errors.WithStack(user.ErrInvalidCredentials)
+	}
+	email = strings.TrimSpace(email)
+	if err := s.users.CheckEmail(ctx, email); err != nil {
+		return err
+	}
+	value, err := s.issue(ctx, userID, PurposeChange, email)
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(s.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("To use this email address for your account, open\n\n%s\n\nwithin %s. "+
+			"If it was not you, ignore this email: nothing is changed.\n",
+			linktoken.Link(s.config.VerifyURL, value), s.config.TokenLifetime),
+	}))
+}
+
+// issue stores a new token of userID for purpose and email, and returns its value. Only the
+// last token of a purpose works, so links of older emails found later are useless.
+func (s *service) issue(ctx context.Context, userID string, purpose Purpose, email string) (string, error) {
+	now := s.timegen.Now().UTC()
+	value, token, err := linktoken.New(userID, now, s.config.TokenLifetime)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := s.store.UseByUserID(ctx, userID, purpose, now); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := s.store.Create(ctx, &Token{Token: token, Purpose: purpose, Email: email}); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return value, nil
+}
+
+func (s *service) Confirm(ctx context.Context, value string) (*user.User, error) {
+	token, err := s.store.FindByHash(ctx, securetoken.Hash(value))
+	if errors.Is(err, ErrNotFound) {
+		return nil, errors.WithStack(ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	now := s.timegen.Now().UTC()
+	if !token.Usable(now) {
+		return nil, errors.WithStack(ErrInvalidToken)
+	}
+	u, err := s.users.FindByID(ctx, token.UserID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if u.Status == user.StatusDisabled || u.Status == user.StatusDeleted {
+		return nil, errors.WithStack(ErrInvalidToken)
+	}
+	switch token.Purpose {
+	case PurposeVerify:
+		return s.verify(ctx, token, u, now)
+	case PurposeChange:
+		return s.change(ctx, token, u, now)
+	}
+	return nil, errors.WithStack(ErrInvalidToken)
+}
+
+// verify verifies the email of u with token.
+func (s *service) verify(ctx context.Context, token *Token, u *user.User, now time.Time) (*user.User, error) {
+	if u.NormalizedEmail != user.NormalizeEmail(token.Email) {
+		return nil, errors.WithStack(ErrInvalidToken)
+	}
+	if err := s.use(ctx, token, now); err != nil {
+		return nil, err
+	}
+	verified, err := s.users.VerifyEmail(ctx, u.ID, token.Email)
+	if errors.Is(err, user.ErrEmailChanged) {
+		return nil, errors.WithStack(ErrInvalidToken)
+	}
+	return verified, errors.WithStack(err)
+}
+
+// change replaces the email of u by the email of token, and notifies the previous address.
+func (s *service) change(ctx context.Context, token *Token, u *user.User, now time.Time) (*user.User, error) {
+	// The new email is checked before the token is used, so the link still works once the
+	// conflicting user is gone.
+	if err := s.users.CheckEmail(ctx, token.Email); err != nil {
+		return nil, err
+	}
+	if err := s.use(ctx, token, now); err != nil {
+		return nil, err
+	}
+	changed, err := s.users.ChangeEmail(ctx, u.ID, token.Email)
+	if err != nil {
+		return nil, err
+	}
+	// Links sent to the previous email are useless now, and so are other pending changes.
+	if err := s.store.UseByUserID(ctx, u.ID, PurposeVerify, now); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := s.store.UseByUserID(ctx, u.ID, PurposeChange, now); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("The email address of your account was changed to %s, which will receive its emails "+
+			"from now on.\nIf it was not you, contact support right away.\n", changed.Email),
+	})
+	return changed, errors.WithStack(err)
+}
+
+// use records token was used at now, or returns ErrInvalidToken when it was used already.
+func (s *service) use(ctx context.Context, token *Token, now time.Time) error {
+	used, err := s.store.Use(ctx, token.ID, now)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !used {
+		return errors.WithStack(ErrInvalidToken)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package emailverification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/linktoken/linktokentest"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/mailer"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+	"github.com/code-and-chill/auth-api/pkg/user"
+)
+
+const testPassword = "correct horse battery staple"
+
+// plainHasher stores passwords as they are, since hashing is not under test.
+type plainHasher struct{}
+
+func (plainHasher) Hash(password string) (string, error) {
+	return password, nil
+}
+
+func (plainHasher) Verify(password, encoded string) (bool, error) {
+	return password == encoded, nil
+}
+
+func (plainHasher) NeedsRehash(string) bool {
+	return false
+}
+
+type fixture struct {
+	verifications Service
+	users         user.Service
+	mailer        *mailer.MemoryMailer
+	timegen       *timegenerator.FakeTimeGenerator
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	users := user.NewService(user.NewMemoryStore(), transaction.NewNoopProvider(), plainHasher{}, timegen)
+	mail := mailer.NewMemoryMailer()
+	verifications := NewService(NewMemoryStore(), users, mail, timegen, Config{
+		TokenLifetime: 24 * time.Hour,
+		VerifyURL:     "https://example.com/verify-email",
+	})
+	return &fixture{verifications: verifications, users: users, mailer: mail, timegen: timegen}
+}
+
+func (f *fixture) register(t *testing.T, email string) *user.User {
+	t.Helper()
+	u, err := f.users.Register(context.Background(), user.Registration{Email: email, Password: testPassword})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	return u
+}
+
+// lastToken returns the token of the link of the last message, which must be sent to to.
+func (f *fixture) lastToken(t *testing.T, to string) string {
+	t.Helper()
+	return linktokentest.LastToken(t, f.mailer, to, "https://example.com/verify-email")
+}
+
+func TestService_SendVerification(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Verifies the email with the last link", func(t *testing.T) {
+		f := newFixture(t)
+		jane := f.register(t, "jane@example.com")
+		if err := f.verifications.SendVerification(ctx, jane); err != nil {
+			t.Fatalf("SendVerification() error = %v", err)
+		}
+		first := f.lastToken(t, "jane@example.com")
+		if err := f.verifications.SendVerification(ctx, jane); err != nil {
+			t.Fatalf("SendVerification() error = %v", err)
+		}
+		last := f.lastToken(t, "jane@example.com")
+		if _, err := f.verifications.Confirm(ctx, first); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Confirm() error = %v, want the first link rejected", err)
+		}
+		verified, err := f.verifications.Confirm(ctx, last)
+		if err != nil || !verified.EmailVerified() {
+			t.Fatalf("Confirm() = %+v, %v, want the email verified", verified, err)
+		}
+		if _, err := f.verifications.Confirm(ctx, last); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Confirm() error = %v, want a used token rejected", err)
+		}
+		if err := f.verifications.SendVerification(ctx, verified); !errors.Is(err, ErrAlreadyVerified) {
+			t.Errorf("SendVerification() error = %v, want ErrAlreadyVerified", err)
+		}
+	})
+
+	t.Run("Rejects an expired token", func(t *testing.T) {
+		f := newFixture(t)
+		jane := f.register(t, "jane@example.com")
+		if err := f.verifications.SendVerification(ctx, jane); err != nil {
+			t.Fatalf("SendVerification() error = %v", err)
+		}
+		f.timegen.Add(24 * time.Hour)
+		if _, err := f.verifications.Confirm(ctx, f.lastToken(t, "jane@example.com")); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Confirm() error = %v, want ErrInvalidToken", err)
+		}
+	})
+}
+
+func TestService_RequestChange(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Replaces the email once the new one is verified", func(t *testing.T) {
+		f := newFixture(t)
+		jane := f.register(t, "jane@example.com")
+		if err := f.verifications.SendVerification(ctx, jane); err != nil {
+			t.Fatalf("SendVerification() error = %v", err)
+		}
+		previous := f.lastToken(t, "jane@example.com")
+		if err := f.verifications.RequestChange(ctx, jane.ID, testPassword, "jane@example.org"); err != nil {
+			t.Fatalf("RequestChange() error = %v", err)
+		}
+		token := f.lastToken(t, "jane@example.org")
+		if found, _ := f.users.FindByID(ctx, jane.ID); found.Email != "jane@example.com" {
+			t.Fatalf("email = %s, want it unchanged before the confirmation", found.Email)
+		}
+
+		changed, err := f.verifications.Confirm(ctx, token)
+		if err != nil {
+			t.Fatalf("Confirm() error = %v", err)
+		}
+		if changed.Email != "jane@example.org" || !changed.EmailVerified() {
+			t.Errorf("Confirm() = %+v, want the new email verified", changed)
+		}
+		messages := f.mailer.Messages()
+		if last := messages[len(messages)-1]; last.To != "jane@example.com" || !strings.Contains(last.Body, "jane@example.org") {
+			t.Errorf("message = %+v, want the previous email notified", last)
+		}
+		if _, err := f.verifications.Confirm(ctx, previous); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Confirm() error = %v, want the link of the previous email rejected", err)
+		}
+	})
+
+	t.Run("Rejects a wrong password and unusable emails", func(t *testing.T) {
+		f := newFixture(t)
+		jane := f.register(t, "jane@example.com")
+		f.register(t, "john@example.com")
+		tests := []struct {
+			password string
+			email    string
+			wantErr  error
+		}{
+			{"wrong", "jane@example.org", user.ErrInvalidCredentials},
+			{testPassword, "jane", user.ErrInvalidEmail},
+			{testPassword, "John@Example.com", user.ErrEmailTaken},
+		}
+		for _, tt := range tests {
+			if err := f.verifications.RequestChange(ctx, jane.ID, tt.password, tt.email); !errors.Is(err, tt.wantErr) {
+				t.Errorf("RequestChange(%s) error = %v, want %v", tt.email, err, tt.wantErr)
+			}
+		}
+		if messages := f.mailer.Messages(); len(messages) != 0 {
+			t.Errorf("messages = %+v, want none", messages)
+		}
+	})
+
+	t.Run("Keeps the token of an email taken meanwhile", func(t *testing.T) {
+		f := newFixture(t)
+		jane := f.register(t, "jane@example.com")
+		if err := f.verifications.RequestChange(ctx, jane.ID, testPassword, "shared@example.com"); err != nil {
+			t.Fatalf("RequestChange() error = %v", err)
+		}
+		token := f.lastToken(t, "shared@example.com")
+		f.register(t, "shared@example.com")
+		if _, err := f.verifications.Confirm(ctx, token); !errors.Is(err, user.ErrEmailTaken) {
+			t.Errorf("Confirm() error = %v, want ErrEmailTaken", err)
+		}
+	})
+}
+
+func TestRegistrationHandler(t *testing.T) {
+	f := newFixture(t)
+	handler := user.NewRegistrationHandler(f.users, logger.NewNoopLogger(), user.WithEmailVerification(f.verifications))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/users",
+		strings.NewReader(`{"email":"jane@example.com","password":"`+testPassword+`"}`)))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	NewConfirmationHandler(f.verifications, logger.NewNoopLogger()).ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodPost, "/email/verify",
+			strings.NewReader(`{"token":"`+f.lastToken(t, "jane@example.com")+`"}`)))
+	var body map[string]interface{}
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil || recorder.Code != http.StatusOK ||
+		body["email_verified_at"] == nil {
+		t.Errorf("status = %d, body = %v, want the verified user", recorder.Code, body)
+	}
+}
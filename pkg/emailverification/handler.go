@@ -0,0 +1,153 @@
+package emailverification
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/oauth"
+	"github.com/code-and-chill/auth-api/pkg/user"
+)
+
+// Error codes of the email verification endpoints.
+const (
+	ErrorCodeInvalidRequest  = "invalid_request"
+	ErrorCodeInvalidToken    = "invalid_token"
+	ErrorCodeInvalidEmail    = "invalid_email"
+	ErrorCodeEmailTaken      = "email_taken"
+	ErrorCodeInvalidPassword = "invalid_password"
+	ErrorCodeAlreadyVerified = "already_verified"
+	ErrorCodeServerError     = "server_error"
+)
+
+// Error is an error response of the email verification endpoints.
+type Error = httperror.Error
+
+// serviceErrors maps the errors of the Service to their responses.
+var serviceErrors = []httperror.Sentinel{
+	{Err: ErrInvalidToken, Response: httperror.New(http.StatusBadRequest, ErrorCodeInvalidToken, "token is invalid, expired or used")},
+	{Err: ErrAlreadyVerified, Response: httperror.New(http.StatusConflict, ErrorCodeAlreadyVerified, "email is already verified")},
+	{Err: user.ErrInvalidEmail, Response: httperror.New(http.StatusBadRequest, ErrorCodeInvalidEmail, "email is invalid")},
+	{Err: user.ErrEmailTaken, Response: httperror.New(http.StatusConflict, ErrorCodeEmailTaken, "email is already registered")},
+	{Err: user.ErrInvalidCredentials, Response: httperror.New(http.StatusForbidden, ErrorCodeInvalidPassword, "password is invalid")},
+}
+
+func writeError(w http.ResponseWriter, err error, log *logger.Logger) {
+	httperror.Write(w, err, log, serviceErrors...)
+}
+
+type resendHandler struct {
+	verifications Service
+	users         user.Service
+	accessTokens  oauth.AccessTokenVerifier
+	logger        *logger.Logger
+}
+
+// NewResendHandler instantiates the resend endpoint, which sends another verification link to
+// the email of the user authenticated by the access token of the request, and responds 202.
+func NewResendHandler(verifications Service, users user.Service, accessTokens oauth.AccessTokenVerifier,
+	logger *logger.Logger) http.Handler {
+	return &resendHandler{verifications: verifications, users: users, accessTokens: accessTokens, logger: logger}
+}
+
+func (h *resendHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	claims, err := h.accessTokens.Verify(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	u, err := h.users.FindByID(r.Context(), claims.Subject)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if err := h.verifications.SendVerification(r.Context(), u); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ChangeRequest is the JSON body of the change endpoint.
+type ChangeRequest struct {
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+type changeHandler struct {
+	verifications Service
+	accessTokens  oauth.AccessTokenVerifier
+	logger        *logger.Logger
+}
+
+// NewChangeHandler instantiates the change endpoint, which sends a link replacing the email of
+// the user authenticated by the access token of the request by the email of a ChangeRequest,
+// once its password is verified. It responds 202; the email is replaced once the link is
+// confirmed.
+func NewChangeHandler(verifications Service, accessTokens oauth.AccessTokenVerifier, logger *logger.Logger) http.Handler {
+	return &changeHandler{verifications: verifications, accessTokens: accessTokens, logger: logger}
+}
+
+func (h *changeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	claims, err := h.accessTokens.Verify(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	var request ChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Password == "" || request.Email == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "password and email are required"), h.logger)
+		return
+	}
+	if err := h.verifications.RequestChange(r.Context(), claims.Subject, request.Password, request.Email); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmationRequest is the JSON body of the confirmation endpoint.
+type ConfirmationRequest struct {
+	Token string `json:"token"`
+}
+
+type confirmationHandler struct {
+	verifications Service
+	logger        *logger.Logger
+}
+
+// NewConfirmationHandler instantiates the confirmation endpoint, which confirms the token of a
+// ConfirmationRequest, verifying or changing the email of its user, and responds with the
+// updated user.User. Tokens already issued keep their email_verified claim until refreshed.
+func NewConfirmationHandler(verifications Service, logger *logger.Logger) http.Handler {
+	return &confirmationHandler{verifications: verifications, logger: logger}
+}
+
+func (h *confirmationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	var request ConfirmationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "token is required"), h.logger)
+		return
+	}
+	u, err := h.verifications.Confirm(r.Context(), request.Token)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, u)
+}
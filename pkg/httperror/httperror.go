@@ -0,0 +1,90 @@
+// Package httperror writes the JSON error responses shared by the HTTP endpoints, so they
+// all respond the same way: an error code, an optional description and response headers.
+package httperror
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// ErrorCodeServerError is the error code of errors which are not reported to clients.
+const ErrorCodeServerError = "server_error"
+
+// Error is an error response, written as JSON with Status.
+type Error struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+
+	// header holds response headers written with the error, e.g. a WWW-Authenticate challenge.
+	header http.Header
+}
+
+// New instantiates an Error.
+func New(status int, code, description string) *Error {
+	return &Error{Status: status, Code: code, Description: description}
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// Header returns the response headers written with this Error.
+func (e *Error) Header() http.Header {
+	return e.header
+}
+
+// WithHeader sets a response header written with this Error.
+func (e *Error) WithHeader(name, value string) *Error {
+	if e.header == nil {
+		e.header = http.Header{}
+	}
+	e.header.Set(name, value)
+	return e
+}
+
+// Sentinel maps a sentinel error to its response.
+type Sentinel struct {
+	Err      error
+	Response *Error
+}
+
+// WriteJSON writes body as a JSON response which must not be cached.
+func WriteJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// Write writes err as an error response: an *Error as is, and an error matching the Err of one
+// of sentinels with its Response. Other errors are logged and reported as server_error without
+// details.
+func Write(w http.ResponseWriter, err error, log *logger.Logger, sentinels ...Sentinel) {
+	var response *Error
+	if !errors.As(err, &response) {
+		for _, sentinel := range sentinels {
+			if errors.Is(err, sentinel.Err) {
+				response = sentinel.Response
+				break
+			}
+		}
+	}
+	if response == nil {
+		log.WithField("err", err).Error()
+		response = New(http.StatusInternalServerError, ErrorCodeServerError, "")
+	}
+	for name, values := range response.header {
+		w.Header()[name] = values
+	}
+	WriteJSON(w, response.Status, response)
+}
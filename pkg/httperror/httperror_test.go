@@ -0,0 +1,65 @@
+package httperror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+func TestWrite(t *testing.T) {
+	errNotFound := errors.New("not found")
+	sentinels := []Sentinel{{Err: errNotFound, Response: New(http.StatusNotFound, "not_found", "thing is not found")}}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantHeader http.Header
+	}{
+		{
+			name:       "Writes an Error with its headers",
+			err:        errors.WithStack(New(http.StatusTooManyRequests, "slow_down", "").WithHeader("Retry-After", "30")),
+			wantStatus: http.StatusTooManyRequests,
+			wantCode:   "slow_down",
+			wantHeader: http.Header{"Retry-After": {"30"}},
+		},
+		{
+			name:       "Maps a sentinel error to its response",
+			err:        errors.WithStack(errNotFound),
+			wantStatus: http.StatusNotFound,
+			wantCode:   "not_found",
+		},
+		{
+			name:       "Hides other errors",
+			err:        errors.New("connection refused"),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   ErrorCodeServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			Write(recorder, tt.err, logger.NewNoopLogger(), sentinels...)
+			var body map[string]interface{}
+			if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+				t.Fatalf("json.Decode() error = %v", err)
+			}
+			if recorder.Code != tt.wantStatus || body["error"] != tt.wantCode {
+				t.Errorf("response = %d %v, want %d %s", recorder.Code, body, tt.wantStatus, tt.wantCode)
+			}
+			if recorder.Header().Get("Cache-Control") != "no-store" || recorder.Header().Get("Pragma") != "no-cache" {
+				t.Errorf("headers = %v, want the response not cached", recorder.Header())
+			}
+			for name := range tt.wantHeader {
+				if got := recorder.Header().Get(name); got != tt.wantHeader.Get(name) {
+					t.Errorf("%s = %q, want %q", name, got, tt.wantHeader.Get(name))
+				}
+			}
+		})
+	}
+}
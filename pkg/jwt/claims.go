@@ -0,0 +1,234 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Audience is the aud claim. It is encoded as a single string when it holds one value and as an
+// array otherwise, and accepts both forms when decoded.
+type Audience []string
+
+// Contains checks whether audience is one of the values of this Audience.
+func (a Audience) Contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON encodes this Audience.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON decodes either a string or an array of strings.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.WithStack(err)
+	}
+	*a = multiple
+	return nil
+}
+
+// Actor is the act claim defined by RFC 8693. It identifies the party acting on behalf of the
+// subject, and nests the previous actors of a delegation chain.
+type Actor struct {
+	Subject string `json:"sub"`
+	Issuer  string `json:"iss,omitempty"`
+	Actor   *Actor `json:"act,omitempty"`
+}
+
+// Depth returns the number of actors in the delegation chain starting at this Actor.
+func (a *Actor) Depth() int {
+	depth := 0
+	for actor := a; actor != nil; actor = actor.Actor {
+		depth++
+	}
+	return depth
+}
+
+// Confirmation is the cnf claim defined by RFC 7800, which binds a token to a key held by its
+// presenter. JWKThumbprint is the RFC 7638 thumbprint of a DPoP key, as defined by RFC 9449, and
+// X509Thumbprint the SHA-256 thumbprint of a TLS client certificate, as defined by RFC 8705.
+type Confirmation struct {
+	JWKThumbprint  string `json:"jkt,omitempty"`
+	X509Thumbprint string `json:"x5t#S256,omitempty"`
+}
+
+// ClaimsProvider provides the claims about a subject added to the tokens issued to it, e.g.
+// whether its email is verified, so tokens reflect its current state.
+type ClaimsProvider interface {
+	// SubjectClaims returns the claims about subject, or none when it is not known.
+	SubjectClaims(ctx context.Context, subject string) (map[string]interface{}, error)
+}
+
+// Claims represents the claims of a token.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	AuthTime  int64    `json:"auth_time,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Tenant    string   `json:"tenant,omitempty"`
+	AMR       []string `json:"amr,omitempty"`
+	ACR       string   `json:"acr,omitempty"`
+	Act       *Actor   `json:"act,omitempty"`
+
+	// Confirmation binds sender-constrained tokens to the key of their holder.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+
+	// Nonce, AuthorizedParty, AccessTokenHash and CodeHash are the claims of OpenID Connect ID tokens.
+	Nonce           string `json:"nonce,omitempty"`
+	AuthorizedParty string `json:"azp,omitempty"`
+	AccessTokenHash string `json:"at_hash,omitempty"`
+	CodeHash        string `json:"c_hash,omitempty"`
+
+	// Extra holds custom claims. Extra claims never override the claims above.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// rawClaims is used to encode and decode Claims without recursing into its JSON methods.
+type rawClaims Claims
+
+// Valid implements jwt.Claims. Claims are validated by a ValidationPolicy instead.
+func (c *Claims) Valid() error {
+	return nil
+}
+
+// Scopes returns the space delimited scope claim as a slice.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope checks whether the scope claim contains scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, value := range c.Scopes() {
+		if value == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Has checks whether the claim named name is present.
+func (c *Claims) Has(name string) bool {
+	switch name {
+	case "iss":
+		return c.Issuer != ""
+	case "sub":
+		return c.Subject != ""
+	case "aud":
+		return len(c.Audience) > 0
+	case "exp":
+		return c.ExpiresAt != 0
+	case "nbf":
+		return c.NotBefore != 0
+	case "iat":
+		return c.IssuedAt != 0
+	case "auth_time":
+		return c.AuthTime != 0
+	case "jti":
+		return c.ID != ""
+	case "scope":
+		return c.Scope != ""
+	case "roles":
+		return len(c.Roles) > 0
+	case "tenant":
+		return c.Tenant != ""
+	case "amr":
+		return len(c.AMR) > 0
+	case "acr":
+		return c.ACR != ""
+	case "act":
+		return c.Act != nil
+	case "cnf":
+		return c.Confirmation != nil
+	case "nonce":
+		return c.Nonce != ""
+	case "azp":
+		return c.AuthorizedParty != ""
+	case "at_hash":
+		return c.AccessTokenHash != ""
+	case "c_hash":
+		return c.CodeHash != ""
+	}
+	_, ok := c.Extra[name]
+	return ok
+}
+
+// MarshalJSON encodes these claims, flattening Extra into the top level object.
+func (c Claims) MarshalJSON() ([]byte, error) {
+	registered, err := json.Marshal(rawClaims(c))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(c.Extra) == 0 {
+		return registered, nil
+	}
+	merged := make(map[string]interface{}, len(c.Extra))
+	for key, value := range c.Extra {
+		merged[key] = value
+	}
+	if err := json.Unmarshal(registered, &merged); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON decodes these claims, collecting unknown claims into Extra.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	var decoded rawClaims
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return errors.WithStack(err)
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, name := range registeredClaimNames {
+		delete(all, name)
+	}
+	if len(all) > 0 {
+		decoded.Extra = all
+	}
+	*c = Claims(decoded)
+	return nil
+}
+
+var registeredClaimNames = []string{
+	"iss", "sub", "aud", "exp", "nbf", "iat", "auth_time", "jti", "scope", "roles", "tenant", "amr", "acr", "act",
+	"cnf", "nonce", "azp", "at_hash", "c_hash",
+}
+
+// NewClaimsFromMap converts a map payload into Claims.
+func NewClaimsFromMap(payload map[string]interface{}) (*Claims, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var claims Claims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &claims, nil
+}
@@ -0,0 +1,74 @@
+package jwt_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+)
+
+func TestClaims_JSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		json   string
+		claims jwt.Claims
+	}{
+		{
+			name:   "Single audience is a string",
+			json:   `{"sub":"user-1","aud":"api"}`,
+			claims: jwt.Claims{Subject: "user-1", Audience: jwt.Audience{"api"}},
+		},
+		{
+			name:   "Multiple audiences are an array",
+			json:   `{"sub":"user-1","aud":["api","web"]}`,
+			claims: jwt.Claims{Subject: "user-1", Audience: jwt.Audience{"api", "web"}},
+		},
+		{
+			name: "Custom claims are flattened into Extra",
+			json: `{"amr":["pwd","otp"],"department":"finance","roles":["admin"],"sub":"user-1"}`,
+			claims: jwt.Claims{
+				Subject: "user-1",
+				Roles:   []string{"admin"},
+				AMR:     []string{"pwd", "otp"},
+				Extra:   map[string]interface{}{"department": "finance"},
+			},
+		},
+		{
+			name: "Delegation chains nest act claims",
+			json: `{"sub":"user-1","act":{"sub":"service-b","act":{"sub":"service-a"}}}`,
+			claims: jwt.Claims{
+				Subject: "user-1",
+				Act:     &jwt.Actor{Subject: "service-b", Actor: &jwt.Actor{Subject: "service-a"}},
+			},
+		},
+		{
+			name:   "Certificate-bound tokens carry a cnf claim",
+			json:   `{"sub":"client-1","cnf":{"x5t#S256":"bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"}}`,
+			claims: jwt.Claims{Subject: "client-1", Confirmation: &jwt.Confirmation{X509Thumbprint: "bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"}},
+		},
+		{
+			name:   "DPoP-bound tokens carry a cnf claim",
+			json:   `{"sub":"user-1","cnf":{"jkt":"0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"}}`,
+			claims: jwt.Claims{Subject: "user-1", Confirmation: &jwt.Confirmation{JWKThumbprint: "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.claims)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(got) != tt.json {
+				t.Errorf("json.Marshal() = %s, want %s", got, tt.json)
+			}
+			var decoded jwt.Claims
+			if err := json.Unmarshal([]byte(tt.json), &decoded); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.claims) {
+				t.Errorf("json.Unmarshal() = %+v, want %+v", decoded, tt.claims)
+			}
+		})
+	}
+}
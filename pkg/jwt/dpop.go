@@ -0,0 +1,147 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// DPoPProofType is the typ header of DPoP proofs.
+const DPoPProofType = "dpop+jwt"
+
+// DPoPProof holds the claims of a DPoP proof JWT as defined by RFC 9449.
+type DPoPProof struct {
+	ID              string `json:"jti"`
+	Method          string `json:"htm"`
+	URI             string `json:"htu"`
+	IssuedAt        int64  `json:"iat"`
+	AccessTokenHash string `json:"ath,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+
+	// Key is the public key the proof is signed with, taken from its jwk header.
+	Key JWK `json:"-"`
+}
+
+// ParseDPoPProof verifies a DPoP proof with the public key of its jwk header. The claims are
+// only checked for presence: binding them to the request is up to the caller.
+func ParseDPoPProof(proof string) (*DPoPProof, error) {
+	var key JWK
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(proof, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, newTokenError(ErrBadSignature, "invalid signing method [%v]", token.Header["alg"])
+		}
+		if typ, _ := token.Header["typ"].(string); typ != DPoPProofType {
+			return nil, newTokenError(ErrMalformed, "invalid proof type [%v]", token.Header["typ"])
+		}
+		var err error
+		if key, err = headerKey(token.Header["jwk"]); err != nil {
+			return nil, wrapTokenError(ErrMalformed, err)
+		}
+		publicKey, err := key.PublicKey()
+		if err != nil {
+			return nil, wrapTokenError(ErrMalformed, err)
+		}
+		return publicKey, nil
+	})
+	if err != nil {
+		return nil, classifyParseError(err)
+	}
+	data, err := json.Marshal(token.Claims)
+	if err != nil {
+		return nil, wrapTokenError(ErrMalformed, err)
+	}
+	var claims DPoPProof
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, wrapTokenError(ErrMalformed, err)
+	}
+	if claims.ID == "" || claims.Method == "" || claims.URI == "" || claims.IssuedAt == 0 {
+		return nil, newTokenError(ErrInvalidClaims, "missing required proof claims")
+	}
+	claims.Key = key
+	return &claims, nil
+}
+
+// headerKey decodes the jwk header of a proof, which must be a public key.
+func headerKey(header interface{}) (JWK, error) {
+	members, ok := header.(map[string]interface{})
+	if !ok {
+		return JWK{}, errors.New("missing jwk header")
+	}
+	if _, ok := members["d"]; ok {
+		return JWK{}, errors.New("jwk header holds a private key")
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return JWK{}, errors.WithStack(err)
+	}
+	var key JWK
+	if err := json.Unmarshal(data, &key); err != nil {
+		return JWK{}, errors.WithStack(err)
+	}
+	return key, nil
+}
+
+// NewDPoPProof signs a proof for a request to method and uri with key, which must be an ECDSA
+// or RSA private key. accessToken is hashed into ath when set, and nonce is the last nonce
+// provided by the server, if any.
+func NewDPoPProof(key crypto.Signer, method, uri, accessToken, nonce string, now time.Time) (string, error) {
+	var signingMethod jwt.SigningMethod
+	var publicKey JWK
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			signingMethod = jwt.SigningMethodES256
+		case 384:
+			signingMethod = jwt.SigningMethodES384
+		case 521:
+			signingMethod = jwt.SigningMethodES512
+		default:
+			return "", errors.Errorf("unsupported curve [%s]", k.Curve.Params().Name)
+		}
+		publicKey = NewECJWK("", &k.PublicKey)
+	case *rsa.PrivateKey:
+		signingMethod = jwt.SigningMethodRS256
+		publicKey = NewRSAJWK("", &k.PublicKey)
+		publicKey.Use, publicKey.Algorithm = "", ""
+	default:
+		return "", errors.Errorf("unsupported key type [%T]", key)
+	}
+	id, err := securetoken.NewID()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	claims := jwt.MapClaims{"jti": id, "htm": method, "htu": uri, "iat": now.Unix()}
+	if accessToken != "" {
+		claims["ath"] = DPoPAccessTokenHash(accessToken)
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	token := jwt.NewWithClaims(signingMethod, claims)
+	token.Header["typ"] = DPoPProofType
+	token.Header["jwk"] = publicKey
+	proof, err := token.SignedString(key)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return proof, nil
+}
+
+// DPoPAccessTokenHash computes the ath claim binding a proof to accessToken: the base64url
+// encoded SHA-256 hash of the token.
+func DPoPAccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,173 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+func TestJWK_Thumbprint(t *testing.T) {
+	// The example key of RFC 7638 section 3.1.
+	key := jwt.JWK{
+		KeyType:   "RSA",
+		KeyID:     "2011-04-29",
+		Algorithm: "RS256",
+		N:         "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:         "AQAB",
+	}
+	got, err := key.Thumbprint()
+	if err != nil {
+		t.Fatalf("Thumbprint() error = %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint() = %s, want %s", got, want)
+	}
+}
+
+func TestJWK_EC(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	jwk := jwt.NewECJWK("", &key.PublicKey)
+	publicKey, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey() error = %v", err)
+	}
+	if !key.PublicKey.Equal(publicKey) {
+		t.Errorf("PublicKey() = %v, want %v", publicKey, key.PublicKey)
+	}
+
+	jwk.Y = jwt.NewECJWK("", &ecdsa.PublicKey{Curve: elliptic.P256(), X: key.X, Y: big.NewInt(1)}).Y
+	if _, err := jwk.PublicKey(); err == nil {
+		t.Error("PublicKey() error = nil, want an error for a point off the curve")
+	}
+}
+
+func TestParseDPoPProof(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	rsaKey, _ := generateKey(t)
+
+	for name, key := range map[string]crypto.Signer{"ES256": ecKey, "RS256": rsaKey} {
+		t.Run("Verifies a "+name+" proof", func(t *testing.T) {
+			proof, err := jwt.NewDPoPProof(key, "POST", "https://auth.example.com/token", "access-token", "nonce-1", now)
+			if err != nil {
+				t.Fatalf("NewDPoPProof() error = %v", err)
+			}
+			claims, err := jwt.ParseDPoPProof(proof)
+			if err != nil {
+				t.Fatalf("ParseDPoPProof() error = %v", err)
+			}
+			if claims.Method != "POST" || claims.URI != "https://auth.example.com/token" || claims.IssuedAt != now.Unix() ||
+				claims.ID == "" || claims.Nonce != "nonce-1" || claims.AccessTokenHash != jwt.DPoPAccessTokenHash("access-token") {
+				t.Errorf("ParseDPoPProof() = %+v", claims)
+			}
+			publicKey, err := claims.Key.PublicKey()
+			if err != nil {
+				t.Fatalf("PublicKey() error = %v", err)
+			}
+			if !publicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+				t.Errorf("Key = %+v, want the signing key", claims.Key)
+			}
+		})
+	}
+
+	sign := func(t *testing.T, header map[string]interface{}, claims jwtgo.MapClaims) string {
+		t.Helper()
+		token := jwtgo.NewWithClaims(jwtgo.SigningMethodES256, claims)
+		for name, value := range header {
+			token.Header[name] = value
+		}
+		proof, err := token.SignedString(ecKey)
+		if err != nil {
+			t.Fatalf("SignedString() error = %v", err)
+		}
+		return proof
+	}
+	publicJWK := map[string]interface{}{"kty": "EC", "crv": "P-256",
+		"x": jwt.NewECJWK("", &ecKey.PublicKey).X, "y": jwt.NewECJWK("", &ecKey.PublicKey).Y}
+	privateJWK := map[string]interface{}{"d": "private"}
+	for name, value := range publicJWK {
+		privateJWK[name] = value
+	}
+	validClaims := jwtgo.MapClaims{"jti": "id-1", "htm": "GET", "htu": "https://api.example.com", "iat": now.Unix()}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		proof func(t *testing.T) string
+		want  error
+	}{
+		{
+			name: "Rejects another typ",
+			proof: func(t *testing.T) string {
+				return sign(t, map[string]interface{}{"typ": "JWT", "jwk": publicJWK}, validClaims)
+			},
+			want: jwt.ErrMalformed,
+		},
+		{
+			name: "Rejects a private jwk",
+			proof: func(t *testing.T) string {
+				return sign(t, map[string]interface{}{"typ": "dpop+jwt", "jwk": privateJWK}, validClaims)
+			},
+			want: jwt.ErrMalformed,
+		},
+		{
+			name:  "Rejects a missing jwk",
+			proof: func(t *testing.T) string { return sign(t, map[string]interface{}{"typ": "dpop+jwt"}, validClaims) },
+			want:  jwt.ErrMalformed,
+		},
+		{
+			name: "Rejects missing claims",
+			proof: func(t *testing.T) string {
+				return sign(t, map[string]interface{}{"typ": "dpop+jwt", "jwk": publicJWK}, jwtgo.MapClaims{"htm": "GET"})
+			},
+			want: jwt.ErrInvalidClaims,
+		},
+		{
+			name: "Rejects a proof signed by another key than its jwk",
+			proof: func(t *testing.T) string {
+				otherJWK := jwt.NewECJWK("", &otherKey.PublicKey)
+				return sign(t, map[string]interface{}{"typ": "dpop+jwt", "jwk": otherJWK}, validClaims)
+			},
+			want: jwt.ErrBadSignature,
+		},
+		{
+			name: "Rejects symmetric algorithms",
+			proof: func(t *testing.T) string {
+				token := jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, validClaims)
+				token.Header["typ"] = "dpop+jwt"
+				token.Header["jwk"] = publicJWK
+				proof, err := token.SignedString([]byte("secret"))
+				if err != nil {
+					t.Fatalf("SignedString() error = %v", err)
+				}
+				return proof
+			},
+			want: jwt.ErrBadSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jwt.ParseDPoPProof(tt.proof(t))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ParseDPoPProof() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package jwt
+
+import (
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrExpired indicates the token has expired or was issued too long ago.
+	ErrExpired = errors.New("token is expired")
+	// ErrNotYetValid indicates the token is used before its nbf or iat.
+	ErrNotYetValid = errors.New("token is not valid yet")
+	// ErrBadSignature indicates the token signature or signing method is invalid.
+	ErrBadSignature = errors.New("token signature is invalid")
+	// ErrUnknownKID indicates no verification key matches the token kid.
+	ErrUnknownKID = errors.New("token key id is unknown")
+	// ErrInvalidIssuer indicates the token issuer is not accepted.
+	ErrInvalidIssuer = errors.New("token issuer is invalid")
+	// ErrInvalidAudience indicates none of the token audiences is accepted.
+	ErrInvalidAudience = errors.New("token audience is invalid")
+	// ErrInvalidClaims indicates the token misses required claims or was rejected by a validator.
+	ErrInvalidClaims = errors.New("token claims are invalid")
+	// ErrMalformed indicates the token cannot be decoded.
+	ErrMalformed = errors.New("token is malformed")
+	// ErrRevoked indicates the token has been revoked before its expiry.
+	ErrRevoked = errors.New("token is revoked")
+	// ErrKeyFetch indicates the verification key could not be fetched.
+	ErrKeyFetch = errors.New("failed fetching token verification key")
+)
+
+// TokenError describes why a token was rejected. Kind is one of the Err variables of this
+// package, so callers can match it with errors.Is, or extract the TokenError with errors.As.
+type TokenError struct {
+	Kind error
+	Err  error
+}
+
+// Error returns the error message.
+func (e *TokenError) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Kind.Error(), e.Err.Error())
+}
+
+// Is reports whether target is the kind of this error.
+func (e *TokenError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying error.
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+func newTokenError(kind error, format string, args ...interface{}) error {
+	return errors.WithStack(&TokenError{Kind: kind, Err: errors.Errorf(format, args...)})
+}
+
+func wrapTokenError(kind error, err error) error {
+	var tokenErr *TokenError
+	if errors.As(err, &tokenErr) {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(&TokenError{Kind: kind, Err: err})
+}
+
+// classifyParseError converts errors returned by the jwt-go parser into TokenError.
+func classifyParseError(err error) error {
+	var tokenErr *TokenError
+	if errors.As(err, &tokenErr) {
+		return errors.WithStack(err)
+	}
+	validationErr, ok := err.(*jwt.ValidationError)
+	if !ok {
+		return wrapTokenError(ErrMalformed, err)
+	}
+	if validationErr.Inner != nil && errors.As(validationErr.Inner, &tokenErr) {
+		return errors.WithStack(validationErr.Inner)
+	}
+	switch {
+	case validationErr.Errors&jwt.ValidationErrorMalformed != 0:
+		return wrapTokenError(ErrMalformed, err)
+	case validationErr.Errors&jwt.ValidationErrorUnverifiable != 0:
+		return wrapTokenError(ErrKeyFetch, err)
+	default:
+		return wrapTokenError(ErrBadSignature, err)
+	}
+}
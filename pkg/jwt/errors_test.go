@@ -0,0 +1,113 @@
+package jwt_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+)
+
+func TestRS256_ParseErrors(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	timegen := timegenerator.NewFakeTimeGenerator(now)
+	key, _ := generateKey(t)
+
+	keySet := &keyServer{keySet: jwt.JWKSet{Keys: []jwt.JWK{jwt.NewRSAJWK("kid-1", &key.PublicKey)}}}
+	keysServer := httptest.NewServer(keySet)
+	defer keysServer.Close()
+	downServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer downServer.Close()
+
+	newVerifier := func(url string) jwt.JWT {
+		verifier, err := jwt.NewRS256(timegen, "kid-1", "issuer", "audience", nil, nil, &url,
+			time.Minute, http.DefaultClient)
+		if err != nil {
+			t.Fatalf("NewRS256() error = %v", err)
+		}
+		return verifier
+	}
+	newSigner := func(keyID, issuer, audience string) jwt.JWT {
+		signer, err := jwt.NewRS256(timegen, keyID, issuer, audience, nil, nil, nil,
+			time.Minute, http.DefaultClient, jwt.WithSigner(jwt.NewLocalSigner(key)))
+		if err != nil {
+			t.Fatalf("NewRS256() error = %v", err)
+		}
+		return signer
+	}
+	sign := func(signer jwt.JWT, claims *jwt.Claims) string {
+		tokenString, _, err := signer.SignClaims(ctx, claims)
+		if err != nil {
+			t.Fatalf("RS256.SignClaims() error = %v", err)
+		}
+		return tokenString
+	}
+
+	valid := sign(newSigner("kid-1", "issuer", "audience"), &jwt.Claims{Subject: "user-1"})
+	forgedKey, _ := generateKey(t)
+	forger, _ := jwt.NewRS256(timegen, "kid-1", "issuer", "audience", nil, nil, nil,
+		time.Minute, http.DefaultClient, jwt.WithSigner(jwt.NewLocalSigner(forgedKey)))
+
+	tests := []struct {
+		name        string
+		tokenString string
+		verifier    jwt.JWT
+		advance     time.Duration
+		wantErr     error
+	}{
+		{name: "Accepts a valid token", tokenString: valid, verifier: newVerifier(keysServer.URL)},
+		{name: "Rejects malformed tokens", tokenString: "not-a-token", verifier: newVerifier(keysServer.URL), wantErr: jwt.ErrMalformed},
+		{
+			name:        "Rejects tokens signed by another key",
+			tokenString: sign(forger, &jwt.Claims{Subject: "user-1"}),
+			verifier:    newVerifier(keysServer.URL),
+			wantErr:     jwt.ErrBadSignature,
+		},
+		{
+			name:        "Rejects unknown key IDs",
+			tokenString: sign(newSigner("kid-2", "issuer", "audience"), &jwt.Claims{}),
+			verifier:    newVerifier(keysServer.URL),
+			wantErr:     jwt.ErrUnknownKID,
+		},
+		{
+			name:        "Rejects other issuers",
+			tokenString: sign(newSigner("kid-1", "evil", "audience"), &jwt.Claims{}),
+			verifier:    newVerifier(keysServer.URL),
+			wantErr:     jwt.ErrInvalidIssuer,
+		},
+		{
+			name:        "Rejects other audiences",
+			tokenString: sign(newSigner("kid-1", "issuer", "other"), &jwt.Claims{}),
+			verifier:    newVerifier(keysServer.URL),
+			wantErr:     jwt.ErrInvalidAudience,
+		},
+		{
+			name:        "Rejects tokens used before nbf",
+			tokenString: sign(newSigner("kid-1", "issuer", "audience"), &jwt.Claims{NotBefore: now.Add(time.Hour).Unix()}),
+			verifier:    newVerifier(keysServer.URL),
+			wantErr:     jwt.ErrNotYetValid,
+		},
+		{name: "Rejects expired tokens", tokenString: valid, verifier: newVerifier(keysServer.URL), advance: time.Hour, wantErr: jwt.ErrExpired},
+		{name: "Reports key fetch failures", tokenString: valid, verifier: newVerifier(downServer.URL), wantErr: jwt.ErrKeyFetch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timegen.Set(now.Add(tt.advance))
+			_, _, err := tt.verifier.Parse(ctx, tt.tokenString, false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RS256.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var tokenErr *jwt.TokenError
+			if tt.wantErr != nil && !errors.As(err, &tokenErr) {
+				t.Errorf("RS256.Parse() error = %T, want *jwt.TokenError", err)
+			}
+		})
+	}
+}
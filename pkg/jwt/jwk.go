@@ -0,0 +1,188 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// JWK is a JSON Web Key as defined by RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set as defined by RFC 7517.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey converts this JWK into a public key.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 2 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key parameters")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		curve, ok := curves[k.Curve]
+		if !ok {
+			return nil, errors.Errorf("unsupported curve [%s]", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		publicKey := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, errors.New("invalid EC key parameters")
+		}
+		return publicKey, nil
+	default:
+		return nil, errors.Errorf("unsupported key type [%s]", k.KeyType)
+	}
+}
+
+// NewRSAJWK converts an RSA public key into a JWK.
+func NewRSAJWK(keyID string, publicKey *rsa.PublicKey) JWK {
+	return JWK{
+		KeyType:   "RSA",
+		KeyID:     keyID,
+		Use:       "sig",
+		Algorithm: "RS256",
+		N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+// NewECJWK converts an ECDSA public key into a JWK.
+func NewECJWK(keyID string, publicKey *ecdsa.PublicKey) JWK {
+	size := (publicKey.Curve.Params().BitSize + 7) / 8
+	return JWK{
+		KeyType: "EC",
+		KeyID:   keyID,
+		Curve:   publicKey.Curve.Params().Name,
+		X:       base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size))),
+		Y:       base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// Thumbprint computes the RFC 7638 SHA-256 thumbprint of this JWK, base64url encoded. Only the
+// required members of the key type are hashed, so the kid, use and alg members do not matter.
+func (k JWK) Thumbprint() (string, error) {
+	var members interface{}
+	switch k.KeyType {
+	case "RSA":
+		// encoding/json sorts map keys, which gives the lexicographic order RFC 7638 requires.
+		members = map[string]string{"e": k.E, "kty": k.KeyType, "n": k.N}
+	case "EC":
+		members = map[string]string{"crv": k.Curve, "kty": k.KeyType, "x": k.X, "y": k.Y}
+	default:
+		return "", errors.Errorf("unsupported key type [%s]", k.KeyType)
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Key finds the key identified by keyID. When keyID is empty, the set must hold exactly one key.
+func (s JWKSet) Key(keyID string) (JWK, bool) {
+	if keyID == "" {
+		if len(s.Keys) == 1 {
+			return s.Keys[0], true
+		}
+		return JWK{}, false
+	}
+	for _, key := range s.Keys {
+		if key.KeyID == keyID {
+			return key, true
+		}
+	}
+	return JWK{}, false
+}
+
+// ParseWithKeySet verifies an RS256 token signed by a third party, e.g. a client assertion, with
+// a key of keySet and validates its claims against policy at the time now.
+func ParseWithKeySet(ctx context.Context, tokenString string, keySet JWKSet, policy ValidationPolicy, now time.Time) (*Claims, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, newTokenError(ErrBadSignature, "invalid signing method [%v]", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keySet.Key(kid)
+		if !ok {
+			return nil, newTokenError(ErrUnknownKID, "unknown kid [%s]", kid)
+		}
+		publicKey, err := key.PublicKey()
+		if err != nil {
+			return nil, wrapTokenError(ErrUnknownKID, err)
+		}
+		return publicKey, nil
+	})
+	if err != nil {
+		return nil, classifyParseError(err)
+	}
+	claims, err := NewClaimsFromMap(token.Claims.(jwt.MapClaims))
+	if err != nil {
+		return nil, wrapTokenError(ErrMalformed, err)
+	}
+	if err := policy.Validate(ctx, claims, now, false); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ParseUnverified decodes the claims of a token without verifying it. The claims must only be
+// used to find the key which verifies the token.
+func ParseUnverified(tokenString string) (*Claims, error) {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, wrapTokenError(ErrMalformed, err)
+	}
+	claims, err := NewClaimsFromMap(token.Claims.(jwt.MapClaims))
+	if err != nil {
+		return nil, wrapTokenError(ErrMalformed, err)
+	}
+	return claims, nil
+}
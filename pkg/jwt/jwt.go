@@ -15,8 +15,14 @@ type JWT interface {
 	// Sign signs jwt token.
 	Sign(ctx context.Context, payload map[string]interface{}) (tokenString string, expiry time.Time, err error)
 
+	// SignClaims signs typed claims into a jwt token.
+	SignClaims(ctx context.Context, claims *Claims) (tokenString string, expiry time.Time, err error)
+
 	// Parse parses token string to jwt.
 	Parse(ctx context.Context, tokenString string, ignoreExpiration bool) (token *jwt.Token, expiry time.Time, err error)
+
+	// ParseClaims parses token string into validated claims.
+	ParseClaims(ctx context.Context, tokenString string, ignoreExpiration bool) (claims *Claims, err error)
 }
 
 type internalHTTPClient interface {
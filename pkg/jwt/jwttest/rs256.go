@@ -0,0 +1,48 @@
+package jwttest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// KeyID is the key ID used by RS256 instances created by NewRS256.
+const KeyID = "test-key"
+
+var (
+	keyOnce sync.Once
+	key     *rsa.PrivateKey
+	keyErr  error
+)
+
+// Key returns an RSA key generated once per test binary.
+func Key() (*rsa.PrivateKey, error) {
+	keyOnce.Do(func() {
+		key, keyErr = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	return key, errors.WithStack(keyErr)
+}
+
+// NewRS256 instantiates an RS256 which signs and verifies with Key.
+func NewRS256(timegen timegenerator.TimeGenerator, issuer, audience string, maxAge time.Duration,
+	options ...jwt.RS256Option) (jwt.JWT, error) {
+	signKey, err := Key()
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&signKey.PublicKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	options = append([]jwt.RS256Option{jwt.WithSigner(jwt.NewLocalSigner(signKey))}, options...)
+	return jwt.NewRS256(timegen, KeyID, issuer, audience, nil, &publicKey, nil, maxAge, http.DefaultClient, options...)
+}
@@ -0,0 +1,52 @@
+// Package jwttest provides utilities for testing code which depends on pkg/jwt.
+package jwttest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+)
+
+// NewSignerServer starts a fake remote signer which signs digests with key.
+// Callers must Close the returned server.
+func NewSignerServer(keyID string, key crypto.Signer) *httptest.Server {
+	return httptest.NewServer(NewSignerHandler(keyID, key))
+}
+
+// NewSignerHandler returns a handler speaking the remote signer protocol, backed by key.
+func NewSignerHandler(keyID string, key crypto.Signer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req jwt.RemoteSignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.KeyID != keyID || req.Algorithm != "RS256" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		digest, err := base64.StdEncoding.DecodeString(req.Digest)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		signature, err := key.Sign(rand.Reader, digest, crypto.SHA256)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(jwt.RemoteSignResponse{
+			Signature: base64.StdEncoding.EncodeToString(signature),
+		})
+	})
+}
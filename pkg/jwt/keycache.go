@@ -0,0 +1,302 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// KeyCache resolves public keys by key ID.
+type KeyCache interface {
+	// PublicKey returns the public key identified by kid.
+	PublicKey(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// KeyCacheConfig provides configs for a remote key cache.
+type KeyCacheConfig struct {
+	// DefaultTTL is used when the key server sends neither Cache-Control nor Expires.
+	DefaultTTL time.Duration
+	// MinTTL and MaxTTL bound the TTL advertised by the key server.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// RefreshAhead is how long before expiry a background refresh is started.
+	RefreshAhead time.Duration
+	// MinRefetchInterval is the minimum interval between fetches triggered by unknown key IDs.
+	MinRefetchInterval time.Duration
+	// NegativeTTL is how long an unknown key ID is remembered as unknown.
+	NegativeTTL time.Duration
+	// MaxMisses bounds the unknown key IDs remembered, so tokens with random key IDs cannot
+	// grow the cache without bound. The ones expiring first are forgotten first. Zero uses the
+	// MaxMisses of DefaultKeyCacheConfig.
+	MaxMisses int
+}
+
+// DefaultKeyCacheConfig is used by NewRS256 when verifying against a public key URL.
+var DefaultKeyCacheConfig = KeyCacheConfig{
+	DefaultTTL:         time.Hour,
+	MinTTL:             time.Minute,
+	MaxTTL:             24 * time.Hour,
+	RefreshAhead:       time.Minute,
+	MinRefetchInterval: 30 * time.Second,
+	NegativeTTL:        5 * time.Minute,
+	MaxMisses:          1024,
+}
+
+type fetchCall struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+type keyCache struct {
+	url        string
+	httpClient internalHTTPClient
+	timegen    timegenerator.TimeGenerator
+	config     KeyCacheConfig
+
+	mu         sync.Mutex
+	keys       map[string]crypto.PublicKey
+	expiresAt  time.Time
+	lastFetch  time.Time
+	misses     map[string]time.Time
+	inflight   *fetchCall
+	refreshing bool
+}
+
+// NewKeyCache instantiates a KeyCache backed by the key set served at url. The key set is either
+// a JWK Set or a JSON object mapping key IDs to PEM encoded public keys.
+func NewKeyCache(url string, httpClient internalHTTPClient, timegen timegenerator.TimeGenerator, config KeyCacheConfig) KeyCache {
+	if config.MaxMisses <= 0 {
+		config.MaxMisses = DefaultKeyCacheConfig.MaxMisses
+	}
+	return &keyCache{
+		url:        url,
+		httpClient: httpClient,
+		timegen:    timegen,
+		config:     config,
+		keys:       map[string]crypto.PublicKey{},
+		misses:     map[string]time.Time{},
+	}
+}
+
+// PublicKey returns the public key identified by kid. Fresh keys are served from memory, keys
+// close to expiry are refreshed in the background, and expired keys keep being served when
+// the key server cannot be reached.
+func (c *keyCache) PublicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	now := c.timegen.Now()
+
+	c.mu.Lock()
+	key, found := c.keys[kid]
+	fresh := now.Before(c.expiresAt)
+	if found && fresh {
+		if !now.Before(c.expiresAt.Add(-c.config.RefreshAhead)) && !c.refreshing {
+			c.refreshing = true
+			go c.refreshInBackground()
+		}
+		c.mu.Unlock()
+		return key, nil
+	}
+	if c.inflight == nil && now.Before(c.lastFetch.Add(c.config.MinRefetchInterval)) {
+		if found {
+			c.mu.Unlock()
+			return key, nil
+		}
+		c.rememberMiss(kid, now)
+		c.mu.Unlock()
+		return nil, newTokenError(ErrUnknownKID, "kid %s is not found", kid)
+	}
+	if until, ok := c.misses[kid]; ok && now.Before(until) {
+		c.mu.Unlock()
+		return nil, newTokenError(ErrUnknownKID, "kid %s is not found", kid)
+	}
+	c.mu.Unlock()
+
+	fetchErr := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if fetchErr != nil {
+		return nil, wrapTokenError(ErrKeyFetch, fetchErr)
+	}
+	c.rememberMiss(kid, c.timegen.Now())
+	return nil, newTokenError(ErrUnknownKID, "kid %s is not found", kid)
+}
+
+// rememberMiss remembers kid as unknown for NegativeTTL from now. When MaxMisses are
+// remembered, expired ones are forgotten, then the one expiring first. c.mu must be held.
+func (c *keyCache) rememberMiss(kid string, now time.Time) {
+	if _, ok := c.misses[kid]; !ok && len(c.misses) >= c.config.MaxMisses {
+		c.pruneMisses(now, nil)
+		if len(c.misses) >= c.config.MaxMisses {
+			var first string
+			var firstUntil time.Time
+			for missed, until := range c.misses {
+				if firstUntil.IsZero() || until.Before(firstUntil) {
+					first, firstUntil = missed, until
+				}
+			}
+			delete(c.misses, first)
+		}
+	}
+	c.misses[kid] = now.Add(c.config.NegativeTTL)
+}
+
+// pruneMisses forgets the unknown key IDs expired at now, and the ones of keys. c.mu must be
+// held.
+func (c *keyCache) pruneMisses(now time.Time, keys map[string]crypto.PublicKey) {
+	for kid, until := range c.misses {
+		if _, ok := keys[kid]; ok || !now.Before(until) {
+			delete(c.misses, kid)
+		}
+	}
+}
+
+func (c *keyCache) refreshInBackground() {
+	_ = c.fetch(context.Background())
+	c.mu.Lock()
+	c.refreshing = false
+	c.mu.Unlock()
+}
+
+// fetch loads the key set, sharing a single request between concurrent callers.
+func (c *keyCache) fetch(ctx context.Context) error {
+	c.mu.Lock()
+	if call := c.inflight; call != nil {
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.err
+	}
+	call := &fetchCall{}
+	call.wg.Add(1)
+	c.inflight = call
+	c.lastFetch = c.timegen.Now()
+	c.mu.Unlock()
+
+	keys, ttl, err := c.load(ctx)
+
+	c.mu.Lock()
+	if err == nil {
+		now := c.timegen.Now()
+		c.keys = keys
+		c.expiresAt = now.Add(ttl)
+		c.pruneMisses(now, keys)
+	}
+	call.err = err
+	c.inflight = nil
+	c.mu.Unlock()
+	call.wg.Done()
+	return err
+}
+
+func (c *keyCache) load(ctx context.Context) (map[string]crypto.PublicKey, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.Errorf("failed getting public key: http status %d", resp.StatusCode)
+	}
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	keys, err := parseKeySet(bodyBytes)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	return keys, c.ttl(resp.Header), nil
+}
+
+func (c *keyCache) ttl(header http.Header) time.Duration {
+	ttl := c.config.DefaultTTL
+	if maxAge, ok := parseMaxAge(header.Get("Cache-Control")); ok {
+		ttl = maxAge
+	} else if expires := header.Get("Expires"); expires != "" {
+		if expiresAt, err := http.ParseTime(expires); err == nil {
+			date, err := http.ParseTime(header.Get("Date"))
+			if err != nil {
+				date = c.timegen.Now()
+			}
+			ttl = expiresAt.Sub(date)
+		}
+	}
+	if ttl < c.config.MinTTL {
+		ttl = c.config.MinTTL
+	}
+	if c.config.MaxTTL > 0 && ttl > c.config.MaxTTL {
+		ttl = c.config.MaxTTL
+	}
+	return ttl
+}
+
+// parseMaxAge reads the max-age directive, treating no-store and no-cache as a zero TTL.
+func parseMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "no-store" || directive == "no-cache" {
+			return 0, true
+		}
+		if value := strings.TrimPrefix(directive, "max-age="); value != directive {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+func parseKeySet(body []byte) (map[string]crypto.PublicKey, error) {
+	keys := map[string]crypto.PublicKey{}
+
+	var keySet JWKSet
+	if err := json.Unmarshal(body, &keySet); err == nil && keySet.Keys != nil {
+		for _, jwk := range keySet.Keys {
+			if jwk.Use != "" && jwk.Use != "sig" {
+				continue
+			}
+			publicKey, err := jwk.PublicKey()
+			if err != nil {
+				continue
+			}
+			keys[jwk.KeyID] = publicKey
+		}
+		return keys, nil
+	}
+
+	pemKeys := make(map[string]string)
+	if err := json.Unmarshal(body, &pemKeys); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for kid, pemKey := range pemKeys {
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pemKey))
+		if err != nil {
+			continue
+		}
+		keys[kid] = publicKey
+	}
+	return keys, nil
+}
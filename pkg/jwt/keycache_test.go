@@ -0,0 +1,198 @@
+package jwt_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+)
+
+type keyServer struct {
+	mu           sync.Mutex
+	keySet       jwt.JWKSet
+	cacheControl string
+	down         bool
+	delay        time.Duration
+	fetches      int32
+	fetched      chan struct{}
+}
+
+func (s *keyServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	atomic.AddInt32(&s.fetches, 1)
+	s.mu.Lock()
+	keySet, cacheControl, down, delay := s.keySet, s.cacheControl, s.down, s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+	defer func() {
+		if s.fetched != nil {
+			s.fetched <- struct{}{}
+		}
+	}()
+	if down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	_ = json.NewEncoder(w).Encode(keySet)
+}
+
+func (s *keyServer) count() int {
+	return int(atomic.LoadInt32(&s.fetches))
+}
+
+func newKeyCache(t *testing.T, server *keyServer) (jwt.KeyCache, *timegenerator.FakeTimeGenerator) {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := jwt.NewKeyCache(httpServer.URL, http.DefaultClient, timegen, jwt.KeyCacheConfig{
+		DefaultTTL:         time.Hour,
+		MinTTL:             time.Minute,
+		MaxTTL:             24 * time.Hour,
+		RefreshAhead:       time.Minute,
+		MinRefetchInterval: 30 * time.Second,
+		NegativeTTL:        5 * time.Minute,
+		MaxMisses:          2,
+	})
+	return cache, timegen
+}
+
+func TestKeyCache_PublicKey(t *testing.T) {
+	key, _ := generateKey(t)
+	keySet := jwt.JWKSet{Keys: []jwt.JWK{jwt.NewRSAJWK("kid-1", &key.PublicKey)}}
+	ctx := context.Background()
+
+	t.Run("Serves cached keys until max-age expires", func(t *testing.T) {
+		server := &keyServer{keySet: keySet, cacheControl: "public, max-age=600"}
+		cache, timegen := newKeyCache(t, server)
+		for i := 0; i < 3; i++ {
+			if _, err := cache.PublicKey(ctx, "kid-1"); err != nil {
+				t.Fatalf("KeyCache.PublicKey() error = %v", err)
+			}
+		}
+		if got := server.count(); got != 1 {
+			t.Errorf("fetches = %d, want 1", got)
+		}
+		timegen.Add(11 * time.Minute)
+		if _, err := cache.PublicKey(ctx, "kid-1"); err != nil {
+			t.Fatalf("KeyCache.PublicKey() error = %v", err)
+		}
+		if got := server.count(); got != 2 {
+			t.Errorf("fetches = %d, want 2", got)
+		}
+	})
+
+	t.Run("De-duplicates concurrent fetches", func(t *testing.T) {
+		server := &keyServer{keySet: keySet, delay: 50 * time.Millisecond}
+		cache, _ := newKeyCache(t, server)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := cache.PublicKey(ctx, "kid-1"); err != nil {
+					t.Errorf("KeyCache.PublicKey() error = %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if got := server.count(); got != 1 {
+			t.Errorf("fetches = %d, want 1", got)
+		}
+	})
+
+	t.Run("Negative caches unknown key IDs", func(t *testing.T) {
+		server := &keyServer{keySet: keySet}
+		cache, timegen := newKeyCache(t, server)
+		for i := 0; i < 10; i++ {
+			if _, err := cache.PublicKey(ctx, "forged"); err == nil {
+				t.Fatalf("KeyCache.PublicKey() error = nil, want error")
+			}
+		}
+		if got := server.count(); got != 1 {
+			t.Errorf("fetches = %d, want 1", got)
+		}
+		timegen.Add(time.Minute)
+		if _, err := cache.PublicKey(ctx, "another-forged"); err == nil {
+			t.Fatalf("KeyCache.PublicKey() error = nil, want error")
+		}
+		if _, err := cache.PublicKey(ctx, "yet-another-forged"); err == nil {
+			t.Fatalf("KeyCache.PublicKey() error = nil, want error")
+		}
+		if got := server.count(); got != 2 {
+			t.Errorf("fetches = %d, want 2", got)
+		}
+	})
+
+	t.Run("Forgets the unknown key IDs expiring first beyond MaxMisses", func(t *testing.T) {
+		server := &keyServer{keySet: keySet}
+		cache, timegen := newKeyCache(t, server)
+		for _, kid := range []string{"forged-1", "forged-2", "forged-3"} {
+			if _, err := cache.PublicKey(ctx, kid); err == nil {
+				t.Fatalf("KeyCache.PublicKey(%s) error = nil, want error", kid)
+			}
+			timegen.Add(time.Minute)
+		}
+		if got := server.count(); got != 3 {
+			t.Errorf("fetches = %d, want 3", got)
+		}
+		if _, err := cache.PublicKey(ctx, "forged-2"); err == nil {
+			t.Fatalf("KeyCache.PublicKey() error = nil, want error")
+		}
+		if got := server.count(); got != 3 {
+			t.Errorf("fetches = %d, want forged-2 still negative cached", got)
+		}
+		if _, err := cache.PublicKey(ctx, "forged-1"); err == nil {
+			t.Fatalf("KeyCache.PublicKey() error = nil, want error")
+		}
+		if got := server.count(); got != 4 {
+			t.Errorf("fetches = %d, want forged-1 forgotten and fetched again", got)
+		}
+	})
+
+	t.Run("Serves stale keys while the key server is down", func(t *testing.T) {
+		server := &keyServer{keySet: keySet, cacheControl: "max-age=60"}
+		cache, timegen := newKeyCache(t, server)
+		if _, err := cache.PublicKey(ctx, "kid-1"); err != nil {
+			t.Fatalf("KeyCache.PublicKey() error = %v", err)
+		}
+		server.mu.Lock()
+		server.down = true
+		server.mu.Unlock()
+		timegen.Add(time.Hour)
+		if _, err := cache.PublicKey(ctx, "kid-1"); err != nil {
+			t.Errorf("KeyCache.PublicKey() error = %v, want stale key", err)
+		}
+		if _, err := cache.PublicKey(ctx, "kid-1"); err != nil {
+			t.Errorf("KeyCache.PublicKey() error = %v, want stale key", err)
+		}
+		if got := server.count(); got != 2 {
+			t.Errorf("fetches = %d, want 2", got)
+		}
+	})
+
+	t.Run("Refreshes in the background before expiry", func(t *testing.T) {
+		server := &keyServer{keySet: keySet, cacheControl: "max-age=600", fetched: make(chan struct{}, 2)}
+		cache, timegen := newKeyCache(t, server)
+		if _, err := cache.PublicKey(ctx, "kid-1"); err != nil {
+			t.Fatalf("KeyCache.PublicKey() error = %v", err)
+		}
+		<-server.fetched
+		timegen.Add(9*time.Minute + 30*time.Second)
+		if _, err := cache.PublicKey(ctx, "kid-1"); err != nil {
+			t.Fatalf("KeyCache.PublicKey() error = %v", err)
+		}
+		select {
+		case <-server.fetched:
+		case <-time.After(time.Second):
+			t.Fatalf("background refresh did not happen")
+		}
+	})
+}
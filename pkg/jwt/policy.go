@@ -0,0 +1,82 @@
+package jwt
+
+import (
+	"context"
+	"time"
+)
+
+// ClaimsValidator validates application specific claims.
+type ClaimsValidator func(ctx context.Context, claims *Claims) error
+
+// ValidationPolicy describes which claims a token must carry to be accepted.
+type ValidationPolicy struct {
+	// Issuers lists accepted issuers.
+	Issuers []string
+	// Audiences lists accepted audiences. At least one audience of the token must be accepted.
+	Audiences []string
+	// Leeway tolerates clock skew when checking exp, nbf and iat.
+	Leeway time.Duration
+	// MaxAge rejects tokens issued longer than MaxAge ago, when set.
+	MaxAge time.Duration
+	// RequiredClaims lists claims which must be present, e.g. sub or jti.
+	RequiredClaims []string
+	// Validators run after the registered claims are validated.
+	Validators []ClaimsValidator
+}
+
+// Validate validates claims against this policy at the time now.
+func (p ValidationPolicy) Validate(ctx context.Context, claims *Claims, now time.Time, ignoreExpiration bool) error {
+	if !contains(p.Issuers, claims.Issuer) {
+		return newTokenError(ErrInvalidIssuer, "invalid issuer [%v]", claims.Issuer)
+	}
+	if !p.hasValidAudience(claims.Audience) {
+		return newTokenError(ErrInvalidAudience, "invalid audience %v", []string(claims.Audience))
+	}
+	if claims.ExpiresAt == 0 {
+		return newTokenError(ErrInvalidClaims, "invalid expiration")
+	}
+	if !ignoreExpiration && !now.Before(time.Unix(claims.ExpiresAt, 0).Add(p.Leeway)) {
+		return newTokenError(ErrExpired, "expired at %d", claims.ExpiresAt)
+	}
+	if claims.NotBefore != 0 && now.Add(p.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return newTokenError(ErrNotYetValid, "not valid before %d", claims.NotBefore)
+	}
+	if claims.IssuedAt != 0 {
+		issuedAt := time.Unix(claims.IssuedAt, 0)
+		if issuedAt.After(now.Add(p.Leeway)) {
+			return newTokenError(ErrNotYetValid, "issued in the future at %d", claims.IssuedAt)
+		}
+		if p.MaxAge > 0 && now.Sub(issuedAt) > p.MaxAge+p.Leeway {
+			return newTokenError(ErrExpired, "issued too long ago at %d", claims.IssuedAt)
+		}
+	}
+	for _, name := range p.RequiredClaims {
+		if !claims.Has(name) {
+			return newTokenError(ErrInvalidClaims, "missing required claim [%s]", name)
+		}
+	}
+	for _, validator := range p.Validators {
+		if err := validator(ctx, claims); err != nil {
+			return wrapTokenError(ErrInvalidClaims, err)
+		}
+	}
+	return nil
+}
+
+func (p ValidationPolicy) hasValidAudience(audience Audience) bool {
+	for _, accepted := range p.Audiences {
+		if audience.Contains(accepted) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
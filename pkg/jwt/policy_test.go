@@ -0,0 +1,77 @@
+package jwt_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+)
+
+func TestValidationPolicy_Validate(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy := jwt.ValidationPolicy{
+		Issuers:        []string{"https://issuer-a", "https://issuer-b"},
+		Audiences:      []string{"api"},
+		Leeway:         30 * time.Second,
+		MaxAge:         time.Hour,
+		RequiredClaims: []string{"sub", "tenant"},
+		Validators: []jwt.ClaimsValidator{
+			func(_ context.Context, claims *jwt.Claims) error {
+				if claims.Extra["blocked"] == true {
+					return errors.New("blocked")
+				}
+				return nil
+			},
+		},
+	}
+	valid := func() *jwt.Claims {
+		return &jwt.Claims{
+			Issuer:    "https://issuer-b",
+			Subject:   "user-1",
+			Audience:  jwt.Audience{"other", "api"},
+			ExpiresAt: now.Add(time.Minute).Unix(),
+			IssuedAt:  now.Add(-time.Minute).Unix(),
+			Tenant:    "tenant-1",
+		}
+	}
+	tests := []struct {
+		name             string
+		mutate           func(*jwt.Claims)
+		ignoreExpiration bool
+		wantErr          error
+	}{
+		{name: "Accepts valid claims", mutate: func(*jwt.Claims) {}},
+		{name: "Rejects unknown issuer", mutate: func(c *jwt.Claims) { c.Issuer = "https://evil" }, wantErr: jwt.ErrInvalidIssuer},
+		{name: "Rejects unknown audience", mutate: func(c *jwt.Claims) { c.Audience = jwt.Audience{"other"} }, wantErr: jwt.ErrInvalidAudience},
+		{name: "Rejects missing expiry", mutate: func(c *jwt.Claims) { c.ExpiresAt = 0 }, wantErr: jwt.ErrInvalidClaims},
+		{name: "Accepts expiry within leeway", mutate: func(c *jwt.Claims) { c.ExpiresAt = now.Add(-20 * time.Second).Unix() }},
+		{name: "Rejects expiry beyond leeway", mutate: func(c *jwt.Claims) { c.ExpiresAt = now.Add(-time.Minute).Unix() }, wantErr: jwt.ErrExpired},
+		{
+			name:             "Accepts expired claims when expiration is ignored",
+			mutate:           func(c *jwt.Claims) { c.ExpiresAt = now.Add(-time.Minute).Unix() },
+			ignoreExpiration: true,
+		},
+		{name: "Rejects claims not valid yet", mutate: func(c *jwt.Claims) { c.NotBefore = now.Add(time.Minute).Unix() }, wantErr: jwt.ErrNotYetValid},
+		{name: "Accepts nbf within leeway", mutate: func(c *jwt.Claims) { c.NotBefore = now.Add(20 * time.Second).Unix() }},
+		{name: "Rejects claims issued in the future", mutate: func(c *jwt.Claims) { c.IssuedAt = now.Add(time.Minute).Unix() }, wantErr: jwt.ErrNotYetValid},
+		{name: "Rejects claims issued too long ago", mutate: func(c *jwt.Claims) { c.IssuedAt = now.Add(-2 * time.Hour).Unix() }, wantErr: jwt.ErrExpired},
+		{name: "Rejects missing required claims", mutate: func(c *jwt.Claims) { c.Tenant = "" }, wantErr: jwt.ErrInvalidClaims},
+		{
+			name:    "Runs custom validators",
+			mutate:  func(c *jwt.Claims) { c.Extra = map[string]interface{}{"blocked": true} },
+			wantErr: jwt.ErrInvalidClaims,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.mutate(claims)
+			err := policy.Validate(context.Background(), claims, now, tt.ignoreExpiration)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidationPolicy.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package jwt
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// RevocationStore keeps track of access tokens revoked before they expire, keyed by jti.
+type RevocationStore interface {
+	// Revoke revokes the token identified by jti until expiresAt, when it expires anyway.
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+
+	// IsRevoked checks whether the token identified by jti is revoked.
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+
+	// RevokeOnce revokes the token identified by jti until expiresAt, unless it is revoked
+	// already, in which case it returns false. It is atomic, so of concurrent calls for a jti
+	// only one returns true, which makes it suited to consume single-use tokens.
+	RevokeOnce(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+}
+
+type memoryRevocationStore struct {
+	timegen timegenerator.TimeGenerator
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+// NewMemoryRevocationStore instantiates a RevocationStore which keeps revoked jti in memory.
+func NewMemoryRevocationStore(timegen timegenerator.TimeGenerator) RevocationStore {
+	return &memoryRevocationStore{timegen: timegen, revoked: map[string]time.Time{}}
+}
+
+func (s *memoryRevocationStore) Revoke(_ context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+func (s *memoryRevocationStore) IsRevoked(_ context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
+
+func (s *memoryRevocationStore) RevokeOnce(_ context.Context, jti string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	if _, ok := s.revoked[jti]; ok {
+		return false, nil
+	}
+	s.revoked[jti] = expiresAt
+	return true, nil
+}
+
+// prune forgets the tokens which expired. s.mu must be held.
+func (s *memoryRevocationStore) prune() {
+	now := s.timegen.Now()
+	for revokedJTI, revokedUntil := range s.revoked {
+		if !now.Before(revokedUntil) {
+			delete(s.revoked, revokedJTI)
+		}
+	}
+}
+
+type revocationEntry struct {
+	jti       string
+	revoked   bool
+	expiresAt time.Time
+}
+
+type cachedRevocationStore struct {
+	store   RevocationStore
+	timegen timegenerator.TimeGenerator
+	size    int
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// NewCachedRevocationStore wraps store with an LRU cache holding up to size entries, so most
+// lookups avoid a round trip to store. Revoked tokens are cached until they expire, while tokens
+// which are not revoked are cached for ttl, which bounds how long a revocation made by another
+// instance can go unnoticed.
+func NewCachedRevocationStore(store RevocationStore, timegen timegenerator.TimeGenerator, size int, ttl time.Duration) RevocationStore {
+	return &cachedRevocationStore{
+		store:   store,
+		timegen: timegen,
+		size:    size,
+		ttl:     ttl,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+func (s *cachedRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := s.store.Revoke(ctx, jti, expiresAt); err != nil {
+		return errors.WithStack(err)
+	}
+	s.put(revocationEntry{jti: jti, revoked: true, expiresAt: expiresAt})
+	return nil
+}
+
+// RevokeOnce is never served from the cache, since only store knows whether another instance
+// revoked jti already.
+func (s *cachedRevocationStore) RevokeOnce(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	revoked, err := s.store.RevokeOnce(ctx, jti, expiresAt)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	s.put(revocationEntry{jti: jti, revoked: true, expiresAt: expiresAt})
+	return revoked, nil
+}
+
+func (s *cachedRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if entry, ok := s.get(jti); ok {
+		return entry.revoked, nil
+	}
+	revoked, err := s.store.IsRevoked(ctx, jti)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	entry := revocationEntry{jti: jti, revoked: revoked, expiresAt: s.timegen.Now().Add(s.ttl)}
+	if revoked {
+		// The expiry of a revoked token is unknown here, so keep it for the cache lifetime.
+		entry.expiresAt = time.Time{}
+	}
+	s.put(entry)
+	return revoked, nil
+}
+
+func (s *cachedRevocationStore) get(jti string) (revocationEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[jti]
+	if !ok {
+		return revocationEntry{}, false
+	}
+	entry := element.Value.(revocationEntry)
+	if !entry.expiresAt.IsZero() && !s.timegen.Now().Before(entry.expiresAt) {
+		s.lru.Remove(element)
+		delete(s.entries, jti)
+		return revocationEntry{}, false
+	}
+	s.lru.MoveToFront(element)
+	return entry, true
+}
+
+func (s *cachedRevocationStore) put(entry revocationEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[entry.jti]; ok {
+		element.Value = entry
+		s.lru.MoveToFront(element)
+		return
+	}
+	s.entries[entry.jti] = s.lru.PushFront(entry)
+	for s.lru.Len() > s.size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(revocationEntry).jti)
+	}
+}
+
+// SubjectRevocationStore keeps track of subjects whose tokens issued before a given time are
+// revoked, e.g. when they reset their password, without knowing the jti of these tokens.
+type SubjectRevocationStore interface {
+	// RevokeSubject revokes the tokens of subject issued before the second of issuedBefore.
+	// Tokens issued within that second are still accepted, since iat has no finer precision.
+	RevokeSubject(ctx context.Context, subject string, issuedBefore time.Time) error
+
+	// RevokedBefore returns the time before which the tokens of subject are revoked, or the zero
+	// time when none are.
+	RevokedBefore(ctx context.Context, subject string) (time.Time, error)
+}
+
+type memorySubjectRevocationStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+// NewMemorySubjectRevocationStore instantiates a SubjectRevocationStore which keeps revoked
+// subjects in memory.
+func NewMemorySubjectRevocationStore() SubjectRevocationStore {
+	return &memorySubjectRevocationStore{revoked: map[string]time.Time{}}
+}
+
+func (s *memorySubjectRevocationStore) RevokeSubject(_ context.Context, subject string, issuedBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issuedBefore = issuedBefore.UTC().Truncate(time.Second)
+	if issuedBefore.After(s.revoked[subject]) {
+		s.revoked[subject] = issuedBefore
+	}
+	return nil
+}
+
+func (s *memorySubjectRevocationStore) RevokedBefore(_ context.Context, subject string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked[subject], nil
+}
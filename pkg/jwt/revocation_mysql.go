@@ -0,0 +1,96 @@
+package jwt
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	insertRevokedTokenQuery = `INSERT INTO revoked_tokens (jti, expires_at) VALUES (:jti, :expires_at)
+		ON DUPLICATE KEY UPDATE expires_at = VALUES(expires_at)`
+	insertRevokedTokenOnceQuery = `INSERT INTO revoked_tokens (jti, expires_at) VALUES (:jti, :expires_at)`
+	countRevokedTokenQuery      = `SELECT COUNT(*) FROM revoked_tokens WHERE jti = :jti`
+	// insertRevokedSubjectQuery never moves the revocation of a subject back in time.
+	insertRevokedSubjectQuery = `INSERT INTO revoked_subjects (subject, issued_before) VALUES (:subject, :issued_before)
+		ON DUPLICATE KEY UPDATE issued_before = GREATEST(issued_before, VALUES(issued_before))`
+	findRevokedSubjectQuery = `SELECT issued_before FROM revoked_subjects WHERE subject = :subject`
+)
+
+// errorCodeDuplicateEntry is the MySQL error raised when a unique key is violated.
+const errorCodeDuplicateEntry = 1062
+
+type mysqlRevocationStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLRevocationStore instantiates a RevocationStore backed by MySQL. Rows whose expires_at
+// has passed are no longer needed and may be purged.
+func NewMySQLRevocationStore(db mysql.MySQL) RevocationStore {
+	return &mysqlRevocationStore{db: db}
+}
+
+func (s *mysqlRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.db.ExecNamed(ctx, insertRevokedTokenQuery, map[string]interface{}{
+		"jti":        jti,
+		"expires_at": expiresAt.UTC(),
+	})
+	return errors.WithStack(err)
+}
+
+func (s *mysqlRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int
+	err := s.db.GetNamedForWrite(ctx, &count, countRevokedTokenQuery, map[string]interface{}{"jti": jti})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return count > 0, nil
+}
+
+func (s *mysqlRevocationStore) RevokeOnce(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	_, err := s.db.ExecNamed(ctx, insertRevokedTokenOnceQuery, map[string]interface{}{
+		"jti":        jti,
+		"expires_at": expiresAt.UTC(),
+	})
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errorCodeDuplicateEntry {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
+
+type mysqlSubjectRevocationStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLSubjectRevocationStore instantiates a SubjectRevocationStore backed by MySQL.
+func NewMySQLSubjectRevocationStore(db mysql.MySQL) SubjectRevocationStore {
+	return &mysqlSubjectRevocationStore{db: db}
+}
+
+func (s *mysqlSubjectRevocationStore) RevokeSubject(ctx context.Context, subject string, issuedBefore time.Time) error {
+	_, err := s.db.ExecNamed(ctx, insertRevokedSubjectQuery, map[string]interface{}{
+		"subject":       subject,
+		"issued_before": issuedBefore.UTC().Truncate(time.Second),
+	})
+	return errors.WithStack(err)
+}
+
+func (s *mysqlSubjectRevocationStore) RevokedBefore(ctx context.Context, subject string) (time.Time, error) {
+	var issuedBefore time.Time
+	err := s.db.GetNamed(ctx, &issuedBefore, findRevokedSubjectQuery, map[string]interface{}{"subject": subject})
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+	return issuedBefore, nil
+}
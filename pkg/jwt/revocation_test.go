@@ -0,0 +1,162 @@
+package jwt_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+)
+
+type countingRevocationStore struct {
+	jwt.RevocationStore
+	lookups int
+}
+
+func (s *countingRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	s.lookups++
+	return s.RevocationStore.IsRevoked(ctx, jti)
+}
+
+func TestRS256_ParseRevoked(t *testing.T) {
+	ctx := context.Background()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := jwt.NewMemoryRevocationStore(timegen)
+	rs256 := newRS256(t, timegen, jwt.WithRevocationStore(store))
+
+	first, expiry, err := rs256.Sign(ctx, map[string]interface{}{"sub": "user-1"})
+	if err != nil {
+		t.Fatalf("RS256.Sign() error = %v", err)
+	}
+	second, _, _ := rs256.Sign(ctx, map[string]interface{}{"sub": "user-1"})
+	claims, err := rs256.ParseClaims(ctx, first, false)
+	if err != nil {
+		t.Fatalf("RS256.ParseClaims() error = %v", err)
+	}
+	if claims.ID == "" {
+		t.Fatalf("RS256.Sign() did not assign a jti")
+	}
+
+	if err := store.Revoke(ctx, claims.ID, expiry); err != nil {
+		t.Fatalf("RevocationStore.Revoke() error = %v", err)
+	}
+	if _, err := rs256.ParseClaims(ctx, first, false); !errors.Is(err, jwt.ErrRevoked) {
+		t.Errorf("RS256.ParseClaims() error = %v, want %v", err, jwt.ErrRevoked)
+	}
+	if _, err := rs256.ParseClaims(ctx, second, false); err != nil {
+		t.Errorf("RS256.ParseClaims() error = %v, want other tokens to stay valid", err)
+	}
+}
+
+func TestRS256_ParseRevokedSubject(t *testing.T) {
+	ctx := context.Background()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := jwt.NewMemorySubjectRevocationStore()
+	rs256 := newRS256(t, timegen, jwt.WithSubjectRevocationStore(store))
+
+	before, _, err := rs256.Sign(ctx, map[string]interface{}{"sub": "user-1"})
+	if err != nil {
+		t.Fatalf("RS256.Sign() error = %v", err)
+	}
+	other, _, _ := rs256.Sign(ctx, map[string]interface{}{"sub": "user-2"})
+	timegen.Add(time.Second)
+	if err := store.RevokeSubject(ctx, "user-1", timegen.Now().Add(500*time.Millisecond)); err != nil {
+		t.Fatalf("RevokeSubject() error = %v", err)
+	}
+	// Revocations never move back in time.
+	if err := store.RevokeSubject(ctx, "user-1", timegen.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("RevokeSubject() error = %v", err)
+	}
+	after, _, _ := rs256.Sign(ctx, map[string]interface{}{"sub": "user-1"})
+
+	if _, err := rs256.ParseClaims(ctx, before, false); !errors.Is(err, jwt.ErrRevoked) {
+		t.Errorf("RS256.ParseClaims() error = %v, want %v", err, jwt.ErrRevoked)
+	}
+	if _, err := rs256.ParseClaims(ctx, after, false); err != nil {
+		t.Errorf("RS256.ParseClaims() error = %v, want tokens issued within the second accepted", err)
+	}
+	if _, err := rs256.ParseClaims(ctx, other, false); err != nil {
+		t.Errorf("RS256.ParseClaims() error = %v, want tokens of other subjects accepted", err)
+	}
+}
+
+func TestRevocationStore_RevokeOnce(t *testing.T) {
+	ctx := context.Background()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := jwt.NewCachedRevocationStore(jwt.NewMemoryRevocationStore(timegen), timegen, 2, time.Minute)
+
+	results := make(chan bool, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first, err := store.RevokeOnce(ctx, "a", timegen.Now().Add(time.Minute))
+			if err != nil {
+				t.Errorf("RevokeOnce() error = %v", err)
+			}
+			results <- first
+		}()
+	}
+	wg.Wait()
+	close(results)
+	firsts := 0
+	for first := range results {
+		if first {
+			firsts++
+		}
+	}
+	if firsts != 1 {
+		t.Errorf("RevokeOnce() = true %d times, want once", firsts)
+	}
+	if revoked, err := store.IsRevoked(ctx, "a"); err != nil || !revoked {
+		t.Errorf("IsRevoked() = %v, %v, want true", revoked, err)
+	}
+}
+
+func TestCachedRevocationStore_IsRevoked(t *testing.T) {
+	ctx := context.Background()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	backing := &countingRevocationStore{RevocationStore: jwt.NewMemoryRevocationStore(timegen)}
+	cache := jwt.NewCachedRevocationStore(backing, timegen, 2, time.Minute)
+
+	isRevoked := func(jti string, want bool) {
+		t.Helper()
+		got, err := cache.IsRevoked(ctx, jti)
+		if err != nil || got != want {
+			t.Fatalf("IsRevoked(%s) = %v, %v, want %v", jti, got, err, want)
+		}
+	}
+
+	isRevoked("a", false)
+	isRevoked("a", false)
+	if backing.lookups != 1 {
+		t.Errorf("lookups = %d, want 1", backing.lookups)
+	}
+
+	if err := cache.Revoke(ctx, "a", timegen.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	isRevoked("a", true)
+	if backing.lookups != 1 {
+		t.Errorf("lookups = %d, want 1", backing.lookups)
+	}
+
+	// Revocations made elsewhere are noticed once the negative entry expires.
+	isRevoked("b", false)
+	_ = backing.Revoke(ctx, "b", timegen.Now().Add(time.Hour))
+	isRevoked("b", false)
+	timegen.Add(2 * time.Minute)
+	isRevoked("b", true)
+
+	// The least recently used entry is evicted beyond the cache size.
+	isRevoked("c", false)
+	lookups := backing.lookups
+	isRevoked("a", true)
+	if backing.lookups != lookups+1 {
+		t.Errorf("lookups = %d, want %d", backing.lookups, lookups+1)
+	}
+}
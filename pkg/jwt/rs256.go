@@ -2,16 +2,14 @@ package jwt
 
 import (
 	"context"
+	"crypto"
 	"crypto/rsa"
-	"encoding/json"
-	"fmt"
+	"crypto/sha256"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
 	"github.com/code-and-chill/auth-api/pkg/timegenerator"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"sync"
+	"strings"
 	"time"
 )
 
@@ -21,206 +19,243 @@ type RS256 struct {
 	issuer          string
 	audience        string
 	maxAge          time.Duration
-	privateKey      *rsa.PrivateKey
+	signer          Signer
 	publicKey       *rsa.PublicKey
-	publicKeyURL    *string
-	httpClient      internalHTTPClient
-	cachedPublicKey sync.Map
+	keyCache        KeyCache
+	policy          ValidationPolicy
+	revocationStore RevocationStore
+	subjects        SubjectRevocationStore
 }
 
-func (R *RS256) Sign(_ context.Context, payload map[string]interface{}) (tokenString string, expiry time.Time, err error) {
-	if R.privateKey == nil {
+// Sign signs jwt token.
+func (R *RS256) Sign(ctx context.Context, payload map[string]interface{}) (tokenString string, expiry time.Time, err error) {
+	claims, err := NewClaimsFromMap(payload)
+	if err != nil {
+		return "", time.Time{}, errors.WithStack(err)
+	}
+	claims.AuthTime = 0
+	claims.Audience = nil
+	claims.ExpiresAt = 0
+	return R.SignClaims(ctx, claims)
+}
+
+// SignClaims signs typed claims into a jwt token. Issuer and issue time are always set by this
+// RS256. Audience defaults to the configured audience and expiry to now plus maxAge, so callers
+// can issue tokens for other audiences or lifetimes, while auth_time defaults to now and jti to
+// a random ID.
+func (R *RS256) SignClaims(ctx context.Context, claims *Claims) (tokenString string, expiry time.Time, err error) {
+	if R.signer == nil {
 		return "", time.Time{}, errors.New("no private key provided")
 	}
 	now := R.timegen.Now().UTC()
-	expiresAt := now.Add(R.maxAge).UTC()
-	payload["iss"] = R.issuer
-	payload["aud"] = R.audience
-	payload["auth_time"] = now.Unix()
-	payload["iat"] = now.Unix()
-	payload["exp"] = expiresAt.Unix()
-
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims(payload))
+	signed := *claims
+	signed.Issuer = R.issuer
+	signed.IssuedAt = now.Unix()
+	if len(signed.Audience) == 0 {
+		signed.Audience = Audience{R.audience}
+	}
+	if signed.ExpiresAt == 0 {
+		signed.ExpiresAt = now.Add(R.maxAge).Unix()
+	}
+	expiresAt := time.Unix(signed.ExpiresAt, 0).UTC()
+	if signed.AuthTime == 0 {
+		signed.AuthTime = now.Unix()
+	}
+	if signed.ID == "" {
+		if signed.ID, err = securetoken.NewID(); err != nil {
+			return "", time.Time{}, errors.WithStack(err)
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &signed)
 	token.Header["kid"] = R.keyID
-	tokenString, err = token.SignedString(R.privateKey)
+	tokenString, err = R.signToken(ctx, token)
 	if err != nil {
 		return "", time.Time{}, errors.WithStack(err)
 	}
 	return tokenString, expiresAt, nil
 }
 
+func (R *RS256) signToken(ctx context.Context, token *jwt.Token) (string, error) {
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	digest := sha256.Sum256([]byte(signingString))
+	signature, err := R.signer.Sign(ctx, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return signingString + "." + jwt.EncodeSegment(signature), nil
+}
+
 // Parse parses token string to jwt.
 func (R *RS256) Parse(ctx context.Context, tokenString string, ignoreExpiration bool) (token *jwt.Token, expiry time.Time, err error) {
-	token, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if err := R.validateHeaders(token); err != nil {
-			return nil, errors.WithStack(err)
-		}
-		if err := R.validateClaims(token.Claims, ignoreExpiration); err != nil {
-			return nil, errors.WithStack(err)
-		}
-		kid := token.Header["kid"].(string)
-		publicKey, err := R.getPublicKey(ctx, kid)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		return publicKey, nil
-	})
+	token, claims, err := R.parse(ctx, tokenString, ignoreExpiration)
 	if err != nil {
-		if err.Error() == "Token is expired" && !ignoreExpiration {
-			err = errors.WithStack(err)
-			return
-		} else if err.Error() != "Token is expired" {
-			err = errors.WithStack(err)
-			return
-		}
+		return nil, time.Time{}, err
 	}
-	claims := token.Claims.(jwt.MapClaims)
-	if claims == nil || claims["exp"] == nil {
-		return nil, time.Time{}, errors.New("invalid claims: invalid expiration")
-	}
-	switch expirationTime := claims["exp"].(type) {
-	case int64:
-		expiry = time.Unix(expirationTime, 0).UTC()
-	case int32:
-		expiry = time.Unix(int64(expirationTime), 0).UTC()
-	case int:
-		expiry = time.Unix(int64(expirationTime), 0).UTC()
-	case float64:
-		expiry = time.Unix(int64(expirationTime), 0).UTC()
-	case float32:
-		expiry = time.Unix(int64(expirationTime), 0).UTC()
-	}
-	return token, expiry, nil
+	return token, time.Unix(claims.ExpiresAt, 0).UTC(), nil
 }
 
-func (R *RS256) getPublicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
-	if R.publicKey != nil {
-		return R.publicKey, nil
-	}
-	if R.publicKeyURL == nil {
-		return nil, errors.New("no public key URL provided")
+// ParseClaims parses token string into claims validated against the validation policy.
+func (R *RS256) ParseClaims(ctx context.Context, tokenString string, ignoreExpiration bool) (claims *Claims, err error) {
+	_, claims, err = R.parse(ctx, tokenString, ignoreExpiration)
+	if err != nil {
+		return nil, err
 	}
+	return claims, nil
+}
 
-	cacheKey := fmt.Sprintf("%s:%s", *R.publicKeyURL, kid)
-	if rawPublicKey, ok := R.cachedPublicKey.Load(cacheKey); ok {
-		switch publicKeyByte := rawPublicKey.(type) {
-		case []byte:
-			return jwt.ParseRSAPublicKeyFromPEM(publicKeyByte)
+func (R *RS256) parse(ctx context.Context, tokenString string, ignoreExpiration bool) (*jwt.Token, *Claims, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if err := R.validateHeaders(token); err != nil {
+			return nil, err
 		}
-	}
-	R.cachedPublicKey.Delete(cacheKey)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *R.publicKeyURL, nil)
-	req.Header.Set("Content-Type", "application/json")
+		kid, _ := token.Header["kid"].(string)
+		return R.getPublicKey(ctx, kid)
+	})
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, classifyParseError(err)
 	}
-	resp, err := R.httpClient.Do(req)
+	claims, err := NewClaimsFromMap(token.Claims.(jwt.MapClaims))
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, wrapTokenError(ErrMalformed, err)
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+	if err := R.policy.Validate(ctx, claims, R.timegen.Now(), ignoreExpiration); err != nil {
+		return nil, nil, err
+	}
+	if R.revocationStore != nil && claims.ID != "" {
+		revoked, err := R.revocationStore.IsRevoked(ctx, claims.ID)
 		if err != nil {
-			panic(err.Error())
+			return nil, nil, errors.WithStack(err)
 		}
-	}(resp.Body)
-
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, errors.WithStack(err)
+		if revoked {
+			return nil, nil, newTokenError(ErrRevoked, "jti %s is revoked", claims.ID)
 		}
-		result := make(map[string]string)
-		err = json.Unmarshal(bodyBytes, &result)
+	}
+	if R.subjects != nil && claims.Subject != "" {
+		issuedBefore, err := R.subjects.RevokedBefore(ctx, claims.Subject)
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return nil, nil, errors.WithStack(err)
 		}
-		if resultKID, ok := result[kid]; ok {
-			return jwt.ParseRSAPublicKeyFromPEM([]byte(resultKID))
+		if !issuedBefore.IsZero() && time.Unix(claims.IssuedAt, 0).Before(issuedBefore) {
+			return nil, nil, newTokenError(ErrRevoked, "tokens of %s issued before %d are revoked", claims.Subject, issuedBefore.Unix())
 		}
-	} else {
-		err = errors.Errorf("failed getting public key: http status %d", resp.StatusCode)
 	}
-	err = errors.Errorf("failed getting public key: kid %s is not found", kid)
-	return nil, errors.WithStack(err)
+	return token, claims, nil
+}
+
+func (R *RS256) getPublicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if R.publicKey != nil {
+		return R.publicKey, nil
+	}
+	if R.keyCache == nil {
+		return nil, newTokenError(ErrKeyFetch, "no public key URL provided")
+	}
+	return R.keyCache.PublicKey(ctx, kid)
 }
 
 func (R *RS256) validateHeaders(token *jwt.Token) error {
 	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-		err := errors.Errorf("invalid signing method [%v]", token.Header["alg"])
-		return errors.WithStack(err)
+		return newTokenError(ErrBadSignature, "invalid signing method [%v]", token.Header["alg"])
 	}
-	var hasValidType bool
-	switch typ := token.Header["type"].(type) {
-	case string:
-		hasValidType = typ == AuthenticationType
+	typ, ok := token.Header["typ"].(string)
+	if !ok {
+		// Tokens issued by older versions carry the type under a non standard header.
+		typ, _ = token.Header["type"].(string)
 	}
-	if !hasValidType {
-		err := errors.Errorf("invalid signing type [%v]", token.Header["typ"])
-		return errors.WithStack(err)
+	if !strings.EqualFold(typ, AuthenticationType) {
+		return newTokenError(ErrMalformed, "invalid signing type [%v]", typ)
 	}
 	return nil
 }
 
-func (R *RS256) validateClaims(jwtClaims jwt.Claims, ignoreExpiration bool) error {
-	if !ignoreExpiration {
-		if err := jwtClaims.Valid(); err != nil {
-			return errors.WithStack(err)
-		}
+// RS256Option configures optional behaviour of RS256.
+type RS256Option func(*RS256)
+
+// WithSigner makes RS256 sign tokens through signer, e.g. a remote KMS or HSM backed signer,
+// instead of an in-process private key.
+func WithSigner(signer Signer) RS256Option {
+	return func(R *RS256) {
+		R.signer = signer
 	}
-	claims := jwtClaims.(jwt.MapClaims)
-	var hasValidIssuer bool
-	switch issuer := claims["iss"].(type) {
-	case string:
-		hasValidIssuer = issuer == R.issuer
+}
+
+// WithKeyCache makes RS256 resolve verification keys through keyCache, e.g. one built with
+// NewKeyCache and a non default KeyCacheConfig.
+func WithKeyCache(keyCache KeyCache) RS256Option {
+	return func(R *RS256) {
+		R.keyCache = keyCache
 	}
-	if !hasValidIssuer {
-		err := errors.Errorf("invalid issuer [%v]", claims["iss"])
-		return errors.WithStack(err)
+}
+
+// WithValidationPolicy replaces the default policy, which accepts the configured issuer and audience.
+func WithValidationPolicy(policy ValidationPolicy) RS256Option {
+	return func(R *RS256) {
+		R.policy = policy
 	}
-	var hasValidAudience bool
-	switch audience := claims["aud"].(type) {
-	case string:
-		hasValidAudience = audience == R.audience
+}
+
+// WithRevocationStore makes Parse reject tokens whose jti has been revoked in store.
+func WithRevocationStore(store RevocationStore) RS256Option {
+	return func(R *RS256) {
+		R.revocationStore = store
 	}
-	if !hasValidAudience {
-		err := errors.Errorf("invalid audience [%v]", claims["aud"])
-		return errors.WithStack(err)
+}
+
+// WithSubjectRevocationStore makes Parse reject tokens issued before their subject was revoked
+// in store.
+func WithSubjectRevocationStore(store SubjectRevocationStore) RS256Option {
+	return func(R *RS256) {
+		R.subjects = store
 	}
-	return nil
 }
 
 // NewRS256 instantiate a new RS256.
 func NewRS256(timegen timegenerator.TimeGenerator, keyID, issuer, audience string,
-	privateKey, publicKey *[]byte, publicKeyURL *string, maxAge time.Duration, httpClient internalHTTPClient) (JWT, error) {
+	privateKey, publicKey *[]byte, publicKeyURL *string, maxAge time.Duration, httpClient internalHTTPClient,
+	options ...RS256Option) (JWT, error) {
 
-	var signKey *rsa.PrivateKey
+	var signer Signer
 	var verifyKey *rsa.PublicKey
-	var err error
 	if privateKey != nil {
-		signKey, err = jwt.ParseRSAPrivateKeyFromPEM(*privateKey)
+		signKey, err := jwt.ParseRSAPrivateKeyFromPEM(*privateKey)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+		signer = NewLocalSigner(signKey)
 	}
 	if publicKey != nil {
+		var err error
 		verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(*publicKey)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 	}
 
-	return &RS256{
-		timegen:         timegen,
-		keyID:           keyID,
-		issuer:          issuer,
-		audience:        audience,
-		maxAge:          maxAge,
-		privateKey:      signKey,
-		publicKey:       verifyKey,
-		publicKeyURL:    publicKeyURL,
-		httpClient:      httpClient,
-		cachedPublicKey: sync.Map{},
-	}, nil
+	var keyCache KeyCache
+	if publicKeyURL != nil {
+		keyCache = NewKeyCache(*publicKeyURL, httpClient, timegen, DefaultKeyCacheConfig)
+	}
+
+	rs256 := &RS256{
+		timegen:   timegen,
+		keyID:     keyID,
+		issuer:    issuer,
+		audience:  audience,
+		maxAge:    maxAge,
+		signer:    signer,
+		publicKey: verifyKey,
+		keyCache:  keyCache,
+		policy: ValidationPolicy{
+			Issuers:   []string{issuer},
+			Audiences: []string{audience},
+		},
+	}
+	for _, option := range options {
+		option(rs256)
+	}
+	return rs256, nil
 }
@@ -0,0 +1,105 @@
+package jwt_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+)
+
+func newRS256(t *testing.T, timegen timegenerator.TimeGenerator, options ...jwt.RS256Option) jwt.JWT {
+	t.Helper()
+	key, publicPEM := generateKey(t)
+	options = append([]jwt.RS256Option{jwt.WithSigner(jwt.NewLocalSigner(key))}, options...)
+	rs256, err := jwt.NewRS256(timegen, "kid-1", "issuer", "audience", nil, &publicPEM, nil,
+		time.Minute, http.DefaultClient, options...)
+	if err != nil {
+		t.Fatalf("NewRS256() error = %v", err)
+	}
+	return rs256
+}
+
+func TestRS256_ParseClaims(t *testing.T) {
+	ctx := context.Background()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	rs256 := newRS256(t, timegen)
+
+	tokenString, expiry, err := rs256.SignClaims(ctx, &jwt.Claims{
+		Subject: "user-1",
+		Scope:   "read write",
+		Roles:   []string{"admin"},
+		AMR:     []string{"pwd"},
+		Extra:   map[string]interface{}{"department": "finance"},
+	})
+	if err != nil {
+		t.Fatalf("RS256.SignClaims() error = %v", err)
+	}
+
+	claims, err := rs256.ParseClaims(ctx, tokenString, false)
+	if err != nil {
+		t.Fatalf("RS256.ParseClaims() error = %v", err)
+	}
+	if claims.Subject != "user-1" || !claims.HasScope("write") || claims.Extra["department"] != "finance" {
+		t.Errorf("RS256.ParseClaims() = %+v", claims)
+	}
+	if claims.Issuer != "issuer" || !claims.Audience.Contains("audience") {
+		t.Errorf("RS256.ParseClaims() iss = %v, aud = %v", claims.Issuer, claims.Audience)
+	}
+	if _, got, err := rs256.Parse(ctx, tokenString, false); err != nil || !got.Equal(expiry) {
+		t.Errorf("RS256.Parse() = %v, %v, want %v", got, err, expiry)
+	}
+
+	timegen.Add(2 * time.Minute)
+	if _, err := rs256.ParseClaims(ctx, tokenString, false); err == nil {
+		t.Errorf("RS256.ParseClaims() error = nil, want expired")
+	}
+	if _, err := rs256.ParseClaims(ctx, tokenString, true); err != nil {
+		t.Errorf("RS256.ParseClaims() ignoring expiration error = %v", err)
+	}
+}
+
+func TestRS256_SignClaims_Overrides(t *testing.T) {
+	ctx := context.Background()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	rs256 := newRS256(t, timegen, jwt.WithValidationPolicy(jwt.ValidationPolicy{
+		Issuers:   []string{"issuer"},
+		Audiences: []string{"orders-api"},
+	}))
+
+	wantExpiry := timegen.Now().Add(10 * time.Minute)
+	tokenString, expiry, err := rs256.SignClaims(ctx, &jwt.Claims{
+		Subject:   "service-1",
+		Audience:  jwt.Audience{"orders-api"},
+		ExpiresAt: wantExpiry.Unix(),
+	})
+	if err != nil {
+		t.Fatalf("RS256.SignClaims() error = %v", err)
+	}
+	if !expiry.Equal(wantExpiry) {
+		t.Errorf("RS256.SignClaims() expiry = %v, want %v", expiry, wantExpiry)
+	}
+	timegen.Add(5 * time.Minute)
+	claims, err := rs256.ParseClaims(ctx, tokenString, false)
+	if err != nil {
+		t.Fatalf("RS256.ParseClaims() error = %v", err)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "orders-api" {
+		t.Errorf("RS256.ParseClaims() aud = %v, want [orders-api]", claims.Audience)
+	}
+
+	// Sign keeps ignoring aud and exp of map payloads.
+	tokenString, expiry, err = rs256.Sign(ctx, map[string]interface{}{"sub": "user-1", "aud": "orders-api", "exp": wantExpiry.Unix()})
+	if err != nil {
+		t.Fatalf("RS256.Sign() error = %v", err)
+	}
+	if want := timegen.Now().Add(time.Minute); !expiry.Equal(want) {
+		t.Errorf("RS256.Sign() expiry = %v, want %v", expiry, want)
+	}
+	if _, err := rs256.ParseClaims(ctx, tokenString, false); !errors.Is(err, jwt.ErrInvalidAudience) {
+		t.Errorf("RS256.ParseClaims() error = %v, want ErrInvalidAudience", err)
+	}
+}
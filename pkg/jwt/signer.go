@@ -0,0 +1,132 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// Signer signs token digests without exposing the private key, so the key may live in
+// process memory or behind a KMS, HSM or Vault transit backend.
+type Signer interface {
+	// Public returns the public key matching the signing key.
+	Public() crypto.PublicKey
+
+	// Sign signs the digest of the token signing input.
+	Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) (signature []byte, err error)
+}
+
+// RemoteSignRequest is the payload sent to a remote signer.
+type RemoteSignRequest struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	Digest    string `json:"digest"`
+}
+
+// RemoteSignResponse is the payload returned by a remote signer.
+type RemoteSignResponse struct {
+	Signature string `json:"signature"`
+}
+
+type localSigner struct {
+	signer crypto.Signer
+}
+
+// NewLocalSigner instantiates a Signer backed by an in-process crypto.Signer such as *rsa.PrivateKey.
+func NewLocalSigner(signer crypto.Signer) Signer {
+	return &localSigner{signer: signer}
+}
+
+func (s *localSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s *localSigner) Sign(_ context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signature, err := s.signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return signature, nil
+}
+
+type remoteSigner struct {
+	url        string
+	keyID      string
+	publicKey  *rsa.PublicKey
+	httpClient internalHTTPClient
+}
+
+// NewRemoteSigner instantiates a Signer which delegates signing to an HTTP signing service.
+// Returned signatures are verified against publicKey before they are used.
+func NewRemoteSigner(url, keyID string, publicKey []byte, httpClient internalHTTPClient) (Signer, error) {
+	verifyKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &remoteSigner{
+		url:        url,
+		keyID:      keyID,
+		publicKey:  verifyKey,
+		httpClient: httpClient,
+	}, nil
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+func (s *remoteSigner) Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, errors.Errorf("unsupported hash function %v", opts.HashFunc())
+	}
+	body, err := json.Marshal(RemoteSignRequest{
+		KeyID:     s.keyID,
+		Algorithm: jwt.SigningMethodRS256.Alg(),
+		Digest:    base64.StdEncoding.EncodeToString(digest),
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed signing token: http status %d", resp.StatusCode)
+	}
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var result RemoteSignResponse
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(result.Signature)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := rsa.VerifyPKCS1v15(s.publicKey, crypto.SHA256, digest, signature); err != nil {
+		return nil, errors.Wrap(err, "remote signer returned an invalid signature")
+	}
+	return signature, nil
+}
@@ -0,0 +1,86 @@
+package jwt_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+func generateKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey() error = %v", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestRS256_SignWithSigner(t *testing.T) {
+	key, publicPEM := generateKey(t)
+	otherKey, _ := generateKey(t)
+	server := jwttest.NewSignerServer("kid-1", key)
+	defer server.Close()
+	forgingServer := jwttest.NewSignerServer("kid-1", otherKey)
+	defer forgingServer.Close()
+
+	remote, err := jwt.NewRemoteSigner(server.URL, "kid-1", publicPEM, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("NewRemoteSigner() error = %v", err)
+	}
+	wrongKeyID, _ := jwt.NewRemoteSigner(server.URL, "kid-2", publicPEM, http.DefaultClient)
+	forging, _ := jwt.NewRemoteSigner(forgingServer.URL, "kid-1", publicPEM, http.DefaultClient)
+
+	tests := []struct {
+		name    string
+		signer  jwt.Signer
+		wantErr bool
+	}{
+		{name: "Signs with a local signer", signer: jwt.NewLocalSigner(key)},
+		{name: "Signs with a remote signer", signer: remote},
+		{name: "Fails when the remote signer rejects the key", signer: wrongKeyID, wantErr: true},
+		{name: "Fails when the remote signer uses another key", signer: forging, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now().Truncate(time.Second)
+			rs256, err := jwt.NewRS256(timegenerator.NewFakeTimeGenerator(now), "kid-1", "issuer", "audience", nil, &publicPEM, nil,
+				time.Minute, http.DefaultClient, jwt.WithSigner(tt.signer))
+			if err != nil {
+				t.Fatalf("NewRS256() error = %v", err)
+			}
+			tokenString, expiry, err := rs256.Sign(context.Background(), map[string]interface{}{"sub": "user-1"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RS256.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := now.Add(time.Minute).UTC(); !expiry.Equal(want) {
+				t.Errorf("RS256.Sign() expiry = %v, want %v", expiry, want)
+			}
+			token, err := jwtgo.Parse(tokenString, func(*jwtgo.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+			if err != nil || !token.Valid {
+				t.Fatalf("jwt.Parse() error = %v", err)
+			}
+			if got := token.Header["kid"]; got != "kid-1" {
+				t.Errorf("kid = %v, want kid-1", got)
+			}
+		})
+	}
+}
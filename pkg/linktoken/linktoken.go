@@ -0,0 +1,133 @@
+// Package linktoken holds what the single-use tokens sent in links by email have in common,
+// e.g. password reset and email verification tokens: their stored shape, how they are issued
+// and used, and how links carry them.
+package linktoken
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound indicates a token is not found.
+var ErrNotFound = errors.New("link token is not found")
+
+// Token is a token sent in a link. Only the hash of its value is stored. Packages embed it in
+// their own token when they store more about it.
+type Token struct {
+	ID        string     `db:"id"`
+	UserID    string     `db:"user_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// New issues a token of userID at now which may be used for lifetime. It returns the value of
+// the token along with it.
+func New(userID string, now time.Time, lifetime time.Duration) (string, Token, error) {
+	value, err := securetoken.New(securetoken.DefaultSize)
+	if err != nil {
+		return "", Token{}, errors.WithStack(err)
+	}
+	id, err := securetoken.NewID()
+	if err != nil {
+		return "", Token{}, errors.WithStack(err)
+	}
+	return value, Token{
+		ID:        id,
+		UserID:    userID,
+		TokenHash: securetoken.Hash(value),
+		ExpiresAt: now.Add(lifetime),
+		CreatedAt: now,
+	}, nil
+}
+
+// Usable tells whether the token is neither used nor expired at now.
+func (t *Token) Usable(now time.Time) bool {
+	return t.UsedAt == nil && now.Before(t.ExpiresAt)
+}
+
+func (t *Token) linkToken() *Token {
+	return t
+}
+
+// Link returns baseURL with value added to its query as the token parameter.
+func Link(baseURL, value string) string {
+	separator := "?"
+	if strings.Contains(baseURL, "?") {
+		separator = "&"
+	}
+	return baseURL + separator + "token=" + url.QueryEscape(value)
+}
+
+// Record is a stored token: a *Token, or a pointer to a struct embedding a Token.
+type Record interface {
+	linkToken() *Token
+}
+
+// Memory keeps records in memory, for the memory stores of tokens.
+type Memory struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemory instantiates an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{records: map[string]Record{}}
+}
+
+// Create stores record, which callers must not modify afterwards.
+func (m *Memory) Create(record Record) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[record.linkToken().ID] = record
+}
+
+// FindByHash calls found with the record of the token hashed to tokenHash, so it copies it
+// before it is used, or returns ErrNotFound.
+func (m *Memory) FindByHash(tokenHash string, found func(Record)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, record := range m.records {
+		if record.linkToken().TokenHash == tokenHash {
+			found(record)
+			return nil
+		}
+	}
+	return errors.WithStack(ErrNotFound)
+}
+
+// Use records token id was used at usedAt, unless it was used already.
+func (m *Memory) Use(id string, usedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.records[id]
+	if !ok {
+		return false, errors.WithStack(ErrNotFound)
+	}
+	token := record.linkToken()
+	if token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &usedAt
+	return true, nil
+}
+
+// UseByUserID records the unused tokens of userID for which match returns true as used at
+// usedAt. A nil match matches every token.
+func (m *Memory) UseByUserID(userID string, usedAt time.Time, match func(Record) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, record := range m.records {
+		token := record.linkToken()
+		if token.UserID == userID && token.UsedAt == nil && (match == nil || match(record)) {
+			used := usedAt
+			token.UsedAt = &used
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package linktoken
+
+import (
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+)
+
+func TestLink(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		want    string
+	}{
+		{name: "Adds a query", baseURL: "https://example.com/reset", want: "https://example.com/reset?token=a%2Bb"},
+		{name: "Extends a query", baseURL: "https://example.com/reset?lang=fr", want: "https://example.com/reset?lang=fr&token=a%2Bb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Link(tt.baseURL, "a+b"); got != tt.want {
+				t.Errorf("Link() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// purposeToken is a record embedding a Token, as packages store them.
+type purposeToken struct {
+	Token
+	Purpose string
+}
+
+func TestMemory(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	value, token, err := New("jane", now, time.Hour)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if token.TokenHash != securetoken.Hash(value) || !token.Usable(now) || token.Usable(now.Add(time.Hour)) {
+		t.Fatalf("New() = %+v, want a token of value usable for an hour", token)
+	}
+	_, other, err := New("jane", now, time.Hour)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	memory := NewMemory()
+	memory.Create(&purposeToken{Token: token, Purpose: "verify"})
+	memory.Create(&purposeToken{Token: other, Purpose: "change"})
+
+	memory.UseByUserID("jane", now, func(record Record) bool { return record.(*purposeToken).Purpose == "change" })
+	var found purposeToken
+	if err := memory.FindByHash(token.TokenHash, func(record Record) { found = *record.(*purposeToken) }); err != nil {
+		t.Fatalf("FindByHash() error = %v", err)
+	}
+	if found.Purpose != "verify" || !found.Usable(now) {
+		t.Fatalf("FindByHash() = %+v, want the unused verify token", found)
+	}
+	for i, want := range []bool{true, false} {
+		if used, err := memory.Use(token.ID, now); err != nil || used != want {
+			t.Errorf("Use() #%d = %v, %v, want %v", i+1, used, err, want)
+		}
+	}
+	if found.UsedAt != nil {
+		t.Error("Use() changed a found token, want a copy")
+	}
+}
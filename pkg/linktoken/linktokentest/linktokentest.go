@@ -0,0 +1,29 @@
+// Package linktokentest provides utilities for testing code which sends links of pkg/linktoken.
+package linktokentest
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/code-and-chill/auth-api/pkg/mailer"
+)
+
+// linkPattern matches the links of a message body carrying a token.
+var linkPattern = regexp.MustCompile(`https?://\S+[?&]token=\S+`)
+
+// LastToken returns the token of the link of the last message of m, which must be sent to to
+// and link to baseURL.
+func LastToken(t testing.TB, m *mailer.MemoryMailer, to, baseURL string) string {
+	t.Helper()
+	messages := m.Messages()
+	if len(messages) == 0 {
+		t.Fatalf("messages are empty, want a link to %s", baseURL)
+	}
+	last := messages[len(messages)-1]
+	link, err := url.Parse(linkPattern.FindString(last.Body))
+	if err != nil || last.To != to || link.Scheme+"://"+link.Host+link.Path != baseURL || link.Query().Get("token") == "" {
+		t.Fatalf("message = %+v, want a link to %s sent to %s", last, baseURL, to)
+	}
+	return link.Query().Get("token")
+}
@@ -0,0 +1,78 @@
+package linktoken
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	"github.com/pkg/errors"
+)
+
+// columns are the columns of a Token.
+var columns = []string{"id", "user_id", "token_hash", "expires_at", "used_at", "created_at"}
+
+// Table is a MySQL table of tokens, for the MySQL stores of tokens.
+type Table struct {
+	db          mysql.MySQL
+	name        string
+	insertQuery string
+}
+
+// NewTable instantiates the Table name, whose rows hold the columns of a Token and extra
+// columns, named after the db tags of the fields a package adds to its records.
+func NewTable(db mysql.MySQL, name string, extra ...string) *Table {
+	names := append(append([]string(nil), columns...), extra...)
+	return &Table{
+		db:   db,
+		name: name,
+		insertQuery: fmt.Sprintf("INSERT INTO %s (%s) VALUES (:%s)",
+			name, strings.Join(names, ", "), strings.Join(names, ", :")),
+	}
+}
+
+// Create inserts record.
+func (t *Table) Create(ctx context.Context, record Record) error {
+	_, err := t.db.ExecNamed(ctx, t.insertQuery, record)
+	return errors.WithStack(err)
+}
+
+// FindByHash scans the token hashed to tokenHash into dest, or returns ErrNotFound.
+func (t *Table) FindByHash(ctx context.Context, dest Record, tokenHash string) error {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE token_hash = :token_hash", t.name)
+	err := t.db.GetNamedForWrite(ctx, dest, query, map[string]interface{}{"token_hash": tokenHash})
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(ErrNotFound)
+	}
+	return errors.WithStack(err)
+}
+
+// Use records token id was used at usedAt, unless it was used already.
+func (t *Table) Use(ctx context.Context, id string, usedAt time.Time) (bool, error) {
+	query := fmt.Sprintf("UPDATE %s SET used_at = :used_at WHERE id = :id AND used_at IS NULL", t.name)
+	result, err := t.db.ExecNamed(ctx, query, map[string]interface{}{"id": id, "used_at": usedAt})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return affected == 1, nil
+}
+
+// UseByUserID records the unused tokens of userID as used at usedAt. Only the tokens whose
+// columns equal the values of filter are, when it is given; its keys are column names, never
+// user input.
+func (t *Table) UseByUserID(ctx context.Context, userID string, usedAt time.Time, filter map[string]interface{}) error {
+	args := map[string]interface{}{"user_id": userID, "used_at": usedAt}
+	query := fmt.Sprintf("UPDATE %s SET used_at = :used_at WHERE user_id = :user_id AND used_at IS NULL", t.name)
+	for column, value := range filter {
+		query += fmt.Sprintf(" AND %s = :%s", column, column)
+		args[column] = value
+	}
+	_, err := t.db.ExecNamed(ctx, query, args)
+	return errors.WithStack(err)
+}
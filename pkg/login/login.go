@@ -0,0 +1,229 @@
+// Package login implements the first-party login endpoints, which authenticate end-users and
+// issue them access and refresh tokens.
+package login
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/throttle"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/pkg/errors"
+)
+
+// Authentication method references of RFC 8176 recorded in the amr claim.
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+)
+
+// Error codes of the login endpoints.
+const (
+	ErrorCodeInvalidRequest     = "invalid_request"
+	ErrorCodeInvalidCredentials = "invalid_credentials"
+	ErrorCodeAccountPending     = "account_pending"
+	ErrorCodeAccountLocked      = "account_locked"
+	ErrorCodeAccountDisabled    = "account_disabled"
+	ErrorCodeTooManyAttempts    = "too_many_attempts"
+	ErrorCodeNotFound           = "not_found"
+	ErrorCodeInvalidMFAToken    = "invalid_mfa_token"
+	ErrorCodeInvalidCode        = "invalid_code"
+	ErrorCodeInvalidSession     = "invalid_session"
+	ErrorCodeServerError        = "server_error"
+)
+
+// Error is an error response of the login endpoints.
+type Error = httperror.Error
+
+func writeError(w http.ResponseWriter, err error, log *logger.Logger) {
+	httperror.Write(w, err, log)
+}
+
+// options holds the optional behaviour of the login endpoints.
+type options struct {
+	throttler throttle.Throttler
+	mfa       *mfa
+}
+
+// Option configures optional behaviour of the login endpoints.
+type Option func(*options)
+
+// WithThrottler throttles failed attempts with throttler. Throttled attempts are rejected with
+// 429 and a Retry-After header before any secret is verified.
+func WithThrottler(throttler throttle.Throttler) Option {
+	return func(o *options) {
+		o.throttler = throttler
+	}
+}
+
+// checkThrottle rejects an attempt on account from ip which must wait.
+func (o *options) checkThrottle(r *http.Request, account, ip string) error {
+	if o.throttler == nil {
+		return nil
+	}
+	err := o.throttler.Check(r.Context(), account, ip)
+	var throttled *throttle.ThrottledError
+	if !errors.As(err, &throttled) {
+		return errors.WithStack(err)
+	}
+	retryAfter := strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds())))
+	if throttled.Locked {
+		return httperror.New(http.StatusTooManyRequests, ErrorCodeAccountLocked,
+			"account is temporarily locked after too many failed attempts").WithHeader("Retry-After", retryAfter)
+	}
+	return httperror.New(http.StatusTooManyRequests, ErrorCodeTooManyAttempts,
+		"too many failed attempts, retry later").WithHeader("Retry-After", retryAfter)
+}
+
+// throttleFailure records a failed attempt on account from ip.
+func (o *options) throttleFailure(r *http.Request, account, ip string) error {
+	if o.throttler == nil {
+		return nil
+	}
+	return errors.WithStack(o.throttler.Failure(r.Context(), account, ip))
+}
+
+// throttleSuccess forgets the failed attempts on account.
+func (o *options) throttleSuccess(r *http.Request, account, ip string) error {
+	if o.throttler == nil {
+		return nil
+	}
+	return errors.WithStack(o.throttler.Success(r.Context(), account, ip))
+}
+
+// throttledAccount returns the account throttled for attempts with login: the user it
+// identifies, so the email and username of a user share their failures and lockout, or login
+// itself, ignoring case and surrounding spaces like user lookups do, when it identifies none.
+func throttledAccount(ctx context.Context, users user.Service, login string) (string, error) {
+	u, err := users.FindByLogin(ctx, login)
+	if errors.Is(err, user.ErrNotFound) {
+		return "login:" + strings.ToLower(strings.TrimSpace(login)), nil
+	}
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return userAccount(u.ID), nil
+}
+
+// userAccount returns the account throttled for attempts on the user with id.
+func userAccount(id string) string {
+	return "user:" + id
+}
+
+// otpAccount returns the account throttled for second factor attempts of the user with id,
+// apart from passwords.
+func otpAccount(id string) string {
+	return "otp:" + id
+}
+
+// Config provides configs for the tokens issued by the login endpoints.
+type Config struct {
+	// ClientID is the first-party client tokens are issued to. It must be registered with the
+	// refresh_token grant for refresh tokens to be exchanged at the token endpoint.
+	ClientID string
+	// Scope is granted to the issued tokens.
+	Scope string
+}
+
+// Response is a successful response of the login endpoints.
+type Response struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope,omitempty"`
+	// RecoveryCodesRemaining is the number of unused recovery codes of users with a second
+	// factor, when recovery codes are configured.
+	RecoveryCodesRemaining *int `json:"recovery_codes_remaining,omitempty"`
+	// Warnings are messages to show the user, e.g. that few recovery codes are left.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// tokenIssuer issues the tokens of authenticated users.
+type tokenIssuer struct {
+	accessTokens  jwt.JWT
+	refreshTokens refreshtoken.Service
+	timegen       timegenerator.TimeGenerator
+	config        Config
+}
+
+// issue signs an access token and starts a refresh token family for u, authenticated with the
+// methods amr at authTime.
+func (i *tokenIssuer) issue(ctx context.Context, u *user.User, amr []string, authTime time.Time) (*Response, error) {
+	extra := user.Claims(u)
+	extra["client_id"] = i.config.ClientID
+	accessToken, expiry, err := i.accessTokens.SignClaims(ctx, &jwt.Claims{
+		Subject:  u.ID,
+		Scope:    i.config.Scope,
+		AMR:      amr,
+		AuthTime: authTime.Unix(),
+		Extra:    extra,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	refreshToken, err := i.refreshTokens.Issue(ctx, refreshtoken.Grant{
+		Subject:  u.ID,
+		ClientID: i.config.ClientID,
+		Scope:    i.config.Scope,
+		AMR:      amr,
+		AuthTime: authTime,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Response{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(expiry.Sub(i.timegen.Now()).Seconds()),
+		RefreshToken: refreshToken.Value,
+		Scope:        i.config.Scope,
+	}, nil
+}
+
+// checkStatus checks whether a user with a verified credential may log in.
+func checkStatus(u *user.User) error {
+	switch u.Status {
+	case user.StatusActive:
+		return nil
+	case user.StatusPending:
+		return httperror.New(http.StatusForbidden, ErrorCodeAccountPending, "account is not activated yet")
+	case user.StatusLocked:
+		return httperror.New(http.StatusForbidden, ErrorCodeAccountLocked, "account is locked")
+	default:
+		return httperror.New(http.StatusForbidden, ErrorCodeAccountDisabled, "account is disabled")
+	}
+}
+
+// newEvent creates the audit event of an attempt to log in with r.
+func newEvent(r *http.Request, eventType, login string, now time.Time) audit.Event {
+	return audit.Event{
+		Type:      eventType,
+		Login:     login,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Time:      now,
+	}
+}
+
+// clientIP returns the IP address of the peer of r. Headers set by proxies are ignored, since
+// clients can forge them; deployments behind a proxy must rewrite RemoteAddr.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
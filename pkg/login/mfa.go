@@ -0,0 +1,276 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/recoverycode"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/totp"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/pkg/errors"
+)
+
+// EventTypeOTP is the audit event type of second steps of logins with a TOTP code.
+const EventTypeOTP = "login.otp"
+
+// tokenUseChallenge marks challenge tokens, on top of their audience.
+const tokenUseChallenge = "mfa_challenge"
+
+// MFA configures the second factor of logins.
+type MFA struct {
+	// Factors verifies the TOTP codes of users who enrolled a factor.
+	Factors totp.Service
+	// Challenges signs and verifies challenge tokens. It must have an audience of its own, so
+	// challenges are never accepted as access tokens, and a short lifetime, e.g. 5 minutes.
+	Challenges jwt.JWT
+	// UsedChallenges records completed challenges, so each is completed once.
+	UsedChallenges jwt.RevocationStore
+	// RecoveryCodes, if set, lets users complete challenges with a recovery code at the
+	// recovery code endpoint instead.
+	RecoveryCodes recoverycode.Service
+}
+
+// WithMFA requires the second factor of users who enrolled one: the password endpoint
+// responds with a challenge instead of tokens, which the OTP endpoint completes.
+func WithMFA(config MFA) Option {
+	return func(o *options) {
+		o.mfa = &mfa{MFA: config}
+	}
+}
+
+// ChallengeResponse is the response of the password endpoint to users with a second factor.
+// MFAToken must be sent along with a code of one of MFAMethods within ExpiresIn seconds.
+type ChallengeResponse struct {
+	MFAToken   string   `json:"mfa_token"`
+	ExpiresIn  int64    `json:"expires_in"`
+	MFAMethods []string `json:"mfa_methods"`
+}
+
+type mfa struct {
+	MFA
+}
+
+// challenge returns a challenge for subject authenticated with a password at now, or nil when
+// subject has no second factor.
+func (m *mfa) challenge(ctx context.Context, subject string, now time.Time) (*ChallengeResponse, error) {
+	enrolled, err := m.Factors.Enrolled(ctx, subject)
+	if err != nil || !enrolled {
+		return nil, errors.WithStack(err)
+	}
+	token, expiry, err := m.Challenges.SignClaims(ctx, &jwt.Claims{
+		Subject:  subject,
+		AMR:      []string{AMRPassword},
+		AuthTime: now.Unix(),
+		Extra:    map[string]interface{}{"token_use": tokenUseChallenge},
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	methods := []string{AMROTP}
+	if m.RecoveryCodes != nil {
+		remaining, err := m.RecoveryCodes.Remaining(ctx, subject)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if remaining > 0 {
+			methods = append(methods, MFAMethodRecoveryCode)
+		}
+	}
+	return &ChallengeResponse{
+		MFAToken:   token,
+		ExpiresIn:  int64(expiry.Sub(now).Seconds()),
+		MFAMethods: methods,
+	}, nil
+}
+
+// invalidMFAToken is the error of challenges which are invalid, expired or used.
+func invalidMFAToken() *Error {
+	return httperror.New(http.StatusUnauthorized, ErrorCodeInvalidMFAToken, "mfa_token is invalid, expired or used")
+}
+
+// redeem parses a challenge token which is not used yet, or returns invalid_mfa_token.
+func (m *mfa) redeem(ctx context.Context, token string) (*jwt.Claims, error) {
+	invalid := invalidMFAToken()
+	claims, err := m.Challenges.ParseClaims(ctx, token, false)
+	var tokenErr *jwt.TokenError
+	if errors.As(err, &tokenErr) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if claims.Extra["token_use"] != tokenUseChallenge || claims.ID == "" {
+		return nil, invalid
+	}
+	used, err := m.UsedChallenges.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if used {
+		return nil, invalid
+	}
+	return claims, nil
+}
+
+// complete records a challenge as used before tokens are issued for it. It returns
+// invalid_mfa_token when the challenge was completed meanwhile, so of concurrent requests with
+// a challenge only one is issued tokens.
+func (m *mfa) complete(ctx context.Context, claims *jwt.Claims) error {
+	completed, err := m.UsedChallenges.RevokeOnce(ctx, claims.ID, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !completed {
+		return invalidMFAToken()
+	}
+	return nil
+}
+
+// warnRecoveryCodes adds the number of unused recovery codes of subject to response, with a
+// warning when few are left.
+func (m *mfa) warnRecoveryCodes(ctx context.Context, response *Response, subject string) error {
+	if m.RecoveryCodes == nil {
+		return nil
+	}
+	remaining, err := m.RecoveryCodes.Remaining(ctx, subject)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	addRecoveryCodes(response, remaining)
+	return nil
+}
+
+// OTPRequest is the JSON body of the OTP endpoint.
+type OTPRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type otpHandler struct {
+	options
+	users   user.Service
+	tokens  *tokenIssuer
+	audit   audit.Logger
+	timegen timegenerator.TimeGenerator
+	logger  *logger.Logger
+}
+
+// NewOTPHandler instantiates the OTP endpoint, which completes the challenge of an OTPRequest
+// with a TOTP code and responds with tokens recording the pwd and otp methods. WithMFA must be
+// given. Failed codes are throttled per user, apart from passwords. Every attempt is audited.
+func NewOTPHandler(users user.Service, accessTokens jwt.JWT, refreshTokens refreshtoken.Service,
+	auditLogger audit.Logger, timegen timegenerator.TimeGenerator, config Config, logger *logger.Logger,
+	options ...Option) http.Handler {
+	h := &otpHandler{
+		users: users,
+		tokens: &tokenIssuer{
+			accessTokens:  accessTokens,
+			refreshTokens: refreshTokens,
+			timegen:       timegen,
+			config:        config,
+		},
+		audit:   auditLogger,
+		timegen: timegen,
+		logger:  logger,
+	}
+	for _, option := range options {
+		option(&h.options)
+	}
+	return h
+}
+
+func (h *otpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	if h.mfa == nil {
+		writeError(w, errors.New("OTP endpoint is served without MFA"), h.logger)
+		return
+	}
+	var request OTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.MFAToken == "" || request.Code == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "mfa_token and code are required"), h.logger)
+		return
+	}
+
+	event := newEvent(r, EventTypeOTP, "", h.timegen.Now().UTC())
+	response, subject, err := h.login(r, request)
+	event.Subject = subject
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Reason = ErrorCodeServerError
+		var loginErr *Error
+		if errors.As(err, &loginErr) {
+			event.Reason = loginErr.Code
+		}
+		h.record(r, event)
+		writeError(w, err, h.logger)
+		return
+	}
+	event.Outcome = audit.OutcomeSuccess
+	h.record(r, event)
+	httperror.WriteJSON(w, http.StatusOK, response)
+}
+
+// login verifies the code of the challenged user and issues tokens. It returns the subject of
+// the challenge once verified.
+func (h *otpHandler) login(r *http.Request, request OTPRequest) (*Response, string, error) {
+	claims, err := h.mfa.redeem(r.Context(), request.MFAToken)
+	if err != nil {
+		return nil, "", err
+	}
+	account, ip := otpAccount(claims.Subject), clientIP(r)
+	if err := h.checkThrottle(r, account, ip); err != nil {
+		return nil, claims.Subject, err
+	}
+	u, err := h.users.FindByID(r.Context(), claims.Subject)
+	if errors.Is(err, user.ErrNotFound) {
+		return nil, claims.Subject, invalidMFAToken()
+	}
+	if err != nil {
+		return nil, claims.Subject, errors.WithStack(err)
+	}
+	if err := checkStatus(u); err != nil {
+		return nil, u.ID, err
+	}
+
+	err = h.mfa.Factors.Verify(r.Context(), u.ID, request.Code)
+	if errors.Is(err, totp.ErrInvalidCode) || errors.Is(err, totp.ErrNotFound) {
+		if err := h.throttleFailure(r, account, ip); err != nil {
+			return nil, u.ID, err
+		}
+		return nil, u.ID, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidCode, "code is invalid")
+	}
+	if err != nil {
+		return nil, u.ID, errors.WithStack(err)
+	}
+	if err := h.throttleSuccess(r, account, ip); err != nil {
+		return nil, u.ID, err
+	}
+	if err := h.mfa.complete(r.Context(), claims); err != nil {
+		return nil, u.ID, err
+	}
+	response, err := h.tokens.issue(r.Context(), u, []string{AMRPassword, AMROTP}, h.timegen.Now())
+	if err != nil {
+		return nil, u.ID, err
+	}
+	if err := h.mfa.warnRecoveryCodes(r.Context(), response, u.ID); err != nil {
+		return nil, u.ID, err
+	}
+	return response, u.ID, nil
+}
+
+func (h *otpHandler) record(r *http.Request, event audit.Event) {
+	if err := h.audit.Log(r.Context(), event); err != nil {
+		h.logger.WithField("err", err).Error("failed to audit login")
+	}
+}
@@ -0,0 +1,206 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/recoverycode"
+	"github.com/code-and-chill/auth-api/pkg/throttle"
+	"github.com/code-and-chill/auth-api/pkg/totp"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+)
+
+// mfaFixture is a fixture with MFA configured, where jane has a confirmed TOTP factor and
+// recovery codes.
+type mfaFixture struct {
+	*fixture
+	mfa           MFA
+	secret        []byte
+	recoveryCodes []string
+}
+
+func newMFAFixture(t *testing.T) *mfaFixture {
+	t.Helper()
+	f := newFixture(t)
+	challenges, err := jwttest.NewRS256(f.timegen, "https://auth.example.com", "https://auth.example.com/mfa", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	cipher, err := totp.NewAESGCM(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewAESGCM() error = %v", err)
+	}
+	factors := totp.NewService(totp.NewMemoryStore(), cipher, f.timegen, totp.DefaultConfig)
+	jane := f.register(t, "jane@example.com")
+	enrollment, err := factors.Enroll(context.Background(), jane.ID, jane.Email)
+	if err != nil {
+		t.Fatalf("Enroll() error = %v", err)
+	}
+	secret, _ := totp.DecodeSecret(enrollment.Secret)
+	codes := recoverycode.NewService(recoverycode.NewMemoryStore(), transaction.NewNoopProvider(), f.timegen,
+		recoverycode.DefaultConfig)
+	m := &mfaFixture{
+		fixture: f,
+		mfa: MFA{
+			Factors:        factors,
+			Challenges:     challenges,
+			UsedChallenges: jwt.NewMemoryRevocationStore(f.timegen),
+			RecoveryCodes:  codes,
+		},
+		secret: secret,
+	}
+	if err := factors.Confirm(context.Background(), jane.ID, m.code()); err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if m.recoveryCodes, err = codes.Generate(context.Background(), jane.ID); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	// Codes of the confirmation step are used, move on to the next one.
+	f.timegen.Add(totp.DefaultConfig.Period)
+	return m
+}
+
+func (m *mfaFixture) code() string {
+	return totp.GenerateCode(m.secret, totp.Step(m.timegen.Now(), totp.DefaultConfig.Period), totp.DefaultConfig.Digits)
+}
+
+// challenge logs jane in with her password and returns the mfa_token of the challenge.
+func (m *mfaFixture) challenge(t *testing.T) string {
+	t.Helper()
+	handler := NewPasswordHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger, WithMFA(m.mfa))
+	recorder, body := postJSON(t, handler, passwordBody("jane@example.com", testPassword))
+	if recorder.Code != http.StatusOK || body["mfa_token"] == nil || body["access_token"] != nil {
+		t.Fatalf("response = %d %v, want a challenge without tokens", recorder.Code, body)
+	}
+	return body["mfa_token"].(string)
+}
+
+// racingRevocationStore never reports tokens as revoked, as before concurrent requests revoke
+// them.
+type racingRevocationStore struct {
+	jwt.RevocationStore
+}
+
+func (racingRevocationStore) IsRevoked(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func otpBody(mfaToken, code string) string {
+	body, _ := json.Marshal(OTPRequest{MFAToken: mfaToken, Code: code})
+	return string(body)
+}
+
+func TestOTPHandler(t *testing.T) {
+	t.Run("Issues tokens recording the password and otp methods", func(t *testing.T) {
+		m := newMFAFixture(t)
+		mfaToken := m.challenge(t)
+		handler := NewOTPHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger, WithMFA(m.mfa))
+		recorder, body := postJSON(t, handler, otpBody(mfaToken, m.code()))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("response = %d %v, want tokens", recorder.Code, body)
+		}
+		claims, err := m.accessTokens.ParseClaims(context.Background(), body["access_token"].(string), false)
+		if err != nil {
+			t.Fatalf("ParseClaims() error = %v", err)
+		}
+		if len(claims.AMR) != 2 || claims.AMR[0] != AMRPassword || claims.AMR[1] != AMROTP {
+			t.Errorf("amr = %v, want [pwd otp]", claims.AMR)
+		}
+		events := m.audit.Events()
+		if len(events) != 2 || events[0].Outcome != audit.OutcomeChallenged ||
+			events[1].Type != EventTypeOTP || events[1].Outcome != audit.OutcomeSuccess || events[1].Subject != claims.Subject {
+			t.Errorf("events = %+v, want a challenged password step and a successful otp step", events)
+		}
+
+		m.timegen.Add(totp.DefaultConfig.Period)
+		recorder, body = postJSON(t, handler, otpBody(mfaToken, m.code()))
+		if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidMFAToken {
+			t.Errorf("response = %d %v, want a used challenge rejected", recorder.Code, body)
+		}
+	})
+
+	t.Run("Rejects challenges as access tokens", func(t *testing.T) {
+		m := newMFAFixture(t)
+		if _, err := m.accessTokens.ParseClaims(context.Background(), m.challenge(t), false); err == nil {
+			t.Error("ParseClaims() error = nil, want a challenge rejected as access token")
+		}
+	})
+
+	t.Run("Rejects tokens which are not challenges", func(t *testing.T) {
+		m := newMFAFixture(t)
+		token, _, err := m.mfa.Challenges.SignClaims(context.Background(), &jwt.Claims{Subject: "jane"})
+		if err != nil {
+			t.Fatalf("SignClaims() error = %v", err)
+		}
+		handler := NewOTPHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger, WithMFA(m.mfa))
+		recorder, body := postJSON(t, handler, otpBody(token, m.code()))
+		if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidMFAToken {
+			t.Errorf("response = %d %v, want invalid_mfa_token", recorder.Code, body)
+		}
+	})
+
+	t.Run("Completes a challenge once when requests race", func(t *testing.T) {
+		m := newMFAFixture(t)
+		mfaToken := m.challenge(t)
+		// Both requests find the challenge unused, as if they ran concurrently.
+		m.mfa.UsedChallenges = racingRevocationStore{m.mfa.UsedChallenges}
+		handler := NewOTPHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger, WithMFA(m.mfa))
+		if recorder, body := postJSON(t, handler, otpBody(mfaToken, m.code())); recorder.Code != http.StatusOK {
+			t.Fatalf("response = %d %v, want tokens", recorder.Code, body)
+		}
+		m.timegen.Add(totp.DefaultConfig.Period)
+		recorder, body := postJSON(t, handler, otpBody(mfaToken, m.code()))
+		if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidMFAToken {
+			t.Errorf("response = %d %v, want a completed challenge rejected", recorder.Code, body)
+		}
+	})
+
+	t.Run("Rejects an expired challenge", func(t *testing.T) {
+		m := newMFAFixture(t)
+		mfaToken := m.challenge(t)
+		m.timegen.Add(6 * time.Minute)
+		handler := NewOTPHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger, WithMFA(m.mfa))
+		recorder, body := postJSON(t, handler, otpBody(mfaToken, m.code()))
+		if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidMFAToken {
+			t.Errorf("response = %d %v, want invalid_mfa_token", recorder.Code, body)
+		}
+	})
+
+	t.Run("Throttles wrong codes", func(t *testing.T) {
+		m := newMFAFixture(t)
+		mfaToken := m.challenge(t)
+		throttler := throttle.NewThrottler(throttle.NewMemoryStore(), m.timegen, throttle.Config{
+			AccountIP: throttle.Policy{FreeFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Hour},
+			Window:    time.Hour,
+		})
+		handler := NewOTPHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger,
+			WithMFA(m.mfa), WithThrottler(throttler))
+		for i := 0; i < 3; i++ {
+			recorder, body := postJSON(t, handler, otpBody(mfaToken, "000000"))
+			if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidCode {
+				t.Fatalf("response = %d %v, want invalid_code", recorder.Code, body)
+			}
+		}
+		recorder, body := postJSON(t, handler, otpBody(mfaToken, m.code()))
+		if recorder.Code != http.StatusTooManyRequests || body["error"] != ErrorCodeTooManyAttempts {
+			t.Errorf("response = %d %v, want too_many_attempts", recorder.Code, body)
+		}
+	})
+}
+
+func TestPasswordHandler_WithoutSecondFactor(t *testing.T) {
+	m := newMFAFixture(t)
+	m.register(t, "john@example.com")
+	handler := NewPasswordHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger, WithMFA(m.mfa))
+	recorder, body := postJSON(t, handler, passwordBody("john@example.com", testPassword))
+	if recorder.Code != http.StatusOK || body["access_token"] == nil {
+		t.Errorf("response = %d %v, want tokens for a user without second factor", recorder.Code, body)
+	}
+}
@@ -0,0 +1,150 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/code-and-chill/auth-api/pkg/webauthn"
+	"github.com/pkg/errors"
+)
+
+// EventTypePasskey is the audit event type of logins with a WebAuthn credential.
+const EventTypePasskey = "login.passkey"
+
+type passkeyOptionsHandler struct {
+	relyingParty webauthn.RelyingParty
+	logger       *logger.Logger
+}
+
+// NewPasskeyOptionsHandler instantiates the endpoint beginning a passkey login, which responds
+// with a webauthn.OptionsResponse holding webauthn.RequestOptions. No credential is listed,
+// so any discoverable credential of the relying party is accepted and the endpoint tells
+// nothing about accounts.
+func NewPasskeyOptionsHandler(relyingParty webauthn.RelyingParty, logger *logger.Logger) http.Handler {
+	return &passkeyOptionsHandler{relyingParty: relyingParty, logger: logger}
+}
+
+func (h *passkeyOptionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	options, session, err := h.relyingParty.BeginLogin(r.Context(), "")
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, webauthn.OptionsResponse{PublicKey: options, Session: session})
+}
+
+// PasskeyRequest is the JSON body of the passkey endpoint.
+type PasskeyRequest struct {
+	Session    string                           `json:"session"`
+	Credential *webauthn.AuthenticationResponse `json:"credential"`
+}
+
+type passkeyHandler struct {
+	relyingParty webauthn.RelyingParty
+	users        user.Service
+	tokens       *tokenIssuer
+	audit        audit.Logger
+	timegen      timegenerator.TimeGenerator
+	logger       *logger.Logger
+}
+
+// NewPasskeyHandler instantiates the passkey endpoint, which verifies the assertion of a
+// PasskeyRequest and responds with tokens recording the hwk method. Every attempt is audited.
+func NewPasskeyHandler(relyingParty webauthn.RelyingParty, users user.Service, accessTokens jwt.JWT,
+	refreshTokens refreshtoken.Service, auditLogger audit.Logger, timegen timegenerator.TimeGenerator, config Config,
+	logger *logger.Logger) http.Handler {
+	return &passkeyHandler{
+		relyingParty: relyingParty,
+		users:        users,
+		tokens: &tokenIssuer{
+			accessTokens:  accessTokens,
+			refreshTokens: refreshTokens,
+			timegen:       timegen,
+			config:        config,
+		},
+		audit:   auditLogger,
+		timegen: timegen,
+		logger:  logger,
+	}
+}
+
+func (h *passkeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	var request PasskeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Session == "" || request.Credential == nil {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "session and credential are required"), h.logger)
+		return
+	}
+
+	event := newEvent(r, EventTypePasskey, "", h.timegen.Now().UTC())
+	response, subject, err := h.login(r, request)
+	event.Subject = subject
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Reason = ErrorCodeServerError
+		var loginErr *Error
+		if errors.As(err, &loginErr) {
+			event.Reason = loginErr.Code
+		}
+		h.record(r, event)
+		writeError(w, err, h.logger)
+		return
+	}
+	event.Outcome = audit.OutcomeSuccess
+	h.record(r, event)
+	httperror.WriteJSON(w, http.StatusOK, response)
+}
+
+// login verifies the assertion and issues tokens. It returns the owner of the credential once
+// verified.
+func (h *passkeyHandler) login(r *http.Request, request PasskeyRequest) (*Response, string, error) {
+	assertion, err := h.relyingParty.FinishLogin(r.Context(), request.Session, request.Credential)
+	var verificationErr *webauthn.VerificationError
+	switch {
+	case errors.Is(err, webauthn.ErrInvalidSession):
+		return nil, "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidSession, "session is invalid, expired or used")
+	case errors.Is(err, webauthn.ErrSignCount):
+		h.logger.WithField("credential_id", request.Credential.ID).Warn("webauthn sign count did not increase, the authenticator may be cloned")
+		return nil, "", httperror.New(http.StatusUnauthorized, ErrorCodeInvalidCredentials, "credential is invalid")
+	case errors.Is(err, webauthn.ErrNotFound), errors.As(err, &verificationErr):
+		return nil, "", httperror.New(http.StatusUnauthorized, ErrorCodeInvalidCredentials, "credential is invalid")
+	case err != nil:
+		return nil, "", errors.WithStack(err)
+	}
+	subject := assertion.Credential.UserID
+	u, err := h.users.FindByID(r.Context(), subject)
+	if errors.Is(err, user.ErrNotFound) {
+		return nil, subject, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidCredentials, "credential is invalid")
+	}
+	if err != nil {
+		return nil, subject, errors.WithStack(err)
+	}
+	if err := checkStatus(u); err != nil {
+		return nil, u.ID, err
+	}
+	response, err := h.tokens.issue(r.Context(), u, []string{AMRHardwareKey}, h.timegen.Now())
+	if err != nil {
+		return nil, u.ID, err
+	}
+	return response, u.ID, nil
+}
+
+func (h *passkeyHandler) record(r *http.Request, event audit.Event) {
+	if err := h.audit.Log(r.Context(), event); err != nil {
+		h.logger.WithField("err", err).Error("failed to audit login")
+	}
+}
@@ -0,0 +1,147 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/code-and-chill/auth-api/pkg/webauthn"
+	"github.com/code-and-chill/auth-api/pkg/webauthn/webauthntest"
+)
+
+// passkeyFixture is a fixture with a relying party, where jane registered a passkey of
+// authenticator.
+type passkeyFixture struct {
+	*fixture
+	relyingParty  webauthn.RelyingParty
+	authenticator *webauthntest.Authenticator
+	jane          *user.User
+}
+
+func newPasskeyFixture(t *testing.T) *passkeyFixture {
+	t.Helper()
+	f := newFixture(t)
+	sessions, err := jwttest.NewRS256(f.timegen, "https://auth.example.com", "https://auth.example.com/webauthn", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	relyingParty := webauthn.NewRelyingParty(webauthn.NewMemoryStore(), sessions, jwt.NewMemoryRevocationStore(f.timegen),
+		f.timegen, webauthn.Config{RPID: "example.com", RPName: "Example", Origins: []string{"https://login.example.com"}})
+	authenticator := webauthntest.NewAuthenticator("https://login.example.com")
+	jane := f.register(t, "jane@example.com")
+
+	options, session, err := relyingParty.BeginRegistration(context.Background(), webauthn.User{ID: jane.ID, Name: jane.Email})
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := relyingParty.FinishRegistration(context.Background(), jane.ID, session, response, "phone"); err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+	return &passkeyFixture{fixture: f, relyingParty: relyingParty, authenticator: authenticator, jane: jane}
+}
+
+func (p *passkeyFixture) passkeyHandler() http.Handler {
+	return NewPasskeyHandler(p.relyingParty, p.users, p.accessTokens, p.refreshTokens, p.audit, p.timegen, testConfig, p.logger)
+}
+
+// assert begins a login at the options endpoint and returns the body of the passkey endpoint
+// asserting with authenticator.
+func (p *passkeyFixture) assert(t *testing.T, authenticator *webauthntest.Authenticator) string {
+	t.Helper()
+	recorder, _ := postJSON(t, NewPasskeyOptionsHandler(p.relyingParty, p.logger), "")
+	var options struct {
+		PublicKey webauthn.RequestOptions `json:"publicKey"`
+		Session   string                  `json:"session"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &options); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("options = %d %s, want request options", recorder.Code, recorder.Body.String())
+	}
+	if len(options.PublicKey.AllowCredentials) != 0 {
+		t.Errorf("allowCredentials = %v, want none", options.PublicKey.AllowCredentials)
+	}
+	response, err := authenticator.Get(&options.PublicKey)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	body, _ := json.Marshal(PasskeyRequest{Session: options.Session, Credential: response})
+	return string(body)
+}
+
+func TestPasskeyHandler(t *testing.T) {
+	t.Run("Issues tokens recording the hwk method", func(t *testing.T) {
+		p := newPasskeyFixture(t)
+		recorder, body := postJSON(t, p.passkeyHandler(), p.assert(t, p.authenticator))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("response = %d %v, want tokens", recorder.Code, body)
+		}
+		claims, err := p.accessTokens.ParseClaims(context.Background(), body["access_token"].(string), false)
+		if err != nil {
+			t.Fatalf("ParseClaims() error = %v", err)
+		}
+		if claims.Subject != p.jane.ID || len(claims.AMR) != 1 || claims.AMR[0] != AMRHardwareKey {
+			t.Errorf("claims = %+v, want sub %s and amr [hwk]", claims, p.jane.ID)
+		}
+		events := p.audit.Events()
+		if len(events) != 1 || events[0].Type != EventTypePasskey || events[0].Outcome != audit.OutcomeSuccess ||
+			events[0].Subject != p.jane.ID {
+			t.Errorf("events = %+v, want a successful passkey login of jane", events)
+		}
+	})
+
+	t.Run("Rejects a replayed assertion", func(t *testing.T) {
+		p := newPasskeyFixture(t)
+		body := p.assert(t, p.authenticator)
+		if recorder, response := postJSON(t, p.passkeyHandler(), body); recorder.Code != http.StatusOK {
+			t.Fatalf("response = %d %v, want tokens", recorder.Code, response)
+		}
+		recorder, response := postJSON(t, p.passkeyHandler(), body)
+		if recorder.Code != http.StatusBadRequest || response["error"] != ErrorCodeInvalidSession {
+			t.Errorf("response = %d %v, want invalid_session", recorder.Code, response)
+		}
+	})
+
+	t.Run("Rejects a cloned authenticator", func(t *testing.T) {
+		p := newPasskeyFixture(t)
+		clone := p.authenticator.Clone()
+		if recorder, response := postJSON(t, p.passkeyHandler(), p.assert(t, p.authenticator)); recorder.Code != http.StatusOK {
+			t.Fatalf("response = %d %v, want tokens", recorder.Code, response)
+		}
+		recorder, response := postJSON(t, p.passkeyHandler(), p.assert(t, clone))
+		if recorder.Code != http.StatusUnauthorized || response["error"] != ErrorCodeInvalidCredentials {
+			t.Errorf("response = %d %v, want invalid_credentials", recorder.Code, response)
+		}
+		events := p.audit.Events()
+		if last := events[len(events)-1]; last.Outcome != audit.OutcomeFailure || last.Reason != ErrorCodeInvalidCredentials {
+			t.Errorf("event = %+v, want an invalid_credentials failure", last)
+		}
+	})
+
+	t.Run("Rejects locked users", func(t *testing.T) {
+		p := newPasskeyFixture(t)
+		if _, err := p.users.SetStatus(context.Background(), p.jane.ID, user.StatusLocked); err != nil {
+			t.Fatalf("SetStatus() error = %v", err)
+		}
+		recorder, response := postJSON(t, p.passkeyHandler(), p.assert(t, p.authenticator))
+		if recorder.Code != http.StatusForbidden || response["error"] != ErrorCodeAccountLocked {
+			t.Errorf("response = %d %v, want account_locked", recorder.Code, response)
+		}
+	})
+
+	t.Run("Rejects a request without credential", func(t *testing.T) {
+		p := newPasskeyFixture(t)
+		recorder, response := postJSON(t, p.passkeyHandler(), `{"session":"x"}`)
+		if recorder.Code != http.StatusBadRequest || response["error"] != ErrorCodeInvalidRequest {
+			t.Errorf("response = %d %v, want invalid_request", recorder.Code, response)
+		}
+	})
+}
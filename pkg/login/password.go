@@ -0,0 +1,140 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/pkg/errors"
+)
+
+// EventTypePassword is the audit event type of password logins.
+const EventTypePassword = "login.password"
+
+// PasswordRequest is the JSON body of the password login endpoint. Login is an email or a
+// username.
+type PasswordRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type passwordHandler struct {
+	options
+	users   user.Service
+	tokens  *tokenIssuer
+	audit   audit.Logger
+	timegen timegenerator.TimeGenerator
+	logger  *logger.Logger
+}
+
+// NewPasswordHandler instantiates the password login endpoint, which verifies a
+// PasswordRequest and responds with tokens recording the pwd method, or with a
+// ChallengeResponse when the user has a second factor and MFA is configured. Every attempt is
+// audited.
+func NewPasswordHandler(users user.Service, accessTokens jwt.JWT, refreshTokens refreshtoken.Service,
+	auditLogger audit.Logger, timegen timegenerator.TimeGenerator, config Config, logger *logger.Logger,
+	options ...Option) http.Handler {
+	h := &passwordHandler{
+		users: users,
+		tokens: &tokenIssuer{
+			accessTokens:  accessTokens,
+			refreshTokens: refreshTokens,
+			timegen:       timegen,
+			config:        config,
+		},
+		audit:   auditLogger,
+		timegen: timegen,
+		logger:  logger,
+	}
+	for _, option := range options {
+		option(&h.options)
+	}
+	return h
+}
+
+func (h *passwordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	var request PasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Login == "" || request.Password == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "login and password are required"), h.logger)
+		return
+	}
+
+	now := h.timegen.Now().UTC()
+	event := newEvent(r, EventTypePassword, request.Login, now)
+	response, subject, err := h.login(r, request)
+	event.Subject = subject
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Reason = ErrorCodeServerError
+		var loginErr *Error
+		if errors.As(err, &loginErr) {
+			event.Reason = loginErr.Code
+		}
+		h.record(r, event)
+		writeError(w, err, h.logger)
+		return
+	}
+	event.Outcome = audit.OutcomeSuccess
+	if _, ok := response.(*ChallengeResponse); ok {
+		event.Outcome = audit.OutcomeChallenged
+	}
+	h.record(r, event)
+	httperror.WriteJSON(w, http.StatusOK, response)
+}
+
+// login authenticates request and issues tokens, or a challenge for the second factor. It
+// returns the subject of the user whose password was verified, even when the login fails
+// afterwards.
+func (h *passwordHandler) login(r *http.Request, request PasswordRequest) (interface{}, string, error) {
+	account, err := throttledAccount(r.Context(), h.users, request.Login)
+	if err != nil {
+		return nil, "", err
+	}
+	ip := clientIP(r)
+	if err := h.checkThrottle(r, account, ip); err != nil {
+		return nil, "", err
+	}
+	u, err := h.users.Authenticate(r.Context(), request.Login, request.Password)
+	if errors.Is(err, user.ErrInvalidCredentials) {
+		if err := h.throttleFailure(r, account, ip); err != nil {
+			return nil, "", err
+		}
+		return nil, "", httperror.New(http.StatusUnauthorized, ErrorCodeInvalidCredentials, "login or password is invalid")
+	}
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	if err := h.throttleSuccess(r, account, ip); err != nil {
+		return nil, u.ID, err
+	}
+	if err := checkStatus(u); err != nil {
+		return nil, u.ID, err
+	}
+	if h.mfa != nil {
+		challenge, err := h.mfa.challenge(r.Context(), u.ID, h.timegen.Now().UTC())
+		if err != nil || challenge != nil {
+			return challenge, u.ID, err
+		}
+	}
+	response, err := h.tokens.issue(r.Context(), u, []string{AMRPassword}, h.timegen.Now())
+	if err != nil {
+		return nil, u.ID, err
+	}
+	return response, u.ID, nil
+}
+
+func (h *passwordHandler) record(r *http.Request, event audit.Event) {
+	if err := h.audit.Log(r.Context(), event); err != nil {
+		h.logger.WithField("err", err).Error("failed to audit login")
+	}
+}
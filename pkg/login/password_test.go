@@ -0,0 +1,341 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/password"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/throttle"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+	"github.com/code-and-chill/auth-api/pkg/user"
+)
+
+const testPassword = "correct horse battery staple"
+
+var testConfig = Config{ClientID: "web", Scope: "profile"}
+
+type fixture struct {
+	timegen       *timegenerator.FakeTimeGenerator
+	users         user.Service
+	accessTokens  jwt.JWT
+	refreshTokens refreshtoken.Service
+	audit         *audit.MemoryLogger
+	logger        *logger.Logger
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	accessTokens, err := jwttest.NewRS256(timegen, "https://auth.example.com", "api", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	hasher := password.NewHasher(password.WithArgon2Params(password.Argon2Params{
+		Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+	}))
+	return &fixture{
+		timegen:      timegen,
+		users:        user.NewService(user.NewMemoryStore(), transaction.NewNoopProvider(), hasher, timegen),
+		accessTokens: accessTokens,
+		refreshTokens: refreshtoken.NewService(refreshtoken.NewMemoryStore(), transaction.NewNoopProvider(),
+			accessTokens, timegen, refreshtoken.Config{SlidingLifetime: time.Hour, AbsoluteLifetime: 24 * time.Hour}),
+		audit:  audit.NewMemoryLogger(),
+		logger: logger.NewNoopLogger(),
+	}
+}
+
+func (f *fixture) register(t *testing.T, email string) *user.User {
+	t.Helper()
+	u, err := f.users.Register(context.Background(), user.Registration{Email: email, Password: testPassword})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	return u
+}
+
+func (f *fixture) passwordHandler() http.Handler {
+	return NewPasswordHandler(f.users, f.accessTokens, f.refreshTokens, f.audit, f.timegen, testConfig, f.logger)
+}
+
+func postJSON(t *testing.T, handler http.Handler, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "login-test")
+	req.RemoteAddr = "203.0.113.7:51000"
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, body = %s", err, recorder.Body.String())
+	}
+	return recorder, response
+}
+
+func passwordBody(login, password string) string {
+	body, _ := json.Marshal(PasswordRequest{Login: login, Password: password})
+	return string(body)
+}
+
+func TestPasswordHandler(t *testing.T) {
+	t.Run("Issues tokens recording the password method", func(t *testing.T) {
+		f := newFixture(t)
+		jane := f.register(t, "jane@example.com")
+		recorder, body := postJSON(t, f.passwordHandler(), passwordBody("Jane@Example.com", testPassword))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %v", recorder.Code, body)
+		}
+		if body["token_type"] != "Bearer" || body["expires_in"] != float64(300) || body["scope"] != "profile" {
+			t.Errorf("body = %v, want a Bearer token for 300 seconds with scope profile", body)
+		}
+		claims, err := f.accessTokens.ParseClaims(context.Background(), body["access_token"].(string), false)
+		if err != nil {
+			t.Fatalf("ParseClaims() error = %v", err)
+		}
+		if claims.Subject != jane.ID || len(claims.AMR) != 1 || claims.AMR[0] != AMRPassword ||
+			claims.AuthTime != f.timegen.Now().Unix() || claims.Extra["client_id"] != "web" ||
+			claims.Extra[user.ClaimEmailVerified] != false {
+			t.Errorf("claims = %+v, want sub %s, amr [pwd], auth_time now, client_id web and an unverified email",
+				claims, jane.ID)
+		}
+		refreshToken, err := f.refreshTokens.Lookup(context.Background(), body["refresh_token"].(string))
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if refreshToken.Subject != jane.ID || refreshToken.ClientID != "web" || refreshToken.AMR != AMRPassword {
+			t.Errorf("refresh token = %+v, want one of jane for web with amr pwd", refreshToken)
+		}
+
+		events := f.audit.Events()
+		want := audit.Event{
+			Type:      EventTypePassword,
+			Outcome:   audit.OutcomeSuccess,
+			Subject:   jane.ID,
+			Login:     "Jane@Example.com",
+			IP:        "203.0.113.7",
+			UserAgent: "login-test",
+			Time:      f.timegen.Now(),
+		}
+		if len(events) != 1 || events[0] != want {
+			t.Errorf("events = %+v, want %+v", events, want)
+		}
+	})
+
+	t.Run("Responds the same to unknown users and wrong passwords", func(t *testing.T) {
+		f := newFixture(t)
+		f.register(t, "jane@example.com")
+		handler := f.passwordHandler()
+		wrongRecorder, wrongBody := postJSON(t, handler, passwordBody("jane@example.com", "wrong"))
+		unknownRecorder, unknownBody := postJSON(t, handler, passwordBody("john@example.com", testPassword))
+		if wrongRecorder.Code != http.StatusUnauthorized || wrongBody["error"] != ErrorCodeInvalidCredentials {
+			t.Errorf("wrong password = %d %v, want invalid_credentials", wrongRecorder.Code, wrongBody)
+		}
+		if unknownRecorder.Code != wrongRecorder.Code || unknownRecorder.Body.String() != wrongRecorder.Body.String() {
+			t.Errorf("unknown user = %d %v, want the response to a wrong password", unknownRecorder.Code, unknownBody)
+		}
+		for _, event := range f.audit.Events() {
+			if event.Outcome != audit.OutcomeFailure || event.Reason != ErrorCodeInvalidCredentials || event.Subject != "" {
+				t.Errorf("event = %+v, want an invalid_credentials failure without subject", event)
+			}
+		}
+	})
+
+	statuses := []struct {
+		status    user.Status
+		wantError string
+	}{
+		{status: user.StatusLocked, wantError: ErrorCodeAccountLocked},
+		{status: user.StatusDisabled, wantError: ErrorCodeAccountDisabled},
+	}
+	for _, tt := range statuses {
+		t.Run("Rejects "+string(tt.status)+" users", func(t *testing.T) {
+			f := newFixture(t)
+			jane := f.register(t, "jane@example.com")
+			if _, err := f.users.SetStatus(context.Background(), jane.ID, tt.status); err != nil {
+				t.Fatalf("SetStatus() error = %v", err)
+			}
+			recorder, body := postJSON(t, f.passwordHandler(), passwordBody("jane@example.com", testPassword))
+			if recorder.Code != http.StatusForbidden || body["error"] != tt.wantError {
+				t.Errorf("response = %d %v, want %s", recorder.Code, body, tt.wantError)
+			}
+			events := f.audit.Events()
+			if len(events) != 1 || events[0].Reason != tt.wantError || events[0].Subject != jane.ID {
+				t.Errorf("events = %+v, want a %s failure of jane", events, tt.wantError)
+			}
+		})
+	}
+
+	t.Run("Treats deleted users as unknown", func(t *testing.T) {
+		f := newFixture(t)
+		jane := f.register(t, "jane@example.com")
+		if _, err := f.users.SetStatus(context.Background(), jane.ID, user.StatusDeleted); err != nil {
+			t.Fatalf("SetStatus() error = %v", err)
+		}
+		recorder, body := postJSON(t, f.passwordHandler(), passwordBody("jane@example.com", testPassword))
+		if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidCredentials {
+			t.Errorf("response = %d %v, want invalid_credentials", recorder.Code, body)
+		}
+	})
+
+	t.Run("Rejects a request without password", func(t *testing.T) {
+		f := newFixture(t)
+		recorder, body := postJSON(t, f.passwordHandler(), `{"login":"jane@example.com"}`)
+		if recorder.Code != http.StatusBadRequest || body["error"] != ErrorCodeInvalidRequest {
+			t.Errorf("response = %d %v, want invalid_request", recorder.Code, body)
+		}
+	})
+}
+
+func TestPasswordHandler_Throttling(t *testing.T) {
+	config := throttle.Config{
+		Account:   throttle.Policy{LockoutThreshold: 3, LockoutDuration: 15 * time.Minute},
+		AccountIP: throttle.Policy{FreeFailures: 1, BaseDelay: 2 * time.Second, MaxDelay: time.Minute},
+		Window:    time.Hour,
+	}
+
+	t.Run("Delays attempts after failures without verifying the password", func(t *testing.T) {
+		f := newFixture(t)
+		f.register(t, "jane@example.com")
+		handler := NewPasswordHandler(f.users, f.accessTokens, f.refreshTokens, f.audit, f.timegen, testConfig, f.logger,
+			WithThrottler(throttle.NewThrottler(throttle.NewMemoryStore(), f.timegen, config)))
+		postJSON(t, handler, passwordBody("jane@example.com", "wrong"))
+		postJSON(t, handler, passwordBody("JANE@example.com", "wrong"))
+		recorder, body := postJSON(t, handler, passwordBody("jane@example.com", testPassword))
+		if recorder.Code != http.StatusTooManyRequests || body["error"] != ErrorCodeTooManyAttempts ||
+			recorder.Header().Get("Retry-After") != "2" {
+			t.Fatalf("response = %d %v %v, want too_many_attempts retrying after 2s", recorder.Code, body, recorder.Header())
+		}
+		events := f.audit.Events()
+		if last := events[len(events)-1]; last.Reason != ErrorCodeTooManyAttempts {
+			t.Errorf("last event = %+v, want a too_many_attempts failure", last)
+		}
+
+		f.timegen.Add(2 * time.Second)
+		recorder, body = postJSON(t, handler, passwordBody("jane@example.com", testPassword))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("response = %d %v once the delay passed, want tokens", recorder.Code, body)
+		}
+	})
+
+	t.Run("Counts the failures of every login of a user together", func(t *testing.T) {
+		f := newFixture(t)
+		if _, err := f.users.Register(context.Background(), user.Registration{
+			Email: "jane@example.com", Username: "jane", Password: testPassword,
+		}); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		handler := NewPasswordHandler(f.users, f.accessTokens, f.refreshTokens, f.audit, f.timegen, testConfig, f.logger,
+			WithThrottler(throttle.NewThrottler(throttle.NewMemoryStore(), f.timegen, config)))
+		for _, login := range []string{"jane@example.com", "jane", "jane@example.com"} {
+			f.timegen.Add(time.Minute)
+			postJSON(t, handler, passwordBody(login, "wrong"))
+		}
+		for _, login := range []string{"jane@example.com", "jane"} {
+			recorder, body := postJSON(t, handler, passwordBody(login, testPassword))
+			if recorder.Code != http.StatusTooManyRequests || body["error"] != ErrorCodeAccountLocked {
+				t.Errorf("response for %s = %d %v, want account_locked", login, recorder.Code, body)
+			}
+		}
+	})
+
+	t.Run("Locks an account until an administrator unlocks it", func(t *testing.T) {
+		f := newFixture(t)
+		jane := f.register(t, "jane@example.com")
+		throttler := throttle.NewThrottler(throttle.NewMemoryStore(), f.timegen, config)
+		handler := NewPasswordHandler(f.users, f.accessTokens, f.refreshTokens, f.audit, f.timegen, testConfig, f.logger,
+			WithThrottler(throttler))
+		for i := 0; i < 3; i++ {
+			f.timegen.Add(time.Minute)
+			postJSON(t, handler, passwordBody("jane@example.com", "wrong"))
+		}
+		recorder, body := postJSON(t, handler, passwordBody("jane@example.com", testPassword))
+		if recorder.Code != http.StatusTooManyRequests || body["error"] != ErrorCodeAccountLocked ||
+			recorder.Header().Get("Retry-After") != "900" {
+			t.Fatalf("response = %d %v %v, want account_locked retrying after 900s", recorder.Code, body, recorder.Header())
+		}
+
+		unlock := NewUnlockHandler(f.users, throttler, f.audit, f.timegen, f.logger)
+		recorder, body = postJSON(t, unlock, `{"user_id":"`+jane.ID+`"}`)
+		if recorder.Code != http.StatusOK || body["id"] != jane.ID {
+			t.Fatalf("unlock response = %d %v, want jane", recorder.Code, body)
+		}
+		events := f.audit.Events()
+		if last := events[len(events)-1]; last.Type != EventTypeUnlock || last.Subject != jane.ID {
+			t.Errorf("last event = %+v, want an unlock of jane", last)
+		}
+		// The delay of the account and IP pair still applies, but not the 15 minute lockout.
+		f.timegen.Add(time.Minute)
+		recorder, body = postJSON(t, handler, passwordBody("jane@example.com", testPassword))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("response = %d %v after the unlock, want tokens", recorder.Code, body)
+		}
+	})
+}
+
+func TestUnlockHandler(t *testing.T) {
+	t.Run("Activates locked users", func(t *testing.T) {
+		f := newFixture(t)
+		jane := f.register(t, "jane@example.com")
+		if _, err := f.users.SetStatus(context.Background(), jane.ID, user.StatusLocked); err != nil {
+			t.Fatalf("SetStatus() error = %v", err)
+		}
+		unlock := NewUnlockHandler(f.users, throttle.NewThrottler(throttle.NewMemoryStore(), f.timegen, throttle.DefaultConfig),
+			f.audit, f.timegen, f.logger)
+		recorder, body := postJSON(t, unlock, `{"user_id":"`+jane.ID+`"}`)
+		if recorder.Code != http.StatusOK || body["status"] != string(user.StatusActive) {
+			t.Errorf("response = %d %v, want jane active", recorder.Code, body)
+		}
+	})
+
+	t.Run("Unlocks the second factor of users", func(t *testing.T) {
+		m := newMFAFixture(t)
+		mfaToken := m.challenge(t)
+		throttler := throttle.NewThrottler(throttle.NewMemoryStore(), m.timegen, throttle.Config{
+			Account: throttle.Policy{LockoutThreshold: 3, LockoutDuration: 15 * time.Minute},
+			Window:  time.Hour,
+		})
+		handler := NewOTPHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger,
+			WithMFA(m.mfa), WithThrottler(throttler))
+		for i := 0; i < 3; i++ {
+			postJSON(t, handler, otpBody(mfaToken, "000000"))
+		}
+		recorder, body := postJSON(t, handler, otpBody(mfaToken, m.code()))
+		if recorder.Code != http.StatusTooManyRequests || body["error"] != ErrorCodeAccountLocked {
+			t.Fatalf("response = %d %v, want account_locked", recorder.Code, body)
+		}
+
+		jane, err := m.users.FindByLogin(context.Background(), "jane@example.com")
+		if err != nil {
+			t.Fatalf("FindByLogin() error = %v", err)
+		}
+		unlock := NewUnlockHandler(m.users, throttler, m.audit, m.timegen, m.logger)
+		if recorder, body := postJSON(t, unlock, `{"user_id":"`+jane.ID+`"}`); recorder.Code != http.StatusOK {
+			t.Fatalf("unlock response = %d %v, want jane", recorder.Code, body)
+		}
+		recorder, body = postJSON(t, handler, otpBody(mfaToken, m.code()))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("response = %d %v after the unlock, want tokens", recorder.Code, body)
+		}
+	})
+
+	t.Run("Rejects unknown users", func(t *testing.T) {
+		f := newFixture(t)
+		unlock := NewUnlockHandler(f.users, throttle.NewThrottler(throttle.NewMemoryStore(), f.timegen, throttle.DefaultConfig),
+			f.audit, f.timegen, f.logger)
+		recorder, body := postJSON(t, unlock, `{"user_id":"unknown"}`)
+		if recorder.Code != http.StatusNotFound || body["error"] != ErrorCodeNotFound {
+			t.Errorf("response = %d %v, want not_found", recorder.Code, body)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/recoverycode"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/pkg/errors"
+)
+
+// EventTypeRecoveryCode is the audit event type of second steps of logins with a recovery code.
+const EventTypeRecoveryCode = "login.recovery_code"
+
+// MFAMethodRecoveryCode is listed by challenges which recovery codes may complete.
+const MFAMethodRecoveryCode = "recovery_code"
+
+// lowRecoveryCodes is the number of unused recovery codes from which users are warned to
+// generate new ones.
+const lowRecoveryCodes = 3
+
+// addRecoveryCodes adds the number of unused recovery codes to response, with a warning when
+// few are left.
+func addRecoveryCodes(response *Response, remaining int) {
+	response.RecoveryCodesRemaining = &remaining
+	switch {
+	case remaining == 0:
+		response.Warnings = append(response.Warnings,
+			"no recovery code is left, generate new ones to keep access if you lose your authenticator")
+	case remaining <= lowRecoveryCodes:
+		response.Warnings = append(response.Warnings, "few recovery codes are left, consider generating new ones")
+	}
+}
+
+// RecoveryCodeRequest is the JSON body of the recovery code endpoint.
+type RecoveryCodeRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type recoveryCodeHandler struct {
+	options
+	users   user.Service
+	tokens  *tokenIssuer
+	audit   audit.Logger
+	timegen timegenerator.TimeGenerator
+	logger  *logger.Logger
+}
+
+// NewRecoveryCodeHandler instantiates the recovery code endpoint, which completes the challenge
+// of a RecoveryCodeRequest with a recovery code instead of a TOTP code. It responds with tokens
+// recording the pwd and otp methods, and the number of codes left. WithMFA must be given, with
+// RecoveryCodes. Failed codes are throttled along with TOTP codes. Every attempt is audited.
+func NewRecoveryCodeHandler(users user.Service, accessTokens jwt.JWT, refreshTokens refreshtoken.Service,
+	auditLogger audit.Logger, timegen timegenerator.TimeGenerator, config Config, logger *logger.Logger,
+	options ...Option) http.Handler {
+	h := &recoveryCodeHandler{
+		users: users,
+		tokens: &tokenIssuer{
+			accessTokens:  accessTokens,
+			refreshTokens: refreshTokens,
+			timegen:       timegen,
+			config:        config,
+		},
+		audit:   auditLogger,
+		timegen: timegen,
+		logger:  logger,
+	}
+	for _, option := range options {
+		option(&h.options)
+	}
+	return h
+}
+
+func (h *recoveryCodeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	if h.mfa == nil || h.mfa.RecoveryCodes == nil {
+		writeError(w, errors.New("recovery code endpoint is served without recovery codes"), h.logger)
+		return
+	}
+	var request RecoveryCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.MFAToken == "" || request.RecoveryCode == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "mfa_token and recovery_code are required"), h.logger)
+		return
+	}
+
+	event := newEvent(r, EventTypeRecoveryCode, "", h.timegen.Now().UTC())
+	response, subject, err := h.login(r, request)
+	event.Subject = subject
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Reason = ErrorCodeServerError
+		var loginErr *Error
+		if errors.As(err, &loginErr) {
+			event.Reason = loginErr.Code
+		}
+		h.record(r, event)
+		writeError(w, err, h.logger)
+		return
+	}
+	event.Outcome = audit.OutcomeSuccess
+	h.record(r, event)
+	httperror.WriteJSON(w, http.StatusOK, response)
+}
+
+// login redeems the recovery code of the challenged user and issues tokens. It returns the
+// subject of the challenge once verified.
+func (h *recoveryCodeHandler) login(r *http.Request, request RecoveryCodeRequest) (*Response, string, error) {
+	claims, err := h.mfa.redeem(r.Context(), request.MFAToken)
+	if err != nil {
+		return nil, "", err
+	}
+	// The account is the one of TOTP codes, so guesses of both factors add up.
+	account, ip := otpAccount(claims.Subject), clientIP(r)
+	if err := h.checkThrottle(r, account, ip); err != nil {
+		return nil, claims.Subject, err
+	}
+	u, err := h.users.FindByID(r.Context(), claims.Subject)
+	if errors.Is(err, user.ErrNotFound) {
+		return nil, claims.Subject, invalidMFAToken()
+	}
+	if err != nil {
+		return nil, claims.Subject, errors.WithStack(err)
+	}
+	if err := checkStatus(u); err != nil {
+		return nil, u.ID, err
+	}
+
+	remaining, err := h.mfa.RecoveryCodes.Redeem(r.Context(), u.ID, request.RecoveryCode)
+	if errors.Is(err, recoverycode.ErrInvalidCode) {
+		if err := h.throttleFailure(r, account, ip); err != nil {
+			return nil, u.ID, err
+		}
+		return nil, u.ID, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidCode, "recovery_code is invalid")
+	}
+	if err != nil {
+		return nil, u.ID, errors.WithStack(err)
+	}
+	if err := h.throttleSuccess(r, account, ip); err != nil {
+		return nil, u.ID, err
+	}
+	if err := h.mfa.complete(r.Context(), claims); err != nil {
+		return nil, u.ID, err
+	}
+	// Recovery codes are one-time passwords in the sense of RFC 8176.
+	response, err := h.tokens.issue(r.Context(), u, []string{AMRPassword, AMROTP}, h.timegen.Now())
+	if err != nil {
+		return nil, u.ID, err
+	}
+	addRecoveryCodes(response, remaining)
+	return response, u.ID, nil
+}
+
+func (h *recoveryCodeHandler) record(r *http.Request, event audit.Event) {
+	if err := h.audit.Log(r.Context(), event); err != nil {
+		h.logger.WithField("err", err).Error("failed to audit login")
+	}
+}
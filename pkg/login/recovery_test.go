@@ -0,0 +1,88 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+)
+
+func recoveryCodeBody(mfaToken, code string) string {
+	body, _ := json.Marshal(RecoveryCodeRequest{MFAToken: mfaToken, RecoveryCode: code})
+	return string(body)
+}
+
+func (m *mfaFixture) recoveryCodeHandler() http.Handler {
+	return NewRecoveryCodeHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger,
+		WithMFA(m.mfa))
+}
+
+func TestRecoveryCodeHandler(t *testing.T) {
+	t.Run("Issues tokens with the number of codes left", func(t *testing.T) {
+		m := newMFAFixture(t)
+		recorder, body := postJSON(t, m.recoveryCodeHandler(), recoveryCodeBody(m.challenge(t), m.recoveryCodes[0]))
+		if recorder.Code != http.StatusOK || body["access_token"] == nil {
+			t.Fatalf("response = %d %v, want tokens", recorder.Code, body)
+		}
+		if body["recovery_codes_remaining"] != float64(len(m.recoveryCodes)-1) || body["warnings"] != nil {
+			t.Errorf("body = %v, want %d codes left without warning", body, len(m.recoveryCodes)-1)
+		}
+		claims, err := m.accessTokens.ParseClaims(context.Background(), body["access_token"].(string), false)
+		if err != nil {
+			t.Fatalf("ParseClaims() error = %v", err)
+		}
+		if len(claims.AMR) != 2 || claims.AMR[0] != AMRPassword || claims.AMR[1] != AMROTP {
+			t.Errorf("amr = %v, want [pwd otp]", claims.AMR)
+		}
+		events := m.audit.Events()
+		if last := events[len(events)-1]; last.Type != EventTypeRecoveryCode || last.Outcome != audit.OutcomeSuccess ||
+			last.Subject != claims.Subject {
+			t.Errorf("event = %+v, want a successful recovery code step", last)
+		}
+	})
+
+	t.Run("Rejects a used code", func(t *testing.T) {
+		m := newMFAFixture(t)
+		recorder, body := postJSON(t, m.recoveryCodeHandler(), recoveryCodeBody(m.challenge(t), m.recoveryCodes[0]))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("response = %d %v, want tokens", recorder.Code, body)
+		}
+		recorder, body = postJSON(t, m.recoveryCodeHandler(), recoveryCodeBody(m.challenge(t), m.recoveryCodes[0]))
+		if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidCode {
+			t.Errorf("response = %d %v, want invalid_code", recorder.Code, body)
+		}
+		events := m.audit.Events()
+		if last := events[len(events)-1]; last.Outcome != audit.OutcomeFailure || last.Reason != ErrorCodeInvalidCode {
+			t.Errorf("event = %+v, want an invalid_code failure", last)
+		}
+	})
+
+	t.Run("Warns when few codes are left", func(t *testing.T) {
+		m := newMFAFixture(t)
+		var body map[string]interface{}
+		for _, code := range m.recoveryCodes[:len(m.recoveryCodes)-lowRecoveryCodes] {
+			var recorder *httptest.ResponseRecorder
+			recorder, body = postJSON(t, m.recoveryCodeHandler(), recoveryCodeBody(m.challenge(t), code))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("response = %d %v, want tokens", recorder.Code, body)
+			}
+		}
+		warnings, _ := body["warnings"].([]interface{})
+		if body["recovery_codes_remaining"] != float64(lowRecoveryCodes) || len(warnings) != 1 {
+			t.Errorf("body = %v, want %d codes left and a warning", body, lowRecoveryCodes)
+		}
+	})
+
+	t.Run("Lists recovery codes in challenges", func(t *testing.T) {
+		m := newMFAFixture(t)
+		handler := NewPasswordHandler(m.users, m.accessTokens, m.refreshTokens, m.audit, m.timegen, testConfig, m.logger, WithMFA(m.mfa))
+		_, body := postJSON(t, handler, passwordBody("jane@example.com", testPassword))
+		methods, _ := body["mfa_methods"].([]interface{})
+		if len(methods) != 2 || methods[0] != AMROTP || methods[1] != MFAMethodRecoveryCode {
+			t.Errorf("mfa_methods = %v, want [otp recovery_code]", body["mfa_methods"])
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/audit"
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/throttle"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/pkg/errors"
+)
+
+// EventTypeUnlock is the audit event type of accounts unlocked by an administrator.
+const EventTypeUnlock = "account.unlock"
+
+// UnlockRequest is the JSON body of the unlock endpoint.
+type UnlockRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type unlockHandler struct {
+	users     user.Service
+	throttler throttle.Throttler
+	audit     audit.Logger
+	timegen   timegenerator.TimeGenerator
+	logger    *logger.Logger
+}
+
+// NewUnlockHandler instantiates the admin unlock endpoint, which forgets the failed attempts
+// and lockouts of the user of an UnlockRequest, of passwords whichever login they were made
+// with as well as of second factors, activates it if its status is locked, and responds with
+// the user. It does not check who makes the request,
+// so it must be mounted behind administrator authorization.
+func NewUnlockHandler(users user.Service, throttler throttle.Throttler, auditLogger audit.Logger,
+	timegen timegenerator.TimeGenerator, logger *logger.Logger) http.Handler {
+	return &unlockHandler{users: users, throttler: throttler, audit: auditLogger, timegen: timegen, logger: logger}
+}
+
+func (h *unlockHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	var request UnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.UserID == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "user_id is required"), h.logger)
+		return
+	}
+	u, err := h.unlock(r, request.UserID)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	event := newEvent(r, EventTypeUnlock, "", h.timegen.Now().UTC())
+	event.Outcome = audit.OutcomeSuccess
+	event.Subject = u.ID
+	if err := h.audit.Log(r.Context(), event); err != nil {
+		h.logger.WithField("err", err).Error("failed to audit unlock")
+	}
+	httperror.WriteJSON(w, http.StatusOK, u)
+}
+
+func (h *unlockHandler) unlock(r *http.Request, id string) (*user.User, error) {
+	u, err := h.users.FindByID(r.Context(), id)
+	if errors.Is(err, user.ErrNotFound) {
+		return nil, httperror.New(http.StatusNotFound, ErrorCodeNotFound, "user is not found")
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := h.throttler.Unlock(r.Context(), userAccount(u.ID), otpAccount(u.ID)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if u.Status != user.StatusLocked {
+		return u, nil
+	}
+	u, err = h.users.SetStatus(r.Context(), u.ID, user.StatusActive)
+	return u, errors.WithStack(err)
+}
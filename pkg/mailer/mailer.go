@@ -0,0 +1,99 @@
+// Package mailer sends emails to users, such as password reset links. Delivery is pluggable:
+// deployments provide a Mailer for their provider, and the file and memory implementations
+// serve development and tests.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidHeader indicates a recipient or subject with a line break, which would inject
+// headers in the message.
+var ErrInvalidHeader = errors.New("mail header contains a line break")
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// validate checks the headers of the message.
+func (m Message) validate() error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.WithStack(ErrInvalidHeader)
+	}
+	return nil
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	// Send sends message.
+	Send(ctx context.Context, message Message) error
+}
+
+// MemoryMailer is a Mailer keeping messages in memory, meant for tests.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryMailer instantiates a MemoryMailer.
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send records message.
+func (m *MemoryMailer) Send(_ context.Context, message Message) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Messages returns the messages sent so far, in order.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+type fileMailer struct {
+	dir     string
+	timegen timegenerator.TimeGenerator
+}
+
+// NewFileMailer instantiates a Mailer writing each message to an .eml file of dir, named after
+// the time it was sent, so developers can open them with a mail client.
+func NewFileMailer(dir string, timegen timegenerator.TimeGenerator) Mailer {
+	return &fileMailer{dir: dir, timegen: timegen}
+}
+
+func (m *fileMailer) Send(_ context.Context, message Message) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+	id, err := securetoken.NewID()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	now := m.timegen.Now().UTC()
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405.000000000Z"), id[:8])
+	content := fmt.Sprintf("Date: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		now.Format("Mon, 02 Jan 2006 15:04:05 -0700"), message.To, mime.QEncoding.Encode("utf-8", message.Subject),
+		strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return errors.WithStack(os.WriteFile(filepath.Join(m.dir, name), []byte(content), 0o600))
+}
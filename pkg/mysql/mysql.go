@@ -2,8 +2,10 @@ package mysql
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -29,6 +31,8 @@ type MySQL interface {
 	GetNamed(ctx context.Context, dest interface{}, query string, args interface{}) error
 	Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 	SelectNamed(ctx context.Context, dest interface{}, query string, args interface{}) (err error)
+	GetNamedForWrite(ctx context.Context, dest interface{}, query string, args interface{}) error
+	ExecNamed(ctx context.Context, query string, args interface{}) (sql.Result, error)
 	In(ctx context.Context, query string, params map[string]interface{}) (string, []interface{}, error)
 	PrepareForWrite(ctx context.Context, query string) (*sqlx.NamedStmt, error)
 	PrepareForRead(ctx context.Context, query string) (*sqlx.NamedStmt, error)
@@ -161,6 +165,32 @@ func (m *mysql) SelectNamed(ctx context.Context, dest interface{}, query string,
 	return stmt.SelectContext(ctx, dest, args)
 }
 
+// GetNamedForWrite gets single data from master using named parameters. When ctx carries a
+// transaction, the query runs inside it, e.g. to lock rows with SELECT ... FOR UPDATE.
+func (m *mysql) GetNamedForWrite(ctx context.Context, dest interface{}, query string, args interface{}) error {
+	var stmt *sqlx.NamedStmt
+	var err error
+	if tx, ok := ctx.Value(transaction.Context).(*sqlx.Tx); ok {
+		stmt, err = tx.PrepareNamedContext(ctx, query)
+	} else {
+		stmt, err = m.GetActiveDB(ModeWrite).PrepareNamedContext(ctx, query)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer stmt.Close()
+	return stmt.GetContext(ctx, dest, args)
+}
+
+// ExecNamed executes a write statement using named parameters. When ctx carries a transaction,
+// the statement runs inside it.
+func (m *mysql) ExecNamed(ctx context.Context, query string, args interface{}) (sql.Result, error) {
+	if tx, ok := ctx.Value(transaction.Context).(*sqlx.Tx); ok {
+		return tx.NamedExecContext(ctx, query, args)
+	}
+	return m.GetActiveDB(ModeWrite).NamedExecContext(ctx, query, args)
+}
+
 // Select gets multiple data from database.
 func (m *mysql) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) (err error) {
 	return m.GetActiveDB(ModeRead).SelectContext(ctx, dest, query, args...)
@@ -0,0 +1,266 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// ErrorCodeUnsupportedResponseType is the error code of the authorization endpoint for
+// response types other than code.
+const ErrorCodeUnsupportedResponseType = "unsupported_response_type"
+
+// AuthorizeConfig provides configs for the authorization endpoint.
+type AuthorizeConfig struct {
+	// LoginURL is where unauthenticated users are sent, with the authorization request in return_to.
+	LoginURL string
+	// CodeLifetime is how long authorization codes can be exchanged.
+	CodeLifetime time.Duration
+}
+
+type authorizeHandler struct {
+	clients  ClientStore
+	codes    AuthorizationCodeStore
+	sessions SessionProvider
+	timegen  timegenerator.TimeGenerator
+	config   AuthorizeConfig
+	logger   *logger.Logger
+}
+
+// NewAuthorizeHandler instantiates the authorization endpoint of the authorization code grant.
+// PKCE with S256 is mandatory for every client. The OpenID Connect nonce, prompt and max_age
+// parameters are supported.
+func NewAuthorizeHandler(clients ClientStore, codes AuthorizationCodeStore, sessions SessionProvider,
+	timegen timegenerator.TimeGenerator, config AuthorizeConfig, logger *logger.Logger) http.Handler {
+	return &authorizeHandler{
+		clients:  clients,
+		codes:    codes,
+		sessions: sessions,
+		timegen:  timegen,
+		config:   config,
+		logger:   logger,
+	}
+}
+
+// authorizeRequest is a validated authorization request.
+type authorizeRequest struct {
+	client        *Client
+	redirectURI   string
+	state         string
+	scope         string
+	codeChallenge string
+	nonce         string
+	prompts       []string
+	// maxAge is the maximum authentication age in seconds, or -1 when not requested.
+	maxAge int64
+}
+
+func (h *authorizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be GET or POST"), h.logger)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "malformed request"), h.logger)
+		return
+	}
+
+	// Errors about the client or redirect URI must not be redirected, since the redirect URI
+	// cannot be trusted yet.
+	client, redirectURI, err := h.validateClient(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	request, err := h.validateRequest(r, client, redirectURI)
+	if err != nil {
+		h.redirectError(w, r, redirectURI, err)
+		return
+	}
+
+	session, err := h.sessions.Session(r)
+	if err != nil {
+		h.redirectError(w, r, redirectURI, err)
+		return
+	}
+	if h.requiresLogin(request, session) {
+		if contains(request.prompts, "none") {
+			h.redirectError(w, r, redirectURI, httperror.New(http.StatusBadRequest, ErrorCodeLoginRequired, "the user must log in"))
+			return
+		}
+		// prompt=login is dropped when returning from the login page, which has just
+		// authenticated the user.
+		returnTo := url.Values{}
+		for key, values := range r.Form {
+			if key != "prompt" {
+				returnTo[key] = values
+			}
+		}
+		redirectToLogin(w, r, h.config.LoginURL, returnTo, h.logger)
+		return
+	}
+
+	code, err := h.issueCode(r, request, session)
+	if err != nil {
+		h.redirectError(w, r, redirectURI, err)
+		return
+	}
+	h.redirect(w, r, redirectURI, url.Values{"code": {code}, "state": {request.state}})
+}
+
+func (h *authorizeHandler) validateClient(r *http.Request) (*Client, string, error) {
+	clientID := r.Form.Get("client_id")
+	if clientID == "" {
+		return nil, "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "client_id is required")
+	}
+	client, err := h.clients.FindByID(r.Context(), clientID)
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidClient, "client is not registered")
+	}
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !client.RedirectURIs.Contains(redirectURI) {
+		return nil, "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "redirect_uri is not registered")
+	}
+	return client, redirectURI, nil
+}
+
+func (h *authorizeHandler) validateRequest(r *http.Request, client *Client, redirectURI string) (*authorizeRequest, error) {
+	if responseType := r.Form.Get("response_type"); responseType != "code" {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeUnsupportedResponseType, "response_type must be code")
+	}
+	if !client.GrantTypes.Contains(GrantTypeAuthorizationCode) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeUnauthorizedClient, "grant type is not allowed for this client")
+	}
+	if r.Form.Get("code_challenge_method") != CodeChallengeMethodS256 {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "code_challenge_method must be S256")
+	}
+	codeChallenge := r.Form.Get("code_challenge")
+	if !codeChallengePattern.MatchString(codeChallenge) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "code_challenge is invalid")
+	}
+	scope := r.Form.Get("scope")
+	if !client.AllowsScopes(splitScope(scope)) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidScope, "scope is not allowed for this client")
+	}
+	prompts := splitScope(r.Form.Get("prompt"))
+	if contains(prompts, "none") && len(prompts) > 1 {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "prompt none cannot be combined")
+	}
+	maxAge := int64(-1)
+	if value := r.Form.Get("max_age"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "max_age is invalid")
+		}
+		maxAge = parsed
+	}
+	return &authorizeRequest{
+		client:        client,
+		redirectURI:   redirectURI,
+		state:         r.Form.Get("state"),
+		scope:         scope,
+		codeChallenge: codeChallenge,
+		nonce:         r.Form.Get("nonce"),
+		prompts:       prompts,
+		maxAge:        maxAge,
+	}, nil
+}
+
+// requiresLogin checks whether the user must log in before a code is issued: when not logged
+// in, when prompt=login asks for a new login, or when the login is older than max_age.
+func (h *authorizeHandler) requiresLogin(request *authorizeRequest, session *Session) bool {
+	switch {
+	case session == nil:
+		return true
+	case contains(request.prompts, "login"):
+		return true
+	case request.maxAge >= 0:
+		return h.timegen.Now().Sub(session.AuthTime) > time.Duration(request.maxAge)*time.Second
+	}
+	return false
+}
+
+func (h *authorizeHandler) issueCode(r *http.Request, request *authorizeRequest, session *Session) (string, error) {
+	code, err := securetoken.New(securetoken.DefaultSize)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	now := h.timegen.Now().UTC()
+	err = h.codes.Create(r.Context(), &AuthorizationCode{
+		CodeHash:            securetoken.Hash(code),
+		ClientID:            request.client.ID,
+		Subject:             session.Subject,
+		RedirectURI:         request.redirectURI,
+		Scope:               request.scope,
+		CodeChallenge:       request.codeChallenge,
+		CodeChallengeMethod: CodeChallengeMethodS256,
+		AuthTime:            session.AuthTime.UTC(),
+		AMR:                 strings.Join(session.AMR, " "),
+		ACR:                 session.ACR,
+		Nonce:               request.nonce,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(h.config.CodeLifetime),
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return code, nil
+}
+
+// redirectToLogin sends an unauthenticated user to loginURL, which returns to the current path
+// with the returnTo parameters.
+func redirectToLogin(w http.ResponseWriter, r *http.Request, rawLoginURL string, returnTo url.Values, log *logger.Logger) {
+	loginURL, err := url.Parse(rawLoginURL)
+	if err != nil {
+		writeError(w, errors.WithStack(err), log)
+		return
+	}
+	query := loginURL.Query()
+	query.Set("return_to", r.URL.Path+"?"+returnTo.Encode())
+	loginURL.RawQuery = query.Encode()
+	http.Redirect(w, r, loginURL.String(), http.StatusFound)
+}
+
+func (h *authorizeHandler) redirectError(w http.ResponseWriter, r *http.Request, redirectURI string, err error) {
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) {
+		h.logger.WithField("err", err).Error()
+		oauthErr = httperror.New(http.StatusInternalServerError, ErrorCodeServerError, "")
+	}
+	params := url.Values{"error": {oauthErr.Code}, "state": {r.Form.Get("state")}}
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+	h.redirect(w, r, redirectURI, params)
+}
+
+func (h *authorizeHandler) redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		writeError(w, errors.WithStack(err), h.logger)
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
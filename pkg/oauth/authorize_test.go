@@ -0,0 +1,332 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+)
+
+const (
+	testAppClientID    = "app"
+	testAppRedirectURI = "https://app.example.com/callback"
+	testCodeVerifier   = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+type authorizationServer struct {
+	*fixture
+	server   *httptest.Server
+	loggedIn bool
+	// authTime is the time the user logged in, defaulting to the time of each request.
+	authTime time.Time
+	users    userInfoStore
+	// dpop validates the DPoP proofs of the token and userinfo endpoints.
+	dpop DPoPVerifier
+}
+
+// userInfoStore is a UserInfoProvider keeping users in a map.
+type userInfoStore map[string]*UserInfo
+
+func (s userInfoStore) UserInfo(_ context.Context, subject string) (*UserInfo, error) {
+	user, ok := s[subject]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func newAuthorizationServer(t *testing.T) *authorizationServer {
+	t.Helper()
+	return newDPoPAuthorizationServer(t, false)
+}
+
+// newDPoPAuthorizationServer instantiates an authorizationServer whose DPoP proofs must carry
+// server nonces when requireNonce is set.
+func newDPoPAuthorizationServer(t *testing.T, requireNonce bool) *authorizationServer {
+	t.Helper()
+	s := &authorizationServer{fixture: newFixture(t), loggedIn: true, users: userInfoStore{}}
+	dpop := DPoPConfig{ProofLifetime: time.Minute}
+	if requireNonce {
+		dpop.Nonces = NewDPoPNonces([]byte("nonce-secret"), s.timegen, time.Minute)
+	}
+	s.dpop = NewDPoPVerifier(jwt.NewMemoryRevocationStore(s.timegen), s.timegen, dpop)
+	err := s.clients.Create(context.Background(), &Client{
+		ID:           testAppClientID,
+		Name:         "App",
+		RedirectURIs: StringList{testAppRedirectURI},
+		GrantTypes:   StringList{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		Scopes:       StringList{"read", "write", ScopeOpenID, ScopeProfile, ScopeEmail, ScopePhone, ScopeAddress},
+	})
+	if err != nil {
+		t.Fatalf("ClientStore.Create() error = %v", err)
+	}
+	codes := NewMemoryAuthorizationCodeStore()
+	sessions := SessionProviderFunc(func(*http.Request) (*Session, error) {
+		if !s.loggedIn {
+			return nil, nil
+		}
+		authTime := s.authTime
+		if authTime.IsZero() {
+			authTime = s.timegen.Now()
+		}
+		return &Session{Subject: "user-1", AuthTime: authTime, AMR: []string{"pwd"}, ACR: "urn:example:loa:1"}, nil
+	})
+	issuer := NewTokenIssuer(s.accessTokens, s.refreshTokens, s.timegen, WithIDTokens(s.accessTokens))
+
+	mux := http.NewServeMux()
+	mux.Handle("/authorize", NewAuthorizeHandler(s.clients, codes, sessions, s.timegen,
+		AuthorizeConfig{LoginURL: "https://auth.example.com/login", CodeLifetime: time.Minute}, s.logger))
+	mux.Handle("/token", NewDPoPTokenHandler(NewTokenHandler(NewClientAuthenticator(s.clients), s.logger,
+		NewAuthorizationCodeGrant(codes, issuer, s.refreshTokens, s.timegen),
+		NewRefreshTokenGrant(s.refreshTokens, s.timegen)), s.dpop, s.logger))
+	mux.Handle("/userinfo", NewUserInfoHandler(NewAccessTokenVerifier(s.accessTokens, s.dpop), s.users, s.logger))
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func authorizeParams() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testAppClientID},
+		"redirect_uri":          {testAppRedirectURI},
+		"scope":                 {"read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {NewCodeChallenge(testCodeVerifier)},
+		"code_challenge_method": {CodeChallengeMethodS256},
+	}
+}
+
+// authorize sends an authorization request and returns the response, without following redirects.
+func (s *authorizationServer) authorize(t *testing.T, params url.Values) *http.Response {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(s.server.URL + "/authorize?" + params.Encode())
+	if err != nil {
+		t.Fatalf("GET /authorize error = %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+// code runs a successful authorization request and returns the issued code.
+func (s *authorizationServer) code(t *testing.T) string {
+	t.Helper()
+	return s.codeFor(t, authorizeParams())
+}
+
+// codeFor runs a successful authorization request with params and returns the issued code.
+func (s *authorizationServer) codeFor(t *testing.T, params url.Values) string {
+	t.Helper()
+	resp := s.authorize(t, params)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("GET /authorize status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	if got := location.Query().Get("state"); got != "xyz" {
+		t.Errorf("state = %q, want xyz", got)
+	}
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("code is empty, location = %s", location)
+	}
+	return code
+}
+
+func (s *authorizationServer) token(t *testing.T, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+	resp, body := s.tokenWithHeader(t, form, http.Header{})
+	return resp.StatusCode, body
+}
+
+func (s *authorizationServer) tokenWithHeader(t *testing.T, form url.Values, header http.Header) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPost, s.server.URL+"/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("http.NewRequest() error = %v", err)
+	}
+	request.Header = header
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("POST /token error = %v", err)
+	}
+	defer resp.Body.Close()
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("json.Decode() error = %v", err)
+	}
+	return resp, body
+}
+
+func codeForm(code, verifier string) url.Values {
+	return url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"client_id":     {testAppClientID},
+		"code":          {code},
+		"redirect_uri":  {testAppRedirectURI},
+		"code_verifier": {verifier},
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	t.Run("Exchanges a code and refreshes the tokens", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		status, body := s.token(t, codeForm(s.code(t), testCodeVerifier))
+		if status != http.StatusOK {
+			t.Fatalf("POST /token status = %d, body = %v", status, body)
+		}
+		claims, err := s.accessTokens.ParseClaims(context.Background(), body["access_token"].(string), false)
+		if err != nil {
+			t.Fatalf("ParseClaims() error = %v", err)
+		}
+		if claims.Subject != "user-1" || claims.Scope != "read" || claims.Extra["client_id"] != testAppClientID {
+			t.Errorf("claims = %+v, want user-1 with scope read for app", claims)
+		}
+
+		status, body = s.token(t, url.Values{
+			"grant_type":    {GrantTypeRefreshToken},
+			"client_id":     {testAppClientID},
+			"refresh_token": {body["refresh_token"].(string)},
+		})
+		if status != http.StatusOK {
+			t.Fatalf("POST /token status = %d, body = %v", status, body)
+		}
+		if body["access_token"] == "" || body["refresh_token"] == "" {
+			t.Errorf("body = %v, want access and refresh tokens", body)
+		}
+	})
+
+	t.Run("Refreshes tokens with the audiences and lifetime of the client", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		client, err := s.clients.FindByID(context.Background(), testAppClientID)
+		if err != nil {
+			t.Fatalf("ClientStore.FindByID() error = %v", err)
+		}
+		client.Audiences = StringList{"orders-api"}
+		client.AccessTokenLifetime = 60
+		if err := s.clients.Create(context.Background(), client); err != nil {
+			t.Fatalf("ClientStore.Create() error = %v", err)
+		}
+		_, body := s.token(t, codeForm(s.code(t), testCodeVerifier))
+		status, body := s.token(t, url.Values{
+			"grant_type":    {GrantTypeRefreshToken},
+			"client_id":     {testAppClientID},
+			"refresh_token": {body["refresh_token"].(string)},
+		})
+		if status != http.StatusOK {
+			t.Fatalf("POST /token status = %d, body = %v", status, body)
+		}
+		claims := parseAccessToken(t, s.fixture, body["access_token"].(string), "orders-api")
+		if len(claims.Audience) != 1 || claims.Audience[0] != "orders-api" {
+			t.Errorf("aud = %v, want [orders-api]", claims.Audience)
+		}
+		if want := s.timegen.Now().Add(time.Minute).Unix(); claims.ExpiresAt != want || body["expires_in"] != float64(60) {
+			t.Errorf("exp = %d, expires_in = %v, want %d and 60", claims.ExpiresAt, body["expires_in"], want)
+		}
+	})
+
+	t.Run("Rejects a wrong code verifier", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		status, body := s.token(t, codeForm(s.code(t), strings.Repeat("a", 43)))
+		if status != http.StatusBadRequest || body["error"] != ErrorCodeInvalidGrant {
+			t.Errorf("POST /token = %d %v, want invalid_grant", status, body)
+		}
+	})
+
+	t.Run("Rejects an expired code", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		code := s.code(t)
+		s.timegen.Add(2 * time.Minute)
+		status, body := s.token(t, codeForm(code, testCodeVerifier))
+		if status != http.StatusBadRequest || body["error"] != ErrorCodeInvalidGrant {
+			t.Errorf("POST /token = %d %v, want invalid_grant", status, body)
+		}
+	})
+
+	t.Run("Revokes issued tokens when a code is replayed", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		code := s.code(t)
+		_, first := s.token(t, codeForm(code, testCodeVerifier))
+		status, body := s.token(t, codeForm(code, testCodeVerifier))
+		if status != http.StatusBadRequest || body["error"] != ErrorCodeInvalidGrant {
+			t.Errorf("POST /token = %d %v, want invalid_grant", status, body)
+		}
+		status, body = s.token(t, url.Values{
+			"grant_type":    {GrantTypeRefreshToken},
+			"client_id":     {testAppClientID},
+			"refresh_token": {first["refresh_token"].(string)},
+		})
+		if status != http.StatusBadRequest || body["error"] != ErrorCodeInvalidGrant {
+			t.Errorf("POST /token = %d %v, want invalid_grant", status, body)
+		}
+	})
+}
+
+func TestAuthorizeHandler(t *testing.T) {
+	t.Run("Does not redirect to an unregistered redirect URI", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		params := authorizeParams()
+		params.Set("redirect_uri", "https://evil.example.com/callback")
+		resp := s.authorize(t, params)
+		if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Location") != "" {
+			t.Errorf("GET /authorize status = %d, location = %q, want 400 without redirect",
+				resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+
+	t.Run("Redirects errors to the registered redirect URI", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			modify func(url.Values)
+			want   string
+		}{
+			{"Missing code challenge", func(p url.Values) { p.Del("code_challenge") }, ErrorCodeInvalidRequest},
+			{"Plain code challenge", func(p url.Values) { p.Set("code_challenge_method", "plain") }, ErrorCodeInvalidRequest},
+			{"Unsupported response type", func(p url.Values) { p.Set("response_type", "token") }, ErrorCodeUnsupportedResponseType},
+			{"Unregistered scope", func(p url.Values) { p.Set("scope", "admin") }, ErrorCodeInvalidScope},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				s := newAuthorizationServer(t)
+				params := authorizeParams()
+				tt.modify(params)
+				resp := s.authorize(t, params)
+				location, _ := url.Parse(resp.Header.Get("Location"))
+				if resp.StatusCode != http.StatusFound || !strings.HasPrefix(location.String(), testAppRedirectURI) {
+					t.Fatalf("GET /authorize status = %d, location = %s", resp.StatusCode, location)
+				}
+				if got := location.Query().Get("error"); got != tt.want {
+					t.Errorf("error = %q, want %q", got, tt.want)
+				}
+				if got := location.Query().Get("state"); got != "xyz" {
+					t.Errorf("state = %q, want xyz", got)
+				}
+			})
+		}
+	})
+
+	t.Run("Sends unauthenticated users to the login page", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		s.loggedIn = false
+		resp := s.authorize(t, authorizeParams())
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusFound || !strings.HasPrefix(location.String(), "https://auth.example.com/login") {
+			t.Fatalf("GET /authorize status = %d, location = %s", resp.StatusCode, location)
+		}
+		if got := location.Query().Get("return_to"); !strings.HasPrefix(got, "/authorize?") {
+			t.Errorf("return_to = %q, want the authorization request", got)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package oauth
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/pkg/errors"
+)
+
+// ErrClientNotFound indicates the client does not exist.
+var ErrClientNotFound = errors.New("client is not found")
+
+// Grant types supported by the token endpoint.
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+)
+
+// Client authentication methods of the token endpoint, as registered by RFC 7591.
+const (
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+	AuthMethodNone              = "none"
+	// AuthMethodTLSClientAuth and AuthMethodSelfSignedTLSClientAuth authenticate clients with
+	// their TLS client certificate, as defined by RFC 8705.
+	AuthMethodTLSClientAuth           = "tls_client_auth"
+	AuthMethodSelfSignedTLSClientAuth = "self_signed_tls_client_auth"
+)
+
+// StringList is a list of strings stored as a JSON array.
+type StringList []string
+
+// Contains checks whether value is in this list.
+func (l StringList) Contains(value string) bool {
+	for _, v := range l {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Value implements driver.Valuer.
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner.
+func (l *StringList) Scan(src interface{}) error {
+	var data []byte
+	switch value := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = value
+	case string:
+		data = []byte(value)
+	default:
+		return errors.Errorf("cannot scan %T into StringList", src)
+	}
+	return errors.WithStack(json.Unmarshal(data, (*[]string)(l)))
+}
+
+// KeySet is a JSON Web Key Set stored as JSON.
+type KeySet jwt.JWKSet
+
+// Value implements driver.Valuer.
+func (k KeySet) Value() (driver.Value, error) {
+	if len(k.Keys) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(jwt.JWKSet(k))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner.
+func (k *KeySet) Scan(src interface{}) error {
+	var data []byte
+	switch value := src.(type) {
+	case nil:
+		*k = KeySet{}
+		return nil
+	case []byte:
+		data = value
+	case string:
+		data = []byte(value)
+	default:
+		return errors.Errorf("cannot scan %T into KeySet", src)
+	}
+	if len(data) == 0 {
+		*k = KeySet{}
+		return nil
+	}
+	return errors.WithStack(json.Unmarshal(data, (*jwt.JWKSet)(k)))
+}
+
+// Client represents a registered OAuth client.
+type Client struct {
+	ID           string     `db:"id"`
+	SecretHash   string     `db:"secret_hash"`
+	Name         string     `db:"name"`
+	RedirectURIs StringList `db:"redirect_uris"`
+	GrantTypes   StringList `db:"grant_types"`
+	Scopes       StringList `db:"scopes"`
+	// TokenEndpointAuthMethod is one of the AuthMethod constants. When empty, clients with a
+	// secret use client_secret_basic and clients without one are public.
+	TokenEndpointAuthMethod string `db:"token_endpoint_auth_method"`
+	// JWKS holds the keys verifying private_key_jwt client assertions, or the public keys of the
+	// certificates of self_signed_tls_client_auth.
+	JWKS KeySet `db:"jwks"`
+	// TLSClientAuthSubjectDN and TLSClientAuthSANDNS identify the certificate of a client using
+	// tls_client_auth, by its subject DN in RFC 4514 form or one of its DNS names. One is required.
+	TLSClientAuthSubjectDN string `db:"tls_client_auth_subject_dn"`
+	TLSClientAuthSANDNS    string `db:"tls_client_auth_san_dns"`
+	// Audiences lists the audiences of access tokens issued to this client. Tokens carry all of
+	// them unless a request narrows them down with the resource parameter.
+	Audiences StringList `db:"audiences"`
+	// AccessTokenLifetime is the lifetime of access tokens in seconds, overriding the default when set.
+	AccessTokenLifetime int64     `db:"access_token_lifetime"`
+	CreatedAt           time.Time `db:"created_at"`
+}
+
+// AuthMethod returns the token endpoint authentication method of this client.
+func (c *Client) AuthMethod() string {
+	switch {
+	case c.TokenEndpointAuthMethod != "":
+		return c.TokenEndpointAuthMethod
+	case c.SecretHash == "":
+		return AuthMethodNone
+	default:
+		return AuthMethodClientSecretBasic
+	}
+}
+
+// UsesMutualTLS checks whether this client authenticates with a TLS client certificate.
+func (c *Client) UsesMutualTLS() bool {
+	method := c.AuthMethod()
+	return method == AuthMethodTLSClientAuth || method == AuthMethodSelfSignedTLSClientAuth
+}
+
+// IsPublic checks whether this client cannot authenticate, e.g. a native or browser application.
+func (c *Client) IsPublic() bool {
+	return c.AuthMethod() == AuthMethodNone
+}
+
+// TokenLifetime returns the lifetime of access tokens issued to this client, or 0 for the default.
+func (c *Client) TokenLifetime() time.Duration {
+	return time.Duration(c.AccessTokenLifetime) * time.Second
+}
+
+// AllowsScopes checks whether every scope in scopes is registered for this client.
+func (c *Client) AllowsScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !c.Scopes.Contains(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewClientSecret generates a client secret. Only its hash is stored in Client.SecretHash, so the
+// secret must be handed to the client right away.
+func NewClientSecret() (secret, hash string, err error) {
+	secret, err = securetoken.New(securetoken.DefaultSize)
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	return secret, securetoken.Hash(secret), nil
+}
+
+// ClientStore persists registered clients.
+type ClientStore interface {
+	// FindByID finds a client by its ID.
+	FindByID(ctx context.Context, id string) (*Client, error)
+
+	// Create registers a new client.
+	Create(ctx context.Context, client *Client) error
+}
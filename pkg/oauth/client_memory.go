@@ -0,0 +1,39 @@
+package oauth
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type memoryClientStore struct {
+	mu      sync.RWMutex
+	clients map[string]Client
+}
+
+// NewMemoryClientStore instantiates a ClientStore which keeps clients in memory.
+func NewMemoryClientStore(clients ...Client) ClientStore {
+	store := &memoryClientStore{clients: map[string]Client{}}
+	for _, client := range clients {
+		store.clients[client.ID] = client
+	}
+	return store
+}
+
+func (s *memoryClientStore) FindByID(_ context.Context, id string) (*Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, ok := s.clients[id]
+	if !ok {
+		return nil, errors.WithStack(ErrClientNotFound)
+	}
+	return &client, nil
+}
+
+func (s *memoryClientStore) Create(_ context.Context, client *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[client.ID] = *client
+	return nil
+}
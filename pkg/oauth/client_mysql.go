@@ -0,0 +1,45 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	findClientByIDQuery = `SELECT * FROM oauth_clients WHERE id = :id`
+	insertClientQuery   = `INSERT INTO oauth_clients (id, secret_hash, name, redirect_uris, grant_types, scopes,
+		token_endpoint_auth_method, jwks, tls_client_auth_subject_dn, tls_client_auth_san_dns, audiences,
+		access_token_lifetime, created_at)
+		VALUES (:id, :secret_hash, :name, :redirect_uris, :grant_types, :scopes,
+		:token_endpoint_auth_method, :jwks, :tls_client_auth_subject_dn, :tls_client_auth_san_dns, :audiences,
+		:access_token_lifetime, :created_at)`
+)
+
+type mysqlClientStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLClientStore instantiates a ClientStore backed by MySQL.
+func NewMySQLClientStore(db mysql.MySQL) ClientStore {
+	return &mysqlClientStore{db: db}
+}
+
+func (s *mysqlClientStore) FindByID(ctx context.Context, id string) (*Client, error) {
+	var client Client
+	err := s.db.GetNamed(ctx, &client, findClientByIDQuery, map[string]interface{}{"id": id})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithStack(ErrClientNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &client, nil
+}
+
+func (s *mysqlClientStore) Create(ctx context.Context, client *Client) error {
+	_, err := s.db.ExecNamed(ctx, insertClientQuery, client)
+	return errors.WithStack(err)
+}
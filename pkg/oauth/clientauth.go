@@ -0,0 +1,196 @@
+package oauth
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// ClientAssertionTypeJWTBearer is the client_assertion_type of private_key_jwt defined by RFC 7523.
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// MaxClientAssertionLifetime bounds exp - iat of client assertions, so the jti of an assertion
+// only has to be remembered briefly.
+const MaxClientAssertionLifetime = 5 * time.Minute
+
+// ClientAuthenticator authenticates the client making a request to an endpoint.
+type ClientAuthenticator interface {
+	// Authenticate returns the authenticated client, or an invalid_client *Error.
+	Authenticate(r *http.Request) (*Client, error)
+}
+
+type clientAuthenticator struct {
+	clients ClientStore
+
+	// assertionAudience, timegen and assertions are set when private_key_jwt is enabled.
+	assertionAudience string
+	timegen           timegenerator.TimeGenerator
+	assertions        jwt.RevocationStore
+
+	// mutualTLS and certificateRoots are set when tls_client_auth is enabled.
+	mutualTLS        bool
+	certificateRoots *x509.CertPool
+}
+
+// ClientAuthenticatorOption configures optional behaviour of the ClientAuthenticator.
+type ClientAuthenticatorOption func(*clientAuthenticator)
+
+// WithPrivateKeyJWT enables private_key_jwt. Assertions must name audience, usually the token
+// endpoint URL, and their jti is recorded in assertions so they cannot be replayed.
+func WithPrivateKeyJWT(audience string, timegen timegenerator.TimeGenerator, assertions jwt.RevocationStore) ClientAuthenticatorOption {
+	return func(a *clientAuthenticator) {
+		a.assertionAudience = audience
+		a.timegen = timegen
+		a.assertions = assertions
+	}
+}
+
+// WithMutualTLS enables tls_client_auth, whose certificates must chain up to roots, and
+// self_signed_tls_client_auth. Clients using them send their client_id as a form parameter.
+func WithMutualTLS(roots *x509.CertPool) ClientAuthenticatorOption {
+	return func(a *clientAuthenticator) {
+		a.mutualTLS = true
+		a.certificateRoots = roots
+	}
+}
+
+// NewClientAuthenticator instantiates a ClientAuthenticator supporting client_secret_basic and
+// client_secret_post. Public clients, which have no secret, identify themselves with the
+// client_id form parameter. Each client must use the method it is registered with.
+func NewClientAuthenticator(clients ClientStore, options ...ClientAuthenticatorOption) ClientAuthenticator {
+	authenticator := &clientAuthenticator{clients: clients}
+	for _, option := range options {
+		option(authenticator)
+	}
+	return authenticator
+}
+
+func (a *clientAuthenticator) Authenticate(r *http.Request) (*Client, error) {
+	clientID, secret, basic := r.BasicAuth()
+	assertionType := r.PostForm.Get("client_assertion_type")
+	postSecret := r.PostForm.Get("client_secret")
+	if countTrue(basic, assertionType != "", postSecret != "") > 1 {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "multiple client authentication methods are used")
+	}
+
+	switch {
+	case basic:
+		// RFC 6749 2.3.1 requires credentials to be form encoded before they are base64 encoded.
+		if decoded, err := url.QueryUnescape(clientID); err == nil {
+			clientID = decoded
+		}
+		if decoded, err := url.QueryUnescape(secret); err == nil {
+			secret = decoded
+		}
+		return a.authenticateSecret(r, AuthMethodClientSecretBasic, clientID, secret)
+	case postSecret != "":
+		return a.authenticateSecret(r, AuthMethodClientSecretPost, r.PostForm.Get("client_id"), postSecret)
+	case assertionType != "":
+		return a.authenticateAssertion(r, assertionType)
+	case a.mutualTLS && peerCertificate(r) != nil:
+		return a.authenticateCertificate(r)
+	default:
+		return a.authenticatePublic(r)
+	}
+}
+
+// findClient finds the client clientID, which must be registered with one of methods.
+func (a *clientAuthenticator) findClient(r *http.Request, clientID string, methods ...string) (*Client, error) {
+	if clientID == "" {
+		return nil, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication is required")
+	}
+	client, err := a.clients.FindByID(r.Context(), clientID)
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication failed")
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !contains(methods, client.AuthMethod()) {
+		return nil, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication method is not allowed for this client")
+	}
+	return client, nil
+}
+
+func (a *clientAuthenticator) authenticateSecret(r *http.Request, method, clientID, secret string) (*Client, error) {
+	client, err := a.findClient(r, clientID, method)
+	if err != nil {
+		return nil, err
+	}
+	if !securetoken.Equal(securetoken.Hash(secret), client.SecretHash) {
+		return nil, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication failed")
+	}
+	return client, nil
+}
+
+func (a *clientAuthenticator) authenticateAssertion(r *http.Request, assertionType string) (*Client, error) {
+	if a.assertions == nil || assertionType != ClientAssertionTypeJWTBearer {
+		return nil, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client_assertion_type is not supported")
+	}
+	assertion := r.PostForm.Get("client_assertion")
+	unverified, err := jwt.ParseUnverified(assertion)
+	if err != nil {
+		return nil, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client_assertion is malformed")
+	}
+	clientID := r.PostForm.Get("client_id")
+	if clientID == "" {
+		clientID = unverified.Issuer
+	}
+	client, err := a.findClient(r, clientID, AuthMethodPrivateKeyJWT)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := jwt.ParseWithKeySet(r.Context(), assertion, jwt.JWKSet(client.JWKS), jwt.ValidationPolicy{
+		Issuers:        []string{client.ID},
+		Audiences:      []string{a.assertionAudience},
+		Leeway:         time.Minute,
+		RequiredClaims: []string{"sub", "jti", "iat"},
+		Validators: []jwt.ClaimsValidator{func(_ context.Context, claims *jwt.Claims) error {
+			if claims.Subject != client.ID {
+				return errors.Errorf("sub [%s] is not the client", claims.Subject)
+			}
+			if lifetime := time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second; lifetime > MaxClientAssertionLifetime {
+				return errors.Errorf("lifetime [%s] exceeds %s", lifetime, MaxClientAssertionLifetime)
+			}
+			return nil
+		}},
+	}, a.timegen.Now())
+	if err != nil {
+		return nil, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client_assertion is invalid")
+	}
+
+	// Assertions are single-use, so their jti is revoked until they expire. It is hashed with the
+	// client ID, since clients choose jti values independently.
+	jti := securetoken.Hash(client.ID + ":" + claims.ID)
+	first, err := a.assertions.RevokeOnce(r.Context(), jti, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !first {
+		return nil, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client_assertion has already been used")
+	}
+	return client, nil
+}
+
+func (a *clientAuthenticator) authenticatePublic(r *http.Request) (*Client, error) {
+	return a.findClient(r, r.PostForm.Get("client_id"), AuthMethodNone)
+}
+
+func countTrue(values ...bool) int {
+	count := 0
+	for _, value := range values {
+		if value {
+			count++
+		}
+	}
+	return count
+}
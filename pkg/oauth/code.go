@@ -0,0 +1,87 @@
+package oauth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCodeNotFound indicates the authorization code does not exist.
+var ErrCodeNotFound = errors.New("authorization code is not found")
+
+// AuthorizationCode represents an issued authorization code. Only the hash of the code is stored.
+type AuthorizationCode struct {
+	CodeHash            string     `db:"code_hash"`
+	ClientID            string     `db:"client_id"`
+	Subject             string     `db:"subject"`
+	RedirectURI         string     `db:"redirect_uri"`
+	Scope               string     `db:"scope"`
+	CodeChallenge       string     `db:"code_challenge"`
+	CodeChallengeMethod string     `db:"code_challenge_method"`
+	AuthTime            time.Time  `db:"auth_time"`
+	AMR                 string     `db:"amr"`
+	ACR                 string     `db:"acr"`
+	Nonce               string     `db:"nonce"`
+	RefreshFamilyID     string     `db:"refresh_family_id"`
+	CreatedAt           time.Time  `db:"created_at"`
+	ExpiresAt           time.Time  `db:"expires_at"`
+	UsedAt              *time.Time `db:"used_at"`
+}
+
+// AuthorizationCodeStore persists authorization codes.
+type AuthorizationCodeStore interface {
+	// Create stores a new authorization code.
+	Create(ctx context.Context, code *AuthorizationCode) error
+
+	// Consume marks a code as used and returns it. firstUse is false when the code had already
+	// been used, which callers must treat as a replay.
+	Consume(ctx context.Context, codeHash string, usedAt time.Time) (code *AuthorizationCode, firstUse bool, err error)
+
+	// SetRefreshFamily records the refresh token family issued for a code, so it can be revoked
+	// when the code is replayed.
+	SetRefreshFamily(ctx context.Context, codeHash, familyID string) error
+}
+
+type memoryAuthorizationCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]*AuthorizationCode
+}
+
+// NewMemoryAuthorizationCodeStore instantiates an AuthorizationCodeStore which keeps codes in memory.
+func NewMemoryAuthorizationCodeStore() AuthorizationCodeStore {
+	return &memoryAuthorizationCodeStore{codes: map[string]*AuthorizationCode{}}
+}
+
+func (s *memoryAuthorizationCodeStore) Create(_ context.Context, code *AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *code
+	s.codes[code.CodeHash] = &stored
+	return nil
+}
+
+func (s *memoryAuthorizationCodeStore) Consume(_ context.Context, codeHash string, usedAt time.Time) (*AuthorizationCode, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[codeHash]
+	if !ok {
+		return nil, false, errors.WithStack(ErrCodeNotFound)
+	}
+	firstUse := code.UsedAt == nil
+	if firstUse {
+		code.UsedAt = &usedAt
+	}
+	consumed := *code
+	return &consumed, firstUse, nil
+}
+
+func (s *memoryAuthorizationCodeStore) SetRefreshFamily(_ context.Context, codeHash, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code, ok := s.codes[codeHash]; ok {
+		code.RefreshFamilyID = familyID
+	}
+	return nil
+}
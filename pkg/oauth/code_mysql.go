@@ -0,0 +1,67 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	insertAuthorizationCodeQuery = `INSERT INTO authorization_codes (code_hash, client_id, subject, redirect_uri, scope,
+		code_challenge, code_challenge_method, auth_time, amr, acr, nonce, refresh_family_id, created_at, expires_at)
+		VALUES (:code_hash, :client_id, :subject, :redirect_uri, :scope,
+		:code_challenge, :code_challenge_method, :auth_time, :amr, :acr, :nonce, :refresh_family_id, :created_at, :expires_at)`
+	consumeAuthorizationCodeQuery = `UPDATE authorization_codes SET used_at = :used_at
+		WHERE code_hash = :code_hash AND used_at IS NULL`
+	findAuthorizationCodeQuery             = `SELECT * FROM authorization_codes WHERE code_hash = :code_hash`
+	setAuthorizationCodeRefreshFamilyQuery = `UPDATE authorization_codes SET refresh_family_id = :refresh_family_id
+		WHERE code_hash = :code_hash`
+)
+
+type mysqlAuthorizationCodeStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLAuthorizationCodeStore instantiates an AuthorizationCodeStore backed by MySQL.
+func NewMySQLAuthorizationCodeStore(db mysql.MySQL) AuthorizationCodeStore {
+	return &mysqlAuthorizationCodeStore{db: db}
+}
+
+func (s *mysqlAuthorizationCodeStore) Create(ctx context.Context, code *AuthorizationCode) error {
+	_, err := s.db.ExecNamed(ctx, insertAuthorizationCodeQuery, code)
+	return errors.WithStack(err)
+}
+
+func (s *mysqlAuthorizationCodeStore) Consume(ctx context.Context, codeHash string, usedAt time.Time) (*AuthorizationCode, bool, error) {
+	result, err := s.db.ExecNamed(ctx, consumeAuthorizationCodeQuery, map[string]interface{}{
+		"code_hash": codeHash,
+		"used_at":   usedAt,
+	})
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	var code AuthorizationCode
+	err = s.db.GetNamedForWrite(ctx, &code, findAuthorizationCodeQuery, map[string]interface{}{"code_hash": codeHash})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, errors.WithStack(ErrCodeNotFound)
+	}
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	return &code, affected == 1, nil
+}
+
+func (s *mysqlAuthorizationCodeStore) SetRefreshFamily(ctx context.Context, codeHash, familyID string) error {
+	_, err := s.db.ExecNamed(ctx, setAuthorizationCodeRefreshFamilyQuery, map[string]interface{}{
+		"code_hash":         codeHash,
+		"refresh_family_id": familyID,
+	})
+	return errors.WithStack(err)
+}
@@ -0,0 +1,233 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GrantTypeDeviceCode is the grant_type of the device authorization grant defined by RFC 8628.
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+var (
+	// ErrDeviceAuthorizationNotFound indicates the device authorization does not exist.
+	ErrDeviceAuthorizationNotFound = errors.New("device authorization is not found")
+	// ErrUserCodeExists indicates the user code of a new device authorization is taken.
+	ErrUserCodeExists = errors.New("user code already exists")
+)
+
+// DeviceStatus represents the status of a device authorization.
+type DeviceStatus string
+
+const (
+	// DeviceStatusPending represents a device authorization waiting for the user.
+	DeviceStatusPending = DeviceStatus("pending")
+	// DeviceStatusApproved represents a device authorization approved by the user.
+	DeviceStatusApproved = DeviceStatus("approved")
+	// DeviceStatusDenied represents a device authorization denied by the user.
+	DeviceStatusDenied = DeviceStatus("denied")
+	// DeviceStatusConsumed represents a device authorization which tokens have been issued for.
+	DeviceStatusConsumed = DeviceStatus("consumed")
+)
+
+// DeviceAuthorization represents a pending device authorization request. Only the hashes of
+// the device and user codes are stored.
+type DeviceAuthorization struct {
+	DeviceCodeHash string       `db:"device_code_hash"`
+	UserCodeHash   string       `db:"user_code_hash"`
+	ClientID       string       `db:"client_id"`
+	Scope          string       `db:"scope"`
+	Status         DeviceStatus `db:"status"`
+	Subject        string       `db:"subject"`
+	AuthTime       *time.Time   `db:"auth_time"`
+	AMR            string       `db:"amr"`
+	// Interval is the minimum number of seconds between two polls of the token endpoint.
+	Interval     int64      `db:"poll_interval"`
+	LastPolledAt *time.Time `db:"last_polled_at"`
+	CreatedAt    time.Time  `db:"created_at"`
+	ExpiresAt    time.Time  `db:"expires_at"`
+}
+
+// DeviceAuthorizationStore persists device authorizations.
+type DeviceAuthorizationStore interface {
+	// Create stores a new device authorization, or returns ErrUserCodeExists when another one
+	// has the same user code.
+	Create(ctx context.Context, authorization *DeviceAuthorization) error
+
+	// FindByDeviceCode finds a device authorization by the hash of its device code.
+	FindByDeviceCode(ctx context.Context, deviceCodeHash string) (*DeviceAuthorization, error)
+
+	// FindByUserCode finds a device authorization by the hash of its normalized user code.
+	FindByUserCode(ctx context.Context, userCodeHash string) (*DeviceAuthorization, error)
+
+	// UpdatePoll records a poll of the token endpoint and the interval required before the next one.
+	UpdatePoll(ctx context.Context, deviceCodeHash string, polledAt time.Time, interval int64) error
+
+	// Approve marks a pending device authorization as approved by the session's user. It returns
+	// false when the authorization is no longer pending.
+	Approve(ctx context.Context, userCodeHash string, session *Session) (bool, error)
+
+	// Deny marks a pending device authorization as denied. It returns false when the
+	// authorization is no longer pending.
+	Deny(ctx context.Context, userCodeHash string) (bool, error)
+
+	// Consume marks an approved device authorization as consumed. It returns false when the
+	// authorization is not approved, e.g. because tokens have already been issued for it.
+	Consume(ctx context.Context, deviceCodeHash string) (bool, error)
+
+	// DeleteExpired deletes device authorizations which expired at or before expiredBefore,
+	// freeing their user codes. It may delete only some of them, so each call is quick.
+	DeleteExpired(ctx context.Context, expiredBefore time.Time) error
+}
+
+// userCodeAlphabet excludes vowels and look-alike characters, as recommended by RFC 8628 6.1.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLength is the number of characters in a user code, giving about 34 bits of entropy.
+const userCodeLength = 8
+
+// newUserCode generates a user code formatted as XXXX-XXXX.
+func newUserCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// normalizeUserCode removes separators and case from a user code typed by a user.
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '-' || r == ' ':
+			return -1
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return r
+	}, userCode)
+}
+
+type memoryDeviceAuthorizationStore struct {
+	mu             sync.Mutex
+	authorizations map[string]*DeviceAuthorization
+}
+
+// NewMemoryDeviceAuthorizationStore instantiates a DeviceAuthorizationStore which keeps
+// device authorizations in memory.
+func NewMemoryDeviceAuthorizationStore() DeviceAuthorizationStore {
+	return &memoryDeviceAuthorizationStore{authorizations: map[string]*DeviceAuthorization{}}
+}
+
+func (s *memoryDeviceAuthorizationStore) Create(_ context.Context, authorization *DeviceAuthorization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findByUserCode(authorization.UserCodeHash) != nil {
+		return errors.WithStack(ErrUserCodeExists)
+	}
+	stored := *authorization
+	s.authorizations[authorization.DeviceCodeHash] = &stored
+	return nil
+}
+
+func (s *memoryDeviceAuthorizationStore) FindByDeviceCode(_ context.Context, deviceCodeHash string) (*DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authorization, ok := s.authorizations[deviceCodeHash]
+	if !ok {
+		return nil, errors.WithStack(ErrDeviceAuthorizationNotFound)
+	}
+	found := *authorization
+	return &found, nil
+}
+
+func (s *memoryDeviceAuthorizationStore) FindByUserCode(_ context.Context, userCodeHash string) (*DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authorization := s.findByUserCode(userCodeHash)
+	if authorization == nil {
+		return nil, errors.WithStack(ErrDeviceAuthorizationNotFound)
+	}
+	found := *authorization
+	return &found, nil
+}
+
+func (s *memoryDeviceAuthorizationStore) findByUserCode(userCodeHash string) *DeviceAuthorization {
+	for _, authorization := range s.authorizations {
+		if authorization.UserCodeHash == userCodeHash {
+			return authorization
+		}
+	}
+	return nil
+}
+
+func (s *memoryDeviceAuthorizationStore) UpdatePoll(_ context.Context, deviceCodeHash string, polledAt time.Time, interval int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if authorization, ok := s.authorizations[deviceCodeHash]; ok {
+		authorization.LastPolledAt = &polledAt
+		authorization.Interval = interval
+	}
+	return nil
+}
+
+func (s *memoryDeviceAuthorizationStore) Approve(_ context.Context, userCodeHash string, session *Session) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authorization := s.findByUserCode(userCodeHash)
+	if authorization == nil || authorization.Status != DeviceStatusPending {
+		return false, nil
+	}
+	authTime := session.AuthTime
+	authorization.Status = DeviceStatusApproved
+	authorization.Subject = session.Subject
+	authorization.AuthTime = &authTime
+	authorization.AMR = strings.Join(session.AMR, " ")
+	return true, nil
+}
+
+func (s *memoryDeviceAuthorizationStore) Deny(_ context.Context, userCodeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authorization := s.findByUserCode(userCodeHash)
+	if authorization == nil || authorization.Status != DeviceStatusPending {
+		return false, nil
+	}
+	authorization.Status = DeviceStatusDenied
+	return true, nil
+}
+
+func (s *memoryDeviceAuthorizationStore) Consume(_ context.Context, deviceCodeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authorization, ok := s.authorizations[deviceCodeHash]
+	if !ok || authorization.Status != DeviceStatusApproved {
+		return false, nil
+	}
+	authorization.Status = DeviceStatusConsumed
+	return true, nil
+}
+
+func (s *memoryDeviceAuthorizationStore) DeleteExpired(_ context.Context, expiredBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for deviceCodeHash, authorization := range s.authorizations {
+		if !authorization.ExpiresAt.After(expiredBefore) {
+			delete(s.authorizations, deviceCodeHash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package oauth
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/throttle"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// maxUserCodeAttempts bounds the user codes generated for a device authorization when the
+// previous ones are taken.
+const maxUserCodeAttempts = 5
+
+// DeviceConfig provides configs for the device authorization grant.
+type DeviceConfig struct {
+	// VerificationURI is the URL of the verification page users open on another device.
+	VerificationURI string
+	// LoginURL is where unauthenticated users are sent from the verification page.
+	LoginURL string
+	// Lifetime is how long device and user codes are valid.
+	Lifetime time.Duration
+	// Interval is the initial minimum time between two polls of the token endpoint.
+	Interval time.Duration
+}
+
+// DeviceAuthorizationResponse is a successful response of the device authorization endpoint.
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+type deviceAuthorizationHandler struct {
+	clients ClientAuthenticator
+	devices DeviceAuthorizationStore
+	timegen timegenerator.TimeGenerator
+	config  DeviceConfig
+	logger  *logger.Logger
+}
+
+// NewDeviceAuthorizationHandler instantiates the device authorization endpoint, which issues
+// the device and user codes of the device authorization grant. It deletes device
+// authorizations expired for longer than their Lifetime along the way, so their user codes
+// can be issued again; until then, polls with their device code are answered expired_token.
+func NewDeviceAuthorizationHandler(clients ClientAuthenticator, devices DeviceAuthorizationStore,
+	timegen timegenerator.TimeGenerator, config DeviceConfig, logger *logger.Logger) http.Handler {
+	return &deviceAuthorizationHandler{
+		clients: clients,
+		devices: devices,
+		timegen: timegen,
+		config:  config,
+		logger:  logger,
+	}
+}
+
+func (h *deviceAuthorizationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	client, err := h.clients.Authenticate(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if !client.GrantTypes.Contains(GrantTypeDeviceCode) {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeUnauthorizedClient, "grant type is not allowed for this client"), h.logger)
+		return
+	}
+	scope := r.PostForm.Get("scope")
+	if !client.AllowsScopes(splitScope(scope)) {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidScope, "scope is not allowed for this client"), h.logger)
+		return
+	}
+	response, err := h.issueCodes(r, client, scope)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *deviceAuthorizationHandler) issueCodes(r *http.Request, client *Client, scope string) (*DeviceAuthorizationResponse, error) {
+	deviceCode, err := securetoken.New(securetoken.DefaultSize)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	now := h.timegen.Now().UTC()
+	if err := h.devices.DeleteExpired(r.Context(), now.Add(-h.config.Lifetime)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	interval := int64(h.config.Interval / time.Second)
+	var userCode string
+	for attempt := 1; ; attempt++ {
+		if userCode, err = newUserCode(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = h.devices.Create(r.Context(), &DeviceAuthorization{
+			DeviceCodeHash: securetoken.Hash(deviceCode),
+			UserCodeHash:   securetoken.Hash(normalizeUserCode(userCode)),
+			ClientID:       client.ID,
+			Scope:          scope,
+			Status:         DeviceStatusPending,
+			Interval:       interval,
+			CreatedAt:      now,
+			ExpiresAt:      now.Add(h.config.Lifetime),
+		})
+		if !errors.Is(err, ErrUserCodeExists) || attempt == maxUserCodeAttempts {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	complete, err := url.Parse(h.config.VerificationURI)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	query := complete.Query()
+	query.Set("user_code", userCode)
+	complete.RawQuery = query.Encode()
+	return &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         h.config.VerificationURI,
+		VerificationURIComplete: complete.String(),
+		ExpiresIn:               int64(h.config.Lifetime / time.Second),
+		Interval:                interval,
+	}, nil
+}
+
+// DeviceVerificationResponse describes a device authorization to the user verifying it.
+type DeviceVerificationResponse struct {
+	UserCode   string       `json:"user_code"`
+	ClientID   string       `json:"client_id"`
+	ClientName string       `json:"client_name"`
+	Scope      string       `json:"scope,omitempty"`
+	Status     DeviceStatus `json:"status"`
+}
+
+type deviceVerificationHandler struct {
+	clients   ClientStore
+	devices   DeviceAuthorizationStore
+	sessions  SessionProvider
+	throttler throttle.Throttler
+	timegen   timegenerator.TimeGenerator
+	config    DeviceConfig
+	logger    *logger.Logger
+}
+
+// DeviceVerificationOption configures optional behaviour of the verification endpoint.
+type DeviceVerificationOption func(*deviceVerificationHandler)
+
+// WithUserCodeThrottler throttles the invalid user codes submitted to the verification
+// endpoint, per user and per client IP, so user codes cannot be guessed by brute force, as
+// RFC 8628 5.1 requires. Throttled requests are answered 429 slow_down with Retry-After.
+func WithUserCodeThrottler(throttler throttle.Throttler) DeviceVerificationOption {
+	return func(h *deviceVerificationHandler) {
+		h.throttler = throttler
+	}
+}
+
+// NewDeviceVerificationHandler instantiates the verification endpoint of the device
+// authorization grant. GET describes the request of a user_code, and POST approves or denies
+// it with action set to approve or deny. The user must be logged in.
+func NewDeviceVerificationHandler(clients ClientStore, devices DeviceAuthorizationStore, sessions SessionProvider,
+	timegen timegenerator.TimeGenerator, config DeviceConfig, logger *logger.Logger,
+	options ...DeviceVerificationOption) http.Handler {
+	h := &deviceVerificationHandler{
+		clients:  clients,
+		devices:  devices,
+		sessions: sessions,
+		timegen:  timegen,
+		config:   config,
+		logger:   logger,
+	}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+func (h *deviceVerificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be GET or POST"), h.logger)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "malformed request"), h.logger)
+		return
+	}
+	session, err := h.sessions.Session(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if session == nil {
+		redirectToLogin(w, r, h.config.LoginURL, r.Form, h.logger)
+		return
+	}
+
+	userCode := r.Form.Get("user_code")
+	authorization, err := h.findPending(r, session, userCode)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := h.decide(r, authorization, session); err != nil {
+			writeError(w, err, h.logger)
+			return
+		}
+	}
+	client, err := h.clients.FindByID(r.Context(), authorization.ClientID)
+	if err != nil {
+		writeError(w, errors.WithStack(err), h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, &DeviceVerificationResponse{
+		UserCode:   userCode,
+		ClientID:   client.ID,
+		ClientName: client.Name,
+		Scope:      authorization.Scope,
+		Status:     authorization.Status,
+	})
+}
+
+// findPending finds the pending device authorization of userCode, submitted by the user of
+// session. Invalid user codes count as failures of the user and of their IP.
+func (h *deviceVerificationHandler) findPending(r *http.Request, session *Session, userCode string) (*DeviceAuthorization, error) {
+	account, ip := "device:"+session.Subject, remoteIP(r)
+	if err := h.checkThrottle(r, account, ip); err != nil {
+		return nil, err
+	}
+	authorization, err := h.devices.FindByUserCode(r.Context(), securetoken.Hash(normalizeUserCode(userCode)))
+	if err != nil && !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+		return nil, errors.WithStack(err)
+	}
+	if err != nil || authorization.Status != DeviceStatusPending || !h.timegen.Now().Before(authorization.ExpiresAt) {
+		if h.throttler != nil {
+			if err := h.throttler.Failure(r.Context(), account, ip); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "user_code is invalid")
+	}
+	// Failures are not forgotten on success: anyone may obtain valid user codes, which would
+	// reset the count between guesses otherwise.
+	return authorization, nil
+}
+
+// checkThrottle rejects a verification by account from ip which must wait.
+func (h *deviceVerificationHandler) checkThrottle(r *http.Request, account, ip string) error {
+	if h.throttler == nil {
+		return nil
+	}
+	err := h.throttler.Check(r.Context(), account, ip)
+	var throttled *throttle.ThrottledError
+	if !errors.As(err, &throttled) {
+		return errors.WithStack(err)
+	}
+	return httperror.New(http.StatusTooManyRequests, ErrorCodeSlowDown, "too many invalid user codes, retry later").
+		WithHeader("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+}
+
+// remoteIP returns the IP address of the client of r.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (h *deviceVerificationHandler) decide(r *http.Request, authorization *DeviceAuthorization, session *Session) error {
+	var decided bool
+	var err error
+	switch r.PostForm.Get("action") {
+	case "approve":
+		decided, err = h.devices.Approve(r.Context(), authorization.UserCodeHash, session)
+		authorization.Status = DeviceStatusApproved
+	case "deny":
+		decided, err = h.devices.Deny(r.Context(), authorization.UserCodeHash)
+		authorization.Status = DeviceStatusDenied
+	default:
+		return httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "action must be approve or deny")
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !decided {
+		return httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "user_code is invalid")
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	insertDeviceAuthorizationQuery = `INSERT INTO device_authorizations (device_code_hash, user_code_hash, client_id,
+		scope, status, subject, auth_time, amr, poll_interval, last_polled_at, created_at, expires_at)
+		VALUES (:device_code_hash, :user_code_hash, :client_id,
+		:scope, :status, :subject, :auth_time, :amr, :poll_interval, :last_polled_at, :created_at, :expires_at)`
+	findDeviceAuthorizationByDeviceCodeQuery = `SELECT * FROM device_authorizations WHERE device_code_hash = :device_code_hash`
+	findDeviceAuthorizationByUserCodeQuery   = `SELECT * FROM device_authorizations WHERE user_code_hash = :user_code_hash`
+	updateDeviceAuthorizationPollQuery       = `UPDATE device_authorizations
+		SET last_polled_at = :last_polled_at, poll_interval = :poll_interval
+		WHERE device_code_hash = :device_code_hash`
+	approveDeviceAuthorizationQuery = `UPDATE device_authorizations
+		SET status = 'approved', subject = :subject, auth_time = :auth_time, amr = :amr
+		WHERE user_code_hash = :user_code_hash AND status = 'pending'`
+	denyDeviceAuthorizationQuery = `UPDATE device_authorizations SET status = 'denied'
+		WHERE user_code_hash = :user_code_hash AND status = 'pending'`
+	consumeDeviceAuthorizationQuery = `UPDATE device_authorizations SET status = 'consumed'
+		WHERE device_code_hash = :device_code_hash AND status = 'approved'`
+	// deleteExpiredDeviceAuthorizationsQuery deletes a batch at a time, so purges never hold
+	// locks long.
+	deleteExpiredDeviceAuthorizationsQuery = `DELETE FROM device_authorizations WHERE expires_at <= :expired_before
+		LIMIT 100`
+)
+
+// errorCodeDuplicateEntry is the MySQL error raised when a unique key is violated.
+const errorCodeDuplicateEntry = 1062
+
+type mysqlDeviceAuthorizationStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLDeviceAuthorizationStore instantiates a DeviceAuthorizationStore backed by MySQL.
+func NewMySQLDeviceAuthorizationStore(db mysql.MySQL) DeviceAuthorizationStore {
+	return &mysqlDeviceAuthorizationStore{db: db}
+}
+
+func (s *mysqlDeviceAuthorizationStore) Create(ctx context.Context, authorization *DeviceAuthorization) error {
+	_, err := s.db.ExecNamed(ctx, insertDeviceAuthorizationQuery, authorization)
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errorCodeDuplicateEntry &&
+		strings.Contains(mysqlErr.Message, "uniq_device_authorizations_user_code_hash") {
+		return errors.WithStack(ErrUserCodeExists)
+	}
+	return errors.WithStack(err)
+}
+
+func (s *mysqlDeviceAuthorizationStore) FindByDeviceCode(ctx context.Context, deviceCodeHash string) (*DeviceAuthorization, error) {
+	return s.find(ctx, findDeviceAuthorizationByDeviceCodeQuery, map[string]interface{}{"device_code_hash": deviceCodeHash})
+}
+
+func (s *mysqlDeviceAuthorizationStore) FindByUserCode(ctx context.Context, userCodeHash string) (*DeviceAuthorization, error) {
+	return s.find(ctx, findDeviceAuthorizationByUserCodeQuery, map[string]interface{}{"user_code_hash": userCodeHash})
+}
+
+func (s *mysqlDeviceAuthorizationStore) find(ctx context.Context, query string, args map[string]interface{}) (*DeviceAuthorization, error) {
+	var authorization DeviceAuthorization
+	// Polls must see approvals right away, so device authorizations are read from master.
+	err := s.db.GetNamedForWrite(ctx, &authorization, query, args)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithStack(ErrDeviceAuthorizationNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &authorization, nil
+}
+
+func (s *mysqlDeviceAuthorizationStore) UpdatePoll(ctx context.Context, deviceCodeHash string, polledAt time.Time, interval int64) error {
+	_, err := s.db.ExecNamed(ctx, updateDeviceAuthorizationPollQuery, map[string]interface{}{
+		"device_code_hash": deviceCodeHash,
+		"last_polled_at":   polledAt,
+		"poll_interval":    interval,
+	})
+	return errors.WithStack(err)
+}
+
+func (s *mysqlDeviceAuthorizationStore) Approve(ctx context.Context, userCodeHash string, session *Session) (bool, error) {
+	return s.update(ctx, approveDeviceAuthorizationQuery, map[string]interface{}{
+		"user_code_hash": userCodeHash,
+		"subject":        session.Subject,
+		"auth_time":      session.AuthTime.UTC(),
+		"amr":            strings.Join(session.AMR, " "),
+	})
+}
+
+func (s *mysqlDeviceAuthorizationStore) Deny(ctx context.Context, userCodeHash string) (bool, error) {
+	return s.update(ctx, denyDeviceAuthorizationQuery, map[string]interface{}{"user_code_hash": userCodeHash})
+}
+
+func (s *mysqlDeviceAuthorizationStore) Consume(ctx context.Context, deviceCodeHash string) (bool, error) {
+	return s.update(ctx, consumeDeviceAuthorizationQuery, map[string]interface{}{"device_code_hash": deviceCodeHash})
+}
+
+func (s *mysqlDeviceAuthorizationStore) DeleteExpired(ctx context.Context, expiredBefore time.Time) error {
+	_, err := s.db.ExecNamed(ctx, deleteExpiredDeviceAuthorizationsQuery, map[string]interface{}{
+		"expired_before": expiredBefore.UTC(),
+	})
+	return errors.WithStack(err)
+}
+
+// update runs a conditional update and reports whether a row matched.
+func (s *mysqlDeviceAuthorizationStore) update(ctx context.Context, query string, args map[string]interface{}) (bool, error) {
+	result, err := s.db.ExecNamed(ctx, query, args)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return affected == 1, nil
+}
@@ -0,0 +1,251 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/throttle"
+)
+
+type deviceServer struct {
+	*fixture
+	authorization http.Handler
+	verification  http.Handler
+	token         http.Handler
+	devices       DeviceAuthorizationStore
+	sessions      SessionProvider
+	config        DeviceConfig
+	session       *Session
+}
+
+func newDeviceServer(t *testing.T) *deviceServer {
+	t.Helper()
+	s := &deviceServer{fixture: newFixture(t)}
+	err := s.clients.Create(context.Background(), &Client{
+		ID:         "cli",
+		Name:       "Internal CLI",
+		GrantTypes: StringList{GrantTypeDeviceCode, GrantTypeRefreshToken},
+		Scopes:     StringList{"read"},
+	})
+	if err != nil {
+		t.Fatalf("ClientStore.Create() error = %v", err)
+	}
+	devices := NewMemoryDeviceAuthorizationStore()
+	config := DeviceConfig{
+		VerificationURI: "https://auth.example.com/device",
+		LoginURL:        "https://auth.example.com/login",
+		Lifetime:        10 * time.Minute,
+		Interval:        5 * time.Second,
+	}
+	sessions := SessionProviderFunc(func(*http.Request) (*Session, error) {
+		return s.session, nil
+	})
+	authenticator := NewClientAuthenticator(s.clients)
+	s.authorization = NewDeviceAuthorizationHandler(authenticator, devices, s.timegen, config, s.logger)
+	s.verification = NewDeviceVerificationHandler(s.clients, devices, sessions, s.timegen, config, s.logger)
+	s.token = NewTokenHandler(authenticator, s.logger,
+		NewDeviceCodeGrant(devices, NewTokenIssuer(s.accessTokens, s.refreshTokens, s.timegen), s.timegen))
+	s.devices, s.sessions, s.config = devices, sessions, config
+	return s
+}
+
+// start requests device and user codes.
+func (s *deviceServer) start(t *testing.T) (deviceCode, userCode string) {
+	t.Helper()
+	recorder, body := postForm(t, s.authorization, url.Values{"client_id": {"cli"}, "scope": {"read"}}, "", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("device authorization status = %d, body = %v", recorder.Code, body)
+	}
+	if body["interval"] != float64(5) || body["expires_in"] != float64(600) {
+		t.Errorf("device authorization body = %v, want interval 5 and expires_in 600", body)
+	}
+	if !strings.HasSuffix(body["verification_uri_complete"].(string), url.QueryEscape(body["user_code"].(string))) {
+		t.Errorf("verification_uri_complete = %v, want the user code", body["verification_uri_complete"])
+	}
+	return body["device_code"].(string), body["user_code"].(string)
+}
+
+func (s *deviceServer) poll(t *testing.T, deviceCode string) (int, map[string]interface{}) {
+	t.Helper()
+	recorder, body := postForm(t, s.token, url.Values{
+		"grant_type":  {GrantTypeDeviceCode},
+		"client_id":   {"cli"},
+		"device_code": {deviceCode},
+	}, "", "")
+	return recorder.Code, body
+}
+
+func (s *deviceServer) verify(t *testing.T, method string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/device?"+form.Encode(), nil)
+	if method == http.MethodPost {
+		req = httptest.NewRequest(method, "/device", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	recorder := httptest.NewRecorder()
+	s.verification.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestDeviceAuthorizationGrant(t *testing.T) {
+	t.Run("Issues tokens once the user approves", func(t *testing.T) {
+		s := newDeviceServer(t)
+		deviceCode, userCode := s.start(t)
+
+		if status, body := s.poll(t, deviceCode); status != http.StatusBadRequest || body["error"] != ErrorCodeAuthorizationPending {
+			t.Errorf("poll = %d %v, want authorization_pending", status, body)
+		}
+
+		if recorder := s.verify(t, http.MethodGet, url.Values{"user_code": {userCode}}); recorder.Code != http.StatusFound {
+			t.Errorf("verification without session status = %d, want %d", recorder.Code, http.StatusFound)
+		}
+		s.session = &Session{Subject: "user-1", AuthTime: s.timegen.Now(), AMR: []string{"pwd"}}
+		// User codes are accepted in lower case and without the separator.
+		typed := strings.ToLower(strings.ReplaceAll(userCode, "-", ""))
+		if recorder := s.verify(t, http.MethodGet, url.Values{"user_code": {typed}}); recorder.Code != http.StatusOK ||
+			!strings.Contains(recorder.Body.String(), "Internal CLI") {
+			t.Errorf("verification = %d %s, want the client description", recorder.Code, recorder.Body.String())
+		}
+		if recorder := s.verify(t, http.MethodPost, url.Values{"user_code": {typed}, "action": {"approve"}}); recorder.Code != http.StatusOK {
+			t.Fatalf("approval = %d %s", recorder.Code, recorder.Body.String())
+		}
+
+		s.timegen.Add(5 * time.Second)
+		status, body := s.poll(t, deviceCode)
+		if status != http.StatusOK || body["access_token"] == nil || body["refresh_token"] == nil {
+			t.Fatalf("poll = %d %v, want tokens", status, body)
+		}
+		claims, err := s.accessTokens.ParseClaims(context.Background(), body["access_token"].(string), false)
+		if err != nil || claims.Subject != "user-1" || claims.Scope != "read" {
+			t.Errorf("ParseClaims() = %+v, %v, want user-1 with scope read", claims, err)
+		}
+
+		s.timegen.Add(5 * time.Second)
+		if status, body := s.poll(t, deviceCode); status != http.StatusBadRequest || body["error"] != ErrorCodeInvalidGrant {
+			t.Errorf("second poll = %d %v, want invalid_grant", status, body)
+		}
+	})
+
+	t.Run("Slows down clients polling too fast", func(t *testing.T) {
+		s := newDeviceServer(t)
+		deviceCode, _ := s.start(t)
+		s.poll(t, deviceCode)
+		if status, body := s.poll(t, deviceCode); status != http.StatusBadRequest || body["error"] != ErrorCodeSlowDown {
+			t.Errorf("poll = %d %v, want slow_down", status, body)
+		}
+		// The interval is now 10 seconds.
+		s.timegen.Add(7 * time.Second)
+		if _, body := s.poll(t, deviceCode); body["error"] != ErrorCodeSlowDown {
+			t.Errorf("poll = %v, want slow_down", body)
+		}
+		s.timegen.Add(15 * time.Second)
+		if _, body := s.poll(t, deviceCode); body["error"] != ErrorCodeAuthorizationPending {
+			t.Errorf("poll = %v, want authorization_pending", body)
+		}
+	})
+
+	t.Run("Reports denied requests", func(t *testing.T) {
+		s := newDeviceServer(t)
+		deviceCode, userCode := s.start(t)
+		s.session = &Session{Subject: "user-1", AuthTime: s.timegen.Now()}
+		if recorder := s.verify(t, http.MethodPost, url.Values{"user_code": {userCode}, "action": {"deny"}}); recorder.Code != http.StatusOK {
+			t.Fatalf("denial = %d %s", recorder.Code, recorder.Body.String())
+		}
+		if _, body := s.poll(t, deviceCode); body["error"] != ErrorCodeAccessDenied {
+			t.Errorf("poll = %v, want access_denied", body)
+		}
+		if recorder := s.verify(t, http.MethodPost, url.Values{"user_code": {userCode}, "action": {"approve"}}); recorder.Code != http.StatusBadRequest {
+			t.Errorf("approval after denial = %d, want %d", recorder.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Reports expired device codes", func(t *testing.T) {
+		s := newDeviceServer(t)
+		deviceCode, userCode := s.start(t)
+		s.timegen.Add(11 * time.Minute)
+		if _, body := s.poll(t, deviceCode); body["error"] != ErrorCodeExpiredToken {
+			t.Errorf("poll = %v, want expired_token", body)
+		}
+		s.session = &Session{Subject: "user-1", AuthTime: s.timegen.Now()}
+		if recorder := s.verify(t, http.MethodGet, url.Values{"user_code": {userCode}}); recorder.Code != http.StatusBadRequest {
+			t.Errorf("verification = %d, want %d", recorder.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+// collidingDeviceStore reports the user codes of the first collisions device authorizations
+// as taken.
+type collidingDeviceStore struct {
+	DeviceAuthorizationStore
+	collisions int
+}
+
+func (s *collidingDeviceStore) Create(ctx context.Context, authorization *DeviceAuthorization) error {
+	if s.collisions > 0 {
+		s.collisions--
+		return ErrUserCodeExists
+	}
+	return s.DeviceAuthorizationStore.Create(ctx, authorization)
+}
+
+func TestDeviceAuthorizationHandler(t *testing.T) {
+	t.Run("Retries taken user codes", func(t *testing.T) {
+		s := newDeviceServer(t)
+		store := &collidingDeviceStore{DeviceAuthorizationStore: s.devices, collisions: maxUserCodeAttempts - 1}
+		s.authorization = NewDeviceAuthorizationHandler(NewClientAuthenticator(s.clients), store, s.timegen, s.config, s.logger)
+		deviceCode, _ := s.start(t)
+		if _, err := s.devices.FindByDeviceCode(context.Background(), securetoken.Hash(deviceCode)); err != nil {
+			t.Errorf("FindByDeviceCode() error = %v, want the device authorization stored", err)
+		}
+
+		store.collisions = maxUserCodeAttempts
+		recorder, body := postForm(t, s.authorization, url.Values{"client_id": {"cli"}, "scope": {"read"}}, "", "")
+		if recorder.Code != http.StatusInternalServerError {
+			t.Errorf("device authorization = %d %v, want server_error once attempts are exhausted", recorder.Code, body)
+		}
+	})
+
+	t.Run("Deletes device authorizations expired for a lifetime", func(t *testing.T) {
+		s := newDeviceServer(t)
+		expired, _ := s.start(t)
+		s.timegen.Add(15 * time.Minute)
+		recent, _ := s.start(t)
+		s.timegen.Add(11 * time.Minute)
+		s.start(t)
+		if _, err := s.devices.FindByDeviceCode(context.Background(), securetoken.Hash(expired)); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+			t.Errorf("FindByDeviceCode() error = %v, want the old device authorization deleted", err)
+		}
+		if _, body := s.poll(t, recent); body["error"] != ErrorCodeExpiredToken {
+			t.Errorf("poll = %v, want expired_token until it is deleted", body)
+		}
+	})
+}
+
+func TestDeviceVerificationHandler_Throttle(t *testing.T) {
+	s := newDeviceServer(t)
+	throttler := throttle.NewThrottler(throttle.NewMemoryStore(), s.timegen, throttle.Config{
+		Account: throttle.Policy{FreeFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Hour},
+		Window:  time.Hour,
+	})
+	s.verification = NewDeviceVerificationHandler(s.clients, s.devices, s.sessions, s.timegen, s.config, s.logger,
+		WithUserCodeThrottler(throttler))
+	_, userCode := s.start(t)
+	s.session = &Session{Subject: "user-1", AuthTime: s.timegen.Now()}
+	for _, guess := range []string{"BCDF-GHJK", "BCDF-GHJL", "BCDF-GHJM"} {
+		if recorder := s.verify(t, http.MethodGet, url.Values{"user_code": {guess}}); recorder.Code != http.StatusBadRequest {
+			t.Fatalf("verification = %d %s, want %d", recorder.Code, recorder.Body, http.StatusBadRequest)
+		}
+	}
+	recorder := s.verify(t, http.MethodGet, url.Values{"user_code": {userCode}})
+	if recorder.Code != http.StatusTooManyRequests || !strings.Contains(recorder.Body.String(), ErrorCodeSlowDown) ||
+		recorder.Header().Get("Retry-After") != "60" {
+		t.Errorf("verification = %d %s %v, want slow_down retrying after 60s", recorder.Code, recorder.Body, recorder.Header())
+	}
+}
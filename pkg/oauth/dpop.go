@@ -0,0 +1,347 @@
+package oauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// TokenTypeDPoP is the token_type of access tokens bound to a DPoP key, as defined by RFC 9449.
+const TokenTypeDPoP = "DPoP"
+
+// dpopAlgorithms lists the proof algorithms advertised in DPoP challenges.
+const dpopAlgorithms = "ES256 ES384 ES512 RS256 RS384 RS512 PS256 PS384 PS512"
+
+// DPoPNonces issues the server nonces clients put in DPoP proofs, which limit how long a
+// proof signed in advance can be used.
+type DPoPNonces interface {
+	// Nonce returns the nonce clients should currently use.
+	Nonce() (string, error)
+
+	// Valid checks whether nonce was issued recently.
+	Valid(nonce string) bool
+}
+
+type hmacDPoPNonces struct {
+	secret   []byte
+	timegen  timegenerator.TimeGenerator
+	lifetime time.Duration
+}
+
+// DefaultDPoPNonceLifetime is the window of DPoPNonces instantiated without a lifetime.
+const DefaultDPoPNonceLifetime = 5 * time.Minute
+
+// NewDPoPNonces instantiates DPoPNonces derived from secret and the current time window of
+// length lifetime, so instances sharing secret accept each other's nonces without shared
+// storage. A nonce is accepted until the end of the window following the one it was issued in.
+// A lifetime which is not positive is replaced with DefaultDPoPNonceLifetime.
+func NewDPoPNonces(secret []byte, timegen timegenerator.TimeGenerator, lifetime time.Duration) DPoPNonces {
+	if lifetime <= 0 {
+		lifetime = DefaultDPoPNonceLifetime
+	}
+	return &hmacDPoPNonces{secret: secret, timegen: timegen, lifetime: lifetime}
+}
+
+func (n *hmacDPoPNonces) Nonce() (string, error) {
+	return n.nonce(n.window()), nil
+}
+
+func (n *hmacDPoPNonces) Valid(nonce string) bool {
+	window := n.window()
+	return hmac.Equal([]byte(nonce), []byte(n.nonce(window))) || hmac.Equal([]byte(nonce), []byte(n.nonce(window-1)))
+}
+
+func (n *hmacDPoPNonces) window() int64 {
+	return n.timegen.Now().UnixNano() / int64(n.lifetime)
+}
+
+func (n *hmacDPoPNonces) nonce(window int64) string {
+	mac := hmac.New(sha256.New, n.secret)
+	_ = binary.Write(mac, binary.BigEndian, window)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DPoPConfig provides configs for the validation of DPoP proofs.
+type DPoPConfig struct {
+	// ProofLifetime is how long after its iat a proof is accepted. Proof jti are remembered as
+	// long, so proofs cannot be replayed.
+	ProofLifetime time.Duration
+	// Leeway tolerates clock skew of clients signing proofs slightly in the future.
+	Leeway time.Duration
+	// BaseURL replaces the scheme and host of request URLs compared with htu, e.g. behind a
+	// reverse proxy. When empty, they are derived from the request.
+	BaseURL string
+	// Nonces makes proofs carry a server nonce, when set.
+	Nonces DPoPNonces
+}
+
+// DPoPVerifier validates the DPoP proofs of RFC 9449.
+type DPoPVerifier interface {
+	// Verify validates the proof in the DPoP header of r and returns the thumbprint of its key,
+	// or an empty string when r carries no proof. When accessToken is set, the proof must be
+	// bound to it by ath. Errors are *Error values of the token endpoint.
+	Verify(r *http.Request, accessToken string) (string, error)
+
+	// Nonce returns the nonce clients should currently use, or an empty string when nonces
+	// are disabled.
+	Nonce() (string, error)
+}
+
+type dpopVerifier struct {
+	replays jwt.RevocationStore
+	timegen timegenerator.TimeGenerator
+	config  DPoPConfig
+}
+
+// NewDPoPVerifier instantiates a DPoPVerifier which remembers the jti of accepted proofs in replays.
+func NewDPoPVerifier(replays jwt.RevocationStore, timegen timegenerator.TimeGenerator, config DPoPConfig) DPoPVerifier {
+	return &dpopVerifier{replays: replays, timegen: timegen, config: config}
+}
+
+func (v *dpopVerifier) Verify(r *http.Request, accessToken string) (string, error) {
+	headers := r.Header.Values("DPoP")
+	if len(headers) == 0 {
+		return "", nil
+	}
+	if len(headers) > 1 {
+		return "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidDPoPProof, "only one DPoP proof is allowed")
+	}
+	proof, err := jwt.ParseDPoPProof(headers[0])
+	var tokenErr *jwt.TokenError
+	if errors.As(err, &tokenErr) {
+		return "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidDPoPProof, "DPoP proof is invalid")
+	}
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if proof.Method != r.Method || !sameURL(proof.URI, v.requestURL(r)) {
+		return "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidDPoPProof, "DPoP proof is for another request")
+	}
+	now := v.timegen.Now()
+	issuedAt := time.Unix(proof.IssuedAt, 0)
+	if issuedAt.After(now.Add(v.config.Leeway)) || now.Sub(issuedAt) > v.config.ProofLifetime+v.config.Leeway {
+		return "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidDPoPProof, "DPoP proof is expired")
+	}
+	if accessToken != "" && proof.AccessTokenHash != jwt.DPoPAccessTokenHash(accessToken) {
+		return "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidDPoPProof, "DPoP proof is for another access token")
+	}
+	if v.config.Nonces != nil && !v.config.Nonces.Valid(proof.Nonce) {
+		nonce, err := v.config.Nonces.Nonce()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		return "", httperror.New(http.StatusBadRequest, ErrorCodeUseDPoPNonce, "DPoP proof must carry the server nonce").
+			WithHeader("DPoP-Nonce", nonce)
+	}
+	thumbprint, err := proof.Key.Thumbprint()
+	if err != nil {
+		return "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidDPoPProof, "DPoP proof is invalid")
+	}
+	// jti only needs to be unique per key, so replays are keyed by both.
+	replayKey := securetoken.Hash(thumbprint + ":" + proof.ID)
+	first, err := v.replays.RevokeOnce(r.Context(), replayKey, issuedAt.Add(v.config.ProofLifetime+v.config.Leeway))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !first {
+		return "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidDPoPProof, "DPoP proof has already been used")
+	}
+	return thumbprint, nil
+}
+
+func (v *dpopVerifier) Nonce() (string, error) {
+	if v.config.Nonces == nil {
+		return "", nil
+	}
+	return v.config.Nonces.Nonce()
+}
+
+// requestURL returns the URL of r compared with htu, without query and fragment.
+func (v *dpopVerifier) requestURL(r *http.Request) string {
+	if v.config.BaseURL != "" {
+		return strings.TrimSuffix(v.config.BaseURL, "/") + r.URL.Path
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+// sameURL compares htu with the request URL, ignoring the query, the fragment and the case of
+// the scheme and host.
+func sameURL(htu, requestURL string) bool {
+	normalize := func(raw string) (string, bool) {
+		parsed, err := url.Parse(raw)
+		if err != nil || !parsed.IsAbs() {
+			return "", false
+		}
+		return strings.ToLower(parsed.Scheme) + "://" + strings.ToLower(parsed.Host) + parsed.EscapedPath(), true
+	}
+	proofURL, ok := normalize(htu)
+	if !ok {
+		return false
+	}
+	expected, ok := normalize(requestURL)
+	return ok && proofURL == expected
+}
+
+type dpopThumbprintKey struct{}
+
+// dpopThumbprint returns the thumbprint of the DPoP key proven by the request of ctx, if any.
+func dpopThumbprint(ctx context.Context) string {
+	thumbprint, _ := ctx.Value(dpopThumbprintKey{}).(string)
+	return thumbprint
+}
+
+type dpopTokenHandler struct {
+	tokens http.Handler
+	proofs DPoPVerifier
+	logger *logger.Logger
+}
+
+// NewDPoPTokenHandler wraps the token endpoint tokens with DPoP. Tokens issued to a request
+// carrying a proof are bound to its key: access tokens carry cnf.jkt and have the DPoP token
+// type, and refresh tokens can only be exchanged with a proof of the same key.
+func NewDPoPTokenHandler(tokens http.Handler, proofs DPoPVerifier, logger *logger.Logger) http.Handler {
+	return &dpopTokenHandler{tokens: tokens, proofs: proofs, logger: logger}
+}
+
+func (h *dpopTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	nonce, err := h.proofs.Nonce()
+	if err != nil {
+		writeError(w, errors.WithStack(err), h.logger)
+		return
+	}
+	if nonce != "" {
+		w.Header().Set("DPoP-Nonce", nonce)
+	}
+	thumbprint, err := h.proofs.Verify(r, "")
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if thumbprint != "" {
+		r = r.WithContext(context.WithValue(r.Context(), dpopThumbprintKey{}, thumbprint))
+	}
+	h.tokens.ServeHTTP(w, r)
+}
+
+// AccessTokenVerifier authenticates requests to protected resources with access tokens.
+type AccessTokenVerifier interface {
+	// Verify returns the claims of the access token of r. Errors are *Error values carrying the
+	// WWW-Authenticate challenge of RFC 6750 or RFC 9449.
+	Verify(r *http.Request) (*jwt.Claims, error)
+}
+
+type accessTokenVerifier struct {
+	accessTokens jwt.JWT
+	proofs       DPoPVerifier
+}
+
+// NewAccessTokenVerifier instantiates an AccessTokenVerifier accepting bearer tokens, and tokens
+// bound to a DPoP key when presented with the DPoP scheme and a proof of the key. Without
+// proofs, tokens bound to a key are rejected. Tokens bound to a certificate must be presented
+// over a TLS connection authenticated with it.
+func NewAccessTokenVerifier(accessTokens jwt.JWT, proofs DPoPVerifier) AccessTokenVerifier {
+	return &accessTokenVerifier{accessTokens: accessTokens, proofs: proofs}
+}
+
+func (v *accessTokenVerifier) Verify(r *http.Request) (*jwt.Claims, error) {
+	scheme, token := accessToken(r)
+	if token == "" {
+		return nil, newChallengeError(scheme, http.StatusUnauthorized, ErrorCodeInvalidRequest, "access token is required")
+	}
+	claims, err := v.accessTokens.ParseClaims(r.Context(), token, false)
+	var tokenErr *jwt.TokenError
+	if errors.As(err, &tokenErr) {
+		return nil, newChallengeError(scheme, http.StatusUnauthorized, ErrorCodeInvalidToken, "access token is invalid")
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var jkt string
+	if cnf := claims.Confirmation; cnf != nil {
+		jkt = cnf.JWKThumbprint
+		if cnf.X509Thumbprint != "" {
+			cert := peerCertificate(r)
+			if cert == nil || CertificateThumbprint(cert) != cnf.X509Thumbprint {
+				return nil, newChallengeError(scheme, http.StatusUnauthorized, ErrorCodeInvalidToken, "access token is bound to another certificate")
+			}
+		}
+	}
+	switch {
+	case jkt == "" && scheme == TokenTypeDPoP:
+		return nil, newChallengeError(scheme, http.StatusUnauthorized, ErrorCodeInvalidToken, "access token is not bound to a DPoP key")
+	case jkt == "":
+		return claims, nil
+	case scheme != TokenTypeDPoP || v.proofs == nil:
+		return nil, newChallengeError(TokenTypeDPoP, http.StatusUnauthorized, ErrorCodeInvalidToken, "access token is bound to a DPoP key")
+	}
+
+	thumbprint, err := v.proofs.Verify(r, token)
+	var oauthErr *Error
+	if errors.As(err, &oauthErr) {
+		challenge := newChallengeError(TokenTypeDPoP, http.StatusUnauthorized, oauthErr.Code, oauthErr.Description)
+		for name := range oauthErr.Header() {
+			challenge.WithHeader(name, oauthErr.Header().Get(name))
+		}
+		return nil, challenge
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if thumbprint == "" {
+		return nil, newChallengeError(TokenTypeDPoP, http.StatusUnauthorized, ErrorCodeInvalidDPoPProof, "DPoP proof is required")
+	}
+	if thumbprint != jkt {
+		return nil, newChallengeError(TokenTypeDPoP, http.StatusUnauthorized, ErrorCodeInvalidDPoPProof, "DPoP proof is signed by another key")
+	}
+	return claims, nil
+}
+
+// accessToken extracts the access token of r and its scheme, Bearer or DPoP, from the
+// Authorization header or a form body.
+func accessToken(r *http.Request) (string, string) {
+	header := r.Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer", TokenTypeDPoP} {
+		if len(header) > len(scheme)+1 && strings.EqualFold(header[:len(scheme)+1], scheme+" ") {
+			return scheme, strings.TrimSpace(header[len(scheme)+1:])
+		}
+	}
+	if r.Method == http.MethodPost && r.ParseForm() == nil {
+		return "Bearer", r.PostForm.Get("access_token")
+	}
+	return "Bearer", ""
+}
+
+// tokenScheme returns the scheme access tokens with claims are presented with.
+func tokenScheme(claims *jwt.Claims) string {
+	if claims.Confirmation != nil && claims.Confirmation.JWKThumbprint != "" {
+		return TokenTypeDPoP
+	}
+	return "Bearer"
+}
+
+// newChallengeError creates an error of a protected resource, which is reported in the
+// WWW-Authenticate challenge of scheme too.
+func newChallengeError(scheme string, status int, code, description string) *Error {
+	challenge := scheme + ` error="` + code + `", error_description="` + description + `"`
+	if scheme == TokenTypeDPoP {
+		challenge += `, algs="` + dpopAlgorithms + `"`
+	}
+	return httperror.New(status, code, description).WithHeader("WWW-Authenticate", challenge)
+}
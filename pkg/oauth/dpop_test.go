@@ -0,0 +1,396 @@
+package oauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+)
+
+// dpopClient holds the DPoP key pair of a client.
+type dpopClient struct {
+	key        *ecdsa.PrivateKey
+	thumbprint string
+}
+
+func newDPoPClient(t *testing.T) *dpopClient {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	thumbprint, err := jwt.NewECJWK("", &key.PublicKey).Thumbprint()
+	if err != nil {
+		t.Fatalf("Thumbprint() error = %v", err)
+	}
+	return &dpopClient{key: key, thumbprint: thumbprint}
+}
+
+func (c *dpopClient) proof(t *testing.T, s *authorizationServer, method, path, accessToken, nonce string) string {
+	t.Helper()
+	proof, err := jwt.NewDPoPProof(c.key, method, s.server.URL+path, accessToken, nonce, s.timegen.Now())
+	if err != nil {
+		t.Fatalf("NewDPoPProof() error = %v", err)
+	}
+	return proof
+}
+
+// dpopTokens runs an authorization code flow whose token request carries a proof of client.
+func (s *authorizationServer) dpopTokens(t *testing.T, client *dpopClient) map[string]interface{} {
+	t.Helper()
+	header := http.Header{"Dpop": {client.proof(t, s, http.MethodPost, "/token", "", "")}}
+	resp, body := s.tokenWithHeader(t, codeForm(s.codeFor(t, oidcParams("openid profile")), testCodeVerifier), header)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /token status = %d, body = %v", resp.StatusCode, body)
+	}
+	return body
+}
+
+func (s *authorizationServer) userInfoWithHeader(t *testing.T, header http.Header) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, s.server.URL+"/userinfo", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest() error = %v", err)
+	}
+	request.Header = header
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("GET /userinfo error = %v", err)
+	}
+	defer resp.Body.Close()
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("json.Decode() error = %v", err)
+	}
+	return resp, body
+}
+
+func TestDPoP_TokenBinding(t *testing.T) {
+	t.Run("Binds tokens to the key of the proof", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		client := newDPoPClient(t)
+		body := s.dpopTokens(t, client)
+		if body["token_type"] != TokenTypeDPoP {
+			t.Errorf("token_type = %v, want DPoP", body["token_type"])
+		}
+		claims := parseAccessToken(t, s.fixture, body["access_token"].(string), "api")
+		if claims.Confirmation == nil || claims.Confirmation.JWKThumbprint != client.thumbprint {
+			t.Errorf("cnf = %+v, want jkt %s", claims.Confirmation, client.thumbprint)
+		}
+		idToken := parseAccessToken(t, s.fixture, body["id_token"].(string), testAppClientID)
+		if idToken.Confirmation != nil {
+			t.Errorf("ID token cnf = %+v, want none", idToken.Confirmation)
+		}
+	})
+
+	t.Run("Issues bearer tokens without a proof", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		status, body := s.token(t, codeForm(s.code(t), testCodeVerifier))
+		if status != http.StatusOK || body["token_type"] != "Bearer" {
+			t.Fatalf("POST /token = %d %v, want a bearer token", status, body)
+		}
+		if claims := parseAccessToken(t, s.fixture, body["access_token"].(string), "api"); claims.Confirmation != nil {
+			t.Errorf("cnf = %+v, want none", claims.Confirmation)
+		}
+	})
+
+	t.Run("Refreshes bound tokens with a proof of the same key only", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		client := newDPoPClient(t)
+		refreshToken := s.dpopTokens(t, client)["refresh_token"].(string)
+		form := url.Values{
+			"grant_type":    {GrantTypeRefreshToken},
+			"client_id":     {testAppClientID},
+			"refresh_token": {refreshToken},
+		}
+
+		for name, header := range map[string]http.Header{
+			"Without a proof":  {},
+			"With another key": {"Dpop": {newDPoPClient(t).proof(t, s, http.MethodPost, "/token", "", "")}},
+		} {
+			resp, body := s.tokenWithHeader(t, form, header)
+			if resp.StatusCode != http.StatusBadRequest || body["error"] != ErrorCodeInvalidGrant {
+				t.Errorf("%s: POST /token = %d %v, want invalid_grant", name, resp.StatusCode, body)
+			}
+		}
+
+		resp, body := s.tokenWithHeader(t, form, http.Header{"Dpop": {client.proof(t, s, http.MethodPost, "/token", "", "")}})
+		if resp.StatusCode != http.StatusOK || body["token_type"] != TokenTypeDPoP {
+			t.Fatalf("POST /token = %d %v, want a DPoP token", resp.StatusCode, body)
+		}
+		claims := parseAccessToken(t, s.fixture, body["access_token"].(string), "api")
+		if claims.Confirmation == nil || claims.Confirmation.JWKThumbprint != client.thumbprint {
+			t.Errorf("cnf = %+v, want jkt %s", claims.Confirmation, client.thumbprint)
+		}
+	})
+}
+
+func TestDPoP_TokenEndpointProofs(t *testing.T) {
+	tests := []struct {
+		name  string
+		proof func(t *testing.T, s *authorizationServer, client *dpopClient) string
+	}{
+		{
+			name: "Rejects a proof for another method",
+			proof: func(t *testing.T, s *authorizationServer, client *dpopClient) string {
+				return client.proof(t, s, http.MethodGet, "/token", "", "")
+			},
+		},
+		{
+			name: "Rejects a proof for another URL",
+			proof: func(t *testing.T, s *authorizationServer, client *dpopClient) string {
+				return client.proof(t, s, http.MethodPost, "/userinfo", "", "")
+			},
+		},
+		{
+			name: "Rejects an expired proof",
+			proof: func(t *testing.T, s *authorizationServer, client *dpopClient) string {
+				proof, err := jwt.NewDPoPProof(client.key, http.MethodPost, s.server.URL+"/token", "", "",
+					s.timegen.Now().Add(-2*time.Minute))
+				if err != nil {
+					t.Fatalf("NewDPoPProof() error = %v", err)
+				}
+				return proof
+			},
+		},
+		{
+			name: "Rejects a proof issued in the future",
+			proof: func(t *testing.T, s *authorizationServer, client *dpopClient) string {
+				proof, err := jwt.NewDPoPProof(client.key, http.MethodPost, s.server.URL+"/token", "", "",
+					s.timegen.Now().Add(time.Minute))
+				if err != nil {
+					t.Fatalf("NewDPoPProof() error = %v", err)
+				}
+				return proof
+			},
+		},
+		{
+			name: "Rejects a malformed proof",
+			proof: func(*testing.T, *authorizationServer, *dpopClient) string {
+				return "malformed"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAuthorizationServer(t)
+			header := http.Header{"Dpop": {tt.proof(t, s, newDPoPClient(t))}}
+			resp, body := s.tokenWithHeader(t, codeForm(s.code(t), testCodeVerifier), header)
+			if resp.StatusCode != http.StatusBadRequest || body["error"] != ErrorCodeInvalidDPoPProof {
+				t.Errorf("POST /token = %d %v, want invalid_dpop_proof", resp.StatusCode, body)
+			}
+		})
+	}
+
+	t.Run("Rejects a replayed proof", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		header := http.Header{"Dpop": {newDPoPClient(t).proof(t, s, http.MethodPost, "/token", "", "")}}
+		if resp, body := s.tokenWithHeader(t, codeForm(s.code(t), testCodeVerifier), header.Clone()); resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /token = %d %v", resp.StatusCode, body)
+		}
+		resp, body := s.tokenWithHeader(t, codeForm(s.code(t), testCodeVerifier), header)
+		if resp.StatusCode != http.StatusBadRequest || body["error"] != ErrorCodeInvalidDPoPProof {
+			t.Errorf("POST /token = %d %v, want invalid_dpop_proof", resp.StatusCode, body)
+		}
+	})
+}
+
+func TestDPoP_Nonces(t *testing.T) {
+	t.Run("Asks for a nonce and accepts proofs carrying it", func(t *testing.T) {
+		s := newDPoPAuthorizationServer(t, true)
+		client := newDPoPClient(t)
+		form := codeForm(s.code(t), testCodeVerifier)
+		resp, body := s.tokenWithHeader(t, form, http.Header{"Dpop": {client.proof(t, s, http.MethodPost, "/token", "", "")}})
+		nonce := resp.Header.Get("DPoP-Nonce")
+		if resp.StatusCode != http.StatusBadRequest || body["error"] != ErrorCodeUseDPoPNonce || nonce == "" {
+			t.Fatalf("POST /token = %d %v, nonce = %q, want use_dpop_nonce with a nonce", resp.StatusCode, body, nonce)
+		}
+		resp, body = s.tokenWithHeader(t, form, http.Header{"Dpop": {client.proof(t, s, http.MethodPost, "/token", "", nonce)}})
+		if resp.StatusCode != http.StatusOK || body["token_type"] != TokenTypeDPoP {
+			t.Errorf("POST /token = %d %v, want a DPoP token", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("Rejects expired nonces", func(t *testing.T) {
+		s := newDPoPAuthorizationServer(t, true)
+		client := newDPoPClient(t)
+		form := codeForm(s.code(t), testCodeVerifier)
+		resp, _ := s.tokenWithHeader(t, form, http.Header{"Dpop": {client.proof(t, s, http.MethodPost, "/token", "", "")}})
+		nonce := resp.Header.Get("DPoP-Nonce")
+		s.timegen.Add(2 * time.Minute)
+		resp, body := s.tokenWithHeader(t, form, http.Header{"Dpop": {client.proof(t, s, http.MethodPost, "/token", "", nonce)}})
+		if resp.StatusCode != http.StatusBadRequest || body["error"] != ErrorCodeUseDPoPNonce || resp.Header.Get("DPoP-Nonce") == nonce {
+			t.Errorf("POST /token = %d %v, want use_dpop_nonce with a new nonce", resp.StatusCode, body)
+		}
+	})
+}
+
+func TestNewDPoPNonces_DefaultLifetime(t *testing.T) {
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	for _, lifetime := range []time.Duration{0, -time.Minute} {
+		nonces := NewDPoPNonces([]byte("nonce-secret"), timegen, lifetime)
+		nonce, err := nonces.Nonce()
+		if err != nil {
+			t.Fatalf("Nonce() error = %v", err)
+		}
+		timegen.Add(DefaultDPoPNonceLifetime)
+		if !nonces.Valid(nonce) {
+			t.Errorf("Valid() = false with lifetime %s, want the nonce accepted in the next default window", lifetime)
+		}
+		timegen.Add(DefaultDPoPNonceLifetime)
+		if nonces.Valid(nonce) {
+			t.Errorf("Valid() = true with lifetime %s, want the nonce expired after two default windows", lifetime)
+		}
+	}
+}
+
+func TestDPoP_ProtectedResource(t *testing.T) {
+	newServer := func(t *testing.T) (*authorizationServer, *dpopClient, string) {
+		t.Helper()
+		s := newAuthorizationServer(t)
+		s.users["user-1"] = &UserInfo{Subject: "user-1", Name: "Jane Doe"}
+		client := newDPoPClient(t)
+		return s, client, s.dpopTokens(t, client)["access_token"].(string)
+	}
+
+	t.Run("Accepts a bound token with a proof of its key", func(t *testing.T) {
+		s, client, accessToken := newServer(t)
+		resp, body := s.userInfoWithHeader(t, http.Header{
+			"Authorization": {"DPoP " + accessToken},
+			"Dpop":          {client.proof(t, s, http.MethodGet, "/userinfo", accessToken, "")},
+		})
+		if resp.StatusCode != http.StatusOK || body["name"] != "Jane Doe" {
+			t.Errorf("GET /userinfo = %d %v, want the user claims", resp.StatusCode, body)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		header    func(t *testing.T, s *authorizationServer, client *dpopClient, accessToken string) http.Header
+		wantError string
+	}{
+		{
+			name: "Rejects a bound token presented as a bearer token",
+			header: func(t *testing.T, s *authorizationServer, client *dpopClient, accessToken string) http.Header {
+				return http.Header{"Authorization": {"Bearer " + accessToken}}
+			},
+			wantError: ErrorCodeInvalidToken,
+		},
+		{
+			name: "Rejects a bound token without a proof",
+			header: func(t *testing.T, s *authorizationServer, client *dpopClient, accessToken string) http.Header {
+				return http.Header{"Authorization": {"DPoP " + accessToken}}
+			},
+			wantError: ErrorCodeInvalidDPoPProof,
+		},
+		{
+			name: "Rejects a proof of another key",
+			header: func(t *testing.T, s *authorizationServer, client *dpopClient, accessToken string) http.Header {
+				return http.Header{
+					"Authorization": {"DPoP " + accessToken},
+					"Dpop":          {newDPoPClient(t).proof(t, s, http.MethodGet, "/userinfo", accessToken, "")},
+				}
+			},
+			wantError: ErrorCodeInvalidDPoPProof,
+		},
+		{
+			name: "Rejects a proof without ath",
+			header: func(t *testing.T, s *authorizationServer, client *dpopClient, accessToken string) http.Header {
+				return http.Header{
+					"Authorization": {"DPoP " + accessToken},
+					"Dpop":          {client.proof(t, s, http.MethodGet, "/userinfo", "", "")},
+				}
+			},
+			wantError: ErrorCodeInvalidDPoPProof,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, client, accessToken := newServer(t)
+			resp, body := s.userInfoWithHeader(t, tt.header(t, s, client, accessToken))
+			if resp.StatusCode != http.StatusUnauthorized || body["error"] != tt.wantError {
+				t.Errorf("GET /userinfo = %d %v, want %s", resp.StatusCode, body, tt.wantError)
+			}
+			if got := resp.Header.Get("WWW-Authenticate"); !strings.HasPrefix(got, `DPoP error="`+tt.wantError+`"`) {
+				t.Errorf("WWW-Authenticate = %q, want a DPoP challenge", got)
+			}
+		})
+	}
+
+	t.Run("Rejects a replayed proof", func(t *testing.T) {
+		s, client, accessToken := newServer(t)
+		header := http.Header{
+			"Authorization": {"DPoP " + accessToken},
+			"Dpop":          {client.proof(t, s, http.MethodGet, "/userinfo", accessToken, "")},
+		}
+		s.userInfoWithHeader(t, header.Clone())
+		if resp, body := s.userInfoWithHeader(t, header); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET /userinfo = %d %v, want 401", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("Accepts a proof once among concurrent requests", func(t *testing.T) {
+		s, client, accessToken := newServer(t)
+		proof := client.proof(t, s, http.MethodGet, "/userinfo", accessToken, "")
+		statuses := make(chan int, 10)
+		var wg sync.WaitGroup
+		for i := 0; i < cap(statuses); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				request, _ := http.NewRequest(http.MethodGet, s.server.URL+"/userinfo", nil)
+				request.Header = http.Header{"Authorization": {"DPoP " + accessToken}, "Dpop": {proof}}
+				resp, err := http.DefaultClient.Do(request)
+				if err != nil {
+					t.Errorf("GET /userinfo error = %v", err)
+					return
+				}
+				resp.Body.Close()
+				statuses <- resp.StatusCode
+			}()
+		}
+		wg.Wait()
+		close(statuses)
+		accepted := 0
+		for status := range statuses {
+			if status == http.StatusOK {
+				accepted++
+			}
+		}
+		if accepted != 1 {
+			t.Errorf("accepted = %d, want the proof accepted once", accepted)
+		}
+	})
+
+	t.Run("Rejects a bearer token presented with the DPoP scheme", func(t *testing.T) {
+		s, client, _ := newServer(t)
+		_, tokens := s.oidcTokens(t, oidcParams("openid"))
+		accessToken := tokens["access_token"].(string)
+		resp, body := s.userInfoWithHeader(t, http.Header{
+			"Authorization": {"DPoP " + accessToken},
+			"Dpop":          {client.proof(t, s, http.MethodGet, "/userinfo", accessToken, "")},
+		})
+		if resp.StatusCode != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidToken {
+			t.Errorf("GET /userinfo = %d %v, want invalid_token", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("Reports the key of bound tokens on introspection", func(t *testing.T) {
+		s, client, accessToken := newServer(t)
+		handler := NewIntrospectionHandler(NewClientAuthenticator(s.clients), s.accessTokens, s.refreshTokens, s.logger)
+		_, body := postForm(t, handler, url.Values{"token": {accessToken}}, testClientID, testClientSecret)
+		cnf, _ := body["cnf"].(map[string]interface{})
+		if body["token_type"] != TokenTypeDPoP || cnf["jkt"] != client.thumbprint {
+			t.Errorf("body = %v, want a DPoP token bound to %s", body, client.thumbprint)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package oauth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+type authorizationCodeGrant struct {
+	codes         AuthorizationCodeStore
+	issuer        TokenIssuer
+	refreshTokens refreshtoken.Service
+	timegen       timegenerator.TimeGenerator
+}
+
+// NewAuthorizationCodeGrant instantiates the authorization_code grant. A replayed code revokes
+// the refresh tokens issued for it, as required by RFC 6749 4.1.2.
+func NewAuthorizationCodeGrant(codes AuthorizationCodeStore, issuer TokenIssuer, refreshTokens refreshtoken.Service,
+	timegen timegenerator.TimeGenerator) GrantHandler {
+	return &authorizationCodeGrant{
+		codes:         codes,
+		issuer:        issuer,
+		refreshTokens: refreshTokens,
+		timegen:       timegen,
+	}
+}
+
+func (g *authorizationCodeGrant) GrantType() string {
+	return GrantTypeAuthorizationCode
+}
+
+func (g *authorizationCodeGrant) Handle(r *http.Request, client *Client) (*TokenResponse, error) {
+	value := r.PostForm.Get("code")
+	if value == "" {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "code is required")
+	}
+	now := g.timegen.Now().UTC()
+	codeHash := securetoken.Hash(value)
+	code, firstUse, err := g.codes.Consume(r.Context(), codeHash, now)
+	if errors.Is(err, ErrCodeNotFound) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "code is invalid")
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !firstUse {
+		if code.RefreshFamilyID != "" {
+			if err := g.refreshTokens.RevokeFamily(r.Context(), code.RefreshFamilyID); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "code has already been used")
+	}
+	if !now.Before(code.ExpiresAt) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "code is expired")
+	}
+	if code.ClientID != client.ID {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "code was issued to another client")
+	}
+	if code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "redirect_uri does not match")
+	}
+	if !verifyCodeVerifier(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "code_verifier is invalid")
+	}
+
+	response, err := g.issuer.Issue(r.Context(), TokenRequest{
+		Client:            client,
+		Subject:           code.Subject,
+		Scope:             code.Scope,
+		AuthTime:          code.AuthTime,
+		AMR:               strings.Fields(code.AMR),
+		IssueRefreshToken: true,
+		Nonce:             code.Nonce,
+		ACR:               code.ACR,
+		Code:              value,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if response.refreshFamilyID != "" {
+		if err := g.codes.SetRefreshFamily(r.Context(), codeHash, response.refreshFamilyID); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,54 @@
+package oauth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/pkg/errors"
+)
+
+type clientCredentialsGrant struct {
+	issuer TokenIssuer
+}
+
+// NewClientCredentialsGrant instantiates the client_credentials grant, which issues tokens to
+// confidential clients acting on their own behalf.
+func NewClientCredentialsGrant(issuer TokenIssuer) GrantHandler {
+	return &clientCredentialsGrant{issuer: issuer}
+}
+
+func (g *clientCredentialsGrant) GrantType() string {
+	return GrantTypeClientCredentials
+}
+
+func (g *clientCredentialsGrant) Handle(r *http.Request, client *Client) (*TokenResponse, error) {
+	if client.IsPublic() {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeUnauthorizedClient, "public clients cannot use client_credentials")
+	}
+	scopes := splitScope(r.PostForm.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !client.AllowsScopes(scopes) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidScope, "scope is not allowed for this client")
+	}
+	// RFC 8707 resource indicators narrow the token down to some of the registered audiences.
+	resources := r.PostForm["resource"]
+	for _, resource := range resources {
+		if !client.Audiences.Contains(resource) {
+			return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidTarget, "resource is not allowed for this client")
+		}
+	}
+
+	response, err := g.issuer.Issue(r.Context(), TokenRequest{
+		Client:   client,
+		Subject:  client.ID,
+		Scope:    strings.Join(scopes, " "),
+		Audience: resources,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return response, nil
+}
@@ -0,0 +1,244 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+)
+
+const testTokenEndpoint = "https://auth.example.com/token"
+
+func newClientCredentialsHandler(t *testing.T, f *fixture) http.Handler {
+	t.Helper()
+	key, err := jwttest.Key()
+	if err != nil {
+		t.Fatalf("jwttest.Key() error = %v", err)
+	}
+	clients := []Client{
+		{
+			ID:                  "orders",
+			SecretHash:          securetoken.Hash("orders-secret"),
+			GrantTypes:          StringList{GrantTypeClientCredentials},
+			Scopes:              StringList{"orders:read", "orders:write"},
+			Audiences:           StringList{"orders-api", "billing-api"},
+			AccessTokenLifetime: 60,
+		},
+		{
+			ID:                      "billing",
+			SecretHash:              securetoken.Hash("billing-secret"),
+			TokenEndpointAuthMethod: AuthMethodClientSecretPost,
+			GrantTypes:              StringList{GrantTypeClientCredentials},
+			Scopes:                  StringList{"billing:read"},
+		},
+		{
+			ID:                      "shipping",
+			TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT,
+			JWKS:                    KeySet{Keys: []jwt.JWK{jwt.NewRSAJWK(jwttest.KeyID, &key.PublicKey)}},
+			GrantTypes:              StringList{GrantTypeClientCredentials},
+			Scopes:                  StringList{"shipping:read"},
+		},
+	}
+	for i := range clients {
+		if err := f.clients.Create(context.Background(), &clients[i]); err != nil {
+			t.Fatalf("ClientStore.Create() error = %v", err)
+		}
+	}
+	authenticator := NewClientAuthenticator(f.clients,
+		WithPrivateKeyJWT(testTokenEndpoint, f.timegen, jwt.NewMemoryRevocationStore(f.timegen)))
+	return NewTokenHandler(authenticator, f.logger,
+		NewClientCredentialsGrant(NewTokenIssuer(f.accessTokens, f.refreshTokens, f.timegen)))
+}
+
+func newClientAssertion(t *testing.T, f *fixture, clientID, audience string, lifetime time.Duration) string {
+	t.Helper()
+	signer, err := jwttest.NewRS256(f.timegen, clientID, audience, lifetime)
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	assertion, _, err := signer.SignClaims(context.Background(), &jwt.Claims{Subject: clientID})
+	if err != nil {
+		t.Fatalf("SignClaims() error = %v", err)
+	}
+	return assertion
+}
+
+func parseAccessToken(t *testing.T, f *fixture, accessToken string, audiences ...string) *jwt.Claims {
+	t.Helper()
+	key, err := jwttest.Key()
+	if err != nil {
+		t.Fatalf("jwttest.Key() error = %v", err)
+	}
+	claims, err := jwt.ParseWithKeySet(context.Background(), accessToken,
+		jwt.JWKSet{Keys: []jwt.JWK{jwt.NewRSAJWK(jwttest.KeyID, &key.PublicKey)}},
+		jwt.ValidationPolicy{Issuers: []string{"https://auth.example.com"}, Audiences: audiences},
+		f.timegen.Now())
+	if err != nil {
+		t.Fatalf("ParseWithKeySet() error = %v", err)
+	}
+	return claims
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	t.Run("Issues tokens with the registered scopes, audiences and lifetime", func(t *testing.T) {
+		f := newFixture(t)
+		handler := newClientCredentialsHandler(t, f)
+		recorder, body := postForm(t, handler, url.Values{"grant_type": {GrantTypeClientCredentials}}, "orders", "orders-secret")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %v", recorder.Code, body)
+		}
+		if body["expires_in"] != float64(60) || body["refresh_token"] != nil {
+			t.Errorf("body = %v, want expires_in 60 without refresh_token", body)
+		}
+		claims := parseAccessToken(t, f, body["access_token"].(string), "api", "orders-api", "billing-api")
+		if claims.Subject != "orders" || claims.Scope != "orders:read orders:write" {
+			t.Errorf("claims = %+v, want subject orders with every registered scope", claims)
+		}
+		if len(claims.Audience) != 2 || !claims.Audience.Contains("orders-api") || !claims.Audience.Contains("billing-api") {
+			t.Errorf("aud = %v, want the registered audiences", claims.Audience)
+		}
+		if want := f.timegen.Now().Add(time.Minute).Unix(); claims.ExpiresAt != want {
+			t.Errorf("exp = %d, want %d", claims.ExpiresAt, want)
+		}
+	})
+
+	t.Run("Narrows the audience to a resource", func(t *testing.T) {
+		f := newFixture(t)
+		handler := newClientCredentialsHandler(t, f)
+		form := url.Values{"grant_type": {GrantTypeClientCredentials}, "resource": {"billing-api"}, "scope": {"orders:read"}}
+		recorder, body := postForm(t, handler, form, "orders", "orders-secret")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %v", recorder.Code, body)
+		}
+		claims := parseAccessToken(t, f, body["access_token"].(string), "api", "orders-api", "billing-api")
+		if len(claims.Audience) != 1 || claims.Audience[0] != "billing-api" || claims.Scope != "orders:read" {
+			t.Errorf("claims = %+v, want aud billing-api with scope orders:read", claims)
+		}
+	})
+
+	t.Run("Authenticates with client_secret_post", func(t *testing.T) {
+		f := newFixture(t)
+		handler := newClientCredentialsHandler(t, f)
+		form := url.Values{"grant_type": {GrantTypeClientCredentials}, "client_id": {"billing"}, "client_secret": {"billing-secret"}}
+		recorder, body := postForm(t, handler, form, "", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %v", recorder.Code, body)
+		}
+		claims := parseAccessToken(t, f, body["access_token"].(string), "api", "orders-api", "billing-api")
+		if !claims.Audience.Contains("api") || body["expires_in"] != float64(300) {
+			t.Errorf("aud = %v, expires_in = %v, want the default audience and lifetime", claims.Audience, body["expires_in"])
+		}
+	})
+
+	t.Run("Authenticates with private_key_jwt once per assertion", func(t *testing.T) {
+		f := newFixture(t)
+		handler := newClientCredentialsHandler(t, f)
+		form := url.Values{
+			"grant_type":            {GrantTypeClientCredentials},
+			"client_assertion_type": {ClientAssertionTypeJWTBearer},
+			"client_assertion":      {newClientAssertion(t, f, "shipping", testTokenEndpoint, time.Minute)},
+		}
+		recorder, body := postForm(t, handler, form, "", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %v", recorder.Code, body)
+		}
+		recorder, body = postForm(t, handler, form, "", "")
+		if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidClient {
+			t.Errorf("replay = %d %v, want invalid_client", recorder.Code, body)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		clientID   string
+		secret     string
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "Rejects a method the client is not registered with",
+			form:       url.Values{"client_id": {"orders"}, "client_secret": {"orders-secret"}},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  ErrorCodeInvalidClient,
+		},
+		{
+			name:       "Rejects a wrong secret",
+			clientID:   "orders",
+			secret:     "wrong",
+			wantStatus: http.StatusUnauthorized,
+			wantError:  ErrorCodeInvalidClient,
+		},
+		{
+			name:       "Rejects several authentication methods",
+			form:       url.Values{"client_id": {"orders"}, "client_secret": {"orders-secret"}},
+			clientID:   "orders",
+			secret:     "orders-secret",
+			wantStatus: http.StatusBadRequest,
+			wantError:  ErrorCodeInvalidRequest,
+		},
+		{
+			name:       "Rejects an unregistered scope",
+			form:       url.Values{"scope": {"billing:read"}},
+			clientID:   "orders",
+			secret:     "orders-secret",
+			wantStatus: http.StatusBadRequest,
+			wantError:  ErrorCodeInvalidScope,
+		},
+		{
+			name:       "Rejects an unregistered resource",
+			form:       url.Values{"resource": {"shipping-api"}},
+			clientID:   "orders",
+			secret:     "orders-secret",
+			wantStatus: http.StatusBadRequest,
+			wantError:  ErrorCodeInvalidTarget,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			handler := newClientCredentialsHandler(t, f)
+			form := url.Values{"grant_type": {GrantTypeClientCredentials}}
+			for key, values := range tt.form {
+				form[key] = values
+			}
+			recorder, body := postForm(t, handler, form, tt.clientID, tt.secret)
+			if recorder.Code != tt.wantStatus || body["error"] != tt.wantError {
+				t.Errorf("response = %d %v, want %d %s", recorder.Code, body, tt.wantStatus, tt.wantError)
+			}
+		})
+	}
+
+	t.Run("Rejects long-lived assertions", func(t *testing.T) {
+		f := newFixture(t)
+		handler := newClientCredentialsHandler(t, f)
+		form := url.Values{
+			"grant_type":            {GrantTypeClientCredentials},
+			"client_assertion_type": {ClientAssertionTypeJWTBearer},
+			"client_assertion":      {newClientAssertion(t, f, "shipping", testTokenEndpoint, time.Hour)},
+		}
+		recorder, body := postForm(t, handler, form, "", "")
+		if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidClient {
+			t.Errorf("response = %d %v, want invalid_client", recorder.Code, body)
+		}
+	})
+
+	t.Run("Rejects assertions for another audience", func(t *testing.T) {
+		f := newFixture(t)
+		handler := newClientCredentialsHandler(t, f)
+		form := url.Values{
+			"grant_type":            {GrantTypeClientCredentials},
+			"client_assertion_type": {ClientAssertionTypeJWTBearer},
+			"client_assertion":      {newClientAssertion(t, f, "shipping", "https://other.example.com/token", time.Minute)},
+		}
+		recorder, body := postForm(t, handler, form, "", "")
+		if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidClient {
+			t.Errorf("response = %d %v, want invalid_client", recorder.Code, body)
+		}
+	})
+}
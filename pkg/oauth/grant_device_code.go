@@ -0,0 +1,112 @@
+package oauth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// slowDownIncrement is added to the polling interval of a client polling too fast, as required
+// by RFC 8628 3.5.
+const slowDownIncrement = 5
+
+type deviceCodeGrant struct {
+	devices DeviceAuthorizationStore
+	issuer  TokenIssuer
+	timegen timegenerator.TimeGenerator
+}
+
+// NewDeviceCodeGrant instantiates the device_code grant polled by devices until the user
+// approves or denies the request.
+func NewDeviceCodeGrant(devices DeviceAuthorizationStore, issuer TokenIssuer, timegen timegenerator.TimeGenerator) GrantHandler {
+	return &deviceCodeGrant{
+		devices: devices,
+		issuer:  issuer,
+		timegen: timegen,
+	}
+}
+
+func (g *deviceCodeGrant) GrantType() string {
+	return GrantTypeDeviceCode
+}
+
+func (g *deviceCodeGrant) Handle(r *http.Request, client *Client) (*TokenResponse, error) {
+	deviceCode := r.PostForm.Get("device_code")
+	if deviceCode == "" {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "device_code is required")
+	}
+	deviceCodeHash := securetoken.Hash(deviceCode)
+	authorization, err := g.devices.FindByDeviceCode(r.Context(), deviceCodeHash)
+	if errors.Is(err, ErrDeviceAuthorizationNotFound) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "device_code is invalid")
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if authorization.ClientID != client.ID {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "device_code was issued to another client")
+	}
+	now := g.timegen.Now().UTC()
+	if !now.Before(authorization.ExpiresAt) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeExpiredToken, "device_code is expired")
+	}
+	if err := g.checkPollRate(r, authorization, now); err != nil {
+		return nil, err
+	}
+
+	switch authorization.Status {
+	case DeviceStatusPending:
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeAuthorizationPending, "")
+	case DeviceStatusDenied:
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeAccessDenied, "the user denied the request")
+	case DeviceStatusApproved:
+	default:
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "device_code has already been used")
+	}
+	consumed, err := g.devices.Consume(r.Context(), deviceCodeHash)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !consumed {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "device_code has already been used")
+	}
+
+	request := TokenRequest{
+		Client:            client,
+		Subject:           authorization.Subject,
+		Scope:             authorization.Scope,
+		AMR:               strings.Fields(authorization.AMR),
+		IssueRefreshToken: true,
+	}
+	if authorization.AuthTime != nil {
+		request.AuthTime = *authorization.AuthTime
+	}
+	response, err := g.issuer.Issue(r.Context(), request)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return response, nil
+}
+
+// checkPollRate records a poll, answering slow_down and raising the interval when the client
+// polls before the interval has elapsed.
+func (g *deviceCodeGrant) checkPollRate(r *http.Request, authorization *DeviceAuthorization, now time.Time) error {
+	interval := authorization.Interval
+	tooFast := authorization.LastPolledAt != nil &&
+		now.Sub(*authorization.LastPolledAt) < time.Duration(interval)*time.Second
+	if tooFast {
+		interval += slowDownIncrement
+	}
+	if err := g.devices.UpdatePoll(r.Context(), authorization.DeviceCodeHash, now, interval); err != nil {
+		return errors.WithStack(err)
+	}
+	if tooFast {
+		return httperror.New(http.StatusBadRequest, ErrorCodeSlowDown, "")
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+type refreshTokenGrant struct {
+	refreshTokens refreshtoken.Service
+	timegen       timegenerator.TimeGenerator
+}
+
+// NewRefreshTokenGrant instantiates the refresh_token grant, which rotates the presented token.
+func NewRefreshTokenGrant(refreshTokens refreshtoken.Service, timegen timegenerator.TimeGenerator) GrantHandler {
+	return &refreshTokenGrant{refreshTokens: refreshTokens, timegen: timegen}
+}
+
+func (g *refreshTokenGrant) GrantType() string {
+	return GrantTypeRefreshToken
+}
+
+func (g *refreshTokenGrant) Handle(r *http.Request, client *Client) (*TokenResponse, error) {
+	value := r.PostForm.Get("refresh_token")
+	if value == "" {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "refresh_token is required")
+	}
+	token, err := g.refreshTokens.Lookup(r.Context(), value)
+	switch {
+	case errors.Is(err, refreshtoken.ErrReused):
+		// Exchange revokes the family of a reused token.
+	case err != nil:
+		return nil, refreshTokenError(err)
+	case token.ClientID != client.ID:
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "refresh_token was issued to another client")
+	case token.JKT != "" && token.JKT != dpopThumbprint(r.Context()):
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "refresh_token is bound to another DPoP key")
+	}
+	// Refreshed access tokens carry the audiences and lifetime registered for the client, as
+	// the first one issued by the TokenIssuer.
+	cnf := confirmation(r.Context())
+	pair, err := g.refreshTokens.Exchange(r.Context(), value, r.PostForm.Get("scope"), &refreshtoken.AccessTokenOptions{
+		Audience:     client.Audiences,
+		Lifetime:     client.TokenLifetime(),
+		Confirmation: cnf,
+	})
+	if err != nil {
+		return nil, refreshTokenError(err)
+	}
+	tokenType := "Bearer"
+	if cnf != nil && cnf.JWKThumbprint != "" {
+		tokenType = TokenTypeDPoP
+	}
+	return &TokenResponse{
+		AccessToken:  pair.AccessToken,
+		TokenType:    tokenType,
+		ExpiresIn:    int64(pair.AccessTokenExpiresAt.Sub(g.timegen.Now()).Seconds()),
+		RefreshToken: pair.RefreshToken.Value,
+		Scope:        pair.Scope,
+	}, nil
+}
+
+// refreshTokenError converts refreshtoken errors into token endpoint errors.
+func refreshTokenError(err error) error {
+	switch {
+	case errors.Is(err, refreshtoken.ErrNotFound), errors.Is(err, refreshtoken.ErrExpired),
+		errors.Is(err, refreshtoken.ErrRevoked), errors.Is(err, refreshtoken.ErrReused):
+		return httperror.New(http.StatusBadRequest, ErrorCodeInvalidGrant, "refresh_token is invalid")
+	case errors.Is(err, refreshtoken.ErrInvalidScope):
+		return httperror.New(http.StatusBadRequest, ErrorCodeInvalidScope, "scope exceeds the granted scope")
+	}
+	return errors.WithStack(err)
+}
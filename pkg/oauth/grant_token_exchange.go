@@ -0,0 +1,203 @@
+package oauth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/pkg/errors"
+)
+
+// GrantTypeTokenExchange is the grant_type of the token exchange grant defined by RFC 8693.
+const GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// Token types of RFC 8693 accepted as subject and actor tokens.
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// ExchangePolicy describes the token exchanges a client may perform.
+type ExchangePolicy struct {
+	// Audiences lists the audiences the client may request tokens for.
+	Audiences []string
+	// Scopes restricts the scopes the client may request, which must also be scopes of the
+	// subject token. When empty, any scope of the subject token may be requested.
+	Scopes []string
+	// SubjectAudiences lists accepted audiences of subject tokens, usually the audience of the
+	// client itself, so the client can only exchange tokens which were sent to it.
+	SubjectAudiences []string
+	// Impersonation allows exchanges without an actor token, whose tokens carry no act claim.
+	Impersonation bool
+	// Delegation allows exchanges with an actor token, whose tokens carry an act claim.
+	Delegation bool
+	// MaxDelegationDepth limits the number of actors in the act claim, when set.
+	MaxDelegationDepth int
+}
+
+// ExchangePolicies maps client IDs to their exchange policy. Clients without a policy cannot
+// exchange tokens.
+type ExchangePolicies map[string]ExchangePolicy
+
+type tokenExchangeGrant struct {
+	subjectTokens jwt.JWT
+	issuer        TokenIssuer
+	policies      ExchangePolicies
+}
+
+// NewTokenExchangeGrant instantiates the token exchange grant. Subject and actor tokens are
+// validated with subjectTokens, whose validation policy must accept the audiences of the
+// services exchanging tokens.
+func NewTokenExchangeGrant(subjectTokens jwt.JWT, issuer TokenIssuer, policies ExchangePolicies) GrantHandler {
+	return &tokenExchangeGrant{
+		subjectTokens: subjectTokens,
+		issuer:        issuer,
+		policies:      policies,
+	}
+}
+
+func (g *tokenExchangeGrant) GrantType() string {
+	return GrantTypeTokenExchange
+}
+
+func (g *tokenExchangeGrant) Handle(r *http.Request, client *Client) (*TokenResponse, error) {
+	policy, ok := g.policies[client.ID]
+	if !ok {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeUnauthorizedClient, "client has no exchange policy")
+	}
+	if tokenType := r.PostForm.Get("requested_token_type"); tokenType != "" && tokenType != TokenTypeAccessToken {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "requested_token_type is not supported")
+	}
+	subject, err := g.parseToken(r, "subject_token")
+	if err != nil {
+		return nil, err
+	}
+	if len(policy.SubjectAudiences) > 0 && !containsAny(subject.Audience, policy.SubjectAudiences) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "subject_token was not issued to this client")
+	}
+	actor, err := g.actor(r, client, policy, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	audience := append(append([]string{}, r.PostForm["audience"]...), r.PostForm["resource"]...)
+	if len(audience) == 0 {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "audience is required")
+	}
+	for _, value := range audience {
+		if !contains(policy.Audiences, value) {
+			return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidTarget, "audience is not allowed for this client")
+		}
+	}
+	scope, err := narrowScope(r.PostForm.Get("scope"), subject, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	request := TokenRequest{
+		Client:   client,
+		Subject:  subject.Subject,
+		Scope:    scope,
+		AMR:      subject.AMR,
+		Audience: audience,
+		Actor:    actor,
+	}
+	if subject.AuthTime != 0 {
+		request.AuthTime = time.Unix(subject.AuthTime, 0)
+	}
+	response, err := g.issuer.Issue(r.Context(), request)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	response.IssuedTokenType = TokenTypeAccessToken
+	return response, nil
+}
+
+// parseToken validates the token in the form parameter name, whose type is in name_type.
+func (g *tokenExchangeGrant) parseToken(r *http.Request, name string) (*jwt.Claims, error) {
+	tokenType := r.PostForm.Get(name + "_type")
+	if tokenType != TokenTypeAccessToken && tokenType != TokenTypeJWT {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, name+"_type is not supported")
+	}
+	claims, err := g.subjectTokens.ParseClaims(r.Context(), r.PostForm.Get(name), false)
+	var tokenErr *jwt.TokenError
+	if errors.As(err, &tokenErr) {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, name+" is invalid")
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return claims, nil
+}
+
+// actor validates the optional actor token, which must represent the exchanging client, and
+// returns the act claim of the delegation chain.
+func (g *tokenExchangeGrant) actor(r *http.Request, client *Client, policy ExchangePolicy, subject *jwt.Claims) (*jwt.Actor, error) {
+	if r.PostForm.Get("actor_token") == "" {
+		if r.PostForm.Get("actor_token_type") != "" {
+			return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "actor_token is required with actor_token_type")
+		}
+		if !policy.Impersonation {
+			return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "impersonation is not allowed for this client")
+		}
+		return nil, nil
+	}
+	if !policy.Delegation {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "delegation is not allowed for this client")
+	}
+	claims, err := g.parseToken(r, "actor_token")
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject != client.ID && claims.Extra["client_id"] != client.ID {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "actor_token does not represent this client")
+	}
+	actor := &jwt.Actor{Subject: claims.Subject, Actor: subject.Act}
+	if policy.MaxDelegationDepth > 0 && actor.Depth() > policy.MaxDelegationDepth {
+		return nil, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "delegation chain is too long")
+	}
+	return actor, nil
+}
+
+// narrowScope checks the requested scope against the subject token and the policy. When no
+// scope is requested, the scopes of the subject token allowed by the policy are kept.
+func narrowScope(requested string, subject *jwt.Claims, policy ExchangePolicy) (string, error) {
+	allowed := func(scope string) bool {
+		return subject.HasScope(scope) && (len(policy.Scopes) == 0 || contains(policy.Scopes, scope))
+	}
+	if requested == "" {
+		var scopes []string
+		for _, scope := range subject.Scopes() {
+			if allowed(scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		return strings.Join(scopes, " "), nil
+	}
+	for _, scope := range splitScope(requested) {
+		if !allowed(scope) {
+			return "", httperror.New(http.StatusBadRequest, ErrorCodeInvalidScope, "scope exceeds the subject_token scope")
+		}
+	}
+	return requested, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if contains(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+)
+
+func newTokenExchangeHandler(t *testing.T, f *fixture) http.Handler {
+	t.Helper()
+	err := f.clients.Create(context.Background(), &Client{
+		ID:         "service-a",
+		SecretHash: securetoken.Hash("service-a-secret"),
+		GrantTypes: StringList{GrantTypeTokenExchange},
+	})
+	if err != nil {
+		t.Fatalf("ClientStore.Create() error = %v", err)
+	}
+	policies := ExchangePolicies{"service-a": {
+		Audiences:          []string{"service-b"},
+		Scopes:             []string{"orders:read", "orders:write"},
+		SubjectAudiences:   []string{"api"},
+		Delegation:         true,
+		MaxDelegationDepth: 2,
+	}}
+	return NewTokenHandler(NewClientAuthenticator(f.clients), f.logger,
+		NewTokenExchangeGrant(f.accessTokens, NewTokenIssuer(f.accessTokens, f.refreshTokens, f.timegen), policies))
+}
+
+func signToken(t *testing.T, f *fixture, claims *jwt.Claims) string {
+	t.Helper()
+	token, _, err := f.accessTokens.SignClaims(context.Background(), claims)
+	if err != nil {
+		t.Fatalf("SignClaims() error = %v", err)
+	}
+	return token
+}
+
+func TestTokenExchangeGrant(t *testing.T) {
+	f := newFixture(t)
+	handler := newTokenExchangeHandler(t, f)
+	userToken := signToken(t, f, &jwt.Claims{Subject: "user-1", Scope: "orders:read orders:write profile", AMR: []string{"pwd"}})
+	actorToken := signToken(t, f, &jwt.Claims{Subject: "service-a"})
+	delegatedToken := signToken(t, f, &jwt.Claims{
+		Subject: "user-1",
+		Scope:   "orders:read",
+		Act:     &jwt.Actor{Subject: "gateway"},
+	})
+
+	exchange := func(overrides url.Values) url.Values {
+		form := url.Values{
+			"grant_type":         {GrantTypeTokenExchange},
+			"subject_token":      {userToken},
+			"subject_token_type": {TokenTypeAccessToken},
+			"actor_token":        {actorToken},
+			"actor_token_type":   {TokenTypeJWT},
+			"audience":           {"service-b"},
+		}
+		for key, values := range overrides {
+			form[key] = values
+		}
+		return form
+	}
+
+	t.Run("Delegates with a narrowed audience and scope", func(t *testing.T) {
+		recorder, body := postForm(t, handler, exchange(url.Values{"scope": {"orders:read"}}), "service-a", "service-a-secret")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %v", recorder.Code, body)
+		}
+		if body["issued_token_type"] != TokenTypeAccessToken {
+			t.Errorf("issued_token_type = %v, want %s", body["issued_token_type"], TokenTypeAccessToken)
+		}
+		claims := parseAccessToken(t, f, body["access_token"].(string), "service-b")
+		if claims.Subject != "user-1" || claims.Scope != "orders:read" || claims.Act == nil || claims.Act.Subject != "service-a" {
+			t.Errorf("claims = %+v, want user-1 with scope orders:read acted by service-a", claims)
+		}
+		if len(claims.Audience) != 1 || claims.Audience[0] != "service-b" {
+			t.Errorf("aud = %v, want [service-b]", claims.Audience)
+		}
+	})
+
+	t.Run("Keeps the allowed scopes of the subject token by default", func(t *testing.T) {
+		_, body := postForm(t, handler, exchange(nil), "service-a", "service-a-secret")
+		claims := parseAccessToken(t, f, body["access_token"].(string), "service-b")
+		if claims.Scope != "orders:read orders:write" {
+			t.Errorf("scope = %q, want orders:read orders:write", claims.Scope)
+		}
+	})
+
+	t.Run("Nests the act claim of delegation chains", func(t *testing.T) {
+		recorder, body := postForm(t, handler, exchange(url.Values{"subject_token": {delegatedToken}}), "service-a", "service-a-secret")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %v", recorder.Code, body)
+		}
+		claims := parseAccessToken(t, f, body["access_token"].(string), "service-b")
+		if claims.Act == nil || claims.Act.Subject != "service-a" || claims.Act.Actor == nil || claims.Act.Actor.Subject != "gateway" {
+			t.Errorf("act = %+v, want service-a acting for gateway", claims.Act)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		wantError string
+	}{
+		{"Rejects an invalid subject token", url.Values{"subject_token": {"invalid"}}, ErrorCodeInvalidRequest},
+		{"Rejects an unsupported token type", url.Values{"subject_token_type": {"urn:ietf:params:oauth:token-type:saml2"}}, ErrorCodeInvalidRequest},
+		{"Rejects impersonation when only delegation is allowed", url.Values{"actor_token": nil, "actor_token_type": nil}, ErrorCodeInvalidRequest},
+		{"Rejects an actor token of another party", url.Values{"actor_token": {signToken(t, f, &jwt.Claims{Subject: "service-c"})}}, ErrorCodeInvalidRequest},
+		{"Rejects an audience outside the policy", url.Values{"audience": {"service-c"}}, ErrorCodeInvalidTarget},
+		{"Rejects a missing audience", url.Values{"audience": nil}, ErrorCodeInvalidRequest},
+		{"Rejects scopes outside the subject token", url.Values{"scope": {"orders:delete"}}, ErrorCodeInvalidScope},
+		{"Rejects scopes outside the policy", url.Values{"scope": {"profile"}}, ErrorCodeInvalidScope},
+		{
+			name:      "Rejects delegation chains longer than allowed",
+			form:      url.Values{"subject_token": {signToken(t, f, &jwt.Claims{Subject: "user-1", Act: &jwt.Actor{Subject: "b", Actor: &jwt.Actor{Subject: "c"}}})}},
+			wantError: ErrorCodeInvalidRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := exchange(nil)
+			for key, values := range tt.form {
+				if values == nil {
+					delete(form, key)
+					continue
+				}
+				form[key] = values
+			}
+			recorder, body := postForm(t, handler, form, "service-a", "service-a-secret")
+			if recorder.Code != http.StatusBadRequest || body["error"] != tt.wantError {
+				t.Errorf("response = %d %v, want %s", recorder.Code, body, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/pkg/errors"
+)
+
+// Token type hints defined by RFC 7009.
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// IntrospectionResponse is the response of the introspection endpoint as defined by RFC 7662.
+type IntrospectionResponse struct {
+	Active    bool         `json:"active"`
+	Scope     string       `json:"scope,omitempty"`
+	ClientID  string       `json:"client_id,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Audience  jwt.Audience `json:"aud,omitempty"`
+	Issuer    string       `json:"iss,omitempty"`
+	ExpiresAt int64        `json:"exp,omitempty"`
+	IssuedAt  int64        `json:"iat,omitempty"`
+	JTI       string       `json:"jti,omitempty"`
+	TokenType string       `json:"token_type,omitempty"`
+	// Confirmation is the key the token is bound to, if any.
+	Confirmation *jwt.Confirmation `json:"cnf,omitempty"`
+}
+
+type introspectionHandler struct {
+	clients       ClientAuthenticator
+	accessTokens  jwt.JWT
+	refreshTokens refreshtoken.Service
+	logger        *logger.Logger
+}
+
+// NewIntrospectionHandler instantiates the RFC 7662 token introspection endpoint. Inactive tokens
+// are reported as {"active": false} only, without revealing why.
+func NewIntrospectionHandler(clients ClientAuthenticator, accessTokens jwt.JWT,
+	refreshTokens refreshtoken.Service, logger *logger.Logger) http.Handler {
+	return &introspectionHandler{
+		clients:       clients,
+		accessTokens:  accessTokens,
+		refreshTokens: refreshTokens,
+		logger:        logger,
+	}
+}
+
+func (h *introspectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	client, err := h.clients.Authenticate(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	// Introspection discloses token metadata, so only confidential clients may use it.
+	if client.IsPublic() {
+		writeError(w, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication is required"), h.logger)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "token is required"), h.logger)
+		return
+	}
+
+	lookups := []func(*http.Request, string) (*IntrospectionResponse, error){h.introspectAccessToken, h.introspectRefreshToken}
+	if r.PostForm.Get("token_type_hint") == TokenTypeHintRefreshToken {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+	for _, lookup := range lookups {
+		response, err := lookup(r, token)
+		if err != nil {
+			writeError(w, err, h.logger)
+			return
+		}
+		if response != nil {
+			httperror.WriteJSON(w, http.StatusOK, response)
+			return
+		}
+	}
+	httperror.WriteJSON(w, http.StatusOK, &IntrospectionResponse{Active: false})
+}
+
+// introspectAccessToken returns nil when token is not an active access token.
+func (h *introspectionHandler) introspectAccessToken(r *http.Request, token string) (*IntrospectionResponse, error) {
+	claims, err := h.accessTokens.ParseClaims(r.Context(), token, false)
+	var tokenErr *jwt.TokenError
+	if errors.As(err, &tokenErr) && !errors.Is(err, jwt.ErrKeyFetch) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	clientID, _ := claims.Extra["client_id"].(string)
+	return &IntrospectionResponse{
+		Active:       true,
+		Scope:        claims.Scope,
+		ClientID:     clientID,
+		Subject:      claims.Subject,
+		Audience:     claims.Audience,
+		Issuer:       claims.Issuer,
+		ExpiresAt:    claims.ExpiresAt,
+		IssuedAt:     claims.IssuedAt,
+		JTI:          claims.ID,
+		TokenType:    tokenScheme(claims),
+		Confirmation: claims.Confirmation,
+	}, nil
+}
+
+// introspectRefreshToken returns nil when token is not an active refresh token.
+func (h *introspectionHandler) introspectRefreshToken(r *http.Request, token string) (*IntrospectionResponse, error) {
+	refreshToken, err := h.refreshTokens.Lookup(r.Context(), token)
+	if isInactiveRefreshToken(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	response := &IntrospectionResponse{
+		Active:    true,
+		Scope:     refreshToken.Scope,
+		ClientID:  refreshToken.ClientID,
+		Subject:   refreshToken.Subject,
+		ExpiresAt: refreshToken.ExpiresAt.Unix(),
+		IssuedAt:  refreshToken.CreatedAt.Unix(),
+		TokenType: TokenTypeHintRefreshToken,
+	}
+	if refreshToken.JKT != "" {
+		response.Confirmation = &jwt.Confirmation{JWKThumbprint: refreshToken.JKT}
+	}
+	return response, nil
+}
+
+func isInactiveRefreshToken(err error) bool {
+	return errors.Is(err, refreshtoken.ErrNotFound) || errors.Is(err, refreshtoken.ErrExpired) ||
+		errors.Is(err, refreshtoken.ErrRevoked) || errors.Is(err, refreshtoken.ErrReused)
+}
@@ -0,0 +1,162 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+)
+
+const (
+	testClientID     = "resource-server"
+	testClientSecret = "resource-server-secret"
+)
+
+type fixture struct {
+	timegen       *timegenerator.FakeTimeGenerator
+	clients       ClientStore
+	accessTokens  jwt.JWT
+	revocations   jwt.RevocationStore
+	refreshTokens refreshtoken.Service
+	logger        *logger.Logger
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	revocations := jwt.NewMemoryRevocationStore(timegen)
+	accessTokens, err := jwttest.NewRS256(timegen, "https://auth.example.com", "api", 5*time.Minute,
+		jwt.WithRevocationStore(revocations))
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	return &fixture{
+		timegen: timegen,
+		clients: NewMemoryClientStore(Client{
+			ID:         testClientID,
+			SecretHash: securetoken.Hash(testClientSecret),
+			Name:       "Resource server",
+		}),
+		accessTokens: accessTokens,
+		revocations:  revocations,
+		refreshTokens: refreshtoken.NewService(refreshtoken.NewMemoryStore(), transaction.NewNoopProvider(),
+			accessTokens, timegen, refreshtoken.Config{SlidingLifetime: time.Hour, AbsoluteLifetime: 24 * time.Hour}),
+		logger: logger.NewNoopLogger(),
+	}
+}
+
+func postForm(t *testing.T, handler http.Handler, form url.Values, clientID, secret string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	body := map[string]interface{}{}
+	if recorder.Body.Len() > 0 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v, body = %s", err, recorder.Body.String())
+		}
+	}
+	return recorder, body
+}
+
+func TestIntrospectionHandler(t *testing.T) {
+	f := newFixture(t)
+	handler := NewIntrospectionHandler(NewClientAuthenticator(f.clients), f.accessTokens, f.refreshTokens, f.logger)
+	ctx := httptest.NewRequest(http.MethodGet, "/", nil).Context()
+
+	accessToken, _, err := f.accessTokens.SignClaims(ctx, &jwt.Claims{
+		Subject: "user-1",
+		Scope:   "read",
+		Extra:   map[string]interface{}{"client_id": "app"},
+	})
+	if err != nil {
+		t.Fatalf("SignClaims() error = %v", err)
+	}
+	refreshToken, err := f.refreshTokens.Issue(ctx, refreshtoken.Grant{Subject: "user-2", ClientID: "app", Scope: "offline"})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		secret     string
+		wantStatus int
+		want       map[string]interface{}
+	}{
+		{
+			name:       "Describes an active access token",
+			form:       url.Values{"token": {accessToken}},
+			secret:     testClientSecret,
+			wantStatus: http.StatusOK,
+			want: map[string]interface{}{
+				"active": true, "sub": "user-1", "scope": "read", "client_id": "app", "token_type": "Bearer",
+			},
+		},
+		{
+			name:       "Describes an active refresh token",
+			form:       url.Values{"token": {refreshToken.Value}, "token_type_hint": {"refresh_token"}},
+			secret:     testClientSecret,
+			wantStatus: http.StatusOK,
+			want: map[string]interface{}{
+				"active": true, "sub": "user-2", "scope": "offline", "client_id": "app", "token_type": "refresh_token",
+			},
+		},
+		{
+			name:       "Reports unknown tokens as inactive only",
+			form:       url.Values{"token": {"garbage"}},
+			secret:     testClientSecret,
+			wantStatus: http.StatusOK,
+			want:       map[string]interface{}{"active": false},
+		},
+		{
+			name:       "Requires client authentication",
+			form:       url.Values{"token": {accessToken}},
+			secret:     "wrong",
+			wantStatus: http.StatusUnauthorized,
+			want:       map[string]interface{}{"error": "invalid_client"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, body := postForm(t, handler, tt.form, testClientID, tt.secret)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			for key, want := range tt.want {
+				if body[key] != want {
+					t.Errorf("%s = %v, want %v", key, body[key], want)
+				}
+			}
+			if tt.want["active"] == false && len(body) != 1 {
+				t.Errorf("inactive response = %v, want only active", body)
+			}
+		})
+	}
+
+	t.Run("Reports expired tokens as inactive", func(t *testing.T) {
+		f.timegen.Add(2 * time.Hour)
+		defer f.timegen.Add(-2 * time.Hour)
+		for _, token := range []string{accessToken, refreshToken.Value} {
+			_, body := postForm(t, handler, url.Values{"token": {token}}, testClientID, testClientSecret)
+			if body["active"] != false || len(body) != 1 {
+				t.Errorf("response = %v, want inactive", body)
+			}
+		}
+	})
+}
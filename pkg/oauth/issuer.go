@@ -0,0 +1,247 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// TokenResponse is a successful response of the token endpoint.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IssuedTokenType is set by the token exchange grant.
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	// IDToken is set when the openid scope is granted.
+	IDToken string `json:"id_token,omitempty"`
+
+	refreshFamilyID string
+}
+
+// TokenRequest describes the tokens to issue for a successful grant.
+type TokenRequest struct {
+	Client   *Client
+	Subject  string
+	Scope    string
+	AuthTime time.Time
+	AMR      []string
+	// Audience overrides the audiences registered for the client.
+	Audience []string
+	// Actor is the act claim of tokens issued on behalf of Subject.
+	Actor *jwt.Actor
+	// IssueRefreshToken issues a refresh token too, when the client may use the refresh_token grant.
+	IssueRefreshToken bool
+
+	// Nonce, ACR and Code are copied into the ID token, Code as c_hash.
+	Nonce string
+	ACR   string
+	Code  string
+}
+
+// TokenIssuer issues the tokens of a successful grant.
+type TokenIssuer interface {
+	// Issue signs an access token and optionally starts a refresh token family. The audiences and
+	// token lifetime registered for the client override the defaults of the access token signer.
+	// An ID token is issued too when the openid scope is granted and ID tokens are enabled.
+	// Access tokens are bound to the DPoP key or TLS client certificate of the request, if any.
+	Issue(ctx context.Context, request TokenRequest) (*TokenResponse, error)
+}
+
+type tokenIssuer struct {
+	accessTokens  jwt.JWT
+	idTokens      jwt.JWT
+	refreshTokens refreshtoken.Service
+	timegen       timegenerator.TimeGenerator
+	claims        jwt.ClaimsProvider
+}
+
+// TokenIssuerOption configures optional behaviour of the TokenIssuer.
+type TokenIssuerOption func(*tokenIssuer)
+
+// WithIDTokens enables OpenID Connect ID tokens signed with idTokens.
+func WithIDTokens(idTokens jwt.JWT) TokenIssuerOption {
+	return func(i *tokenIssuer) {
+		i.idTokens = idTokens
+	}
+}
+
+// WithClaimsProvider adds the claims of provider about the subject to the access and ID tokens,
+// e.g. email_verified. ID tokens only get the standard claims of the scopes granted, as the
+// userinfo endpoint.
+func WithClaimsProvider(provider jwt.ClaimsProvider) TokenIssuerOption {
+	return func(i *tokenIssuer) {
+		i.claims = provider
+	}
+}
+
+// NewTokenIssuer instantiates a TokenIssuer signing access tokens with accessTokens.
+func NewTokenIssuer(accessTokens jwt.JWT, refreshTokens refreshtoken.Service, timegen timegenerator.TimeGenerator,
+	options ...TokenIssuerOption) TokenIssuer {
+	issuer := &tokenIssuer{
+		accessTokens:  accessTokens,
+		refreshTokens: refreshTokens,
+		timegen:       timegen,
+	}
+	for _, option := range options {
+		option(issuer)
+	}
+	return issuer
+}
+
+func (i *tokenIssuer) Issue(ctx context.Context, request TokenRequest) (*TokenResponse, error) {
+	subjectClaims, err := i.subjectClaims(ctx, request.Subject)
+	if err != nil {
+		return nil, err
+	}
+	extra := map[string]interface{}{}
+	for name, value := range subjectClaims {
+		extra[name] = value
+	}
+	extra["client_id"] = request.Client.ID
+	claims := &jwt.Claims{
+		Subject:  request.Subject,
+		Audience: jwt.Audience(request.Client.Audiences),
+		Scope:    request.Scope,
+		AMR:      request.AMR,
+		Act:      request.Actor,
+		Extra:    extra,
+	}
+	if len(request.Audience) > 0 {
+		claims.Audience = request.Audience
+	}
+	if !request.AuthTime.IsZero() {
+		claims.AuthTime = request.AuthTime.Unix()
+	}
+	if lifetime := request.Client.TokenLifetime(); lifetime > 0 {
+		claims.ExpiresAt = i.timegen.Now().Add(lifetime).Unix()
+	}
+	claims.Confirmation = confirmation(ctx)
+	accessToken, expiry, err := i.accessTokens.SignClaims(ctx, claims)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	response := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   tokenScheme(claims),
+		ExpiresIn:   int64(expiry.Sub(i.timegen.Now()).Seconds()),
+		Scope:       request.Scope,
+	}
+	if i.idTokens != nil && contains(splitScope(request.Scope), ScopeOpenID) {
+		idTokenClaims := grantedClaims(subjectClaims, splitScope(request.Scope))
+		if response.IDToken, err = i.signIDToken(ctx, request, accessToken, idTokenClaims); err != nil {
+			return nil, err
+		}
+	}
+	if request.IssueRefreshToken && request.Client.GrantTypes.Contains(GrantTypeRefreshToken) {
+		refreshToken, err := i.refreshTokens.Issue(ctx, refreshtoken.Grant{
+			Subject:  request.Subject,
+			ClientID: request.Client.ID,
+			Scope:    request.Scope,
+			AMR:      request.AMR,
+			AuthTime: request.AuthTime,
+			JKT:      dpopThumbprint(ctx),
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		response.RefreshToken = refreshToken.Value
+		response.refreshFamilyID = refreshToken.FamilyID
+	}
+	return response, nil
+}
+
+// confirmation returns the cnf claim binding the tokens of the request of ctx to the DPoP key
+// proven to NewDPoPTokenHandler and the certificate the client authenticated with, or nil.
+func confirmation(ctx context.Context) *jwt.Confirmation {
+	cnf := &jwt.Confirmation{JWKThumbprint: dpopThumbprint(ctx), X509Thumbprint: certificateThumbprint(ctx)}
+	if cnf.JWKThumbprint == "" && cnf.X509Thumbprint == "" {
+		return nil
+	}
+	return cnf
+}
+
+// subjectClaims returns the claims of the ClaimsProvider about subject, if any.
+func (i *tokenIssuer) subjectClaims(ctx context.Context, subject string) (map[string]interface{}, error) {
+	if i.claims == nil {
+		return nil, nil
+	}
+	claims, err := i.claims.SubjectClaims(ctx, subject)
+	return claims, errors.WithStack(err)
+}
+
+// scopeClaims lists the standard claims released by the scopes of OpenID Connect Core 5.4.
+var scopeClaims = map[string][]string{
+	ScopeProfile: {"name", "family_name", "given_name", "middle_name", "nickname", "preferred_username",
+		"profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale", "updated_at"},
+	ScopeEmail:   {"email", "email_verified"},
+	ScopePhone:   {"phone_number", "phone_number_verified"},
+	ScopeAddress: {"address"},
+}
+
+// grantedClaims returns claims without the standard claims of the scopes which are not granted.
+func grantedClaims(claims map[string]interface{}, scopes []string) map[string]interface{} {
+	if claims == nil {
+		return nil
+	}
+	granted := make(map[string]interface{}, len(claims))
+	for name, value := range claims {
+		granted[name] = value
+	}
+	for scope, names := range scopeClaims {
+		if contains(scopes, scope) {
+			continue
+		}
+		for _, name := range names {
+			delete(granted, name)
+		}
+	}
+	return granted
+}
+
+// signIDToken signs the ID token of request, as defined by OpenID Connect Core 3.1.3.6, with the
+// subjectClaims of the ClaimsProvider granted to it.
+func (i *tokenIssuer) signIDToken(ctx context.Context, request TokenRequest, accessToken string,
+	subjectClaims map[string]interface{}) (string, error) {
+	claims := &jwt.Claims{
+		Subject:         request.Subject,
+		Audience:        jwt.Audience{request.Client.ID},
+		AMR:             request.AMR,
+		ACR:             request.ACR,
+		Nonce:           request.Nonce,
+		AuthorizedParty: request.Client.ID,
+		AccessTokenHash: halfHash(accessToken),
+		Extra:           subjectClaims,
+	}
+	if !request.AuthTime.IsZero() {
+		claims.AuthTime = request.AuthTime.Unix()
+	}
+	if request.Code != "" {
+		claims.CodeHash = halfHash(request.Code)
+	}
+	idToken, _, err := i.idTokens.SignClaims(ctx, claims)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return idToken, nil
+}
+
+// halfHash computes at_hash and c_hash of RS256 ID tokens: the base64url encoded left half of
+// the SHA-256 hash of value.
+func halfHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+func splitScope(scope string) []string {
+	return strings.Fields(scope)
+}
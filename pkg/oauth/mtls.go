@@ -0,0 +1,113 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/pkg/errors"
+)
+
+// CertificateThumbprint computes the x5t#S256 confirmation of cert: the base64url encoded
+// SHA-256 hash of its DER encoding.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// peerCertificate returns the TLS client certificate of r, or nil.
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// authenticateCertificate authenticates the client named by client_id with the TLS client
+// certificate of r. The TLS server must request client certificates without verifying them,
+// since self-signed certificates are verified against the keys of each client instead.
+func (a *clientAuthenticator) authenticateCertificate(r *http.Request) (*Client, error) {
+	client, err := a.findClient(r, r.PostForm.Get("client_id"),
+		AuthMethodTLSClientAuth, AuthMethodSelfSignedTLSClientAuth, AuthMethodNone)
+	if err != nil {
+		return nil, err
+	}
+	switch client.AuthMethod() {
+	case AuthMethodTLSClientAuth:
+		err = a.verifyPKICertificate(r, client)
+	case AuthMethodSelfSignedTLSClientAuth:
+		err = verifySelfSignedCertificate(peerCertificate(r), client)
+	}
+	if err != nil {
+		return nil, httperror.New(http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication failed")
+	}
+	return client, nil
+}
+
+// verifyPKICertificate verifies the chain of the certificate of r up to the trusted roots, and
+// that the certificate is the one registered for client.
+func (a *clientAuthenticator) verifyPKICertificate(r *http.Request, client *Client) error {
+	cert := peerCertificate(r)
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         a.certificateRoots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	switch {
+	case client.TLSClientAuthSubjectDN != "":
+		if cert.Subject.String() != client.TLSClientAuthSubjectDN {
+			return errors.Errorf("subject [%s] is not registered", cert.Subject)
+		}
+	case client.TLSClientAuthSANDNS != "":
+		if !contains(cert.DNSNames, client.TLSClientAuthSANDNS) {
+			return errors.Errorf("DNS names %v are not registered", cert.DNSNames)
+		}
+	default:
+		return errors.New("client has no registered certificate subject")
+	}
+	return nil
+}
+
+// verifySelfSignedCertificate checks that the public key of cert is one of the keys of client.
+func verifySelfSignedCertificate(cert *x509.Certificate, client *Client) error {
+	certKey, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return errors.Errorf("unsupported certificate key %T", cert.PublicKey)
+	}
+	for _, key := range client.JWKS.Keys {
+		publicKey, err := key.PublicKey()
+		if err == nil && certKey.Equal(publicKey) {
+			return nil
+		}
+	}
+	return errors.New("certificate key is not registered")
+}
+
+type certificateThumbprintKey struct{}
+
+// withCertificateBinding binds the tokens issued for r to the certificate client authenticated
+// with, if any.
+func withCertificateBinding(r *http.Request, client *Client) *http.Request {
+	cert := peerCertificate(r)
+	if cert == nil || !client.UsesMutualTLS() {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), certificateThumbprintKey{}, CertificateThumbprint(cert)))
+}
+
+// certificateThumbprint returns the thumbprint of the certificate bound to the request of ctx, if any.
+func certificateThumbprint(ctx context.Context) string {
+	thumbprint, _ := ctx.Value(certificateThumbprintKey{}).(string)
+	return thumbprint
+}
@@ -0,0 +1,283 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+)
+
+type certificateAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	return key
+}
+
+// newCertificate issues a certificate for key signed by parent, or a self-signed one when
+// parent is nil.
+func newCertificate(t *testing.T, template *x509.Certificate, key *ecdsa.PrivateKey, parent *certificateAuthority) *x509.Certificate {
+	t.Helper()
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() error = %v", err)
+	}
+	return cert
+}
+
+func newCertificateAuthority(t *testing.T) *certificateAuthority {
+	t.Helper()
+	key := newKey(t)
+	cert := newCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, key, nil)
+	return &certificateAuthority{cert: cert, key: key}
+}
+
+func (ca *certificateAuthority) issue(t *testing.T, commonName string, dnsNames ...string) *x509.Certificate {
+	t.Helper()
+	return newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    dnsNames,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, newKey(t), ca)
+}
+
+type mutualTLSServer struct {
+	tokens     http.Handler
+	ca         *certificateAuthority
+	selfSigned *x509.Certificate
+}
+
+func newMutualTLSServer(t *testing.T, f *fixture) *mutualTLSServer {
+	t.Helper()
+	ca := newCertificateAuthority(t)
+	selfSignedKey := newKey(t)
+	selfSigned := newCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "reports"}}, selfSignedKey, nil)
+	clients := []Client{
+		{
+			ID:                      "orders",
+			TokenEndpointAuthMethod: AuthMethodTLSClientAuth,
+			TLSClientAuthSubjectDN:  "CN=orders",
+			GrantTypes:              StringList{GrantTypeClientCredentials},
+			Scopes:                  StringList{"orders:read"},
+		},
+		{
+			ID:                      "billing",
+			TokenEndpointAuthMethod: AuthMethodTLSClientAuth,
+			TLSClientAuthSANDNS:     "billing.example.com",
+			GrantTypes:              StringList{GrantTypeClientCredentials},
+			Scopes:                  StringList{"billing:read"},
+		},
+		{
+			ID:                      "reports",
+			TokenEndpointAuthMethod: AuthMethodSelfSignedTLSClientAuth,
+			JWKS:                    KeySet{Keys: []jwt.JWK{jwt.NewECJWK("reports", &selfSignedKey.PublicKey)}},
+			GrantTypes:              StringList{GrantTypeClientCredentials},
+			Scopes:                  StringList{"reports:read"},
+		},
+	}
+	for i := range clients {
+		if err := f.clients.Create(context.Background(), &clients[i]); err != nil {
+			t.Fatalf("ClientStore.Create() error = %v", err)
+		}
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	return &mutualTLSServer{
+		tokens: NewTokenHandler(NewClientAuthenticator(f.clients, WithMutualTLS(roots)), f.logger,
+			NewClientCredentialsGrant(NewTokenIssuer(f.accessTokens, f.refreshTokens, f.timegen))),
+		ca:         ca,
+		selfSigned: selfSigned,
+	}
+}
+
+// withPeerCertificate makes r look received over a TLS connection authenticated with cert.
+func withPeerCertificate(r *http.Request, cert *x509.Certificate) *http.Request {
+	if cert != nil {
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	}
+	return r
+}
+
+func (s *mutualTLSServer) token(t *testing.T, clientID string, cert *x509.Certificate) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	return serveForm(t, s.tokens, url.Values{"grant_type": {GrantTypeClientCredentials}, "client_id": {clientID}}, cert)
+}
+
+func serveForm(t *testing.T, handler http.Handler, form url.Values, cert *x509.Certificate) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, withPeerCertificate(req, cert))
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, body = %s", err, recorder.Body.String())
+	}
+	return recorder, body
+}
+
+func TestMutualTLS_ClientAuthentication(t *testing.T) {
+	t.Run("Binds tokens to the certificate of tls_client_auth clients", func(t *testing.T) {
+		f := newFixture(t)
+		s := newMutualTLSServer(t, f)
+		for clientID, cert := range map[string]*x509.Certificate{
+			"orders":  s.ca.issue(t, "orders"),
+			"billing": s.ca.issue(t, "billing", "billing.example.com"),
+		} {
+			recorder, body := s.token(t, clientID, cert)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("%s: status = %d, body = %v", clientID, recorder.Code, body)
+			}
+			if body["token_type"] != "Bearer" {
+				t.Errorf("%s: token_type = %v, want Bearer", clientID, body["token_type"])
+			}
+			claims := parseAccessToken(t, f, body["access_token"].(string), "api")
+			if claims.Confirmation == nil || claims.Confirmation.X509Thumbprint != CertificateThumbprint(cert) {
+				t.Errorf("%s: cnf = %+v, want the thumbprint of the certificate", clientID, claims.Confirmation)
+			}
+		}
+	})
+
+	t.Run("Binds tokens to the certificate of self_signed_tls_client_auth clients", func(t *testing.T) {
+		f := newFixture(t)
+		s := newMutualTLSServer(t, f)
+		recorder, body := s.token(t, "reports", s.selfSigned)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %v", recorder.Code, body)
+		}
+		claims := parseAccessToken(t, f, body["access_token"].(string), "api")
+		if claims.Confirmation == nil || claims.Confirmation.X509Thumbprint != CertificateThumbprint(s.selfSigned) {
+			t.Errorf("cnf = %+v, want the thumbprint of the certificate", claims.Confirmation)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		clientID string
+		cert     func(t *testing.T, s *mutualTLSServer) *x509.Certificate
+	}{
+		{
+			name:     "Rejects a certificate with another subject",
+			clientID: "orders",
+			cert:     func(t *testing.T, s *mutualTLSServer) *x509.Certificate { return s.ca.issue(t, "billing") },
+		},
+		{
+			name:     "Rejects a certificate without the registered DNS name",
+			clientID: "billing",
+			cert: func(t *testing.T, s *mutualTLSServer) *x509.Certificate {
+				return s.ca.issue(t, "billing", "other.example.com")
+			},
+		},
+		{
+			name:     "Rejects a certificate of an untrusted authority",
+			clientID: "orders",
+			cert: func(t *testing.T, s *mutualTLSServer) *x509.Certificate {
+				return newCertificateAuthority(t).issue(t, "orders")
+			},
+		},
+		{
+			name:     "Rejects a self-signed certificate for tls_client_auth",
+			clientID: "orders",
+			cert: func(t *testing.T, s *mutualTLSServer) *x509.Certificate {
+				return newCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "orders"}}, newKey(t), nil)
+			},
+		},
+		{
+			name:     "Rejects a self-signed certificate of an unregistered key",
+			clientID: "reports",
+			cert: func(t *testing.T, s *mutualTLSServer) *x509.Certificate {
+				return newCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "reports"}}, newKey(t), nil)
+			},
+		},
+		{
+			name:     "Rejects mutual-TLS clients without a certificate",
+			clientID: "orders",
+			cert:     func(t *testing.T, s *mutualTLSServer) *x509.Certificate { return nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			s := newMutualTLSServer(t, f)
+			recorder, body := s.token(t, tt.clientID, tt.cert(t, s))
+			if recorder.Code != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidClient {
+				t.Errorf("response = %d %v, want invalid_client", recorder.Code, body)
+			}
+		})
+	}
+}
+
+func TestMutualTLS_ProtectedResource(t *testing.T) {
+	f := newFixture(t)
+	s := newMutualTLSServer(t, f)
+	cert := s.ca.issue(t, "orders")
+	_, body := s.token(t, "orders", cert)
+	accessToken, _ := body["access_token"].(string)
+	verifier := NewAccessTokenVerifier(f.accessTokens, nil)
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{name: "Accepts the bound certificate", cert: cert},
+		{name: "Rejects requests without a certificate", wantErr: true},
+		{name: "Rejects another certificate", cert: s.ca.issue(t, "orders"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+			claims, err := verifier.Verify(withPeerCertificate(req, tt.cert))
+			if !tt.wantErr {
+				if err != nil || claims.Subject != "orders" {
+					t.Errorf("Verify() = %v, %v, want the claims of orders", claims, err)
+				}
+				return
+			}
+			var oauthErr *Error
+			if !errors.As(err, &oauthErr) || oauthErr.Code != ErrorCodeInvalidToken ||
+				!strings.HasPrefix(oauthErr.Header().Get("WWW-Authenticate"), "Bearer ") {
+				t.Errorf("Verify() error = %v, want an invalid_token Bearer challenge", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Package oauth implements the HTTP endpoints of an OAuth 2.0 authorization server.
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// Error codes defined by RFC 6749 and its extensions.
+const (
+	ErrorCodeInvalidRequest       = "invalid_request"
+	ErrorCodeInvalidClient        = "invalid_client"
+	ErrorCodeInvalidGrant         = "invalid_grant"
+	ErrorCodeUnauthorizedClient   = "unauthorized_client"
+	ErrorCodeUnsupportedGrantType = "unsupported_grant_type"
+	ErrorCodeInvalidScope         = "invalid_scope"
+	ErrorCodeUnsupportedTokenType = "unsupported_token_type"
+	ErrorCodeServerError          = "server_error"
+	ErrorCodeInvalidTarget        = "invalid_target"
+	ErrorCodeAuthorizationPending = "authorization_pending"
+	ErrorCodeSlowDown             = "slow_down"
+	ErrorCodeExpiredToken         = "expired_token"
+	ErrorCodeAccessDenied         = "access_denied"
+	ErrorCodeInvalidToken         = "invalid_token"
+	ErrorCodeInsufficientScope    = "insufficient_scope"
+	ErrorCodeLoginRequired        = "login_required"
+	ErrorCodeInvalidDPoPProof     = "invalid_dpop_proof"
+	ErrorCodeUseDPoPNonce         = "use_dpop_nonce"
+)
+
+// Error is an OAuth 2.0 error response.
+type Error = httperror.Error
+
+// writeError writes err as an OAuth 2.0 error response. Errors which are not *Error are logged
+// and reported as server_error without details.
+func writeError(w http.ResponseWriter, err error, log *logger.Logger) {
+	var oauthErr *Error
+	if errors.As(err, &oauthErr) && oauthErr.Status == http.StatusUnauthorized &&
+		oauthErr.Header().Get("WWW-Authenticate") == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	httperror.Write(w, err, log)
+}
+
+// parseForm parses a POST request with a form encoded body.
+func parseForm(r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST")
+	}
+	if err := r.ParseForm(); err != nil {
+		return httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "malformed form body")
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// oidcTokens runs an authorization code flow with params and returns the code and token response.
+func (s *authorizationServer) oidcTokens(t *testing.T, params url.Values) (string, map[string]interface{}) {
+	t.Helper()
+	code := s.codeFor(t, params)
+	status, body := s.token(t, codeForm(code, testCodeVerifier))
+	if status != http.StatusOK {
+		t.Fatalf("POST /token status = %d, body = %v", status, body)
+	}
+	return code, body
+}
+
+// userInfo calls the userinfo endpoint with accessToken in the Authorization header, or in
+// the form body when inBody is set.
+func (s *authorizationServer) userInfo(t *testing.T, method, accessToken string, inBody bool) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	var request *http.Request
+	var err error
+	if inBody {
+		form := url.Values{"access_token": {accessToken}}
+		request, err = http.NewRequest(method, s.server.URL+"/userinfo", strings.NewReader(form.Encode()))
+		if err == nil {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		request, err = http.NewRequest(method, s.server.URL+"/userinfo", nil)
+		if err == nil {
+			request.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+	}
+	if err != nil {
+		t.Fatalf("http.NewRequest() error = %v", err)
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%s /userinfo error = %v", method, err)
+	}
+	defer resp.Body.Close()
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("json.Decode() error = %v", err)
+	}
+	return resp, body
+}
+
+func oidcParams(scope string) url.Values {
+	params := authorizeParams()
+	params.Set("scope", scope)
+	params.Set("nonce", "n-0S6_WzA2Mj")
+	return params
+}
+
+func TestOpenIDConnect_IDToken(t *testing.T) {
+	t.Run("Issues an ID token bound to the code, access token and nonce", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		s.authTime = s.timegen.Now().Add(-time.Minute)
+		code, body := s.oidcTokens(t, oidcParams("openid profile"))
+		idToken, ok := body["id_token"].(string)
+		if !ok {
+			t.Fatalf("body = %v, want an id_token", body)
+		}
+		claims := parseAccessToken(t, s.fixture, idToken, testAppClientID)
+		if claims.Issuer != "https://auth.example.com" || claims.Subject != "user-1" {
+			t.Errorf("iss = %q, sub = %q, want https://auth.example.com and user-1", claims.Issuer, claims.Subject)
+		}
+		if len(claims.Audience) != 1 || claims.Audience[0] != testAppClientID || claims.AuthorizedParty != testAppClientID {
+			t.Errorf("aud = %v, azp = %q, want app", claims.Audience, claims.AuthorizedParty)
+		}
+		if claims.Nonce != "n-0S6_WzA2Mj" {
+			t.Errorf("nonce = %q, want n-0S6_WzA2Mj", claims.Nonce)
+		}
+		if want := halfHash(body["access_token"].(string)); claims.AccessTokenHash != want {
+			t.Errorf("at_hash = %q, want %q", claims.AccessTokenHash, want)
+		}
+		if want := halfHash(code); claims.CodeHash != want {
+			t.Errorf("c_hash = %q, want %q", claims.CodeHash, want)
+		}
+		if claims.AuthTime != s.authTime.Unix() {
+			t.Errorf("auth_time = %d, want %d", claims.AuthTime, s.authTime.Unix())
+		}
+		if len(claims.AMR) != 1 || claims.AMR[0] != "pwd" || claims.ACR != "urn:example:loa:1" {
+			t.Errorf("amr = %v, acr = %q, want [pwd] and urn:example:loa:1", claims.AMR, claims.ACR)
+		}
+	})
+
+	t.Run("Issues no ID token without the openid scope", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		_, body := s.oidcTokens(t, oidcParams("read profile"))
+		if _, ok := body["id_token"]; ok {
+			t.Errorf("body = %v, want no id_token", body)
+		}
+	})
+
+	t.Run("Omits the nonce when not requested", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		params := oidcParams("openid")
+		params.Del("nonce")
+		_, body := s.oidcTokens(t, params)
+		claims := parseAccessToken(t, s.fixture, body["id_token"].(string), testAppClientID)
+		if claims.Nonce != "" {
+			t.Errorf("nonce = %q, want none", claims.Nonce)
+		}
+	})
+}
+
+// staticClaims provides the same claims about every subject.
+type staticClaims map[string]interface{}
+
+func (c staticClaims) SubjectClaims(context.Context, string) (map[string]interface{}, error) {
+	return c, nil
+}
+
+func TestTokenIssuer_SubjectClaims(t *testing.T) {
+	tests := []struct {
+		name              string
+		scope             string
+		wantEmailVerified bool
+	}{
+		{"Adds the claims of the email scope when granted", "openid email", true},
+		{"Drops the claims of the email scope otherwise", "openid profile", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			issuer := NewTokenIssuer(f.accessTokens, f.refreshTokens, f.timegen, WithIDTokens(f.accessTokens),
+				WithClaimsProvider(staticClaims{"email_verified": true, "org": "acme"}))
+			response, err := issuer.Issue(context.Background(), TokenRequest{
+				Client:  &Client{ID: testAppClientID},
+				Subject: "user-1",
+				Scope:   tt.scope,
+			})
+			if err != nil {
+				t.Fatalf("Issue() error = %v", err)
+			}
+			claims := parseAccessToken(t, f, response.IDToken, testAppClientID)
+			if _, ok := claims.Extra["email_verified"]; ok != tt.wantEmailVerified || claims.Extra["org"] != "acme" {
+				t.Errorf("claims = %v, want email_verified %v and org", claims.Extra, tt.wantEmailVerified)
+			}
+		})
+	}
+}
+
+func TestOpenIDConnect_UserInfo(t *testing.T) {
+	newServer := func(t *testing.T) *authorizationServer {
+		s := newAuthorizationServer(t)
+		s.users["user-1"] = &UserInfo{
+			Subject:           "user-1",
+			Name:              "Jane Doe",
+			PreferredUsername: "jane",
+			Email:             "jane@example.com",
+			EmailVerified:     true,
+			PhoneNumber:       "+1 555 0100",
+			Address:           &Address{Country: "US"},
+		}
+		return s
+	}
+
+	t.Run("Releases the claims of the granted scopes", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			method string
+			inBody bool
+		}{
+			{"GET with an Authorization header", http.MethodGet, false},
+			{"POST with an Authorization header", http.MethodPost, false},
+			{"POST with a form body", http.MethodPost, true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				s := newServer(t)
+				_, tokens := s.oidcTokens(t, oidcParams("openid profile email"))
+				resp, body := s.userInfo(t, tt.method, tokens["access_token"].(string), tt.inBody)
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("status = %d, body = %v", resp.StatusCode, body)
+				}
+				want := map[string]interface{}{
+					"sub":                "user-1",
+					"name":               "Jane Doe",
+					"preferred_username": "jane",
+					"email":              "jane@example.com",
+					"email_verified":     true,
+				}
+				if len(body) != len(want) {
+					t.Errorf("body = %v, want %v", body, want)
+				}
+				for name, value := range want {
+					if body[name] != value {
+						t.Errorf("%s = %v, want %v", name, body[name], value)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("Releases phone and address claims", func(t *testing.T) {
+		s := newServer(t)
+		_, tokens := s.oidcTokens(t, oidcParams("openid phone address"))
+		_, body := s.userInfo(t, http.MethodGet, tokens["access_token"].(string), false)
+		if body["phone_number"] != "+1 555 0100" || body["phone_number_verified"] != false {
+			t.Errorf("body = %v, want the phone number", body)
+		}
+		if address, ok := body["address"].(map[string]interface{}); !ok || address["country"] != "US" {
+			t.Errorf("address = %v, want country US", body["address"])
+		}
+		if _, ok := body["email"]; ok {
+			t.Errorf("body = %v, want no email", body)
+		}
+	})
+
+	t.Run("Rejects an invalid access token", func(t *testing.T) {
+		s := newServer(t)
+		resp, body := s.userInfo(t, http.MethodGet, "invalid", false)
+		if resp.StatusCode != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidToken {
+			t.Errorf("response = %d %v, want 401 invalid_token", resp.StatusCode, body)
+		}
+		if got := resp.Header.Get("WWW-Authenticate"); !strings.HasPrefix(got, `Bearer error="invalid_token"`) {
+			t.Errorf("WWW-Authenticate = %q, want a bearer invalid_token error", got)
+		}
+	})
+
+	t.Run("Requires the openid scope", func(t *testing.T) {
+		s := newServer(t)
+		_, tokens := s.oidcTokens(t, oidcParams("read profile"))
+		resp, body := s.userInfo(t, http.MethodGet, tokens["access_token"].(string), false)
+		if resp.StatusCode != http.StatusForbidden || body["error"] != ErrorCodeInsufficientScope {
+			t.Errorf("response = %d %v, want 403 insufficient_scope", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("Rejects tokens of deleted users", func(t *testing.T) {
+		s := newServer(t)
+		_, tokens := s.oidcTokens(t, oidcParams("openid"))
+		delete(s.users, "user-1")
+		resp, body := s.userInfo(t, http.MethodGet, tokens["access_token"].(string), false)
+		if resp.StatusCode != http.StatusUnauthorized || body["error"] != ErrorCodeInvalidToken {
+			t.Errorf("response = %d %v, want 401 invalid_token", resp.StatusCode, body)
+		}
+	})
+}
+
+func TestOpenIDConnect_Prompt(t *testing.T) {
+	redirect := func(t *testing.T, resp *http.Response) *url.URL {
+		t.Helper()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("GET /authorize status = %d, want %d", resp.StatusCode, http.StatusFound)
+		}
+		location, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("url.Parse() error = %v", err)
+		}
+		return location
+	}
+
+	t.Run("Returns login_required with prompt=none when logged out", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		s.loggedIn = false
+		params := oidcParams("openid")
+		params.Set("prompt", "none")
+		location := redirect(t, s.authorize(t, params))
+		if !strings.HasPrefix(location.String(), testAppRedirectURI) || location.Query().Get("error") != ErrorCodeLoginRequired {
+			t.Errorf("location = %s, want a login_required error", location)
+		}
+	})
+
+	t.Run("Issues a code with prompt=none when logged in", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		params := oidcParams("openid")
+		params.Set("prompt", "none")
+		if code := s.codeFor(t, params); code == "" {
+			t.Error("code is empty")
+		}
+	})
+
+	t.Run("Forces a new login with prompt=login", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		params := oidcParams("openid")
+		params.Set("prompt", "login")
+		location := redirect(t, s.authorize(t, params))
+		if !strings.HasPrefix(location.String(), "https://auth.example.com/login") {
+			t.Fatalf("location = %s, want the login page", location)
+		}
+		returnTo, err := url.Parse(location.Query().Get("return_to"))
+		if err != nil {
+			t.Fatalf("url.Parse() error = %v", err)
+		}
+		if returnTo.Query().Get("prompt") != "" || returnTo.Query().Get("nonce") != "n-0S6_WzA2Mj" {
+			t.Errorf("return_to = %s, want the request without prompt", returnTo)
+		}
+	})
+
+	t.Run("Rejects prompt=none combined with other values", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		params := oidcParams("openid")
+		params.Set("prompt", "none login")
+		location := redirect(t, s.authorize(t, params))
+		if location.Query().Get("error") != ErrorCodeInvalidRequest {
+			t.Errorf("location = %s, want an invalid_request error", location)
+		}
+	})
+}
+
+func TestOpenIDConnect_MaxAge(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxAge    string
+		authAge   time.Duration
+		wantLogin bool
+	}{
+		{"Issues a code for a recent login", "3600", 10 * time.Minute, false},
+		{"Requires a new login when the login is too old", "60", 10 * time.Minute, true},
+		{"Requires a new login with max_age=0", "0", time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAuthorizationServer(t)
+			s.authTime = s.timegen.Now().Add(-tt.authAge)
+			params := oidcParams("openid")
+			params.Set("max_age", tt.maxAge)
+			resp := s.authorize(t, params)
+			location := resp.Header.Get("Location")
+			if got := strings.HasPrefix(location, "https://auth.example.com/login"); got != tt.wantLogin {
+				t.Errorf("location = %s, want login %t", location, tt.wantLogin)
+			}
+		})
+	}
+
+	t.Run("Rejects an invalid max_age", func(t *testing.T) {
+		s := newAuthorizationServer(t)
+		params := oidcParams("openid")
+		params.Set("max_age", "-1")
+		location, _ := url.Parse(s.authorize(t, params).Header.Get("Location"))
+		if location.Query().Get("error") != ErrorCodeInvalidRequest {
+			t.Errorf("location = %s, want an invalid_request error", location)
+		}
+	})
+}
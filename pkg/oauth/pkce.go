@@ -0,0 +1,33 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+)
+
+// CodeChallengeMethodS256 is the only PKCE method accepted, as plain offers no protection
+// against an intercepted authorization request.
+const CodeChallengeMethodS256 = "S256"
+
+// codeVerifierPattern matches code verifiers and S256 challenges as defined by RFC 7636.
+var (
+	codeVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// NewCodeChallenge derives the S256 code challenge of verifier.
+func NewCodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyCodeVerifier checks verifier against an S256 challenge.
+func verifyCodeVerifier(verifier, challenge string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	return securetoken.Equal(NewCodeChallenge(verifier), challenge)
+}
@@ -0,0 +1,106 @@
+package oauth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/pkg/errors"
+)
+
+type revocationHandler struct {
+	clients       ClientAuthenticator
+	accessTokens  jwt.JWT
+	revocations   jwt.RevocationStore
+	refreshTokens refreshtoken.Service
+	logger        *logger.Logger
+}
+
+// NewRevocationHandler instantiates the RFC 7009 token revocation endpoint. Access tokens are
+// revoked by jti in revocations, refresh tokens are revoked together with their family. Unknown
+// or already invalid tokens are acknowledged with 200 as the RFC requires, while access tokens
+// without a jti cannot be revoked and are refused with 400 unsupported_token_type.
+func NewRevocationHandler(clients ClientAuthenticator, accessTokens jwt.JWT, revocations jwt.RevocationStore,
+	refreshTokens refreshtoken.Service, logger *logger.Logger) http.Handler {
+	return &revocationHandler{
+		clients:       clients,
+		accessTokens:  accessTokens,
+		revocations:   revocations,
+		refreshTokens: refreshTokens,
+		logger:        logger,
+	}
+}
+
+func (h *revocationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	client, err := h.clients.Authenticate(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "token is required"), h.logger)
+		return
+	}
+
+	revokers := []func(*http.Request, string, *Client) (bool, error){h.revokeAccessToken, h.revokeRefreshToken}
+	if r.PostForm.Get("token_type_hint") == TokenTypeHintRefreshToken {
+		revokers[0], revokers[1] = revokers[1], revokers[0]
+	}
+	for _, revoke := range revokers {
+		revoked, err := revoke(r, token, client)
+		if err != nil {
+			writeError(w, err, h.logger)
+			return
+		}
+		if revoked {
+			break
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeAccessToken reports false when token is not an access token.
+func (h *revocationHandler) revokeAccessToken(r *http.Request, token string, client *Client) (bool, error) {
+	claims, err := h.accessTokens.ParseClaims(r.Context(), token, true)
+	var tokenErr *jwt.TokenError
+	if errors.As(err, &tokenErr) && !errors.Is(err, jwt.ErrKeyFetch) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if clientID, _ := claims.Extra["client_id"].(string); clientID != client.ID {
+		return false, httperror.New(http.StatusBadRequest, ErrorCodeUnauthorizedClient, "token was issued to another client")
+	}
+	if claims.ID == "" {
+		return false, httperror.New(http.StatusBadRequest, ErrorCodeUnsupportedTokenType, "token cannot be revoked")
+	}
+	if err := h.revocations.Revoke(r.Context(), claims.ID, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
+
+// revokeRefreshToken reports false when token is not a refresh token.
+func (h *revocationHandler) revokeRefreshToken(r *http.Request, token string, client *Client) (bool, error) {
+	err := h.refreshTokens.Revoke(r.Context(), token, client.ID)
+	if errors.Is(err, refreshtoken.ErrNotFound) {
+		return false, nil
+	}
+	if errors.Is(err, refreshtoken.ErrClientMismatch) {
+		return false, httperror.New(http.StatusBadRequest, ErrorCodeUnauthorizedClient, "token was issued to another client")
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
@@ -0,0 +1,104 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+)
+
+// withoutJTI is a jwt.JWT whose parsed claims have no jti, like tokens of another issuer.
+type withoutJTI struct {
+	jwt.JWT
+}
+
+func (j withoutJTI) ParseClaims(ctx context.Context, token string, ignoreExpiration bool) (*jwt.Claims, error) {
+	claims, err := j.JWT.ParseClaims(ctx, token, ignoreExpiration)
+	if claims != nil {
+		claims.ID = ""
+	}
+	return claims, err
+}
+
+func TestRevocationHandler(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	_ = f.clients.Create(ctx, &Client{ID: "app", SecretHash: securetoken.Hash("app-secret"), Name: "App"})
+	handler := NewRevocationHandler(NewClientAuthenticator(f.clients), f.accessTokens, f.revocations, f.refreshTokens, f.logger)
+
+	signAccessToken := func(clientID string) string {
+		token, _, err := f.accessTokens.SignClaims(ctx, &jwt.Claims{
+			Subject: "user-1",
+			Extra:   map[string]interface{}{"client_id": clientID},
+		})
+		if err != nil {
+			t.Fatalf("SignClaims() error = %v", err)
+		}
+		return token
+	}
+
+	t.Run("Revokes access tokens", func(t *testing.T) {
+		token := signAccessToken("app")
+		recorder, _ := postForm(t, handler, url.Values{"token": {token}, "token_type_hint": {"access_token"}}, "app", "app-secret")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+		}
+		if _, err := f.accessTokens.ParseClaims(ctx, token, false); !errors.Is(err, jwt.ErrRevoked) {
+			t.Errorf("ParseClaims() error = %v, want %v", err, jwt.ErrRevoked)
+		}
+	})
+
+	t.Run("Revokes the family of refresh tokens", func(t *testing.T) {
+		issued, _ := f.refreshTokens.Issue(ctx, refreshtoken.Grant{Subject: "user-1", ClientID: "app"})
+		pair, err := f.refreshTokens.Exchange(ctx, issued.Value, "", nil)
+		if err != nil {
+			t.Fatalf("Exchange() error = %v", err)
+		}
+		recorder, _ := postForm(t, handler, url.Values{"token": {issued.Value}}, "app", "app-secret")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+		}
+		if _, err := f.refreshTokens.Lookup(ctx, pair.RefreshToken.Value); !errors.Is(err, refreshtoken.ErrRevoked) {
+			t.Errorf("Lookup() error = %v, want %v", err, refreshtoken.ErrRevoked)
+		}
+	})
+
+	t.Run("Acknowledges unknown tokens", func(t *testing.T) {
+		recorder, _ := postForm(t, handler, url.Values{"token": {"unknown"}}, "app", "app-secret")
+		if recorder.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", recorder.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("Refuses tokens issued to another client", func(t *testing.T) {
+		token := signAccessToken(testClientID)
+		recorder, body := postForm(t, handler, url.Values{"token": {token}}, "app", "app-secret")
+		if recorder.Code != http.StatusBadRequest || body["error"] != ErrorCodeUnauthorizedClient {
+			t.Errorf("response = %d %v, want unauthorized_client", recorder.Code, body)
+		}
+		if _, err := f.accessTokens.ParseClaims(ctx, token, false); err != nil {
+			t.Errorf("ParseClaims() error = %v, want token to stay valid", err)
+		}
+	})
+
+	t.Run("Refuses access tokens without a jti", func(t *testing.T) {
+		handler := NewRevocationHandler(NewClientAuthenticator(f.clients), withoutJTI{f.accessTokens}, f.revocations,
+			f.refreshTokens, f.logger)
+		recorder, body := postForm(t, handler, url.Values{"token": {signAccessToken("app")}}, "app", "app-secret")
+		if recorder.Code != http.StatusBadRequest || body["error"] != ErrorCodeUnsupportedTokenType {
+			t.Errorf("response = %d %v, want 400 unsupported_token_type", recorder.Code, body)
+		}
+	})
+
+	t.Run("Requires client authentication", func(t *testing.T) {
+		recorder, _ := postForm(t, handler, url.Values{"token": {"unknown"}}, "", "")
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+		}
+	})
+}
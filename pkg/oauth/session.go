@@ -0,0 +1,28 @@
+package oauth
+
+import (
+	"net/http"
+	"time"
+)
+
+// Session is an authenticated end-user session.
+type Session struct {
+	Subject  string
+	AuthTime time.Time
+	AMR      []string
+	ACR      string
+}
+
+// SessionProvider resolves the end-user authenticated in a browser request.
+type SessionProvider interface {
+	// Session returns the current session, or nil when the user is not authenticated.
+	Session(r *http.Request) (*Session, error)
+}
+
+// SessionProviderFunc adapts a function to a SessionProvider.
+type SessionProviderFunc func(r *http.Request) (*Session, error)
+
+// Session calls f(r).
+func (f SessionProviderFunc) Session(r *http.Request) (*Session, error) {
+	return f(r)
+}
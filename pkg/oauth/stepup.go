@@ -0,0 +1,36 @@
+package oauth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+)
+
+// ErrorCodeInsufficientUserAuthentication is the error of RFC 9470, telling clients to
+// authenticate the user again, more strongly or more recently.
+const ErrorCodeInsufficientUserAuthentication = "insufficient_user_authentication"
+
+// RequireStepUp checks the user was authenticated with one of methods, recorded in the amr
+// claim, less than maxAge before now, for sensitive operations a stolen access token must not
+// allow alone. It returns an *Error with the challenge of RFC 9470 otherwise.
+func RequireStepUp(r *http.Request, claims *jwt.Claims, now time.Time, maxAge time.Duration, methods ...string) error {
+	scheme, _ := accessToken(r)
+	authTime := time.Unix(claims.AuthTime, 0)
+	if claims.AuthTime == 0 || now.Sub(authTime) > maxAge {
+		return newStepUpError(scheme, "authentication is too old", maxAge)
+	}
+	for _, method := range claims.AMR {
+		if contains(methods, method) {
+			return nil
+		}
+	}
+	return newStepUpError(scheme, "a second factor is required", maxAge)
+}
+
+func newStepUpError(scheme, description string, maxAge time.Duration) *Error {
+	err := newChallengeError(scheme, http.StatusUnauthorized, ErrorCodeInsufficientUserAuthentication, description)
+	challenge := err.Header().Get("WWW-Authenticate") + `, max_age="` + strconv.Itoa(int(maxAge.Seconds())) + `"`
+	return err.WithHeader("WWW-Authenticate", challenge)
+}
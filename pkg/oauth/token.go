@@ -0,0 +1,64 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+)
+
+// GrantHandler handles one grant_type of the token endpoint.
+type GrantHandler interface {
+	// GrantType returns the grant_type handled, e.g. authorization_code.
+	GrantType() string
+
+	// Handle exchanges the grant in r for tokens on behalf of the authenticated client.
+	Handle(r *http.Request, client *Client) (*TokenResponse, error)
+}
+
+type tokenHandler struct {
+	clients ClientAuthenticator
+	grants  map[string]GrantHandler
+	logger  *logger.Logger
+}
+
+// NewTokenHandler instantiates the token endpoint, dispatching requests to grants by grant_type.
+func NewTokenHandler(clients ClientAuthenticator, logger *logger.Logger, grants ...GrantHandler) http.Handler {
+	handler := &tokenHandler{
+		clients: clients,
+		grants:  map[string]GrantHandler{},
+		logger:  logger,
+	}
+	for _, grant := range grants {
+		handler.grants[grant.GrantType()] = grant
+	}
+	return handler
+}
+
+func (h *tokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	grantType := r.PostForm.Get("grant_type")
+	grant, ok := h.grants[grantType]
+	if !ok {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeUnsupportedGrantType, ""), h.logger)
+		return
+	}
+	client, err := h.clients.Authenticate(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if !client.GrantTypes.Contains(grantType) {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeUnauthorizedClient, "grant type is not allowed for this client"), h.logger)
+		return
+	}
+	response, err := grant.Handle(withCertificateBinding(r, client), client)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, response)
+}
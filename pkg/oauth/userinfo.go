@@ -0,0 +1,155 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// Scopes of OpenID Connect. ScopeOpenID requests an ID token, while the others request
+// standard claims.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopePhone   = "phone"
+	ScopeAddress = "address"
+)
+
+// ErrUserNotFound indicates the subject of a token no longer exists.
+var ErrUserNotFound = errors.New("user is not found")
+
+// Address is the address claim defined by OpenID Connect Core 5.1.1.
+type Address struct {
+	Formatted     string `json:"formatted,omitempty"`
+	StreetAddress string `json:"street_address,omitempty"`
+	Locality      string `json:"locality,omitempty"`
+	Region        string `json:"region,omitempty"`
+	PostalCode    string `json:"postal_code,omitempty"`
+	Country       string `json:"country,omitempty"`
+}
+
+// UserInfo holds the standard claims of an end-user defined by OpenID Connect Core 5.1.
+type UserInfo struct {
+	Subject             string
+	Name                string
+	GivenName           string
+	FamilyName          string
+	MiddleName          string
+	Nickname            string
+	PreferredUsername   string
+	Profile             string
+	Picture             string
+	Website             string
+	Gender              string
+	Birthdate           string
+	Zoneinfo            string
+	Locale              string
+	UpdatedAt           int64
+	Email               string
+	EmailVerified       bool
+	PhoneNumber         string
+	PhoneNumberVerified bool
+	Address             *Address
+}
+
+// UserInfoProvider provides the standard claims of end-users.
+type UserInfoProvider interface {
+	// UserInfo returns the claims of subject, or ErrUserNotFound.
+	UserInfo(ctx context.Context, subject string) (*UserInfo, error)
+}
+
+// Claims returns the claims of this UserInfo released by scopes. Empty claims are omitted.
+func (u *UserInfo) Claims(scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": u.Subject}
+	set := func(name string, value interface{}) {
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				return
+			}
+		case int64:
+			if v == 0 {
+				return
+			}
+		case *Address:
+			if v == nil {
+				return
+			}
+		}
+		claims[name] = value
+	}
+	if contains(scopes, ScopeProfile) {
+		set("name", u.Name)
+		set("given_name", u.GivenName)
+		set("family_name", u.FamilyName)
+		set("middle_name", u.MiddleName)
+		set("nickname", u.Nickname)
+		set("preferred_username", u.PreferredUsername)
+		set("profile", u.Profile)
+		set("picture", u.Picture)
+		set("website", u.Website)
+		set("gender", u.Gender)
+		set("birthdate", u.Birthdate)
+		set("zoneinfo", u.Zoneinfo)
+		set("locale", u.Locale)
+		set("updated_at", u.UpdatedAt)
+	}
+	if contains(scopes, ScopeEmail) && u.Email != "" {
+		claims["email"] = u.Email
+		claims["email_verified"] = u.EmailVerified
+	}
+	if contains(scopes, ScopePhone) && u.PhoneNumber != "" {
+		claims["phone_number"] = u.PhoneNumber
+		claims["phone_number_verified"] = u.PhoneNumberVerified
+	}
+	if contains(scopes, ScopeAddress) {
+		set("address", u.Address)
+	}
+	return claims
+}
+
+type userInfoHandler struct {
+	accessTokens AccessTokenVerifier
+	users        UserInfoProvider
+	logger       *logger.Logger
+}
+
+// NewUserInfoHandler instantiates the userinfo endpoint, which returns the claims of the
+// end-user released by the scopes of the access token.
+func NewUserInfoHandler(accessTokens AccessTokenVerifier, users UserInfoProvider, logger *logger.Logger) http.Handler {
+	return &userInfoHandler{
+		accessTokens: accessTokens,
+		users:        users,
+		logger:       logger,
+	}
+}
+
+func (h *userInfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be GET or POST"), h.logger)
+		return
+	}
+	claims, err := h.accessTokens.Verify(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if !claims.HasScope(ScopeOpenID) {
+		writeError(w, newChallengeError(tokenScheme(claims), http.StatusForbidden, ErrorCodeInsufficientScope, "openid scope is required"), h.logger)
+		return
+	}
+	user, err := h.users.UserInfo(r.Context(), claims.Subject)
+	if errors.Is(err, ErrUserNotFound) {
+		writeError(w, newChallengeError(tokenScheme(claims), http.StatusUnauthorized, ErrorCodeInvalidToken, "user is not found"), h.logger)
+		return
+	}
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, user.Claims(claims.Scopes()))
+}
@@ -0,0 +1,194 @@
+// Package password hashes passwords with Argon2id, encoded in the PHC string format, and
+// verifies the bcrypt and PBKDF2 hashes of imported accounts so they can be rehashed.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrMalformedHash indicates an encoded hash cannot be parsed.
+	ErrMalformedHash = errors.New("password hash is malformed")
+	// ErrUnsupportedHash indicates an encoded hash uses an unknown algorithm.
+	ErrUnsupportedHash = errors.New("password hash algorithm is not supported")
+)
+
+// Argon2Params are the cost parameters of Argon2id.
+type Argon2Params struct {
+	// Memory is the memory cost in KiB.
+	Memory uint32
+	// Iterations is the number of passes over the memory.
+	Iterations uint32
+	// Parallelism is the number of lanes.
+	Parallelism uint8
+	// SaltLength is the length of random salts in bytes.
+	SaltLength uint32
+	// KeyLength is the length of hashes in bytes.
+	KeyLength uint32
+}
+
+// DefaultArgon2Params follows the OWASP recommendation of 19 MiB of memory and 2 iterations.
+// Run the benchmarks of this package on production hardware to calibrate them.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hasher hashes and verifies passwords.
+type Hasher interface {
+	// Hash returns the PHC encoded Argon2id hash of password, with a random salt.
+	Hash(password string) (string, error)
+
+	// Verify checks password against encoded, in constant time. Besides Argon2id, encoded can be
+	// a bcrypt hash, or a PBKDF2 hash in the format of passlib.
+	Verify(password, encoded string) (bool, error)
+
+	// NeedsRehash checks whether encoded uses another algorithm or other parameters than the
+	// ones Hash uses, so a verified password should be hashed again.
+	NeedsRehash(encoded string) bool
+}
+
+type hasher struct {
+	params Argon2Params
+}
+
+// Option configures optional behaviour of the Hasher.
+type Option func(*hasher)
+
+// WithArgon2Params sets the parameters of new hashes, DefaultArgon2Params by default.
+func WithArgon2Params(params Argon2Params) Option {
+	return func(h *hasher) {
+		h.params = params
+	}
+}
+
+// NewHasher instantiates a new Hasher.
+func NewHasher(options ...Option) Hasher {
+	h := &hasher{params: DefaultArgon2Params}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+func (h *hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.WithStack(err)
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	return encodeArgon2(h.params, salt, key), nil
+}
+
+func (h *hasher) Verify(password, encoded string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, err
+		}
+		actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		return subtle.ConstantTimeCompare(actual, key) == 1, nil
+	case isBcrypt(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(ErrMalformedHash, err.Error())
+		}
+		return true, nil
+	case strings.HasPrefix(encoded, "$pbkdf2"):
+		return verifyPBKDF2(password, encoded)
+	default:
+		return false, errors.WithStack(ErrUnsupportedHash)
+	}
+}
+
+func (h *hasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.params.Memory || params.Iterations != h.params.Iterations ||
+		params.Parallelism != h.params.Parallelism || uint32(len(salt)) < h.params.SaltLength ||
+		uint32(len(key)) != h.params.KeyLength
+}
+
+// encodeArgon2 encodes an Argon2id hash in the PHC string format, e.g.
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>, with unpadded standard base64.
+func encodeArgon2(params Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeArgon2 parses a PHC encoded Argon2id hash of the current Argon2 version.
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, errors.WithStack(ErrMalformedHash)
+	}
+	if parts[2] != "v="+strconv.Itoa(argon2.Version) {
+		return params, nil, nil, errors.WithStack(ErrUnsupportedHash)
+	}
+	var memory, iterations, parallelism uint64
+	for _, field := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(field, "=")
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return params, nil, nil, errors.WithStack(ErrMalformedHash)
+		}
+		switch name {
+		case "m":
+			memory = parsed
+		case "t":
+			iterations = parsed
+		case "p":
+			parallelism = parsed
+		default:
+			return params, nil, nil, errors.WithStack(ErrMalformedHash)
+		}
+	}
+	if memory == 0 || iterations == 0 || parallelism == 0 || parallelism > 255 {
+		return params, nil, nil, errors.WithStack(ErrMalformedHash)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errors.WithStack(ErrMalformedHash)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.WithStack(ErrMalformedHash)
+	}
+	params = Argon2Params{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+		SaltLength:  uint32(len(salt)),
+		KeyLength:   uint32(len(key)),
+	}
+	return params, salt, key, nil
+}
+
+func isBcrypt(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
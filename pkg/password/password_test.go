@@ -0,0 +1,183 @@
+package password
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keeps tests fast; they are far too weak for production.
+var testParams = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func newBcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt.GenerateFromPassword() error = %v", err)
+	}
+	return string(hash)
+}
+
+func TestHasher_Hash(t *testing.T) {
+	hasher := NewHasher(WithArgon2Params(testParams))
+	first, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.HasPrefix(first, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash() = %s, want a PHC encoded Argon2id hash", first)
+	}
+	second, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if first == second {
+		t.Errorf("Hash() = %s twice, want random salts", first)
+	}
+}
+
+func TestHasher_Verify(t *testing.T) {
+	hasher := NewHasher(WithArgon2Params(testParams))
+	argon2Hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		encoded  string
+		want     bool
+		wantErr  error
+	}{
+		{name: "Verifies an Argon2id hash", password: "correct horse", encoded: argon2Hash, want: true},
+		{name: "Rejects another password for an Argon2id hash", password: "battery staple", encoded: argon2Hash},
+		{
+			name:     "Verifies the reference Argon2id test vector",
+			password: "password",
+			encoded:  "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+			want:     true,
+		},
+		{name: "Verifies a bcrypt hash", password: "correct horse", encoded: newBcryptHash(t, "correct horse"), want: true},
+		{name: "Rejects another password for a bcrypt hash", password: "battery staple", encoded: newBcryptHash(t, "correct horse")},
+		{
+			name:     "Verifies a PBKDF2-SHA1 hash",
+			password: "correct horse",
+			encoded:  "$pbkdf2$29000$AAECAwQFBgcICQoLDA0ODw$JppZ11L9r0j3Zbivqu.TC4XrpYk",
+			want:     true,
+		},
+		{
+			name:     "Verifies a PBKDF2-SHA256 hash",
+			password: "correct horse",
+			encoded:  "$pbkdf2-sha256$29000$AAECAwQFBgcICQoLDA0ODw$ZvLORN3Wu1.2s6Mb0kBKZ43bylSsC/t0Vz5rE9nJPNg",
+			want:     true,
+		},
+		{
+			name:     "Verifies a PBKDF2-SHA512 hash",
+			password: "correct horse",
+			encoded:  "$pbkdf2-sha512$29000$AAECAwQFBgcICQoLDA0ODw$tFBJNmdbabFGdromEJjlq9IxWTvZKHmNCNy.6dUMQxyC21DEUTa22.V4oO3hrJTLDPvVLwOoc0kjHP80n56kag",
+			want:     true,
+		},
+		{
+			name:     "Rejects another password for a PBKDF2 hash",
+			password: "battery staple",
+			encoded:  "$pbkdf2-sha256$29000$AAECAwQFBgcICQoLDA0ODw$ZvLORN3Wu1.2s6Mb0kBKZ43bylSsC/t0Vz5rE9nJPNg",
+		},
+		{name: "Rejects an unknown algorithm", encoded: "$scrypt$ln=16,r=8,p=1$c2FsdA$aGFzaA", wantErr: ErrUnsupportedHash},
+		{name: "Rejects another Argon2 version", encoded: "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA", wantErr: ErrUnsupportedHash},
+		{name: "Rejects malformed Argon2id parameters", encoded: "$argon2id$v=19$m=64,t=0,p=1$c2FsdA$aGFzaA", wantErr: ErrMalformedHash},
+		{name: "Rejects a malformed Argon2id hash", encoded: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", wantErr: ErrMalformedHash},
+		{name: "Rejects a malformed bcrypt hash", encoded: "$2b$10$short", wantErr: ErrMalformedHash},
+		{name: "Rejects malformed PBKDF2 rounds", encoded: "$pbkdf2-sha256$many$AAEC$AAEC", wantErr: ErrMalformedHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hasher.Verify(tt.password, tt.encoded)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Verify() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasher_NeedsRehash(t *testing.T) {
+	hasher := NewHasher(WithArgon2Params(testParams))
+	current, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	stronger := testParams
+	stronger.Iterations = 2
+	outdated, err := NewHasher(WithArgon2Params(stronger)).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		want    bool
+	}{
+		{name: "Keeps hashes with the current parameters", encoded: current},
+		{name: "Rehashes hashes with other parameters", encoded: outdated, want: true},
+		{name: "Rehashes bcrypt hashes", encoded: newBcryptHash(t, "correct horse"), want: true},
+		{name: "Rehashes PBKDF2 hashes", encoded: "$pbkdf2-sha256$29000$AAECAwQFBgcICQoLDA0ODw$ZvLORN3Wu1.2s6Mb0kBKZ43bylSsC/t0Vz5rE9nJPNg", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasher.NeedsRehash(tt.encoded); got != tt.want {
+				t.Errorf("NeedsRehash() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkArgon2id measures Argon2id with candidate parameters. Choose the strongest ones
+// whose time per operation the login endpoint can afford, usually well under a second:
+//
+//	go test ./pkg/password -run '^$' -bench Argon2id -benchmem
+func BenchmarkArgon2id(b *testing.B) {
+	candidates := []struct {
+		name   string
+		params Argon2Params
+	}{
+		{name: "m=19MiB,t=2,p=1", params: DefaultArgon2Params},
+		{name: "m=46MiB,t=1,p=1", params: Argon2Params{Memory: 46 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}},
+		{name: "m=64MiB,t=3,p=4", params: Argon2Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32}},
+	}
+	for _, candidate := range candidates {
+		b.Run(candidate.name, func(b *testing.B) {
+			hasher := NewHasher(WithArgon2Params(candidate.params))
+			for i := 0; i < b.N; i++ {
+				if _, err := hasher.Hash("correct horse battery staple"); err != nil {
+					b.Fatalf("Hash() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBcrypt measures the verification of legacy bcrypt hashes, for comparison.
+func BenchmarkBcrypt(b *testing.B) {
+	for _, cost := range []int{10, 12} {
+		b.Run("cost="+strconv.Itoa(cost), func(b *testing.B) {
+			hash, err := bcrypt.GenerateFromPassword([]byte("correct horse battery staple"), cost)
+			if err != nil {
+				b.Fatalf("bcrypt.GenerateFromPassword() error = %v", err)
+			}
+			hasher := NewHasher()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := hasher.Verify("correct horse battery staple", string(hash)); err != nil {
+					b.Fatalf("Verify() error = %v", err)
+				}
+			}
+		})
+	}
+}
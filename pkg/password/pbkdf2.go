@@ -0,0 +1,54 @@
+package password
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// pbkdf2Digests maps the identifiers of passlib PBKDF2 hashes to their digest.
+var pbkdf2Digests = map[string]func() hash.Hash{
+	"pbkdf2":        sha1.New,
+	"pbkdf2-sha256": sha256.New,
+	"pbkdf2-sha512": sha512.New,
+}
+
+// verifyPBKDF2 checks password against a PBKDF2 hash in the format of passlib, e.g.
+// $pbkdf2-sha256$29000$<salt>$<hash>, whose salt and hash use base64 with "." instead of "+"
+// and without padding.
+func verifyPBKDF2(password, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return false, errors.WithStack(ErrMalformedHash)
+	}
+	digest, ok := pbkdf2Digests[parts[1]]
+	if !ok {
+		return false, errors.WithStack(ErrUnsupportedHash)
+	}
+	rounds, err := strconv.Atoi(parts[2])
+	if err != nil || rounds <= 0 {
+		return false, errors.WithStack(ErrMalformedHash)
+	}
+	salt, err := decodeAdaptedBase64(parts[3])
+	if err != nil {
+		return false, errors.WithStack(ErrMalformedHash)
+	}
+	key, err := decodeAdaptedBase64(parts[4])
+	if err != nil || len(key) == 0 {
+		return false, errors.WithStack(ErrMalformedHash)
+	}
+	actual := pbkdf2.Key([]byte(password), salt, rounds, len(key), digest)
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+func decodeAdaptedBase64(value string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(value, ".", "+"))
+}
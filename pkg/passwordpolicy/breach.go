@@ -0,0 +1,134 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// prefixLength is the number of hex characters of the SHA-1 prefix ranges are looked up by.
+const prefixLength = 5
+
+// ErrMalformedRange indicates a line of a breached password dataset cannot be parsed.
+var ErrMalformedRange = errors.New("breached password range is malformed")
+
+// RangeSource looks up breached passwords by k-anonymity, as the Pwned Passwords range API
+// does: only the first 5 hex characters of the SHA-1 of a password are asked for, so sources
+// may be remote without learning which password is checked.
+type RangeSource interface {
+	// Range returns how many times each breached password whose uppercase hex SHA-1 starts
+	// with prefix appeared, by the remaining 35 characters.
+	Range(ctx context.Context, prefix string) (map[string]int, error)
+}
+
+// BreachCount returns how many times password appeared in the breaches of source.
+func BreachCount(ctx context.Context, source RangeSource, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := source.Range(ctx, hash[:prefixLength])
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return suffixes[hash[prefixLength:]], nil
+}
+
+type memoryRanges struct {
+	ranges map[string]map[string]int
+}
+
+// NewMemoryRanges loads a whole dataset in memory from r, which holds a HASH:COUNT line per
+// breached password, as in the downloadable Pwned Passwords SHA-1 files. Lines without a count
+// count once.
+func NewMemoryRanges(r io.Reader) (RangeSource, error) {
+	source := &memoryRanges{ranges: map[string]map[string]int{}}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		hash, count, err := parseRangeLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(hash) != sha1.Size*2 {
+			return nil, errors.WithStack(ErrMalformedRange)
+		}
+		prefix := hash[:prefixLength]
+		if source.ranges[prefix] == nil {
+			source.ranges[prefix] = map[string]int{}
+		}
+		source.ranges[prefix][hash[prefixLength:]] += count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return source, nil
+}
+
+func (s *memoryRanges) Range(_ context.Context, prefix string) (map[string]int, error) {
+	return s.ranges[strings.ToUpper(prefix)], nil
+}
+
+type directoryRanges struct {
+	dir string
+}
+
+// NewDirectoryRanges instantiates a RangeSource reading the file PREFIX.txt of dir for each
+// prefix, with a SUFFIX:COUNT line per breached password, as the range API responds. This is
+// the layout the Pwned Passwords downloader writes, which is too large to keep in memory.
+// Missing files are empty ranges.
+func NewDirectoryRanges(dir string) RangeSource {
+	return &directoryRanges{dir: dir}
+}
+
+func (s *directoryRanges) Range(_ context.Context, prefix string) (map[string]int, error) {
+	prefix = strings.ToUpper(prefix)
+	if len(prefix) != prefixLength || strings.Trim(prefix, "0123456789ABCDEF") != "" {
+		return nil, errors.WithStack(ErrMalformedRange)
+	}
+	file, err := os.Open(filepath.Join(s.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer file.Close()
+
+	suffixes := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		suffix, count, err := parseRangeLine(line)
+		if err != nil {
+			return nil, err
+		}
+		suffixes[suffix] += count
+	}
+	return suffixes, errors.WithStack(scanner.Err())
+}
+
+// parseRangeLine parses a HASH:COUNT line, uppercasing the hash.
+func parseRangeLine(line string) (string, int, error) {
+	hash, countText, hasCount := strings.Cut(line, ":")
+	count := 1
+	if hasCount {
+		var err error
+		if count, err = strconv.Atoi(strings.TrimSpace(countText)); err != nil || count < 0 {
+			return "", 0, errors.WithStack(ErrMalformedRange)
+		}
+	}
+	return strings.ToUpper(strings.TrimSpace(hash)), count, nil
+}
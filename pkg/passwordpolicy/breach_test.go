@@ -0,0 +1,57 @@
+package passwordpolicy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirectoryRanges(t *testing.T) {
+	dir := t.TempDir()
+	// The range of "password", whose SHA-1 is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8.
+	content := "003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(content), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	source := NewDirectoryRanges(dir)
+
+	t.Run("Counts a breached password", func(t *testing.T) {
+		count, err := BreachCount(context.Background(), source, "password")
+		if err != nil || count != 9545824 {
+			t.Errorf("BreachCount() = %d, %v, want 9545824", count, err)
+		}
+	})
+
+	t.Run("Treats a missing range as empty", func(t *testing.T) {
+		count, err := BreachCount(context.Background(), source, "Correct-horse-7")
+		if err != nil || count != 0 {
+			t.Errorf("BreachCount() = %d, %v, want 0", count, err)
+		}
+	})
+
+	t.Run("Rejects prefixes escaping the directory", func(t *testing.T) {
+		if _, err := source.Range(context.Background(), "../.."); !errors.Is(err, ErrMalformedRange) {
+			t.Errorf("Range() error = %v, want ErrMalformedRange", err)
+		}
+	})
+}
+
+func TestNewMemoryRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		dataset string
+	}{
+		{name: "Rejects a truncated hash", dataset: "5BAA61E4C9B93F3F:3\n"},
+		{name: "Rejects a malformed count", dataset: "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:many\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMemoryRanges(strings.NewReader(tt.dataset)); !errors.Is(err, ErrMalformedRange) {
+				t.Errorf("NewMemoryRanges() error = %v, want ErrMalformedRange", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+// Package passwordpolicy checks new passwords against a configurable policy: length, character
+// classes, personal information, reuse of previous passwords and known breaches. Violations
+// are reported with a code and parameters, so clients can show them in their own language.
+package passwordpolicy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Codes of the violations of a policy.
+const (
+	CodeTooShort            = "password_too_short"
+	CodeTooLong             = "password_too_long"
+	CodeMissingClasses      = "password_missing_character_classes"
+	CodePersonalInformation = "password_contains_personal_information"
+	CodeDenylisted          = "password_denylisted"
+	CodeReused              = "password_reused"
+	CodeBreached            = "password_breached"
+)
+
+// Violation is a rule a password breaks. Params hold the values the message of Code refers to.
+type Violation struct {
+	Code   string                 `json:"code"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// Catalog maps violation codes to message templates, in which {name} is replaced by the
+// param name.
+type Catalog map[string]string
+
+// EnglishCatalog holds the English messages of violations.
+var EnglishCatalog = Catalog{
+	CodeTooShort:            "Password must have at least {min} characters.",
+	CodeTooLong:             "Password must have at most {max} characters.",
+	CodeMissingClasses:      "Password must mix at least {min} of lowercase letters, uppercase letters, digits and symbols.",
+	CodePersonalInformation: "Password must not contain your {field}.",
+	CodeDenylisted:          "Password must not contain commonly used words.",
+	CodeReused:              "Password must differ from your last {count} passwords.",
+	CodeBreached:            "Password has appeared in a data breach and must not be used.",
+}
+
+// Message returns the message of v in catalog, or its code when catalog has none.
+func (c Catalog) Message(v Violation) string {
+	template, ok := c[v.Code]
+	if !ok {
+		return v.Code
+	}
+	for name, value := range v.Params {
+		template = strings.ReplaceAll(template, "{"+name+"}", fmt.Sprint(value))
+	}
+	return template
+}
+
+// Error is returned when a password violates the policy.
+type Error struct {
+	Violations []Violation
+}
+
+// Error returns the English messages of the violations.
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, EnglishCatalog.Message(violation))
+	}
+	return "password violates the policy: " + strings.Join(messages, " ")
+}
+
+// Policy configures the rules a password must follow. Zero values disable a rule.
+type Policy struct {
+	// MinLength and MaxLength bound the number of characters, not bytes.
+	MinLength int
+	MaxLength int
+	// MinCharacterClasses is how many of lowercase letters, uppercase letters, digits and
+	// symbols a password must mix.
+	MinCharacterClasses int
+	// Denylist holds words passwords must not contain, compared case-insensitively, e.g. the
+	// name of the service.
+	Denylist []string
+	// HistorySize is how many previous passwords a new one must differ from.
+	HistorySize int
+	// MinBreachCount is how many times a password must have appeared in breaches to be
+	// rejected, when breached passwords are checked. It defaults to 1.
+	MinBreachCount int
+}
+
+// DefaultPolicy follows NIST SP 800-63B: it favours length over composition, and is meant to
+// be combined with breached password checks.
+var DefaultPolicy = Policy{
+	MinLength:           10,
+	MaxLength:           128,
+	MinCharacterClasses: 2,
+	HistorySize:         5,
+}
+
+// minContextWordLength is the shortest part of personal information looked for in passwords,
+// so short names do not reject most passwords.
+const minContextWordLength = 4
+
+// Subject is who a password is set for.
+type Subject struct {
+	Email    string
+	Username string
+	Name     string
+	// History holds the encoded hashes of the previous passwords, newest first.
+	History []string
+}
+
+// Verifier verifies passwords against encoded hashes. password.Hasher implements it.
+type Verifier interface {
+	Verify(password, encoded string) (bool, error)
+}
+
+// Checker checks passwords against a Policy.
+type Checker interface {
+	// Check returns an *Error listing every violation of password set for subject.
+	Check(ctx context.Context, password string, subject Subject) error
+
+	// HistorySize is how many previous passwords Check compares new ones with.
+	HistorySize() int
+}
+
+type checker struct {
+	policy   Policy
+	verifier Verifier
+	breaches RangeSource
+}
+
+// CheckerOption configures optional behaviour of the Checker.
+type CheckerOption func(*checker)
+
+// WithBreachedPasswords rejects passwords found in breaches, looked up in source.
+func WithBreachedPasswords(source RangeSource) CheckerOption {
+	return func(c *checker) {
+		c.breaches = source
+	}
+}
+
+// NewChecker instantiates a Checker of policy, comparing new passwords with previous ones
+// through verifier.
+func NewChecker(policy Policy, verifier Verifier, options ...CheckerOption) Checker {
+	c := &checker{policy: policy, verifier: verifier}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+func (c *checker) HistorySize() int {
+	return c.policy.HistorySize
+}
+
+func (c *checker) Check(ctx context.Context, password string, subject Subject) error {
+	var violations []Violation
+	length := utf8.RuneCountInString(password)
+	if c.policy.MinLength > 0 && length < c.policy.MinLength {
+		violations = append(violations, Violation{Code: CodeTooShort, Params: map[string]interface{}{"min": c.policy.MinLength}})
+	}
+	if c.policy.MaxLength > 0 && length > c.policy.MaxLength {
+		// Longer passwords are not checked further, as hashing them would waste resources.
+		violations = append(violations, Violation{Code: CodeTooLong, Params: map[string]interface{}{"max": c.policy.MaxLength}})
+		return &Error{Violations: violations}
+	}
+	if c.policy.MinCharacterClasses > 0 && characterClasses(password) < c.policy.MinCharacterClasses {
+		violations = append(violations, Violation{
+			Code:   CodeMissingClasses,
+			Params: map[string]interface{}{"min": c.policy.MinCharacterClasses},
+		})
+	}
+	violations = append(violations, personalInformation(password, subject)...)
+	if containsAny(password, c.policy.Denylist) {
+		violations = append(violations, Violation{Code: CodeDenylisted})
+	}
+
+	reused, err := c.reused(password, subject.History)
+	if err != nil {
+		return err
+	}
+	if reused {
+		violations = append(violations, Violation{Code: CodeReused, Params: map[string]interface{}{"count": c.policy.HistorySize}})
+	}
+	breached, err := c.breached(ctx, password)
+	if err != nil {
+		return err
+	}
+	if breached {
+		violations = append(violations, Violation{Code: CodeBreached})
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+	return nil
+}
+
+// characterClasses counts the classes of characters password mixes.
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// personalInformation reports the fields of subject whose words password contains.
+func personalInformation(password string, subject Subject) []Violation {
+	email := subject.Email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		// Only the local part is personal, the domain is often shared by many users.
+		email = email[:at]
+	}
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"email", email},
+		{"username", subject.Username},
+		{"name", subject.Name},
+	}
+	var violations []Violation
+	for _, field := range fields {
+		if containsAny(password, contextWords(field.value)) {
+			violations = append(violations, Violation{Code: CodePersonalInformation, Params: map[string]interface{}{"field": field.name}})
+		}
+	}
+	return violations
+}
+
+// contextWords splits value into words long enough to be looked for in passwords. value is a
+// word too, so e.g. "jane.doe" is found besides "jane" and "doe".
+func contextWords(value string) []string {
+	words := strings.FieldsFunc(value, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	words = append(words, value)
+	candidates := words[:0]
+	for _, word := range words {
+		if utf8.RuneCountInString(word) >= minContextWordLength {
+			candidates = append(candidates, word)
+		}
+	}
+	return candidates
+}
+
+// containsAny checks whether password contains one of words, ignoring case.
+func containsAny(password string, words []string) bool {
+	password = strings.ToLower(password)
+	for _, word := range words {
+		if word != "" && strings.Contains(password, strings.ToLower(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// reused checks whether password matches one of the last HistorySize hashes of history.
+func (c *checker) reused(password string, history []string) (bool, error) {
+	if c.policy.HistorySize <= 0 || c.verifier == nil {
+		return false, nil
+	}
+	if len(history) > c.policy.HistorySize {
+		history = history[:c.policy.HistorySize]
+	}
+	for _, encoded := range history {
+		ok, err := c.verifier.Verify(password, encoded)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *checker) breached(ctx context.Context, password string) (bool, error) {
+	if c.breaches == nil {
+		return false, nil
+	}
+	count, err := BreachCount(ctx, c.breaches, password)
+	if err != nil {
+		return false, err
+	}
+	minCount := c.policy.MinBreachCount
+	if minCount <= 0 {
+		minCount = 1
+	}
+	return count >= minCount, nil
+}
@@ -0,0 +1,119 @@
+package passwordpolicy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// plainVerifier verifies passwords hashed as "hashed:" followed by the password.
+type plainVerifier struct{}
+
+func (plainVerifier) Verify(password, encoded string) (bool, error) {
+	return encoded == "hashed:"+password, nil
+}
+
+func violationCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var policyErr *Error
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("Check() error = %v, want an *Error", err)
+	}
+	var codes []string
+	for _, violation := range policyErr.Violations {
+		codes = append(codes, violation.Code)
+	}
+	return codes
+}
+
+func TestChecker_Check(t *testing.T) {
+	policy := Policy{
+		MinLength:           10,
+		MaxLength:           64,
+		MinCharacterClasses: 3,
+		Denylist:            []string{"acme"},
+		HistorySize:         2,
+	}
+	subject := Subject{
+		Email:    "jane.doe@example.com",
+		Username: "jdoe",
+		Name:     "Jane Doe",
+		History:  []string{"hashed:Previous-pass1", "hashed:Older-pass22", "hashed:Oldest-pass333"},
+	}
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{name: "Accepts a password following the policy", password: "Correct-horse-7"},
+		{name: "Counts characters rather than bytes", password: "Ünïcödé-pä55"},
+		{name: "Rejects a short password", password: "Sh0rt-pw", want: []string{CodeTooShort}},
+		{name: "Rejects a long password without checking further", password: strings.Repeat("a", 65), want: []string{CodeTooLong}},
+		{name: "Rejects a password missing character classes", password: "onlylowercaseletters", want: []string{CodeMissingClasses}},
+		{name: "Rejects a password with the email", password: "Jane.Doe-2022", want: []string{CodePersonalInformation, CodePersonalInformation}},
+		{name: "Rejects a password with the username", password: "JDOE-is-great1", want: []string{CodePersonalInformation}},
+		{name: "Rejects a password with a denylisted word", password: "I-love-ACME-99", want: []string{CodeDenylisted}},
+		{name: "Rejects a recent password", password: "Older-pass22", want: []string{CodeReused}},
+		{name: "Accepts a password older than the history", password: "Oldest-pass333"},
+		{name: "Lists every violation", password: "jdoe", want: []string{CodeTooShort, CodeMissingClasses, CodePersonalInformation}},
+	}
+	checker := NewChecker(policy, plainVerifier{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := violationCodes(t, checker.Check(context.Background(), tt.password, subject))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecker_BreachedPasswords(t *testing.T) {
+	// SHA-1 of "password" and of "P@ssw0rd!".
+	ranges, err := NewMemoryRanges(strings.NewReader(
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n" +
+			"076d3e6c4b9f654b5b220b9045b7458ab6b4cbc6:2\n",
+	))
+	if err != nil {
+		t.Fatalf("NewMemoryRanges() error = %v", err)
+	}
+	tests := []struct {
+		name           string
+		password       string
+		minBreachCount int
+		want           []string
+	}{
+		{name: "Rejects a breached password", password: "password", want: []string{CodeBreached}},
+		{name: "Matches hashes in any case", password: "P@ssw0rd!", want: []string{CodeBreached}},
+		{name: "Accepts a password breached less than the minimum", password: "P@ssw0rd!", minBreachCount: 3},
+		{name: "Accepts a password never breached", password: "Correct-horse-7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(Policy{MinBreachCount: tt.minBreachCount}, nil, WithBreachedPasswords(ranges))
+			got := violationCodes(t, checker.Check(context.Background(), tt.password, Subject{}))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalog_Message(t *testing.T) {
+	french := Catalog{CodeTooShort: "Le mot de passe doit avoir au moins {min} caractères."}
+	violation := Violation{Code: CodeTooShort, Params: map[string]interface{}{"min": 10}}
+	if got := french.Message(violation); got != "Le mot de passe doit avoir au moins 10 caractères." {
+		t.Errorf("Message() = %q", got)
+	}
+	if got := french.Message(Violation{Code: CodeBreached}); got != CodeBreached {
+		t.Errorf("Message() = %q, want the code when the catalog has no message", got)
+	}
+	if got := EnglishCatalog.Message(violation); got != "Password must have at least 10 characters." {
+		t.Errorf("Message() = %q", got)
+	}
+}
@@ -0,0 +1,176 @@
+package passwordreset
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/passwordpolicy"
+	"github.com/pkg/errors"
+)
+
+// Error codes of the password reset endpoints.
+const (
+	ErrorCodeInvalidRequest = "invalid_request"
+	ErrorCodeInvalidToken   = "invalid_token"
+	ErrorCodeWeakPassword   = "weak_password"
+	ErrorCodeServerError    = "server_error"
+)
+
+// Error is an error response of the password reset endpoints.
+type Error = httperror.Error
+
+// Violation is a rule of the password policy a password breaks, with its English message.
+type Violation struct {
+	passwordpolicy.Violation
+	Message string `json:"message"`
+}
+
+// PolicyError is the weak_password response to a new password violating the password policy.
+type PolicyError struct {
+	*Error
+	// Violations lists the rules of the password policy the password breaks.
+	Violations []Violation `json:"violations"`
+}
+
+// newPolicyError returns the response to a password violating the policy.
+func newPolicyError(policyErr *passwordpolicy.Error) *PolicyError {
+	response := &PolicyError{
+		Error: httperror.New(http.StatusBadRequest, ErrorCodeWeakPassword, "password violates the password policy"),
+	}
+	for _, violation := range policyErr.Violations {
+		response.Violations = append(response.Violations, Violation{
+			Violation: violation,
+			Message:   passwordpolicy.EnglishCatalog.Message(violation),
+		})
+	}
+	return response
+}
+
+// serviceErrors maps the errors of the Service to their responses.
+var serviceErrors = []httperror.Sentinel{
+	{Err: ErrInvalidToken, Response: httperror.New(http.StatusBadRequest, ErrorCodeInvalidToken, "token is invalid, expired or used")},
+}
+
+func writeError(w http.ResponseWriter, err error, log *logger.Logger) {
+	var policyErr *passwordpolicy.Error
+	if errors.As(err, &policyErr) {
+		response := newPolicyError(policyErr)
+		httperror.WriteJSON(w, response.Status, response)
+		return
+	}
+	httperror.Write(w, err, log, serviceErrors...)
+}
+
+// RequestRequest is the JSON body of the request endpoint.
+type RequestRequest struct {
+	Login string `json:"login"`
+}
+
+// RequestResponse is the response of the request endpoint, the same whether a link was sent
+// or not.
+type RequestResponse struct {
+	Message string `json:"message"`
+}
+
+// requestMessage is the message of every response of the request endpoint.
+const requestMessage = "if an account matches, a link to reset its password was sent to its email address"
+
+const (
+	// maxPendingRequests bounds the reset links being issued in the background.
+	maxPendingRequests = 64
+	// requestTimeout bounds issuing and sending a reset link in the background.
+	requestTimeout = 30 * time.Second
+)
+
+type requestHandler struct {
+	resets Service
+	logger *logger.Logger
+
+	// slots holds a value per reset link being issued in the background, and pending tracks
+	// them, so tests can wait for them.
+	slots   chan struct{}
+	pending sync.WaitGroup
+}
+
+// NewRequestHandler instantiates the request endpoint, which sends a reset link for the login
+// of a RequestRequest. The link is issued and sent in the background, so the endpoint responds
+// 202 with the same RequestResponse, in the same time, whether the account exists or not and
+// whether sending failed or not, and accounts cannot be enumerated. Requests beyond
+// maxPendingRequests in progress are dropped.
+func NewRequestHandler(resets Service, logger *logger.Logger) http.Handler {
+	return &requestHandler{resets: resets, logger: logger, slots: make(chan struct{}, maxPendingRequests)}
+}
+
+func (h *requestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	var request RequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Login == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "login is required"), h.logger)
+		return
+	}
+	select {
+	case h.slots <- struct{}{}:
+		h.pending.Add(1)
+		go h.request(request.Login)
+	default:
+		h.logger.Warn("dropped a password reset request, too many are in progress")
+	}
+	httperror.WriteJSON(w, http.StatusAccepted, RequestResponse{Message: requestMessage})
+}
+
+// request issues and sends a reset link for login, with a context of its own since the
+// response is sent already.
+func (h *requestHandler) request(login string) {
+	defer func() {
+		<-h.slots
+		h.pending.Done()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := h.resets.Request(ctx, login); err != nil {
+		h.logger.WithField("err", err).Error("failed to send a password reset link")
+	}
+}
+
+// ConfirmationRequest is the JSON body of the confirmation endpoint.
+type ConfirmationRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type confirmationHandler struct {
+	resets Service
+	logger *logger.Logger
+}
+
+// NewConfirmationHandler instantiates the confirmation endpoint, which sets the password of a
+// ConfirmationRequest for the user of its token, and responds 204.
+func NewConfirmationHandler(resets Service, logger *logger.Logger) http.Handler {
+	return &confirmationHandler{resets: resets, logger: logger}
+}
+
+func (h *confirmationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	var request ConfirmationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" || request.Password == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "token and password are required"), h.logger)
+		return
+	}
+	if err := h.resets.Confirm(r.Context(), request.Token, request.Password); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
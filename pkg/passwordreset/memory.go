@@ -0,0 +1,48 @@
+package passwordreset
+
+import (
+	"context"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/linktoken"
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	tokens *linktoken.Memory
+}
+
+// NewMemoryStore instantiates a Store which keeps tokens in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{tokens: linktoken.NewMemory()}
+}
+
+func (s *memoryStore) Create(_ context.Context, token *Token) error {
+	stored := *token
+	s.tokens.Create(&stored)
+	return nil
+}
+
+func (s *memoryStore) FindByHash(_ context.Context, tokenHash string) (*Token, error) {
+	var found Token
+	err := s.tokens.FindByHash(tokenHash, func(record linktoken.Record) {
+		found = *record.(*Token)
+	})
+	if errors.Is(err, linktoken.ErrNotFound) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	return &found, nil
+}
+
+func (s *memoryStore) Use(_ context.Context, id string, usedAt time.Time) (bool, error) {
+	used, err := s.tokens.Use(id, usedAt)
+	if errors.Is(err, linktoken.ErrNotFound) {
+		return false, errors.WithStack(ErrNotFound)
+	}
+	return used, nil
+}
+
+func (s *memoryStore) UseByUserID(_ context.Context, userID string, usedAt time.Time) error {
+	s.tokens.UseByUserID(userID, usedAt, nil)
+	return nil
+}
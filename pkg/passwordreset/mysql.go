@@ -0,0 +1,43 @@
+package passwordreset
+
+import (
+	"context"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/linktoken"
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	"github.com/pkg/errors"
+)
+
+type mysqlStore struct {
+	tokens *linktoken.Table
+}
+
+// NewMySQLStore instantiates a Store backed by the password_reset_tokens table of MySQL.
+func NewMySQLStore(db mysql.MySQL) Store {
+	return &mysqlStore{tokens: linktoken.NewTable(db, "password_reset_tokens")}
+}
+
+func (s *mysqlStore) Create(ctx context.Context, token *Token) error {
+	return s.tokens.Create(ctx, token)
+}
+
+func (s *mysqlStore) FindByHash(ctx context.Context, tokenHash string) (*Token, error) {
+	var token Token
+	err := s.tokens.FindByHash(ctx, &token, tokenHash)
+	if errors.Is(err, linktoken.ErrNotFound) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *mysqlStore) Use(ctx context.Context, id string, usedAt time.Time) (bool, error) {
+	return s.tokens.Use(ctx, id, usedAt)
+}
+
+func (s *mysqlStore) UseByUserID(ctx context.Context, userID string, usedAt time.Time) error {
+	return s.tokens.UseByUserID(ctx, userID, usedAt, nil)
+}
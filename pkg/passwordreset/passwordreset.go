@@ -0,0 +1,179 @@
+// Package passwordreset lets users who forgot their password set a new one, with a single-use
+// link sent to their email address.
+package passwordreset
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/linktoken"
+	"github.com/code-and-chill/auth-api/pkg/mailer"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound indicates a token is not found.
+	ErrNotFound = errors.New("password reset token is not found")
+	// ErrInvalidToken indicates a token which is unknown, expired or used.
+	ErrInvalidToken = errors.New("password reset token is invalid")
+)
+
+// Token is a password reset token, sent in a reset link.
+type Token = linktoken.Token
+
+// Store persists tokens.
+type Store interface {
+	// Create stores a new token.
+	Create(ctx context.Context, token *Token) error
+
+	// FindByHash finds a token by the hash of its value, or returns ErrNotFound.
+	FindByHash(ctx context.Context, tokenHash string) (*Token, error)
+
+	// Use records the reset link of token id was followed at usedAt. It returns false when the
+	// link was followed already, so a link replaces the password once even when submitted twice.
+	Use(ctx context.Context, id string, usedAt time.Time) (bool, error)
+
+	// UseByUserID records the pending reset links of a user as followed at usedAt, so only the
+	// last one sent works and none works once the password is reset.
+	UseByUserID(ctx context.Context, userID string, usedAt time.Time) error
+}
+
+// Config provides configs for the Service.
+type Config struct {
+	// TokenLifetime is how long a reset link may be used.
+	TokenLifetime time.Duration
+	// ResetURL is the page of the front end where users choose their new password. Reset links
+	// add the token to its query as the token parameter.
+	ResetURL string
+}
+
+// Service resets passwords.
+type Service interface {
+	// Request sends a reset link to the user identified by login, an email or a username. It
+	// returns no error and sends nothing when no active user has this login, so callers cannot
+	// tell which logins exist.
+	Request(ctx context.Context, login string) error
+
+	// Confirm replaces the password of the user of token, ends all their logins by revoking
+	// their refresh tokens and the access tokens issued before, and notifies them. It returns
+	// ErrInvalidToken when the token is unknown, expired or used, and a *passwordpolicy.Error
+	// when password violates the password policy; the token can still be used then.
+	Confirm(ctx context.Context, token, password string) error
+}
+
+type service struct {
+	store         Store
+	users         user.Service
+	refreshTokens refreshtoken.Service
+	accessTokens  jwt.SubjectRevocationStore
+	mailer        mailer.Mailer
+	timegen       timegenerator.TimeGenerator
+	config        Config
+}
+
+// NewService instantiates a new Service. Confirm revokes the refresh tokens of the user in
+// refreshTokens and their access tokens in accessTokens, which the access token verifiers must
+// check, e.g. with jwt.WithSubjectRevocationStore.
+func NewService(store Store, users user.Service, refreshTokens refreshtoken.Service,
+	accessTokens jwt.SubjectRevocationStore, mailer mailer.Mailer, timegen timegenerator.TimeGenerator, config Config) Service {
+	return &service{
+		store:         store,
+		users:         users,
+		refreshTokens: refreshTokens,
+		accessTokens:  accessTokens,
+		mailer:        mailer,
+		timegen:       timegen,
+		config:        config,
+	}
+}
+
+func (s *service) Request(ctx context.Context, login string) error {
+	u, err := s.users.FindByLogin(ctx, login)
+	if errors.Is(err, user.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if u.Status != user.StatusActive {
+		return nil
+	}
+
+	now := s.timegen.Now().UTC()
+	value, token, err := linktoken.New(u.ID, now, s.config.TokenLifetime)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// Only the last link works, so links of older emails found later are useless.
+	if err := s.store.UseByUserID(ctx, u.ID, now); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.store.Create(ctx, &token); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(s.mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. To choose a new password, open\n\n"+
+			"%s\n\nwithin %s. If it was not you, ignore this email: your password is unchanged.\n",
+			linktoken.Link(s.config.ResetURL, value), s.config.TokenLifetime),
+	}))
+}
+
+func (s *service) Confirm(ctx context.Context, value, password string) error {
+	token, err := s.store.FindByHash(ctx, securetoken.Hash(value))
+	if errors.Is(err, ErrNotFound) {
+		return errors.WithStack(ErrInvalidToken)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	now := s.timegen.Now().UTC()
+	if !token.Usable(now) {
+		return errors.WithStack(ErrInvalidToken)
+	}
+	u, err := s.users.FindByID(ctx, token.UserID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if u.Status != user.StatusActive {
+		return errors.WithStack(ErrInvalidToken)
+	}
+	// The password is checked before the token is used, so a rejected password does not burn
+	// the link, and the token is used before the password is replaced, so it replaces it once.
+	if err := s.users.CheckPassword(ctx, u.ID, password); err != nil {
+		return err
+	}
+	used, err := s.store.Use(ctx, token.ID, now)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !used {
+		return errors.WithStack(ErrInvalidToken)
+	}
+	if err := s.users.ResetPassword(ctx, u.ID, password); err != nil {
+		return err
+	}
+
+	if err := s.store.UseByUserID(ctx, u.ID, now); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.refreshTokens.RevokeSubject(ctx, u.ID); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.accessTokens.RevokeSubject(ctx, u.ID, now); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(s.mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: "Your password was reset",
+		Body: "The password of your account was reset, and you were logged out of all your devices.\n" +
+			"If it was not you, contact support right away.\n",
+	}))
+}
@@ -0,0 +1,226 @@
+package passwordreset
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/linktoken/linktokentest"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/mailer"
+	"github.com/code-and-chill/auth-api/pkg/password"
+	"github.com/code-and-chill/auth-api/pkg/passwordpolicy"
+	"github.com/code-and-chill/auth-api/pkg/refreshtoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+	"github.com/code-and-chill/auth-api/pkg/user"
+)
+
+const testPassword = "correct horse battery staple"
+
+type fixture struct {
+	resets        Service
+	users         user.Service
+	accessTokens  jwt.JWT
+	refreshTokens refreshtoken.Service
+	mailer        *mailer.MemoryMailer
+	timegen       *timegenerator.FakeTimeGenerator
+	jane          *user.User
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	hasher := password.NewHasher(password.WithArgon2Params(password.Argon2Params{
+		Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+	}))
+	users := user.NewService(user.NewMemoryStore(), transaction.NewNoopProvider(), hasher, timegen,
+		user.WithPasswordPolicy(passwordpolicy.NewChecker(passwordpolicy.Policy{MinLength: 12, HistorySize: 2}, hasher)))
+	revokedSubjects := jwt.NewMemorySubjectRevocationStore()
+	accessTokens, err := jwttest.NewRS256(timegen, "https://auth.example.com", "api", 5*time.Minute,
+		jwt.WithSubjectRevocationStore(revokedSubjects))
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	refreshTokens := refreshtoken.NewService(refreshtoken.NewMemoryStore(), transaction.NewNoopProvider(), accessTokens,
+		timegen, refreshtoken.Config{SlidingLifetime: time.Hour, AbsoluteLifetime: 24 * time.Hour})
+	jane, err := users.Register(context.Background(), user.Registration{Email: "jane@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := users.SetStatus(context.Background(), jane.ID, user.StatusActive); err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	mail := mailer.NewMemoryMailer()
+	resets := NewService(NewMemoryStore(), users, refreshTokens, revokedSubjects, mail, timegen, Config{
+		TokenLifetime: 30 * time.Minute,
+		ResetURL:      "https://example.com/reset-password",
+	})
+	return &fixture{resets: resets, users: users, accessTokens: accessTokens, refreshTokens: refreshTokens, mailer: mail,
+		timegen: timegen, jane: jane}
+}
+
+// request requests a reset for jane and returns the token of the link sent.
+func (f *fixture) request(t *testing.T) string {
+	t.Helper()
+	if err := f.resets.Request(context.Background(), "Jane@Example.com"); err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+	return linktokentest.LastToken(t, f.mailer, f.jane.Email, "https://example.com/reset-password")
+}
+
+func TestService_Request(t *testing.T) {
+	t.Run("Sends nothing to unknown and inactive users", func(t *testing.T) {
+		f := newFixture(t)
+		if _, err := f.users.SetStatus(context.Background(), f.jane.ID, user.StatusDisabled); err != nil {
+			t.Fatalf("SetStatus() error = %v", err)
+		}
+		for _, login := range []string{"john@example.com", "jane@example.com"} {
+			if err := f.resets.Request(context.Background(), login); err != nil {
+				t.Errorf("Request(%s) error = %v, want none", login, err)
+			}
+		}
+		if messages := f.mailer.Messages(); len(messages) != 0 {
+			t.Errorf("messages = %+v, want none", messages)
+		}
+	})
+
+	t.Run("Keeps only the last link working", func(t *testing.T) {
+		f := newFixture(t)
+		first := f.request(t)
+		second := f.request(t)
+		if err := f.resets.Confirm(context.Background(), first, "a brand new password"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Confirm() error = %v, want the first link rejected", err)
+		}
+		if err := f.resets.Confirm(context.Background(), second, "a brand new password"); err != nil {
+			t.Errorf("Confirm() error = %v, want the last link accepted", err)
+		}
+	})
+}
+
+func TestService_Confirm(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Resets the password and ends every login", func(t *testing.T) {
+		f := newFixture(t)
+		refreshToken, err := f.refreshTokens.Issue(ctx, refreshtoken.Grant{Subject: f.jane.ID, ClientID: "web", AuthTime: f.timegen.Now()})
+		if err != nil {
+			t.Fatalf("Issue() error = %v", err)
+		}
+		accessToken, _, err := f.accessTokens.SignClaims(ctx, &jwt.Claims{Subject: f.jane.ID})
+		if err != nil {
+			t.Fatalf("SignClaims() error = %v", err)
+		}
+		token := f.request(t)
+		f.timegen.Add(time.Second)
+		if err := f.resets.Confirm(ctx, token, "a brand new password"); err != nil {
+			t.Fatalf("Confirm() error = %v", err)
+		}
+		if ok, _ := f.users.VerifyPassword(ctx, f.jane.ID, "a brand new password"); !ok {
+			t.Error("VerifyPassword() = false, want the new password verified")
+		}
+		if _, err := f.refreshTokens.Lookup(ctx, refreshToken.Value); !errors.Is(err, refreshtoken.ErrRevoked) {
+			t.Errorf("Lookup() error = %v, want the refresh token revoked", err)
+		}
+		if _, err := f.accessTokens.ParseClaims(ctx, accessToken, false); !errors.Is(err, jwt.ErrRevoked) {
+			t.Errorf("ParseClaims() error = %v, want the access token revoked", err)
+		}
+		if accessToken, _, err = f.accessTokens.SignClaims(ctx, &jwt.Claims{Subject: f.jane.ID}); err != nil {
+			t.Fatalf("SignClaims() error = %v", err)
+		}
+		if _, err := f.accessTokens.ParseClaims(ctx, accessToken, false); err != nil {
+			t.Errorf("ParseClaims() error = %v, want a new access token accepted", err)
+		}
+		messages := f.mailer.Messages()
+		if last := messages[len(messages)-1]; last.To != f.jane.Email || !strings.Contains(last.Subject, "was reset") {
+			t.Errorf("message = %+v, want jane notified", last)
+		}
+		if err := f.resets.Confirm(ctx, token, "another new password"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Confirm() error = %v, want a used token rejected", err)
+		}
+	})
+
+	t.Run("Rejects an expired token", func(t *testing.T) {
+		f := newFixture(t)
+		token := f.request(t)
+		f.timegen.Add(30 * time.Minute)
+		if err := f.resets.Confirm(ctx, token, "a brand new password"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Confirm() error = %v, want ErrInvalidToken", err)
+		}
+	})
+
+	t.Run("Keeps the token of a password violating the policy", func(t *testing.T) {
+		f := newFixture(t)
+		token := f.request(t)
+		var policyErr *passwordpolicy.Error
+		if err := f.resets.Confirm(ctx, token, testPassword); !errors.As(err, &policyErr) {
+			t.Fatalf("Confirm() error = %v, want the current password rejected", err)
+		}
+		if err := f.resets.Confirm(ctx, token, "a brand new password"); err != nil {
+			t.Errorf("Confirm() error = %v, want the token still accepted", err)
+		}
+	})
+}
+
+func TestRequestHandler(t *testing.T) {
+	f := newFixture(t)
+	handler := NewRequestHandler(f.resets, logger.NewNoopLogger())
+	post := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(body)))
+		return recorder
+	}
+	known := post(`{"login":"jane@example.com"}`)
+	unknown := post(`{"login":"john@example.com"}`)
+	handler.(*requestHandler).pending.Wait()
+	if known.Code != http.StatusAccepted || unknown.Code != known.Code || unknown.Body.String() != known.Body.String() {
+		t.Errorf("responses = %d %s and %d %s, want the same 202", known.Code, known.Body, unknown.Code, unknown.Body)
+	}
+	if messages := f.mailer.Messages(); len(messages) != 1 {
+		t.Errorf("messages = %+v, want a link sent to jane only", messages)
+	}
+}
+
+// blockingService is a Service whose requests wait until release is closed, like a slow mail
+// server.
+type blockingService struct {
+	Service
+	release chan struct{}
+}
+
+func (s *blockingService) Request(ctx context.Context, _ string) error {
+	select {
+	case <-s.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestRequestHandler_Background(t *testing.T) {
+	resets := &blockingService{release: make(chan struct{})}
+	handler := NewRequestHandler(resets, logger.NewNoopLogger())
+	responded := make(chan int)
+	go func() {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/password/reset",
+			strings.NewReader(`{"login":"jane@example.com"}`)))
+		responded <- recorder.Code
+	}()
+	select {
+	case code := <-responded:
+		if code != http.StatusAccepted {
+			t.Errorf("status = %d, want 202", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the response waits for the reset link to be sent")
+	}
+	close(resets.release)
+	handler.(*requestHandler).pending.Wait()
+}
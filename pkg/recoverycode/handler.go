@@ -0,0 +1,76 @@
+package recoverycode
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/oauth"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+)
+
+// Error codes of the recovery code endpoints.
+const (
+	ErrorCodeInvalidRequest = "invalid_request"
+	ErrorCodeServerError    = "server_error"
+)
+
+// Error is an error response of the recovery code endpoints.
+type Error = httperror.Error
+
+func writeError(w http.ResponseWriter, err error, log *logger.Logger) {
+	httperror.Write(w, err, log)
+}
+
+// Response is the response of endpoints generating codes.
+type Response struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// StepUpMaxAge is how recently users must have logged in with a second factor to regenerate
+// their codes.
+const StepUpMaxAge = 10 * time.Minute
+
+// stepUpMethods are the authentication method references of RFC 8176 of a second factor.
+var stepUpMethods = []string{"otp", "hwk"}
+
+type regenerationHandler struct {
+	codes        Service
+	accessTokens oauth.AccessTokenVerifier
+	timegen      timegenerator.TimeGenerator
+	logger       *logger.Logger
+}
+
+// NewRegenerationHandler instantiates the regeneration endpoint, which replaces the codes of
+// the user authenticated by the access token of the request, so the previous ones are no longer
+// accepted, and responds with a Response. The access token must be issued for a login with a
+// second factor less than StepUpMaxAge ago, so a stolen access token cannot mint codes
+// bypassing the second factor; the endpoint responds with the insufficient_user_authentication
+// challenge of RFC 9470 otherwise.
+func NewRegenerationHandler(codes Service, accessTokens oauth.AccessTokenVerifier, timegen timegenerator.TimeGenerator,
+	logger *logger.Logger) http.Handler {
+	return &regenerationHandler{codes: codes, accessTokens: accessTokens, timegen: timegen, logger: logger}
+}
+
+func (h *regenerationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	claims, err := h.accessTokens.Verify(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if err := oauth.RequireStepUp(r, claims, h.timegen.Now(), StepUpMaxAge, stepUpMethods...); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	codes, err := h.codes.Generate(r.Context(), claims.Subject)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, Response{RecoveryCodes: codes})
+}
@@ -0,0 +1,76 @@
+package recoverycode
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	mu    sync.Mutex
+	codes map[string]*Code
+}
+
+// NewMemoryStore instantiates a Store which keeps codes in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{codes: map[string]*Code{}}
+}
+
+func (s *memoryStore) Create(_ context.Context, code *Code) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *code
+	s.codes[code.ID] = &stored
+	return nil
+}
+
+func (s *memoryStore) FindUnused(_ context.Context, userID, hash string) (*Code, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, code := range s.codes {
+		if code.UserID == userID && code.Hash == hash && code.UsedAt == nil {
+			found := *code
+			return &found, nil
+		}
+	}
+	return nil, errors.WithStack(ErrNotFound)
+}
+
+func (s *memoryStore) Use(_ context.Context, id string, usedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[id]
+	if !ok {
+		return false, errors.WithStack(ErrNotFound)
+	}
+	if code.UsedAt != nil {
+		return false, nil
+	}
+	code.UsedAt = &usedAt
+	return true, nil
+}
+
+func (s *memoryStore) CountUnused(_ context.Context, userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, code := range s.codes {
+		if code.UserID == userID && code.UsedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) DeleteByUserID(_ context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, code := range s.codes {
+		if code.UserID == userID {
+			delete(s.codes, id)
+		}
+	}
+	return nil
+}
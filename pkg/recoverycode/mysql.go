@@ -0,0 +1,71 @@
+package recoverycode
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	insertCodeQuery = `INSERT INTO recovery_codes (id, user_id, hash, used_at, created_at)
+		VALUES (:id, :user_id, :hash, :used_at, :created_at)`
+	findUnusedCodeQuery = `SELECT * FROM recovery_codes
+		WHERE user_id = :user_id AND hash = :hash AND used_at IS NULL FOR UPDATE`
+	useCodeQuery         = `UPDATE recovery_codes SET used_at = :used_at WHERE id = :id AND used_at IS NULL`
+	countUnusedCodeQuery = `SELECT COUNT(*) FROM recovery_codes WHERE user_id = :user_id AND used_at IS NULL`
+	deleteCodesQuery     = `DELETE FROM recovery_codes WHERE user_id = :user_id`
+)
+
+type mysqlStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLStore instantiates a Store backed by MySQL.
+func NewMySQLStore(db mysql.MySQL) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) Create(ctx context.Context, code *Code) error {
+	_, err := s.db.ExecNamed(ctx, insertCodeQuery, code)
+	return errors.WithStack(err)
+}
+
+func (s *mysqlStore) FindUnused(ctx context.Context, userID, hash string) (*Code, error) {
+	var code Code
+	err := s.db.GetNamedForWrite(ctx, &code, findUnusedCodeQuery, map[string]interface{}{"user_id": userID, "hash": hash})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &code, nil
+}
+
+func (s *mysqlStore) Use(ctx context.Context, id string, usedAt time.Time) (bool, error) {
+	result, err := s.db.ExecNamed(ctx, useCodeQuery, map[string]interface{}{"id": id, "used_at": usedAt})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return affected == 1, nil
+}
+
+func (s *mysqlStore) CountUnused(ctx context.Context, userID string) (int, error) {
+	var count int
+	// Counted on the master, inside the transaction of ctx if any, so a code just used is not
+	// counted from a lagging replica.
+	err := s.db.GetNamedForWrite(ctx, &count, countUnusedCodeQuery, map[string]interface{}{"user_id": userID})
+	return count, errors.WithStack(err)
+}
+
+func (s *mysqlStore) DeleteByUserID(ctx context.Context, userID string) error {
+	_, err := s.db.ExecNamed(ctx, deleteCodesQuery, map[string]interface{}{"user_id": userID})
+	return errors.WithStack(err)
+}
@@ -0,0 +1,184 @@
+// Package recoverycode implements one-time recovery codes, which let users who lost their
+// second factor complete a login. Codes are stored hashed, each accepted once.
+package recoverycode
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound indicates an unused code is not found.
+	ErrNotFound = errors.New("recovery code is not found")
+	// ErrInvalidCode indicates a code is wrong or already used.
+	ErrInvalidCode = errors.New("recovery code is invalid")
+)
+
+// alphabet is the alphabet of codes, without characters users confuse such as 0 and O, or 1
+// and I. Its 32 characters give codes of 10 characters 50 bits of entropy.
+const alphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// codeLength is the number of characters of a code, shown in two groups of 5.
+const codeLength = 10
+
+// Code is a recovery code of a user.
+type Code struct {
+	ID     string `db:"id"`
+	UserID string `db:"user_id"`
+	// Hash is the hash of the normalized code.
+	Hash      string     `db:"hash"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// Store persists codes.
+type Store interface {
+	// Create stores a new code.
+	Create(ctx context.Context, code *Code) error
+
+	// FindUnused finds the unused code of a user with hash, locking it until the transaction
+	// of ctx ends.
+	FindUnused(ctx context.Context, userID, hash string) (*Code, error)
+
+	// Use crosses out code id at usedAt. It returns false when the code is crossed out already,
+	// so a code written down by the user completes a single login even when submitted twice.
+	Use(ctx context.Context, id string, usedAt time.Time) (bool, error)
+
+	// CountUnused counts the unused codes of a user.
+	CountUnused(ctx context.Context, userID string) (int, error)
+
+	// DeleteByUserID removes the codes of a user.
+	DeleteByUserID(ctx context.Context, userID string) error
+}
+
+// Config provides configs for the Service.
+type Config struct {
+	// Count is the number of codes generated at once.
+	Count int
+}
+
+// DefaultConfig generates 10 codes at once.
+var DefaultConfig = Config{Count: 10}
+
+// Service generates and redeems codes.
+type Service interface {
+	// Generate replaces the codes of a user by new ones, which are returned formatted for
+	// display. They cannot be retrieved again.
+	Generate(ctx context.Context, userID string) ([]string, error)
+
+	// Redeem uses code of a user, ignoring case, spaces and dashes, and returns the number of
+	// unused codes left.
+	Redeem(ctx context.Context, userID, code string) (int, error)
+
+	// Remaining returns the number of unused codes of a user.
+	Remaining(ctx context.Context, userID string) (int, error)
+}
+
+type service struct {
+	store      Store
+	txProvider transaction.Provider
+	timegen    timegenerator.TimeGenerator
+	config     Config
+}
+
+// NewService instantiates a new Service.
+func NewService(store Store, txProvider transaction.Provider, timegen timegenerator.TimeGenerator, config Config) Service {
+	return &service{store: store, txProvider: txProvider, timegen: timegen, config: config}
+}
+
+func (s *service) Generate(ctx context.Context, userID string) ([]string, error) {
+	now := s.timegen.Now().UTC()
+	formatted := make([]string, 0, s.config.Count)
+	works := []transaction.UnitOfWork{{
+		Execute: func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return nil, s.store.DeleteByUserID(ctx, userID)
+		},
+	}}
+	for i := 0; i < s.config.Count; i++ {
+		code, err := generate()
+		if err != nil {
+			return nil, err
+		}
+		id, err := securetoken.NewID()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		formatted = append(formatted, code[:codeLength/2]+"-"+code[codeLength/2:])
+		works = append(works, transaction.UnitOfWork{
+			Execute: func(ctx context.Context, data interface{}) (interface{}, error) {
+				return nil, s.store.Create(ctx, data.(*Code))
+			},
+			Data: &Code{ID: id, UserID: userID, Hash: securetoken.Hash(code), CreatedAt: now},
+		})
+	}
+	if _, err := s.txProvider.WithTransaction(ctx, works...); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return formatted, nil
+}
+
+func (s *service) Redeem(ctx context.Context, userID, code string) (int, error) {
+	hash := securetoken.Hash(normalize(code))
+	now := s.timegen.Now().UTC()
+	result, err := s.txProvider.WithTransaction(ctx, transaction.UnitOfWork{
+		Execute: func(ctx context.Context, _ interface{}) (interface{}, error) {
+			found, err := s.store.FindUnused(ctx, userID, hash)
+			if errors.Is(err, ErrNotFound) {
+				return nil, errors.WithStack(ErrInvalidCode)
+			}
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			used, err := s.store.Use(ctx, found.ID, now)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if !used {
+				return nil, errors.WithStack(ErrInvalidCode)
+			}
+			return s.store.CountUnused(ctx, userID)
+		},
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return result.([]interface{})[0].(int), nil
+}
+
+func (s *service) Remaining(ctx context.Context, userID string) (int, error) {
+	count, err := s.store.CountUnused(ctx, userID)
+	return count, errors.WithStack(err)
+}
+
+// generate returns a random code of codeLength characters of alphabet.
+func generate() (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(alphabet)))
+	for i := 0; i < codeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		code.WriteByte(alphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// normalize returns code as generated, so it may be typed in lower case and with the dash or
+// spaces.
+func normalize(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
@@ -0,0 +1,139 @@
+package recoverycode
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/oauth"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+)
+
+func newTestService() Service {
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	return NewService(NewMemoryStore(), transaction.NewNoopProvider(), timegen, DefaultConfig)
+}
+
+func TestService_Generate(t *testing.T) {
+	codes := newTestService()
+	generated, err := codes.Generate(context.Background(), "jane")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if len(generated) != DefaultConfig.Count {
+		t.Fatalf("Generate() = %d codes, want %d", len(generated), DefaultConfig.Count)
+	}
+	seen := map[string]bool{}
+	for _, code := range generated {
+		if len(code) != codeLength+1 || code[codeLength/2] != '-' || seen[code] {
+			t.Errorf("code = %q, want a distinct code of two groups of 5", code)
+		}
+		if strings.Trim(strings.Replace(code, "-", "", 1), alphabet) != "" {
+			t.Errorf("code = %q, want characters of the alphabet", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestService_Redeem(t *testing.T) {
+	t.Run("Accepts each code once", func(t *testing.T) {
+		codes := newTestService()
+		generated, err := codes.Generate(context.Background(), "jane")
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		typed := " " + strings.ToLower(strings.Replace(generated[0], "-", " ", 1)) + " "
+		if remaining, err := codes.Redeem(context.Background(), "jane", typed); err != nil || remaining != DefaultConfig.Count-1 {
+			t.Fatalf("Redeem() = %d, %v, want %d codes left", remaining, err, DefaultConfig.Count-1)
+		}
+		if _, err := codes.Redeem(context.Background(), "jane", generated[0]); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Redeem() error = %v, want a used code rejected", err)
+		}
+	})
+
+	t.Run("Rejects the codes of another user", func(t *testing.T) {
+		codes := newTestService()
+		generated, err := codes.Generate(context.Background(), "jane")
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		if _, err := codes.Redeem(context.Background(), "john", generated[0]); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Redeem() error = %v, want ErrInvalidCode", err)
+		}
+	})
+
+	t.Run("Rejects codes replaced by new ones", func(t *testing.T) {
+		codes := newTestService()
+		previous, err := codes.Generate(context.Background(), "jane")
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		if _, err := codes.Redeem(context.Background(), "jane", previous[0]); err != nil {
+			t.Fatalf("Redeem() error = %v", err)
+		}
+		if _, err := codes.Generate(context.Background(), "jane"); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		if _, err := codes.Redeem(context.Background(), "jane", previous[1]); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Redeem() error = %v, want a replaced code rejected", err)
+		}
+		if remaining, err := codes.Remaining(context.Background(), "jane"); err != nil || remaining != DefaultConfig.Count {
+			t.Errorf("Remaining() = %d, %v, want %d new codes", remaining, err, DefaultConfig.Count)
+		}
+	})
+}
+
+func TestRegenerationHandler(t *testing.T) {
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	accessTokens, err := jwttest.NewRS256(timegen, "https://auth.example.com", "api", time.Hour)
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	codes := NewService(NewMemoryStore(), transaction.NewNoopProvider(), timegen, DefaultConfig)
+	handler := NewRegenerationHandler(codes, oauth.NewAccessTokenVerifier(accessTokens, nil), timegen,
+		logger.NewNoopLogger())
+
+	tests := []struct {
+		name       string
+		amr        []string
+		authAge    time.Duration
+		wantStatus int
+	}{
+		{name: "Regenerates the codes after a recent second factor", amr: []string{"pwd", "otp"}, wantStatus: http.StatusOK},
+		{name: "Regenerates the codes after a recent passkey login", amr: []string{"hwk"}, wantStatus: http.StatusOK},
+		{name: "Rejects a token without a second factor", amr: []string{"pwd"}, wantStatus: http.StatusUnauthorized},
+		{name: "Rejects an old second factor", amr: []string{"pwd", "otp"}, authAge: StepUpMaxAge + time.Second,
+			wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, _, err := accessTokens.SignClaims(context.Background(), &jwt.Claims{
+				Subject:  "jane",
+				AMR:      tt.amr,
+				AuthTime: timegen.Now().Add(-tt.authAge).Unix(),
+			})
+			if err != nil {
+				t.Fatalf("SignClaims() error = %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/recovery-codes", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, body = %s, want %d", recorder.Code, recorder.Body, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized &&
+				!strings.Contains(recorder.Header().Get("WWW-Authenticate"), oauth.ErrorCodeInsufficientUserAuthentication) {
+				t.Errorf("WWW-Authenticate = %q, want a step-up challenge", recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
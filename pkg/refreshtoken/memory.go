@@ -0,0 +1,73 @@
+package refreshtoken
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]*RefreshToken
+}
+
+// NewMemoryStore instantiates a Store which keeps refresh tokens in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{tokens: map[string]*RefreshToken{}}
+}
+
+func (s *memoryStore) Create(_ context.Context, token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *token
+	s.tokens[token.ID] = &stored
+	return nil
+}
+
+func (s *memoryStore) FindByHash(_ context.Context, tokenHash string) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.tokens {
+		if token.TokenHash == tokenHash {
+			found := *token
+			return &found, nil
+		}
+	}
+	return nil, errors.WithStack(ErrNotFound)
+}
+
+func (s *memoryStore) MarkRotated(_ context.Context, id string, rotatedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok || token.Status != StatusActive {
+		return false, nil
+	}
+	token.Status = StatusRotated
+	token.RotatedAt = &rotatedAt
+	return true, nil
+}
+
+func (s *memoryStore) RevokeFamily(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.tokens {
+		if token.FamilyID == familyID {
+			token.Status = StatusRevoked
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RevokeSubject(_ context.Context, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.tokens {
+		if token.Subject == subject {
+			token.Status = StatusRevoked
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package refreshtoken
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	insertRefreshTokenQuery = `INSERT INTO refresh_tokens (id, family_id, token_hash, subject, client_id, scope, amr,
+		auth_time, jkt, status, created_at, expires_at, family_expires_at)
+		VALUES (:id, :family_id, :token_hash, :subject, :client_id, :scope, :amr,
+		:auth_time, :jkt, :status, :created_at, :expires_at, :family_expires_at)`
+	findRefreshTokenByHashQuery  = `SELECT * FROM refresh_tokens WHERE token_hash = :token_hash`
+	markRefreshTokenRotatedQuery = `UPDATE refresh_tokens SET status = 'rotated', rotated_at = :rotated_at
+		WHERE id = :id AND status = 'active'`
+	revokeRefreshTokenFamilyQuery  = `UPDATE refresh_tokens SET status = 'revoked' WHERE family_id = :family_id`
+	revokeRefreshTokenSubjectQuery = `UPDATE refresh_tokens SET status = 'revoked' WHERE subject = :subject`
+)
+
+type mysqlStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLStore instantiates a Store backed by MySQL.
+func NewMySQLStore(db mysql.MySQL) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) Create(ctx context.Context, token *RefreshToken) error {
+	if _, err := s.db.ExecNamed(ctx, insertRefreshTokenQuery, token); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (s *mysqlStore) FindByHash(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	err := s.db.GetNamedForWrite(ctx, &token, findRefreshTokenByHashQuery, map[string]interface{}{
+		"token_hash": tokenHash,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &token, nil
+}
+
+func (s *mysqlStore) MarkRotated(ctx context.Context, id string, rotatedAt time.Time) (bool, error) {
+	result, err := s.db.ExecNamed(ctx, markRefreshTokenRotatedQuery, map[string]interface{}{
+		"id":         id,
+		"rotated_at": rotatedAt,
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return affected == 1, nil
+}
+
+func (s *mysqlStore) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := s.db.ExecNamed(ctx, revokeRefreshTokenFamilyQuery, map[string]interface{}{
+		"family_id": familyID,
+	})
+	return errors.WithStack(err)
+}
+
+func (s *mysqlStore) RevokeSubject(ctx context.Context, subject string) error {
+	_, err := s.db.ExecNamed(ctx, revokeRefreshTokenSubjectQuery, map[string]interface{}{
+		"subject": subject,
+	})
+	return errors.WithStack(err)
+}
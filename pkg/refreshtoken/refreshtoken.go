@@ -0,0 +1,74 @@
+// Package refreshtoken issues opaque refresh tokens which are rotated on every use. Tokens
+// rotated from the same login share a family, and reusing a rotated token revokes the family.
+package refreshtoken
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound indicates the refresh token does not exist.
+	ErrNotFound = errors.New("refresh token is not found")
+	// ErrExpired indicates the refresh token or its family has expired.
+	ErrExpired = errors.New("refresh token is expired")
+	// ErrRevoked indicates the refresh token family has been revoked.
+	ErrRevoked = errors.New("refresh token is revoked")
+	// ErrReused indicates an already rotated refresh token was presented again.
+	ErrReused = errors.New("refresh token is reused")
+	// ErrClientMismatch indicates the refresh token was issued to another client.
+	ErrClientMismatch = errors.New("refresh token was issued to another client")
+	// ErrInvalidScope indicates the requested scope exceeds the scope originally granted.
+	ErrInvalidScope = errors.New("requested scope exceeds granted scope")
+)
+
+// Status represents the status of a refresh token.
+type Status string
+
+const (
+	// StatusActive represents a refresh token which can be exchanged.
+	StatusActive = Status("active")
+	// StatusRotated represents a refresh token which has been exchanged.
+	StatusRotated = Status("rotated")
+	// StatusRevoked represents a refresh token whose family has been revoked.
+	StatusRevoked = Status("revoked")
+)
+
+// RefreshToken represents a stored refresh token. Only the hash of the token is stored.
+type RefreshToken struct {
+	ID              string     `db:"id"`
+	FamilyID        string     `db:"family_id"`
+	TokenHash       string     `db:"token_hash"`
+	Subject         string     `db:"subject"`
+	ClientID        string     `db:"client_id"`
+	Scope           string     `db:"scope"`
+	AMR             string     `db:"amr"`
+	AuthTime        time.Time  `db:"auth_time"`
+	JKT             string     `db:"jkt"`
+	Status          Status     `db:"status"`
+	CreatedAt       time.Time  `db:"created_at"`
+	ExpiresAt       time.Time  `db:"expires_at"`
+	FamilyExpiresAt time.Time  `db:"family_expires_at"`
+	RotatedAt       *time.Time `db:"rotated_at"`
+}
+
+// Store persists refresh tokens.
+type Store interface {
+	// Create stores a new refresh token.
+	Create(ctx context.Context, token *RefreshToken) error
+
+	// FindByHash finds a refresh token by the hash of its value.
+	FindByHash(ctx context.Context, tokenHash string) (*RefreshToken, error)
+
+	// MarkRotated marks an active refresh token as rotated. It reports false when the token
+	// was not active anymore, e.g. because a concurrent request rotated it first.
+	MarkRotated(ctx context.Context, id string, rotatedAt time.Time) (bool, error)
+
+	// RevokeFamily revokes every refresh token of a family.
+	RevokeFamily(ctx context.Context, familyID string) error
+
+	// RevokeSubject revokes every refresh token issued to a subject.
+	RevokeSubject(ctx context.Context, subject string) error
+}
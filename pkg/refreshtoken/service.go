@@ -0,0 +1,317 @@
+package refreshtoken
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// Config provides configs for refresh token lifetimes.
+type Config struct {
+	// SlidingLifetime is how long a refresh token stays valid after it is issued or rotated.
+	SlidingLifetime time.Duration
+	// AbsoluteLifetime is how long a family stays valid after the first token is issued,
+	// regardless of rotations.
+	AbsoluteLifetime time.Duration
+}
+
+// Grant describes what a new refresh token family is issued for.
+type Grant struct {
+	Subject  string
+	ClientID string
+	Scope    string
+	AMR      []string
+	AuthTime time.Time
+	// JKT is the thumbprint of the DPoP key the family is bound to, if any.
+	JKT string
+}
+
+// Token is an issued refresh token.
+type Token struct {
+	Value     string
+	FamilyID  string
+	ExpiresAt time.Time
+}
+
+// TokenPair is the result of exchanging a refresh token.
+type TokenPair struct {
+	AccessToken          string
+	AccessTokenExpiresAt time.Time
+	RefreshToken         Token
+	Scope                string
+}
+
+// AccessTokenOptions overrides the defaults of the access token signer for the access tokens
+// minted by Exchange, e.g. with the audiences and lifetime registered for the client.
+type AccessTokenOptions struct {
+	// Audience replaces the default audience when not empty.
+	Audience []string
+	// Lifetime replaces the default lifetime when positive.
+	Lifetime time.Duration
+	// Confirmation binds the access token to the key proven by the client making the request.
+	Confirmation *jwt.Confirmation
+}
+
+// Service issues and exchanges refresh tokens.
+type Service interface {
+	// Issue starts a new refresh token family.
+	Issue(ctx context.Context, grant Grant) (*Token, error)
+
+	// Lookup finds the stored state of a refresh token which can still be exchanged.
+	Lookup(ctx context.Context, refreshToken string) (*RefreshToken, error)
+
+	// Revoke revokes the family of a refresh token issued to clientID.
+	Revoke(ctx context.Context, refreshToken, clientID string) error
+
+	// RevokeFamily revokes every refresh token of a family.
+	RevokeFamily(ctx context.Context, familyID string) error
+
+	// RevokeSubject revokes every refresh token family of a subject, ending all their logins,
+	// e.g. after their password was reset.
+	RevokeSubject(ctx context.Context, subject string) error
+
+	// Exchange rotates a refresh token and mints a new access token. When scope is not empty,
+	// the access token is narrowed down to it. options, when set, override the defaults of the
+	// access token.
+	Exchange(ctx context.Context, refreshToken, scope string, options *AccessTokenOptions) (*TokenPair, error)
+}
+
+type service struct {
+	store        Store
+	txProvider   transaction.Provider
+	accessTokens jwt.JWT
+	timegen      timegenerator.TimeGenerator
+	config       Config
+	claims       jwt.ClaimsProvider
+}
+
+// ServiceOption configures optional behaviour of the Service.
+type ServiceOption func(*service)
+
+// WithClaimsProvider adds the claims of provider about the subject to the access tokens minted
+// by Exchange, as they are at the time of the exchange.
+func WithClaimsProvider(provider jwt.ClaimsProvider) ServiceOption {
+	return func(s *service) {
+		s.claims = provider
+	}
+}
+
+// NewService instantiates a new refresh token Service.
+func NewService(store Store, txProvider transaction.Provider, accessTokens jwt.JWT,
+	timegen timegenerator.TimeGenerator, config Config, options ...ServiceOption) Service {
+	s := &service{
+		store:        store,
+		txProvider:   txProvider,
+		accessTokens: accessTokens,
+		timegen:      timegen,
+		config:       config,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func (s *service) Issue(ctx context.Context, grant Grant) (*Token, error) {
+	familyID, err := securetoken.NewID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	now := s.timegen.Now().UTC()
+	parent := &RefreshToken{
+		FamilyID:        familyID,
+		Subject:         grant.Subject,
+		ClientID:        grant.ClientID,
+		Scope:           grant.Scope,
+		AMR:             strings.Join(grant.AMR, " "),
+		AuthTime:        grant.AuthTime.UTC(),
+		JKT:             grant.JKT,
+		FamilyExpiresAt: now.Add(s.config.AbsoluteLifetime),
+	}
+	return s.create(ctx, parent, now)
+}
+
+func (s *service) Lookup(ctx context.Context, refreshToken string) (*RefreshToken, error) {
+	token, err := s.store.FindByHash(ctx, securetoken.Hash(refreshToken))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := s.checkUsable(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *service) Revoke(ctx context.Context, refreshToken, clientID string) error {
+	token, err := s.store.FindByHash(ctx, securetoken.Hash(refreshToken))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if token.ClientID != clientID {
+		return errors.WithStack(ErrClientMismatch)
+	}
+	return errors.WithStack(s.store.RevokeFamily(ctx, token.FamilyID))
+}
+
+func (s *service) RevokeFamily(ctx context.Context, familyID string) error {
+	return errors.WithStack(s.store.RevokeFamily(ctx, familyID))
+}
+
+func (s *service) RevokeSubject(ctx context.Context, subject string) error {
+	return errors.WithStack(s.store.RevokeSubject(ctx, subject))
+}
+
+// checkUsable checks whether token is active and not expired.
+func (s *service) checkUsable(token *RefreshToken) error {
+	switch token.Status {
+	case StatusRevoked:
+		return errors.WithStack(ErrRevoked)
+	case StatusRotated:
+		return errors.WithStack(ErrReused)
+	}
+	now := s.timegen.Now().UTC()
+	if !now.Before(token.ExpiresAt) || !now.Before(token.FamilyExpiresAt) {
+		return errors.WithStack(ErrExpired)
+	}
+	return nil
+}
+
+func (s *service) Exchange(ctx context.Context, refreshToken, scope string, options *AccessTokenOptions) (*TokenPair, error) {
+	current, err := s.store.FindByHash(ctx, securetoken.Hash(refreshToken))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := s.checkUsable(current); errors.Is(err, ErrReused) {
+		return nil, s.revokeReusedFamily(ctx, current)
+	} else if err != nil {
+		return nil, err
+	}
+	now := s.timegen.Now().UTC()
+	if scope == "" {
+		scope = current.Scope
+	} else if !isSubset(strings.Fields(scope), strings.Fields(current.Scope)) {
+		return nil, errors.WithStack(ErrInvalidScope)
+	}
+
+	result, err := s.txProvider.WithTransaction(ctx, transaction.UnitOfWork{
+		Execute: func(ctx context.Context, data interface{}) (interface{}, error) {
+			current := data.(*RefreshToken)
+			rotated, err := s.store.MarkRotated(ctx, current.ID, now)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if !rotated {
+				return nil, errors.WithStack(ErrReused)
+			}
+			return s.create(ctx, current, now)
+		},
+		Data: current,
+	})
+	if errors.Is(err, ErrReused) {
+		return nil, s.revokeReusedFamily(ctx, current)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	next := result.([]interface{})[0].(*Token)
+
+	extra := map[string]interface{}{}
+	if s.claims != nil {
+		subjectClaims, err := s.claims.SubjectClaims(ctx, current.Subject)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for name, value := range subjectClaims {
+			extra[name] = value
+		}
+	}
+	extra["client_id"] = current.ClientID
+	claims := &jwt.Claims{
+		Subject:  current.Subject,
+		Scope:    scope,
+		AMR:      strings.Fields(current.AMR),
+		AuthTime: current.AuthTime.Unix(),
+		Extra:    extra,
+	}
+	if options != nil {
+		claims.Audience = options.Audience
+		claims.Confirmation = options.Confirmation
+		if options.Lifetime > 0 {
+			claims.ExpiresAt = now.Add(options.Lifetime).Unix()
+		}
+	}
+	accessToken, accessTokenExpiresAt, err := s.accessTokens.SignClaims(ctx, claims)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &TokenPair{
+		AccessToken:          accessToken,
+		AccessTokenExpiresAt: accessTokenExpiresAt,
+		RefreshToken:         *next,
+		Scope:                scope,
+	}, nil
+}
+
+// create stores a new active token of the family of parent.
+func (s *service) create(ctx context.Context, parent *RefreshToken, now time.Time) (*Token, error) {
+	value, err := securetoken.New(securetoken.DefaultSize)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	id, err := securetoken.NewID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	expiresAt := now.Add(s.config.SlidingLifetime)
+	if expiresAt.After(parent.FamilyExpiresAt) {
+		expiresAt = parent.FamilyExpiresAt
+	}
+	token := &RefreshToken{
+		ID:              id,
+		FamilyID:        parent.FamilyID,
+		TokenHash:       securetoken.Hash(value),
+		Subject:         parent.Subject,
+		ClientID:        parent.ClientID,
+		Scope:           parent.Scope,
+		AMR:             parent.AMR,
+		AuthTime:        parent.AuthTime,
+		JKT:             parent.JKT,
+		Status:          StatusActive,
+		CreatedAt:       now,
+		ExpiresAt:       expiresAt,
+		FamilyExpiresAt: parent.FamilyExpiresAt,
+	}
+	if err := s.store.Create(ctx, token); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Token{Value: value, FamilyID: token.FamilyID, ExpiresAt: expiresAt}, nil
+}
+
+func (s *service) revokeReusedFamily(ctx context.Context, token *RefreshToken) error {
+	if err := s.store.RevokeFamily(ctx, token.FamilyID); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ErrReused)
+}
+
+func isSubset(values, set []string) bool {
+	for _, value := range values {
+		found := false
+		for _, candidate := range set {
+			if value == candidate {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
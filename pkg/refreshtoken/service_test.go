@@ -0,0 +1,172 @@
+package refreshtoken
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+)
+
+// staticClaims provides the same claims about every subject.
+type staticClaims map[string]interface{}
+
+func (c staticClaims) SubjectClaims(context.Context, string) (map[string]interface{}, error) {
+	return c, nil
+}
+
+func newTestService(t *testing.T, options ...ServiceOption) (Service, *timegenerator.FakeTimeGenerator) {
+	t.Helper()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	accessTokens, err := jwttest.NewRS256(timegen, "issuer", "audience", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	service := NewService(NewMemoryStore(), transaction.NewNoopProvider(), accessTokens, timegen, Config{
+		SlidingLifetime:  24 * time.Hour,
+		AbsoluteLifetime: 72 * time.Hour,
+	}, options...)
+	return service, timegen
+}
+
+func TestService_Exchange(t *testing.T) {
+	ctx := context.Background()
+	grant := Grant{Subject: "user-1", ClientID: "client-1", Scope: "read write", AMR: []string{"pwd"}}
+
+	t.Run("Rotates the refresh token and mints an access token", func(t *testing.T) {
+		service, _ := newTestService(t)
+		issued, err := service.Issue(ctx, grant)
+		if err != nil {
+			t.Fatalf("Service.Issue() error = %v", err)
+		}
+		pair, err := service.Exchange(ctx, issued.Value, "read", nil)
+		if err != nil {
+			t.Fatalf("Service.Exchange() error = %v", err)
+		}
+		if pair.AccessToken == "" || pair.Scope != "read" {
+			t.Errorf("Service.Exchange() = %+v", pair)
+		}
+		if pair.RefreshToken.Value == issued.Value || pair.RefreshToken.FamilyID != issued.FamilyID {
+			t.Errorf("Service.Exchange() refresh token = %+v, want a rotated token of family %s", pair.RefreshToken, issued.FamilyID)
+		}
+	})
+
+	t.Run("Adds the current claims about the subject", func(t *testing.T) {
+		service, _ := newTestService(t, WithClaimsProvider(staticClaims{"email_verified": true, "client_id": "forged"}))
+		issued, _ := service.Issue(ctx, grant)
+		pair, err := service.Exchange(ctx, issued.Value, "", nil)
+		if err != nil {
+			t.Fatalf("Service.Exchange() error = %v", err)
+		}
+		claims, err := jwt.ParseUnverified(pair.AccessToken)
+		if err != nil || claims.Extra["email_verified"] != true || claims.Extra["client_id"] != "client-1" {
+			t.Errorf("claims = %+v, %v, want email_verified true and client_id client-1", claims, err)
+		}
+	})
+
+	t.Run("Rejects scopes which were not granted", func(t *testing.T) {
+		service, _ := newTestService(t)
+		issued, _ := service.Issue(ctx, grant)
+		if _, err := service.Exchange(ctx, issued.Value, "read admin", nil); !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("Service.Exchange() error = %v, want %v", err, ErrInvalidScope)
+		}
+	})
+
+	t.Run("Revokes the family when a rotated token is reused", func(t *testing.T) {
+		service, _ := newTestService(t)
+		issued, _ := service.Issue(ctx, grant)
+		pair, err := service.Exchange(ctx, issued.Value, "", nil)
+		if err != nil {
+			t.Fatalf("Service.Exchange() error = %v", err)
+		}
+		if _, err := service.Exchange(ctx, issued.Value, "", nil); !errors.Is(err, ErrReused) {
+			t.Errorf("Service.Exchange() error = %v, want %v", err, ErrReused)
+		}
+		if _, err := service.Exchange(ctx, pair.RefreshToken.Value, "", nil); !errors.Is(err, ErrRevoked) {
+			t.Errorf("Service.Exchange() error = %v, want %v", err, ErrRevoked)
+		}
+	})
+
+	t.Run("Expires unused tokens after the sliding lifetime", func(t *testing.T) {
+		service, timegen := newTestService(t)
+		issued, _ := service.Issue(ctx, grant)
+		timegen.Add(25 * time.Hour)
+		if _, err := service.Exchange(ctx, issued.Value, "", nil); !errors.Is(err, ErrExpired) {
+			t.Errorf("Service.Exchange() error = %v, want %v", err, ErrExpired)
+		}
+	})
+
+	t.Run("Expires the family after the absolute lifetime", func(t *testing.T) {
+		service, timegen := newTestService(t)
+		issued, _ := service.Issue(ctx, grant)
+		value := issued.Value
+		for i := 0; i < 3; i++ {
+			timegen.Add(23 * time.Hour)
+			pair, err := service.Exchange(ctx, value, "", nil)
+			if err != nil {
+				t.Fatalf("Service.Exchange() error = %v", err)
+			}
+			value = pair.RefreshToken.Value
+		}
+		timegen.Add(4 * time.Hour)
+		if _, err := service.Exchange(ctx, value, "", nil); !errors.Is(err, ErrExpired) {
+			t.Errorf("Service.Exchange() error = %v, want %v", err, ErrExpired)
+		}
+	})
+
+	t.Run("Binds access tokens to the confirmation key", func(t *testing.T) {
+		service, _ := newTestService(t)
+		bound := grant
+		bound.JKT = "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"
+		issued, _ := service.Issue(ctx, bound)
+		pair, err := service.Exchange(ctx, issued.Value, "", &AccessTokenOptions{Confirmation: &jwt.Confirmation{JWKThumbprint: bound.JKT}})
+		if err != nil {
+			t.Fatalf("Service.Exchange() error = %v", err)
+		}
+		claims, err := jwt.ParseUnverified(pair.AccessToken)
+		if err != nil {
+			t.Fatalf("jwt.ParseUnverified() error = %v", err)
+		}
+		if claims.Confirmation == nil || claims.Confirmation.JWKThumbprint != bound.JKT {
+			t.Errorf("cnf = %+v, want jkt %s", claims.Confirmation, bound.JKT)
+		}
+		rotated, err := service.Lookup(ctx, pair.RefreshToken.Value)
+		if err != nil {
+			t.Fatalf("Service.Lookup() error = %v", err)
+		}
+		if rotated.JKT != bound.JKT {
+			t.Errorf("JKT = %q, want the JKT of the family %q", rotated.JKT, bound.JKT)
+		}
+	})
+
+	t.Run("Rejects unknown tokens", func(t *testing.T) {
+		service, _ := newTestService(t)
+		if _, err := service.Exchange(ctx, "unknown", "", nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Service.Exchange() error = %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestService_Revoke(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestService(t)
+	issued, _ := service.Issue(ctx, Grant{Subject: "user-1", ClientID: "client-1"})
+	pair, err := service.Exchange(ctx, issued.Value, "", nil)
+	if err != nil {
+		t.Fatalf("Service.Exchange() error = %v", err)
+	}
+
+	if err := service.Revoke(ctx, pair.RefreshToken.Value, "client-2"); !errors.Is(err, ErrClientMismatch) {
+		t.Errorf("Service.Revoke() error = %v, want %v", err, ErrClientMismatch)
+	}
+	if err := service.Revoke(ctx, issued.Value, "client-1"); err != nil {
+		t.Fatalf("Service.Revoke() error = %v", err)
+	}
+	if _, err := service.Exchange(ctx, pair.RefreshToken.Value, "", nil); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Service.Exchange() error = %v, want %v", err, ErrRevoked)
+	}
+}
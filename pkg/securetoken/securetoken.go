@@ -0,0 +1,46 @@
+// Package securetoken generates and hashes opaque high-entropy tokens such as refresh tokens
+// and authorization codes.
+package securetoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSize is the default number of random bytes in a token.
+const DefaultSize = 32
+
+// New generates a URL safe token from size random bytes.
+func New(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// NewID generates a random hex encoded identifier.
+func NewID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Hash hashes token for storage. Tokens are high-entropy, so an unsalted SHA-256 is enough to
+// keep stored hashes useless to an attacker who reads the database.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Equal compares two tokens or hashes in constant time.
+func Equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,57 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewMemoryStore instantiates a Store which keeps counters in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{counters: map[string]*Counter{}}
+}
+
+func (s *memoryStore) Find(_ context.Context, keyHash string) (*Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counter, ok := s.counters[keyHash]
+	if !ok {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	found := *counter
+	return &found, nil
+}
+
+func (s *memoryStore) Increment(_ context.Context, keyHash string, scope Scope, now, windowStart time.Time,
+	lockoutThreshold int, lockedUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counter, ok := s.counters[keyHash]
+	if !ok || !counter.LastFailureAt.After(windowStart) {
+		counter = &Counter{KeyHash: keyHash, Scope: scope}
+		s.counters[keyHash] = counter
+	}
+	counter.Failures++
+	counter.LastFailureAt = now
+	locked := counter.LockedUntil != nil && counter.LockedUntil.After(now)
+	if lockoutThreshold > 0 && counter.Failures >= lockoutThreshold && !locked {
+		counter.LockedUntil = &lockedUntil
+	}
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, keyHashes ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, keyHash := range keyHashes {
+		delete(s.counters, keyHash)
+	}
+	return nil
+}
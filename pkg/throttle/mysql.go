@@ -0,0 +1,73 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	findCounterQuery = `SELECT * FROM login_throttles WHERE key_hash = :key_hash`
+	// incrementCounterQuery restarts counters whose last failure is out of the window, together
+	// with their lockout, and locks counters reaching the threshold unless they are locked
+	// already. failures and locked_until are assigned before last_failure_at, since MySQL
+	// evaluates the assignments in order: locked_until sees the updated failures.
+	incrementCounterQuery = `INSERT INTO login_throttles (key_hash, scope, failures, last_failure_at, locked_until)
+		VALUES (:key_hash, :scope, 1, :now, IF(:threshold = 1, :locked_until, NULL))
+		ON DUPLICATE KEY UPDATE
+		failures = IF(last_failure_at <= :window_start, 1, failures + 1),
+		locked_until = CASE
+			WHEN :threshold > 0 AND failures >= :threshold
+				AND (last_failure_at <= :window_start OR locked_until IS NULL OR locked_until <= :now) THEN :locked_until
+			WHEN last_failure_at <= :window_start THEN NULL
+			ELSE locked_until
+		END,
+		last_failure_at = :now`
+	deleteCounterQuery = `DELETE FROM login_throttles WHERE key_hash = :key_hash`
+)
+
+type mysqlStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLStore instantiates a Store backed by MySQL.
+func NewMySQLStore(db mysql.MySQL) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) Find(ctx context.Context, keyHash string) (*Counter, error) {
+	var counter Counter
+	err := s.db.GetNamed(ctx, &counter, findCounterQuery, map[string]interface{}{"key_hash": keyHash})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &counter, nil
+}
+
+func (s *mysqlStore) Increment(ctx context.Context, keyHash string, scope Scope, now, windowStart time.Time,
+	lockoutThreshold int, lockedUntil time.Time) error {
+	_, err := s.db.ExecNamed(ctx, incrementCounterQuery, map[string]interface{}{
+		"key_hash":     keyHash,
+		"scope":        scope,
+		"now":          now,
+		"window_start": windowStart,
+		"threshold":    lockoutThreshold,
+		"locked_until": lockedUntil,
+	})
+	return errors.WithStack(err)
+}
+
+func (s *mysqlStore) Delete(ctx context.Context, keyHashes ...string) error {
+	for _, keyHash := range keyHashes {
+		if _, err := s.db.ExecNamed(ctx, deleteCounterQuery, map[string]interface{}{"key_hash": keyHash}); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Package throttle slows down and locks out repeated failed login attempts. Failures are counted
+// per account, per client IP and per account and IP pair, so credential stuffing from many IPs
+// and password guessing from one IP are both throttled, while an attacker guessing from one IP
+// is delayed well before the account is locked for everyone.
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound indicates no failure is recorded for a key.
+var ErrNotFound = errors.New("throttle counter is not found")
+
+// Scope identifies what the failures of a counter are counted for.
+type Scope string
+
+const (
+	// ScopeAccount counts the failures of an account, from any IP.
+	ScopeAccount = Scope("account")
+	// ScopeIP counts the failures from an IP, for any account.
+	ScopeIP = Scope("ip")
+	// ScopeAccountIP counts the failures of an account from an IP.
+	ScopeAccountIP = Scope("account_ip")
+)
+
+// Counter is the stored state of a throttled key.
+type Counter struct {
+	KeyHash       string     `db:"key_hash"`
+	Scope         Scope      `db:"scope"`
+	Failures      int        `db:"failures"`
+	LastFailureAt time.Time  `db:"last_failure_at"`
+	LockedUntil   *time.Time `db:"locked_until"`
+}
+
+// Store persists failure counters.
+type Store interface {
+	// Find finds the counter of keyHash.
+	Find(ctx context.Context, keyHash string) (*Counter, error)
+
+	// Increment records a failure at now, restarting the count when the last failure happened
+	// at or before windowStart. Once keyHash has lockoutThreshold failures or more, it locks it
+	// until lockedUntil, unless it is locked already; a zero lockoutThreshold never locks. It is
+	// atomic, so concurrent failures cannot skip a lockout.
+	Increment(ctx context.Context, keyHash string, scope Scope, now, windowStart time.Time, lockoutThreshold int,
+		lockedUntil time.Time) error
+
+	// Delete removes the counters of keyHashes.
+	Delete(ctx context.Context, keyHashes ...string) error
+}
+
+// Policy configures the throttling of one scope.
+type Policy struct {
+	// FreeFailures is how many failures are allowed before attempts are delayed.
+	FreeFailures int
+	// BaseDelay is the delay after the first failure beyond FreeFailures. It doubles with every
+	// further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold locks the key for LockoutDuration once it has that many failures, and
+	// again on every failure after a lockout passes, until the failures are forgotten. Zero
+	// disables lockout.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// delay returns how long to wait after the last of failures.
+func (p Policy) delay(failures int) time.Duration {
+	excess := failures - p.FreeFailures
+	if excess <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < excess && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Config provides configs for the Throttler.
+type Config struct {
+	Account   Policy
+	IP        Policy
+	AccountIP Policy
+	// Window is how long failures are remembered after the last one. It should exceed the
+	// longest delay and lockout.
+	Window time.Duration
+}
+
+// DefaultConfig delays attempts on an account and IP pair after 3 failures and locks an
+// account for 15 minutes after 10 failures. IPs, which may be shared by many users behind a
+// NAT, get more free failures and are never locked.
+var DefaultConfig = Config{
+	Account: Policy{
+		FreeFailures:     5,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	},
+	IP:        Policy{FreeFailures: 50, BaseDelay: time.Second, MaxDelay: time.Minute},
+	AccountIP: Policy{FreeFailures: 3, BaseDelay: 2 * time.Second, MaxDelay: 5 * time.Minute},
+	Window:    24 * time.Hour,
+}
+
+// ThrottledError indicates an attempt must wait before being made.
+type ThrottledError struct {
+	// RetryAfter is how long to wait.
+	RetryAfter time.Duration
+	// Locked tells the account is locked out, rather than delayed.
+	Locked bool
+}
+
+// Error returns the error message.
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account is locked for %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("attempt is delayed for %s", e.RetryAfter)
+}
+
+// Throttler throttles login attempts. Accounts are identified by the normalized login they are
+// attempted with, so unknown accounts are throttled alike and lockouts reveal nothing.
+type Throttler interface {
+	// Check returns a *ThrottledError when an attempt on account from ip must wait.
+	Check(ctx context.Context, account, ip string) error
+
+	// Failure records a failed attempt on account from ip.
+	Failure(ctx context.Context, account, ip string) error
+
+	// Success forgets the failures of account, but not the ones of ip, which may have
+	// targeted other accounts.
+	Success(ctx context.Context, account, ip string) error
+
+	// Unlock forgets the failures and lockout of accounts, e.g. on the action of an
+	// administrator. Failures of account and IP pairs are forgotten when they expire.
+	Unlock(ctx context.Context, accounts ...string) error
+}
+
+type throttler struct {
+	store   Store
+	timegen timegenerator.TimeGenerator
+	config  Config
+}
+
+// NewThrottler instantiates a new Throttler.
+func NewThrottler(store Store, timegen timegenerator.TimeGenerator, config Config) Throttler {
+	return &throttler{store: store, timegen: timegen, config: config}
+}
+
+// key is a throttled key and the policy it follows.
+type key struct {
+	hash   string
+	scope  Scope
+	policy Policy
+}
+
+func (t *throttler) keys(account, ip string) []key {
+	return []key{
+		{hash: accountKey(account), scope: ScopeAccount, policy: t.config.Account},
+		{hash: securetoken.Hash("ip:" + ip), scope: ScopeIP, policy: t.config.IP},
+		{hash: securetoken.Hash("account_ip:" + account + "\x00" + ip), scope: ScopeAccountIP, policy: t.config.AccountIP},
+	}
+}
+
+func accountKey(account string) string {
+	return securetoken.Hash("account:" + account)
+}
+
+func (t *throttler) Check(ctx context.Context, account, ip string) error {
+	now := t.timegen.Now().UTC()
+	var throttled *ThrottledError
+	for _, key := range t.keys(account, ip) {
+		counter, err := t.store.Find(ctx, key.hash)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if now.Sub(counter.LastFailureAt) >= t.config.Window {
+			continue
+		}
+		if counter.LockedUntil != nil && counter.LockedUntil.After(now) {
+			wait := counter.LockedUntil.Sub(now)
+			if throttled == nil || !throttled.Locked || wait > throttled.RetryAfter {
+				throttled = &ThrottledError{RetryAfter: wait, Locked: true}
+			}
+			continue
+		}
+		wait := counter.LastFailureAt.Add(key.policy.delay(counter.Failures)).Sub(now)
+		if wait > 0 && (throttled == nil || (!throttled.Locked && wait > throttled.RetryAfter)) {
+			throttled = &ThrottledError{RetryAfter: wait}
+		}
+	}
+	if throttled != nil {
+		return throttled
+	}
+	return nil
+}
+
+func (t *throttler) Failure(ctx context.Context, account, ip string) error {
+	now := t.timegen.Now().UTC()
+	for _, key := range t.keys(account, ip) {
+		err := t.store.Increment(ctx, key.hash, key.scope, now, now.Add(-t.config.Window),
+			key.policy.LockoutThreshold, now.Add(key.policy.LockoutDuration))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+func (t *throttler) Success(ctx context.Context, account, ip string) error {
+	keys := t.keys(account, ip)
+	return errors.WithStack(t.store.Delete(ctx, keys[0].hash, keys[2].hash))
+}
+
+func (t *throttler) Unlock(ctx context.Context, accounts ...string) error {
+	hashes := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		hashes = append(hashes, accountKey(account))
+	}
+	return errors.WithStack(t.store.Delete(ctx, hashes...))
+}
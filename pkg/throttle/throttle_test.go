@@ -0,0 +1,163 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+)
+
+var testConfig = Config{
+	Account: Policy{
+		FreeFailures:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         4 * time.Second,
+		LockoutThreshold: 6,
+		LockoutDuration:  15 * time.Minute,
+	},
+	IP:        Policy{FreeFailures: 10, BaseDelay: time.Second, MaxDelay: time.Minute},
+	AccountIP: Policy{FreeFailures: 100},
+	Window:    time.Hour,
+}
+
+func newTestThrottler() (Throttler, *timegenerator.FakeTimeGenerator) {
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	return NewThrottler(NewMemoryStore(), timegen, testConfig), timegen
+}
+
+// fail records n failures of account from ip.
+func fail(t *testing.T, throttler Throttler, account, ip string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := throttler.Failure(context.Background(), account, ip); err != nil {
+			t.Fatalf("Failure() error = %v", err)
+		}
+	}
+}
+
+func checkThrottled(t *testing.T, throttler Throttler, account, ip string) *ThrottledError {
+	t.Helper()
+	err := throttler.Check(context.Background(), account, ip)
+	if err == nil {
+		return nil
+	}
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("Check() error = %v, want a *ThrottledError", err)
+	}
+	return throttled
+}
+
+func TestPolicy_Delay(t *testing.T) {
+	policy := Policy{FreeFailures: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	want := map[int]time.Duration{0: 0, 3: 0, 4: time.Second, 5: 2 * time.Second, 6: 4 * time.Second, 7: 5 * time.Second, 50: 5 * time.Second}
+	for failures, delay := range want {
+		if got := policy.delay(failures); got != delay {
+			t.Errorf("delay(%d) = %s, want %s", failures, got, delay)
+		}
+	}
+}
+
+func TestThrottler(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Delays attempts progressively after the free failures", func(t *testing.T) {
+		throttler, timegen := newTestThrottler()
+		fail(t, throttler, "jane", "203.0.113.7", 3)
+		if throttled := checkThrottled(t, throttler, "jane", "203.0.113.7"); throttled != nil {
+			t.Fatalf("Check() = %v after the free failures, want nil", throttled)
+		}
+		fail(t, throttler, "jane", "203.0.113.7", 1)
+		if throttled := checkThrottled(t, throttler, "jane", "203.0.113.7"); throttled == nil ||
+			throttled.Locked || throttled.RetryAfter != time.Second {
+			t.Fatalf("Check() = %v, want a delay of 1s", throttled)
+		}
+		timegen.Add(time.Second)
+		if throttled := checkThrottled(t, throttler, "jane", "198.51.100.1"); throttled != nil {
+			t.Fatalf("Check() = %v once the delay passed, want nil", throttled)
+		}
+		fail(t, throttler, "jane", "198.51.100.1", 1)
+		if throttled := checkThrottled(t, throttler, "jane", "198.51.100.1"); throttled == nil || throttled.RetryAfter != 2*time.Second {
+			t.Fatalf("Check() = %v, want a delay of 2s from any IP", throttled)
+		}
+	})
+
+	t.Run("Locks an account until the cool-down passes", func(t *testing.T) {
+		throttler, timegen := newTestThrottler()
+		fail(t, throttler, "jane", "203.0.113.7", 6)
+		timegen.Add(time.Minute)
+		throttled := checkThrottled(t, throttler, "jane", "203.0.113.7")
+		if throttled == nil || !throttled.Locked || throttled.RetryAfter != 14*time.Minute {
+			t.Fatalf("Check() = %v, want a lockout for 14m", throttled)
+		}
+		timegen.Add(14 * time.Minute)
+		if throttled := checkThrottled(t, throttler, "jane", "203.0.113.7"); throttled != nil {
+			t.Fatalf("Check() = %v after the cool-down, want nil", throttled)
+		}
+		fail(t, throttler, "jane", "203.0.113.7", 1)
+		if throttled := checkThrottled(t, throttler, "jane", "203.0.113.7"); throttled == nil ||
+			!throttled.Locked || throttled.RetryAfter != 15*time.Minute {
+			t.Fatalf("Check() = %v after one more failure, want a lockout for 15m again", throttled)
+		}
+	})
+
+	t.Run("Keeps a lockout on failures made meanwhile", func(t *testing.T) {
+		throttler, timegen := newTestThrottler()
+		fail(t, throttler, "jane", "203.0.113.7", 6)
+		timegen.Add(time.Minute)
+		// Attempts racing the lockout are not checked against it.
+		fail(t, throttler, "jane", "203.0.113.7", 6)
+		throttled := checkThrottled(t, throttler, "jane", "203.0.113.7")
+		if throttled == nil || !throttled.Locked || throttled.RetryAfter != 14*time.Minute {
+			t.Fatalf("Check() = %v, want the first lockout for 14m", throttled)
+		}
+	})
+
+	t.Run("Forgets failures out of the window", func(t *testing.T) {
+		throttler, timegen := newTestThrottler()
+		fail(t, throttler, "jane", "203.0.113.7", 5)
+		timegen.Add(time.Hour)
+		fail(t, throttler, "jane", "203.0.113.7", 3)
+		if throttled := checkThrottled(t, throttler, "jane", "203.0.113.7"); throttled != nil {
+			t.Fatalf("Check() = %v, want nil since older failures expired", throttled)
+		}
+	})
+
+	t.Run("Delays an IP failing on many accounts", func(t *testing.T) {
+		throttler, _ := newTestThrottler()
+		for _, account := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"} {
+			fail(t, throttler, account, "203.0.113.7", 1)
+		}
+		if throttled := checkThrottled(t, throttler, "jane", "203.0.113.7"); throttled == nil || throttled.RetryAfter != time.Second {
+			t.Errorf("Check() = %v, want a delay of 1s for the IP", throttled)
+		}
+		if throttled := checkThrottled(t, throttler, "jane", "198.51.100.1"); throttled != nil {
+			t.Errorf("Check() = %v from another IP, want nil", throttled)
+		}
+	})
+
+	t.Run("Forgets the failures of an account on success", func(t *testing.T) {
+		throttler, _ := newTestThrottler()
+		fail(t, throttler, "jane", "203.0.113.7", 5)
+		if err := throttler.Success(ctx, "jane", "203.0.113.7"); err != nil {
+			t.Fatalf("Success() error = %v", err)
+		}
+		fail(t, throttler, "jane", "203.0.113.7", 3)
+		if throttled := checkThrottled(t, throttler, "jane", "203.0.113.7"); throttled != nil {
+			t.Errorf("Check() = %v, want nil", throttled)
+		}
+	})
+
+	t.Run("Unlocks an account", func(t *testing.T) {
+		throttler, _ := newTestThrottler()
+		fail(t, throttler, "jane", "203.0.113.7", 6)
+		if err := throttler.Unlock(ctx, "jane"); err != nil {
+			t.Fatalf("Unlock() error = %v", err)
+		}
+		if throttled := checkThrottled(t, throttler, "jane", "198.51.100.1"); throttled != nil {
+			t.Errorf("Check() = %v, want nil", throttled)
+		}
+	})
+}
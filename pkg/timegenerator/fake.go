@@ -0,0 +1,38 @@
+package timegenerator
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeTimeGenerator is a TimeGenerator whose clock is moved by hand, for use in tests.
+type FakeTimeGenerator struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeTimeGenerator instantiates a new fake time generator starting at now.
+func NewFakeTimeGenerator(now time.Time) *FakeTimeGenerator {
+	return &FakeTimeGenerator{now: now}
+}
+
+// Now returns the current fake time.
+func (gen *FakeTimeGenerator) Now() time.Time {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+	return gen.now
+}
+
+// Set moves the clock to now.
+func (gen *FakeTimeGenerator) Set(now time.Time) {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+	gen.now = now
+}
+
+// Add moves the clock forward by d.
+func (gen *FakeTimeGenerator) Add(d time.Duration) {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+	gen.now = gen.now.Add(d)
+}
@@ -0,0 +1,61 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDecryption indicates a secret cannot be decrypted, e.g. with another key or for another
+// user than it was encrypted for.
+var ErrDecryption = errors.New("secret cannot be decrypted")
+
+// Cipher encrypts secrets at rest. Additional data binds a ciphertext to its owner, so it
+// cannot be copied to another row.
+type Cipher interface {
+	// Encrypt encrypts plaintext, authenticating additionalData along.
+	Encrypt(plaintext, additionalData []byte) ([]byte, error)
+
+	// Decrypt decrypts ciphertext encrypted with the same additionalData.
+	Decrypt(ciphertext, additionalData []byte) ([]byte, error)
+}
+
+type aesGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM instantiates a Cipher using AES-GCM with key, which must have 16, 24 or 32 bytes.
+// Ciphertexts are prefixed with their random nonce.
+func NewAESGCM(key []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &aesGCM{aead: aead}, nil
+}
+
+func (c *aesGCM) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *aesGCM) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, errors.WithStack(ErrDecryption)
+	}
+	nonce, sealed := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, errors.WithStack(ErrDecryption)
+	}
+	return plaintext, nil
+}
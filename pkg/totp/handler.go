@@ -0,0 +1,141 @@
+package totp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/oauth"
+	"github.com/code-and-chill/auth-api/pkg/recoverycode"
+	"github.com/code-and-chill/auth-api/pkg/user"
+)
+
+// Error codes of the TOTP endpoints.
+const (
+	ErrorCodeInvalidRequest  = "invalid_request"
+	ErrorCodeAlreadyEnrolled = "already_enrolled"
+	ErrorCodeNotEnrolled     = "not_enrolled"
+	ErrorCodeInvalidCode     = "invalid_code"
+	ErrorCodeServerError     = "server_error"
+)
+
+// Error is an error response of the TOTP endpoints.
+type Error = httperror.Error
+
+// serviceErrors maps the errors of the Service to their responses.
+var serviceErrors = []httperror.Sentinel{
+	{Err: ErrAlreadyEnrolled, Response: httperror.New(http.StatusConflict, ErrorCodeAlreadyEnrolled, "an authenticator is already enrolled")},
+	{Err: ErrNotFound, Response: httperror.New(http.StatusNotFound, ErrorCodeNotEnrolled, "no authenticator is being enrolled")},
+	{Err: ErrInvalidCode, Response: httperror.New(http.StatusBadRequest, ErrorCodeInvalidCode, "code is invalid")},
+}
+
+func writeError(w http.ResponseWriter, err error, log *logger.Logger) {
+	httperror.Write(w, err, log, serviceErrors...)
+}
+
+type enrollmentHandler struct {
+	factors      Service
+	users        user.Service
+	accessTokens oauth.AccessTokenVerifier
+	logger       *logger.Logger
+}
+
+// NewEnrollmentHandler instantiates the enrolment endpoint, which generates a factor for the
+// user authenticated by the access token of the request and responds with its Enrollment,
+// named by the email of the user. The factor is required at login once confirmed.
+func NewEnrollmentHandler(factors Service, users user.Service, accessTokens oauth.AccessTokenVerifier,
+	logger *logger.Logger) http.Handler {
+	return &enrollmentHandler{factors: factors, users: users, accessTokens: accessTokens, logger: logger}
+}
+
+func (h *enrollmentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	claims, err := h.accessTokens.Verify(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	u, err := h.users.FindByID(r.Context(), claims.Subject)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	enrollment, err := h.factors.Enroll(r.Context(), u.ID, u.Email)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, enrollment)
+}
+
+// ConfirmationRequest is the JSON body of the confirmation endpoint.
+type ConfirmationRequest struct {
+	Code string `json:"code"`
+}
+
+type confirmationHandler struct {
+	factors       Service
+	accessTokens  oauth.AccessTokenVerifier
+	recoveryCodes recoverycode.Service
+	logger        *logger.Logger
+}
+
+// ConfirmationOption configures optional behaviour of the confirmation endpoint.
+type ConfirmationOption func(*confirmationHandler)
+
+// WithRecoveryCodes generates the recovery codes of users confirming their factor, so they can
+// log in without their authenticator. The endpoint responds with a recoverycode.Response
+// instead of 204.
+func WithRecoveryCodes(codes recoverycode.Service) ConfirmationOption {
+	return func(h *confirmationHandler) {
+		h.recoveryCodes = codes
+	}
+}
+
+// NewConfirmationHandler instantiates the confirmation endpoint, which confirms the enrolled
+// factor of the user authenticated by the access token of the request with the code of a
+// ConfirmationRequest, and responds 204.
+func NewConfirmationHandler(factors Service, accessTokens oauth.AccessTokenVerifier, logger *logger.Logger,
+	options ...ConfirmationOption) http.Handler {
+	h := &confirmationHandler{factors: factors, accessTokens: accessTokens, logger: logger}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+func (h *confirmationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	claims, err := h.accessTokens.Verify(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	var request ConfirmationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "code is required"), h.logger)
+		return
+	}
+	if err := h.factors.Confirm(r.Context(), claims.Subject, request.Code); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if h.recoveryCodes != nil {
+		codes, err := h.recoveryCodes.Generate(r.Context(), claims.Subject)
+		if err != nil {
+			writeError(w, err, h.logger)
+			return
+		}
+		httperror.WriteJSON(w, http.StatusOK, recoverycode.Response{RecoveryCodes: codes})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package totp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	factors map[string]*Factor
+}
+
+// NewMemoryStore instantiates a Store which keeps factors in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{factors: map[string]*Factor{}}
+}
+
+func (s *memoryStore) Create(_ context.Context, factor *Factor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.factors[factor.UserID]; ok && existing.ConfirmedAt != nil {
+		return errors.WithStack(ErrAlreadyEnrolled)
+	}
+	stored := *factor
+	s.factors[factor.UserID] = &stored
+	return nil
+}
+
+func (s *memoryStore) FindByUserID(_ context.Context, userID string) (*Factor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	factor, ok := s.factors[userID]
+	if !ok {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	found := *factor
+	return &found, nil
+}
+
+func (s *memoryStore) Use(_ context.Context, id string, step int64, confirmedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, factor := range s.factors {
+		if factor.ID != id {
+			continue
+		}
+		if factor.LastUsedStep >= step {
+			return false, nil
+		}
+		factor.LastUsedStep = step
+		if factor.ConfirmedAt == nil {
+			factor.ConfirmedAt = &confirmedAt
+		}
+		return true, nil
+	}
+	return false, errors.WithStack(ErrNotFound)
+}
+
+func (s *memoryStore) Delete(_ context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.factors, userID)
+	return nil
+}
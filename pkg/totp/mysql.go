@@ -0,0 +1,84 @@
+package totp
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	// createFactorQuery replaces an unconfirmed factor of the user, but never a confirmed one.
+	createFactorQuery = `INSERT INTO totp_factors (id, user_id, secret, confirmed_at, last_used_step, created_at)
+		VALUES (:id, :user_id, :secret, :confirmed_at, :last_used_step, :created_at)
+		ON DUPLICATE KEY UPDATE
+		id = IF(confirmed_at IS NULL, VALUES(id), id),
+		secret = IF(confirmed_at IS NULL, VALUES(secret), secret),
+		last_used_step = IF(confirmed_at IS NULL, VALUES(last_used_step), last_used_step),
+		created_at = IF(confirmed_at IS NULL, VALUES(created_at), created_at)`
+	findFactorQuery = `SELECT * FROM totp_factors WHERE user_id = :user_id`
+	useFactorQuery  = `UPDATE totp_factors
+		SET last_used_step = :step, confirmed_at = COALESCE(confirmed_at, :confirmed_at)
+		WHERE id = :id AND last_used_step < :step`
+	deleteFactorQuery = `DELETE FROM totp_factors WHERE user_id = :user_id`
+)
+
+type mysqlStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLStore instantiates a Store backed by MySQL.
+func NewMySQLStore(db mysql.MySQL) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) Create(ctx context.Context, factor *Factor) error {
+	result, err := s.db.ExecNamed(ctx, createFactorQuery, factor)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// MySQL reports 0 affected rows when the duplicate row is left unchanged, i.e. confirmed.
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if affected == 0 {
+		return errors.WithStack(ErrAlreadyEnrolled)
+	}
+	return nil
+}
+
+func (s *mysqlStore) FindByUserID(ctx context.Context, userID string) (*Factor, error) {
+	var factor Factor
+	err := s.db.GetNamedForWrite(ctx, &factor, findFactorQuery, map[string]interface{}{"user_id": userID})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &factor, nil
+}
+
+func (s *mysqlStore) Use(ctx context.Context, id string, step int64, confirmedAt time.Time) (bool, error) {
+	result, err := s.db.ExecNamed(ctx, useFactorQuery, map[string]interface{}{
+		"id":           id,
+		"step":         step,
+		"confirmed_at": confirmedAt,
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return affected == 1, nil
+}
+
+func (s *mysqlStore) Delete(ctx context.Context, userID string) error {
+	_, err := s.db.ExecNamed(ctx, deleteFactorQuery, map[string]interface{}{"user_id": userID})
+	return errors.WithStack(err)
+}
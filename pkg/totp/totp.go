@@ -0,0 +1,270 @@
+// Package totp implements time-based one-time passwords (RFC 6238) as a second authentication
+// factor: enrolment with secrets users add to authenticator apps, and verification of their
+// codes, each accepted once.
+package totp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound indicates the user has no factor.
+	ErrNotFound = errors.New("totp factor is not found")
+	// ErrAlreadyEnrolled indicates the user already has a confirmed factor.
+	ErrAlreadyEnrolled = errors.New("totp factor is already enrolled")
+	// ErrInvalidCode indicates a code is wrong, out of the drift window or already used.
+	ErrInvalidCode = errors.New("totp code is invalid")
+)
+
+// secretSize is the size of generated secrets, the 160 bits RFC 4226 recommends.
+const secretSize = 20
+
+// encoding is the base32 encoding of secrets in otpauth URIs, without padding as authenticator
+// apps expect.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Factor is the TOTP factor of a user. Its secret is stored encrypted.
+type Factor struct {
+	ID     string `db:"id"`
+	UserID string `db:"user_id"`
+	// Secret is the secret encrypted by the Cipher of the Service.
+	Secret []byte `db:"secret"`
+	// ConfirmedAt is set once the user proved their authenticator generates codes. Until then
+	// the factor is not required at login.
+	ConfirmedAt *time.Time `db:"confirmed_at"`
+	// LastUsedStep is the time-step of the last accepted code. Codes of this step and earlier
+	// ones are rejected, so a code cannot be replayed.
+	LastUsedStep int64     `db:"last_used_step"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// Store persists factors.
+type Store interface {
+	// Create stores a new factor, replacing the unconfirmed factor of its user if any.
+	Create(ctx context.Context, factor *Factor) error
+
+	// FindByUserID finds the factor of a user.
+	FindByUserID(ctx context.Context, userID string) (*Factor, error)
+
+	// Use records that the code of step was accepted for factor id, confirming the factor at
+	// confirmedAt if it was not yet. It returns false, changing nothing, when a code of step
+	// or a later one was accepted already, so a code read over the shoulder of the user cannot
+	// be replayed while it is still valid, nor an older one after it.
+	Use(ctx context.Context, id string, step int64, confirmedAt time.Time) (bool, error)
+
+	// Delete removes the factor of a user.
+	Delete(ctx context.Context, userID string) error
+}
+
+// Config provides configs for the Service.
+type Config struct {
+	// Issuer names the service in authenticator apps.
+	Issuer string
+	// Digits is the length of codes, 6 or 8.
+	Digits int
+	// Period is the duration of a time-step.
+	Period time.Duration
+	// Skew is how many time-steps before and after the current one are accepted, for clocks
+	// drifting apart.
+	Skew int
+}
+
+// DefaultConfig follows the defaults of RFC 6238 and of authenticator apps, accepting one
+// time-step of drift.
+var DefaultConfig = Config{Digits: 6, Period: 30 * time.Second, Skew: 1}
+
+// Enrollment is what a user adds to an authenticator app.
+type Enrollment struct {
+	// Secret is the base32 secret, for apps the URI cannot be scanned into.
+	Secret string `json:"secret"`
+	// URI is the otpauth URI, usually shown as a QR code.
+	URI string `json:"uri"`
+}
+
+// Service manages the TOTP factors of users.
+type Service interface {
+	// Enroll generates a new unconfirmed factor for a user, named accountName in authenticator
+	// apps, or returns ErrAlreadyEnrolled.
+	Enroll(ctx context.Context, userID, accountName string) (*Enrollment, error)
+
+	// Confirm verifies a code of the unconfirmed factor of a user, which is then required at
+	// login.
+	Confirm(ctx context.Context, userID, code string) error
+
+	// Verify verifies a code of the confirmed factor of a user, or returns ErrInvalidCode.
+	Verify(ctx context.Context, userID, code string) error
+
+	// Enrolled checks whether a user has a confirmed factor.
+	Enrolled(ctx context.Context, userID string) (bool, error)
+
+	// Disable removes the factor of a user.
+	Disable(ctx context.Context, userID string) error
+}
+
+type service struct {
+	store   Store
+	cipher  Cipher
+	timegen timegenerator.TimeGenerator
+	config  Config
+}
+
+// NewService instantiates a new TOTP Service, which encrypts secrets with cipher.
+func NewService(store Store, cipher Cipher, timegen timegenerator.TimeGenerator, config Config) Service {
+	return &service{store: store, cipher: cipher, timegen: timegen, config: config}
+}
+
+func (s *service) Enroll(ctx context.Context, userID, accountName string) (*Enrollment, error) {
+	factor, err := s.store.FindByUserID(ctx, userID)
+	if err == nil && factor.ConfirmedAt != nil {
+		return nil, errors.WithStack(ErrAlreadyEnrolled)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, errors.WithStack(err)
+	}
+
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	encrypted, err := s.cipher.Encrypt(secret, []byte(userID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	id, err := securetoken.NewID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = s.store.Create(ctx, &Factor{
+		ID:        id,
+		UserID:    userID,
+		Secret:    encrypted,
+		CreatedAt: s.timegen.Now().UTC(),
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Enrollment{Secret: encoding.EncodeToString(secret), URI: s.uri(secret, accountName)}, nil
+}
+
+// uri returns the otpauth URI of secret, in the Key Uri Format of Google Authenticator.
+func (s *service) uri(secret []byte, accountName string) string {
+	label := url.PathEscape(accountName)
+	if s.config.Issuer != "" {
+		label = url.PathEscape(s.config.Issuer) + ":" + label
+	}
+	query := url.Values{}
+	query.Set("secret", encoding.EncodeToString(secret))
+	if s.config.Issuer != "" {
+		query.Set("issuer", s.config.Issuer)
+	}
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(s.config.Digits))
+	query.Set("period", strconv.Itoa(int(s.config.Period/time.Second)))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func (s *service) Confirm(ctx context.Context, userID, code string) error {
+	return s.verify(ctx, userID, code, false)
+}
+
+func (s *service) Verify(ctx context.Context, userID, code string) error {
+	return s.verify(ctx, userID, code, true)
+}
+
+// verify verifies code against the factor of userID, confirmed or not as required.
+func (s *service) verify(ctx context.Context, userID, code string, confirmed bool) error {
+	factor, err := s.store.FindByUserID(ctx, userID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if (factor.ConfirmedAt != nil) != confirmed {
+		if confirmed {
+			return errors.WithStack(ErrNotFound)
+		}
+		return errors.WithStack(ErrAlreadyEnrolled)
+	}
+	secret, err := s.cipher.Decrypt(factor.Secret, []byte(userID))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	now := s.timegen.Now().UTC()
+	current := Step(now, s.config.Period)
+	matched, found := int64(0), false
+	for offset := -s.config.Skew; offset <= s.config.Skew; offset++ {
+		step := current + int64(offset)
+		// Every step is compared, so the time taken does not tell which one matched.
+		if subtle.ConstantTimeCompare([]byte(GenerateCode(secret, step, s.config.Digits)), []byte(code)) == 1 && !found {
+			matched, found = step, true
+		}
+	}
+	if !found || matched <= factor.LastUsedStep {
+		return errors.WithStack(ErrInvalidCode)
+	}
+	used, err := s.store.Use(ctx, factor.ID, matched, now)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !used {
+		return errors.WithStack(ErrInvalidCode)
+	}
+	return nil
+}
+
+func (s *service) Enrolled(ctx context.Context, userID string) (bool, error) {
+	factor, err := s.store.FindByUserID(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return factor.ConfirmedAt != nil, nil
+}
+
+func (s *service) Disable(ctx context.Context, userID string) error {
+	return errors.WithStack(s.store.Delete(ctx, userID))
+}
+
+// Step returns the time-step of t, the number of periods since the Unix epoch.
+func Step(t time.Time, period time.Duration) int64 {
+	return t.Unix() / int64(period/time.Second)
+}
+
+// GenerateCode generates the code of secret for a time-step, as HOTP (RFC 4226) does for a
+// counter.
+func GenerateCode(secret []byte, step int64, digits int) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
+
+// DecodeSecret decodes a base32 secret of an Enrollment, as authenticator apps do.
+func DecodeSecret(secret string) ([]byte, error) {
+	decoded, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	return decoded, errors.WithStack(err)
+}
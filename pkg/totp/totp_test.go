@@ -0,0 +1,178 @@
+package totp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+)
+
+func TestGenerateCode(t *testing.T) {
+	// Test vectors of RFC 6238 Appendix B for HMAC-SHA1.
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "94287082"},
+		{unix: 1111111109, want: "07081804"},
+		{unix: 1111111111, want: "14050471"},
+		{unix: 1234567890, want: "89005924"},
+		{unix: 2000000000, want: "69279037"},
+		{unix: 20000000000, want: "65353130"},
+	}
+	for _, tt := range tests {
+		if got := GenerateCode(secret, Step(time.Unix(tt.unix, 0), 30*time.Second), 8); got != tt.want {
+			t.Errorf("GenerateCode() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func newTestService(t *testing.T) (Service, Store, *timegenerator.FakeTimeGenerator) {
+	t.Helper()
+	cipher, err := NewAESGCM(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewAESGCM() error = %v", err)
+	}
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryStore()
+	config := DefaultConfig
+	config.Issuer = "Example"
+	return NewService(store, cipher, timegen, config), store, timegen
+}
+
+// enroll enrolls a factor for jane and returns its decoded secret.
+func enroll(t *testing.T, factors Service) []byte {
+	t.Helper()
+	enrollment, err := factors.Enroll(context.Background(), "jane", "jane@example.com")
+	if err != nil {
+		t.Fatalf("Enroll() error = %v", err)
+	}
+	secret, err := DecodeSecret(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("DecodeSecret() error = %v", err)
+	}
+	return secret
+}
+
+// codeAt generates the code of secret at the current time of timegen shifted by offset.
+func codeAt(secret []byte, timegen timegenerator.TimeGenerator, offset time.Duration) string {
+	return GenerateCode(secret, Step(timegen.Now().Add(offset), DefaultConfig.Period), DefaultConfig.Digits)
+}
+
+func TestService_Enroll(t *testing.T) {
+	factors, store, _ := newTestService(t)
+	enrollment, err := factors.Enroll(context.Background(), "jane", "jane@example.com")
+	if err != nil {
+		t.Fatalf("Enroll() error = %v", err)
+	}
+	uri, err := url.Parse(enrollment.URI)
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	query := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Example:jane@example.com" ||
+		query.Get("secret") != enrollment.Secret || query.Get("issuer") != "Example" ||
+		query.Get("digits") != "6" || query.Get("period") != "30" || query.Get("algorithm") != "SHA1" {
+		t.Errorf("URI = %s, want an otpauth URI of the secret", enrollment.URI)
+	}
+	secret, _ := DecodeSecret(enrollment.Secret)
+	factor, err := store.FindByUserID(context.Background(), "jane")
+	if err != nil {
+		t.Fatalf("FindByUserID() error = %v", err)
+	}
+	if len(secret) != secretSize || bytes.Contains(factor.Secret, secret) {
+		t.Errorf("stored secret = %x, want the secret encrypted", factor.Secret)
+	}
+	if enrolled, _ := factors.Enrolled(context.Background(), "jane"); enrolled {
+		t.Error("Enrolled() = true, want false until confirmed")
+	}
+}
+
+func TestService_Verify(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Confirms and verifies codes within the drift window", func(t *testing.T) {
+		factors, _, timegen := newTestService(t)
+		secret := enroll(t, factors)
+		if err := factors.Verify(ctx, "jane", codeAt(secret, timegen, 0)); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Verify() error = %v before confirmation, want ErrNotFound", err)
+		}
+		if err := factors.Confirm(ctx, "jane", codeAt(secret, timegen, 0)); err != nil {
+			t.Fatalf("Confirm() error = %v", err)
+		}
+		if enrolled, _ := factors.Enrolled(ctx, "jane"); !enrolled {
+			t.Error("Enrolled() = false, want true once confirmed")
+		}
+		timegen.Add(2 * time.Minute)
+		if err := factors.Verify(ctx, "jane", codeAt(secret, timegen, -30*time.Second)); err != nil {
+			t.Errorf("Verify() error = %v for the previous step", err)
+		}
+		timegen.Add(time.Minute)
+		if err := factors.Verify(ctx, "jane", codeAt(secret, timegen, 30*time.Second)); err != nil {
+			t.Errorf("Verify() error = %v for the next step", err)
+		}
+	})
+
+	tests := []struct {
+		name string
+		code func(secret []byte, timegen timegenerator.TimeGenerator) string
+	}{
+		{name: "Rejects a code out of the drift window", code: func(secret []byte, timegen timegenerator.TimeGenerator) string {
+			return codeAt(secret, timegen, -time.Minute)
+		}},
+		{name: "Rejects a wrong code", code: func(secret []byte, timegen timegenerator.TimeGenerator) string {
+			return "000000"
+		}},
+		{name: "Rejects a replayed code", code: func(secret []byte, timegen timegenerator.TimeGenerator) string {
+			return codeAt(secret, timegen, 0)
+		}},
+		{name: "Rejects a code older than the last used one", code: func(secret []byte, timegen timegenerator.TimeGenerator) string {
+			return codeAt(secret, timegen, -30*time.Second)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factors, _, timegen := newTestService(t)
+			secret := enroll(t, factors)
+			if err := factors.Confirm(ctx, "jane", codeAt(secret, timegen, 0)); err != nil {
+				t.Fatalf("Confirm() error = %v", err)
+			}
+			if err := factors.Verify(ctx, "jane", tt.code(secret, timegen)); !errors.Is(err, ErrInvalidCode) {
+				t.Errorf("Verify() error = %v, want ErrInvalidCode", err)
+			}
+		})
+	}
+
+	t.Run("Rejects enrolling twice", func(t *testing.T) {
+		factors, _, timegen := newTestService(t)
+		secret := enroll(t, factors)
+		if err := factors.Confirm(ctx, "jane", codeAt(secret, timegen, 0)); err != nil {
+			t.Fatalf("Confirm() error = %v", err)
+		}
+		if _, err := factors.Enroll(ctx, "jane", "jane@example.com"); !errors.Is(err, ErrAlreadyEnrolled) {
+			t.Errorf("Enroll() error = %v, want ErrAlreadyEnrolled", err)
+		}
+	})
+}
+
+func TestAESGCM(t *testing.T) {
+	cipher, err := NewAESGCM(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewAESGCM() error = %v", err)
+	}
+	ciphertext, err := cipher.Encrypt([]byte("secret"), []byte("jane"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if plaintext, err := cipher.Decrypt(ciphertext, []byte("jane")); err != nil || string(plaintext) != "secret" {
+		t.Errorf("Decrypt() = %q, %v, want the plaintext", plaintext, err)
+	}
+	if _, err := cipher.Decrypt(ciphertext, []byte("john")); !errors.Is(err, ErrDecryption) {
+		t.Errorf("Decrypt() error = %v for another user, want ErrDecryption", err)
+	}
+}
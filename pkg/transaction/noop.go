@@ -0,0 +1,29 @@
+package transaction
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+type noopProvider struct {
+}
+
+// NewNoopProvider instantiates a Provider which runs unit of works in order without a
+// transaction. It is meant for in-memory stores and tests.
+func NewNoopProvider() Provider {
+	return &noopProvider{}
+}
+
+// WithTransaction runs unit of works in order, stopping at the first error.
+func (p *noopProvider) WithTransaction(ctx context.Context, unitOfWorks ...UnitOfWork) (interface{}, error) {
+	var resultData []interface{}
+	for _, unitOfWork := range unitOfWorks {
+		uowData, err := unitOfWork.Execute(ctx, unitOfWork.Data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		resultData = append(resultData, uowData)
+	}
+	return resultData, nil
+}
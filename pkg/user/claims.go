@@ -0,0 +1,34 @@
+package user
+
+import (
+	"context"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/pkg/errors"
+)
+
+type claimsProvider struct {
+	users Service
+}
+
+// NewClaimsProvider instantiates a jwt.ClaimsProvider adding ClaimEmailVerified to the tokens
+// of users. Subjects which are not users, e.g. clients, get no claims.
+func NewClaimsProvider(users Service) jwt.ClaimsProvider {
+	return &claimsProvider{users: users}
+}
+
+func (p *claimsProvider) SubjectClaims(ctx context.Context, subject string) (map[string]interface{}, error) {
+	user, err := p.users.FindByID(ctx, subject)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return Claims(user), nil
+}
+
+// Claims returns the claims about user added to its tokens.
+func Claims(user *User) map[string]interface{} {
+	return map[string]interface{}{ClaimEmailVerified: user.EmailVerified()}
+}
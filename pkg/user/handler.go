@@ -0,0 +1,228 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/code-and-chill/auth-api/pkg/filter"
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/oauth"
+	"github.com/code-and-chill/auth-api/pkg/passwordpolicy"
+	"github.com/pkg/errors"
+)
+
+// Error codes of the user endpoints.
+const (
+	ErrorCodeInvalidRequest  = "invalid_request"
+	ErrorCodeInvalidEmail    = "invalid_email"
+	ErrorCodeInvalidUsername = "invalid_username"
+	ErrorCodeEmailTaken      = "email_taken"
+	ErrorCodeUsernameTaken   = "username_taken"
+	ErrorCodeInvalidStatus   = "invalid_status"
+	ErrorCodeWeakPassword    = "weak_password"
+	ErrorCodeInvalidPassword = "invalid_password"
+	ErrorCodeServerError     = "server_error"
+)
+
+// Error is an error response of the user endpoints.
+type Error = httperror.Error
+
+// Violation is a rule of the password policy a password breaks, with its English message.
+// Clients may localize it by code and params instead.
+type Violation struct {
+	passwordpolicy.Violation
+	Message string `json:"message"`
+}
+
+// PolicyError is the weak_password response to a password violating the password policy.
+type PolicyError struct {
+	*Error
+	// Violations lists the rules of the password policy the password breaks.
+	Violations []Violation `json:"violations"`
+}
+
+// newPolicyError returns the response to a password violating the policy.
+func newPolicyError(policyErr *passwordpolicy.Error) *PolicyError {
+	response := &PolicyError{
+		Error: httperror.New(http.StatusBadRequest, ErrorCodeWeakPassword, "password violates the password policy"),
+	}
+	for _, violation := range policyErr.Violations {
+		response.Violations = append(response.Violations, Violation{
+			Violation: violation,
+			Message:   passwordpolicy.EnglishCatalog.Message(violation),
+		})
+	}
+	return response
+}
+
+// serviceErrors maps the errors of the Service to their responses.
+var serviceErrors = []httperror.Sentinel{
+	{Err: ErrInvalidEmail, Response: httperror.New(http.StatusBadRequest, ErrorCodeInvalidEmail, "email is invalid")},
+	{Err: ErrInvalidUsername, Response: httperror.New(http.StatusBadRequest, ErrorCodeInvalidUsername, "username must have 3 to 32 letters, digits, dots, dashes or underscores")},
+	{Err: ErrEmailTaken, Response: httperror.New(http.StatusConflict, ErrorCodeEmailTaken, "email is already registered")},
+	{Err: ErrUsernameTaken, Response: httperror.New(http.StatusConflict, ErrorCodeUsernameTaken, "username is already registered")},
+	{Err: ErrInvalidStatus, Response: httperror.New(http.StatusBadRequest, ErrorCodeInvalidStatus, "status is invalid")},
+}
+
+func writeError(w http.ResponseWriter, err error, log *logger.Logger) {
+	var policyErr *passwordpolicy.Error
+	if errors.As(err, &policyErr) {
+		response := newPolicyError(policyErr)
+		httperror.WriteJSON(w, response.Status, response)
+		return
+	}
+	httperror.Write(w, err, log, serviceErrors...)
+}
+
+// RegistrationRequest is the JSON body of the registration endpoint.
+type RegistrationRequest struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// EmailVerifier sends users a link to verify their email. emailverification.Service
+// implements it.
+type EmailVerifier interface {
+	// SendVerification sends user a link to verify their email.
+	SendVerification(ctx context.Context, user *User) error
+}
+
+type registrationHandler struct {
+	users         Service
+	emailVerifier EmailVerifier
+	logger        *logger.Logger
+}
+
+// RegistrationOption configures optional behaviour of the registration endpoint.
+type RegistrationOption func(*registrationHandler)
+
+// WithEmailVerification sends registered users a link to verify their email with verifier.
+// Users are registered even when it cannot be sent, since they can ask for another link.
+func WithEmailVerification(verifier EmailVerifier) RegistrationOption {
+	return func(h *registrationHandler) {
+		h.emailVerifier = verifier
+	}
+}
+
+// NewRegistrationHandler instantiates the registration endpoint, which creates a user from a
+// JSON RegistrationRequest and responds with the created User.
+func NewRegistrationHandler(users Service, logger *logger.Logger, options ...RegistrationOption) http.Handler {
+	h := &registrationHandler{users: users, logger: logger}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+func (h *registrationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	var request RegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "malformed JSON body"), h.logger)
+		return
+	}
+	if request.Email == "" || request.Password == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "email and password are required"), h.logger)
+		return
+	}
+	user, err := h.users.Register(r.Context(), Registration{
+		Email:    request.Email,
+		Username: request.Username,
+		Name:     request.Name,
+		Password: request.Password,
+	})
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if h.emailVerifier != nil {
+		if err := h.emailVerifier.SendVerification(r.Context(), user); err != nil {
+			h.logger.WithField("err", err).Error()
+		}
+	}
+	httperror.WriteJSON(w, http.StatusCreated, user)
+}
+
+type listHandler struct {
+	users  Service
+	logger *logger.Logger
+}
+
+// NewListHandler instantiates the admin listing endpoint, which responds with a Page of the
+// users matching the query parameters: the Filter keys, limit and offset. It does not check
+// who makes the request, so it must be mounted behind administrator authorization.
+func NewListHandler(users Service, logger *logger.Logger) http.Handler {
+	return &listHandler{users: users, logger: logger}
+}
+
+func (h *listHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be GET"), h.logger)
+		return
+	}
+	f := filter.Filter{}
+	for key := range r.URL.Query() {
+		f[key] = r.URL.Query().Get(key)
+	}
+	page, err := h.users.List(r.Context(), f)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, page)
+}
+
+// PasswordChangeRequest is the JSON body of the password change endpoint.
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type passwordChangeHandler struct {
+	users        Service
+	accessTokens oauth.AccessTokenVerifier
+	logger       *logger.Logger
+}
+
+// NewPasswordChangeHandler instantiates the password change endpoint, which replaces the
+// password of the user authenticated by the access token of the request with the new password
+// of a PasswordChangeRequest, once its current password is verified. It responds 204, or
+// weak_password with the violations of the password policy.
+func NewPasswordChangeHandler(users Service, accessTokens oauth.AccessTokenVerifier, logger *logger.Logger) http.Handler {
+	return &passwordChangeHandler{users: users, accessTokens: accessTokens, logger: logger}
+}
+
+func (h *passwordChangeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	claims, err := h.accessTokens.Verify(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	var request PasswordChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.CurrentPassword == "" || request.NewPassword == "" {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "current_password and new_password are required"), h.logger)
+		return
+	}
+	err = h.users.ChangePassword(r.Context(), claims.Subject, request.CurrentPassword, request.NewPassword)
+	if errors.Is(err, ErrInvalidCredentials) {
+		writeError(w, httperror.New(http.StatusForbidden, ErrorCodeInvalidPassword, "current password is invalid"), h.logger)
+		return
+	}
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,201 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/oauth"
+	"github.com/code-and-chill/auth-api/pkg/passwordpolicy"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+)
+
+func serve(t *testing.T, handler http.Handler, method, target, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, body = %s", err, recorder.Body.String())
+	}
+	return recorder, response
+}
+
+func TestRegistrationHandler(t *testing.T) {
+	t.Run("Registers a user", func(t *testing.T) {
+		users, _, _ := newTestService()
+		handler := NewRegistrationHandler(users, logger.NewNoopLogger())
+		recorder, body := serve(t, handler, http.MethodPost, "/register",
+			`{"email":"Jane@Example.com","username":"jane","name":"Jane Doe","password":"correct horse"}`)
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("status = %d, body = %v", recorder.Code, body)
+		}
+		if body["id"] == "" || body["email"] != "Jane@Example.com" || body["username"] != "jane" || body["status"] != "active" {
+			t.Errorf("body = %v, want the created user", body)
+		}
+		if _, ok := body["normalized_email"]; ok {
+			t.Errorf("body = %v, want no normalized identifiers", body)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "Rejects a registered email",
+			method:     http.MethodPost,
+			body:       `{"email":"JANE@example.com","password":"secret"}`,
+			wantStatus: http.StatusConflict,
+			wantError:  ErrorCodeEmailTaken,
+		},
+		{
+			name:       "Rejects a registered username",
+			method:     http.MethodPost,
+			body:       `{"email":"john@example.com","username":"Jane","password":"secret"}`,
+			wantStatus: http.StatusConflict,
+			wantError:  ErrorCodeUsernameTaken,
+		},
+		{
+			name:       "Rejects an invalid email",
+			method:     http.MethodPost,
+			body:       `{"email":"john","password":"secret"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  ErrorCodeInvalidEmail,
+		},
+		{
+			name:       "Rejects a missing password",
+			method:     http.MethodPost,
+			body:       `{"email":"john@example.com"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  ErrorCodeInvalidRequest,
+		},
+		{
+			name:       "Rejects a malformed body",
+			method:     http.MethodPost,
+			body:       `{"email":`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  ErrorCodeInvalidRequest,
+		},
+		{
+			name:       "Rejects other methods",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantError:  ErrorCodeInvalidRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, _, _ := newTestService()
+			register(t, users, "jane@example.com", "jane")
+			handler := NewRegistrationHandler(users, logger.NewNoopLogger())
+			recorder, body := serve(t, handler, tt.method, "/register", tt.body)
+			if recorder.Code != tt.wantStatus || body["error"] != tt.wantError {
+				t.Errorf("response = %d %v, want %d %s", recorder.Code, body, tt.wantStatus, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestListHandler(t *testing.T) {
+	users, _, timegen := newTestService()
+	for _, email := range []string{"ann@example.com", "bob@example.com", "carol@example.org"} {
+		timegen.Add(time.Minute)
+		register(t, users, email, "")
+	}
+	handler := NewListHandler(users, logger.NewNoopLogger())
+
+	recorder, body := serve(t, handler, http.MethodGet, "/users?email=example.com&limit=1&offset=1", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", recorder.Code, body)
+	}
+	page, _ := body["users"].([]interface{})
+	if len(page) != 1 || page[0].(map[string]interface{})["email"] != "bob@example.com" {
+		t.Errorf("users = %v, want bob only", body["users"])
+	}
+	if body["total"] != float64(2) || body["limit"] != float64(1) || body["offset"] != float64(1) {
+		t.Errorf("body = %v, want total 2, limit 1 and offset 1", body)
+	}
+
+	recorder, body = serve(t, handler, http.MethodGet, "/users?status=archived", "")
+	if recorder.Code != http.StatusBadRequest || body["error"] != ErrorCodeInvalidStatus {
+		t.Errorf("response = %d %v, want invalid_status", recorder.Code, body)
+	}
+}
+
+func TestPasswordChangeHandler(t *testing.T) {
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	accessTokens, err := jwttest.NewRS256(timegen, "https://auth.example.com", "api", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	users := NewService(NewMemoryStore(), transaction.NewNoopProvider(), fakeHasher{}, timegen,
+		WithPasswordPolicy(passwordpolicy.NewChecker(testPolicy, fakeHasher{})))
+	jane := register(t, users, "jane@example.com", "")
+	token, _, err := accessTokens.SignClaims(context.Background(), &jwt.Claims{Subject: jane.ID})
+	if err != nil {
+		t.Fatalf("SignClaims() error = %v", err)
+	}
+	handler := NewPasswordChangeHandler(users, oauth.NewAccessTokenVerifier(accessTokens, nil), logger.NewNoopLogger())
+	change := func(authorization, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/password", strings.NewReader(body))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("Rejects a password violating the policy", func(t *testing.T) {
+		recorder := change("Bearer "+token, `{"current_password":"correct horse","new_password":"short"}`)
+		var body PolicyError
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		if recorder.Code != http.StatusBadRequest || body.Code != ErrorCodeWeakPassword || len(body.Violations) != 1 {
+			t.Fatalf("response = %d %s, want weak_password with a violation", recorder.Code, recorder.Body.String())
+		}
+		if violation := body.Violations[0]; violation.Code != passwordpolicy.CodeTooShort ||
+			violation.Params["min"] != float64(8) || violation.Message != "Password must have at least 8 characters." {
+			t.Errorf("violation = %+v, want password_too_short with its params and message", violation)
+		}
+	})
+
+	t.Run("Rejects a wrong current password", func(t *testing.T) {
+		recorder := change("Bearer "+token, `{"current_password":"wrong","new_password":"battery staple"}`)
+		if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), ErrorCodeInvalidPassword) {
+			t.Errorf("response = %d %s, want invalid_password", recorder.Code, recorder.Body.String())
+		}
+	})
+
+	t.Run("Challenges requests without access token", func(t *testing.T) {
+		recorder := change("", `{"current_password":"correct horse","new_password":"battery staple"}`)
+		if recorder.Code != http.StatusUnauthorized || !strings.HasPrefix(recorder.Header().Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("response = %d %v, want a Bearer challenge", recorder.Code, recorder.Header())
+		}
+	})
+
+	t.Run("Changes the password", func(t *testing.T) {
+		recorder := change("Bearer "+token, `{"current_password":"correct horse","new_password":"battery staple"}`)
+		if recorder.Code != http.StatusNoContent {
+			t.Fatalf("response = %d %s, want 204", recorder.Code, recorder.Body.String())
+		}
+		if ok, _ := users.VerifyPassword(context.Background(), jane.ID, "battery staple"); !ok {
+			t.Error("VerifyPassword() = false, want the new password verified")
+		}
+	})
+}
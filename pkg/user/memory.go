@@ -0,0 +1,201 @@
+package user
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/filter"
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	mu          sync.Mutex
+	users       map[string]*User
+	credentials map[string]*Credential
+	history     []PasswordHistory
+}
+
+// NewMemoryStore instantiates a Store which keeps users in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{users: map[string]*User{}, credentials: map[string]*Credential{}}
+}
+
+func (s *memoryStore) Create(_ context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.users {
+		if existing.NormalizedEmail == user.NormalizedEmail {
+			return errors.WithStack(ErrEmailTaken)
+		}
+		if user.NormalizedUsername != nil && existing.NormalizedUsername != nil &&
+			*existing.NormalizedUsername == *user.NormalizedUsername {
+			return errors.WithStack(ErrUsernameTaken)
+		}
+	}
+	stored := *user
+	s.users[user.ID] = &stored
+	return nil
+}
+
+func (s *memoryStore) FindByID(_ context.Context, id string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	found := *user
+	return &found, nil
+}
+
+func (s *memoryStore) FindByEmail(_ context.Context, normalizedEmail string) (*User, error) {
+	return s.find(func(user *User) bool { return user.NormalizedEmail == normalizedEmail })
+}
+
+func (s *memoryStore) FindByUsername(_ context.Context, normalizedUsername string) (*User, error) {
+	return s.find(func(user *User) bool {
+		return user.NormalizedUsername != nil && *user.NormalizedUsername == normalizedUsername
+	})
+}
+
+func (s *memoryStore) find(match func(*User) bool) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if match(user) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, errors.WithStack(ErrNotFound)
+}
+
+func (s *memoryStore) UpdateStatus(_ context.Context, id string, status Status, updatedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return errors.WithStack(ErrNotFound)
+	}
+	user.Status = status
+	user.UpdatedAt = updatedAt
+	return nil
+}
+
+func (s *memoryStore) UpdateEmail(_ context.Context, id, email, normalizedEmail string, verifiedAt *time.Time,
+	updatedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return errors.WithStack(ErrNotFound)
+	}
+	for _, existing := range s.users {
+		if existing.ID != id && existing.NormalizedEmail == normalizedEmail {
+			return errors.WithStack(ErrEmailTaken)
+		}
+	}
+	user.Email = email
+	user.NormalizedEmail = normalizedEmail
+	user.EmailVerifiedAt = verifiedAt
+	user.UpdatedAt = updatedAt
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context, f filter.Filter, limit, offset int) ([]User, error) {
+	users := s.matching(f)
+	if offset >= len(users) {
+		return nil, nil
+	}
+	users = users[offset:]
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *memoryStore) Count(_ context.Context, f filter.Filter) (int, error) {
+	return len(s.matching(f)), nil
+}
+
+// matching returns the users matching f in listing order, oldest first.
+func (s *memoryStore) matching(f filter.Filter) []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []User
+	for _, user := range s.users {
+		status := Status(f[FilterStatus])
+		if (status == "" && user.Status == StatusDeleted) || (status != "" && user.Status != status) {
+			continue
+		}
+		if !strings.Contains(user.NormalizedEmail, NormalizeEmail(f[FilterEmail])) {
+			continue
+		}
+		if username := NormalizeUsername(f[FilterUsername]); username != "" &&
+			(user.NormalizedUsername == nil || !strings.Contains(*user.NormalizedUsername, username)) {
+			continue
+		}
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].ID < users[j].ID
+		}
+		return users[i].CreatedAt.Before(users[j].CreatedAt)
+	})
+	return users
+}
+
+func (s *memoryStore) CreateCredential(_ context.Context, credential *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *credential
+	s.credentials[credential.ID] = &stored
+	return nil
+}
+
+func (s *memoryStore) FindCredential(_ context.Context, userID string, credentialType CredentialType) (*Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, credential := range s.credentials {
+		if credential.UserID == userID && credential.Type == credentialType {
+			found := *credential
+			return &found, nil
+		}
+	}
+	return nil, errors.WithStack(ErrNotFound)
+}
+
+func (s *memoryStore) UpdateCredential(_ context.Context, id, secret string, updatedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	credential, ok := s.credentials[id]
+	if !ok {
+		return errors.WithStack(ErrNotFound)
+	}
+	credential.Secret = secret
+	credential.UpdatedAt = updatedAt
+	return nil
+}
+
+func (s *memoryStore) AddPasswordHistory(_ context.Context, history *PasswordHistory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append(s.history, *history)
+	return nil
+}
+
+func (s *memoryStore) ListPasswordHistory(_ context.Context, userID string, limit int) ([]PasswordHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var history []PasswordHistory
+	for i := len(s.history) - 1; i >= 0 && len(history) < limit; i-- {
+		if s.history[i].UserID == userID {
+			history = append(history, s.history[i])
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,208 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/filter"
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	insertUserQuery = `INSERT INTO users (id, email, normalized_email, email_verified_at, username, normalized_username,
+		name, status, created_at, updated_at)
+		VALUES (:id, :email, :normalized_email, :email_verified_at, :username, :normalized_username,
+		:name, :status, :created_at, :updated_at)`
+	findUserByIDQuery       = `SELECT * FROM users WHERE id = :id`
+	findUserByEmailQuery    = `SELECT * FROM users WHERE normalized_email = :normalized_email`
+	findUserByUsernameQuery = `SELECT * FROM users WHERE normalized_username = :normalized_username`
+	updateUserStatusQuery   = `UPDATE users SET status = :status, updated_at = :updated_at WHERE id = :id`
+	updateUserEmailQuery    = `UPDATE users SET email = :email, normalized_email = :normalized_email,
+		email_verified_at = :email_verified_at, updated_at = :updated_at WHERE id = :id`
+	listUsersQuery        = `SELECT * FROM users`
+	countUsersQuery       = `SELECT COUNT(*) FROM users`
+	insertCredentialQuery = `INSERT INTO user_credentials (id, user_id, type, secret, created_at, updated_at)
+		VALUES (:id, :user_id, :type, :secret, :created_at, :updated_at)`
+	findCredentialQuery        = `SELECT * FROM user_credentials WHERE user_id = :user_id AND type = :type`
+	updateCredentialQuery      = `UPDATE user_credentials SET secret = :secret, updated_at = :updated_at WHERE id = :id`
+	insertPasswordHistoryQuery = `INSERT INTO user_password_history (id, user_id, secret, created_at)
+		VALUES (:id, :user_id, :secret, :created_at)`
+	listPasswordHistoryQuery = `SELECT * FROM user_password_history WHERE user_id = :user_id
+		ORDER BY created_at DESC, id DESC LIMIT :limit`
+)
+
+// errorCodeDuplicateEntry is the MySQL error raised when a unique key is violated.
+const errorCodeDuplicateEntry = 1062
+
+type mysqlStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLStore instantiates a Store backed by MySQL.
+func NewMySQLStore(db mysql.MySQL) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) Create(ctx context.Context, user *User) error {
+	_, err := s.db.ExecNamed(ctx, insertUserQuery, user)
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errorCodeDuplicateEntry {
+		if strings.Contains(mysqlErr.Message, "uk_users_normalized_username") {
+			return errors.WithStack(ErrUsernameTaken)
+		}
+		return errors.WithStack(ErrEmailTaken)
+	}
+	return errors.WithStack(err)
+}
+
+func (s *mysqlStore) FindByID(ctx context.Context, id string) (*User, error) {
+	return s.find(ctx, findUserByIDQuery, map[string]interface{}{"id": id})
+}
+
+func (s *mysqlStore) FindByEmail(ctx context.Context, normalizedEmail string) (*User, error) {
+	return s.find(ctx, findUserByEmailQuery, map[string]interface{}{"normalized_email": normalizedEmail})
+}
+
+func (s *mysqlStore) FindByUsername(ctx context.Context, normalizedUsername string) (*User, error) {
+	return s.find(ctx, findUserByUsernameQuery, map[string]interface{}{"normalized_username": normalizedUsername})
+}
+
+func (s *mysqlStore) find(ctx context.Context, query string, args map[string]interface{}) (*User, error) {
+	var user User
+	err := s.db.GetNamed(ctx, &user, query, args)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &user, nil
+}
+
+func (s *mysqlStore) UpdateStatus(ctx context.Context, id string, status Status, updatedAt time.Time) error {
+	result, err := s.db.ExecNamed(ctx, updateUserStatusQuery, map[string]interface{}{
+		"id":         id,
+		"status":     status,
+		"updated_at": updatedAt,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if affected == 0 {
+		return errors.WithStack(ErrNotFound)
+	}
+	return nil
+}
+
+func (s *mysqlStore) UpdateEmail(ctx context.Context, id, email, normalizedEmail string, verifiedAt *time.Time,
+	updatedAt time.Time) error {
+	result, err := s.db.ExecNamed(ctx, updateUserEmailQuery, map[string]interface{}{
+		"id":                id,
+		"email":             email,
+		"normalized_email":  normalizedEmail,
+		"email_verified_at": verifiedAt,
+		"updated_at":        updatedAt,
+	})
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errorCodeDuplicateEntry {
+		return errors.WithStack(ErrEmailTaken)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if affected == 0 {
+		return errors.WithStack(ErrNotFound)
+	}
+	return nil
+}
+
+func (s *mysqlStore) List(ctx context.Context, f filter.Filter, limit, offset int) ([]User, error) {
+	var paging mysql.DynamicQueryBuilder
+	query := listConditions(f).BindSQL(listUsersQuery) + " ORDER BY created_at, id" + paging.Limit(offset, limit).ToString()
+	var users []User
+	if err := s.db.Select(ctx, &users, query); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return users, nil
+}
+
+func (s *mysqlStore) Count(ctx context.Context, f filter.Filter) (int, error) {
+	var count int
+	if err := s.db.Get(ctx, &count, listConditions(f).BindSQL(countUsersQuery)); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return count, nil
+}
+
+// listConditions builds the WHERE clause of user listings. The query builder inlines values,
+// so backslashes are escaped on top of the quotes it escapes itself.
+func listConditions(f filter.Filter) mysql.DynamicQueryBuilder {
+	escape := func(value string) string {
+		return strings.ReplaceAll(value, `\`, `\\`)
+	}
+	var dqb mysql.DynamicQueryBuilder
+	status := interface{}("status <> 'deleted'")
+	if value := f[FilterStatus]; value != "" {
+		status = dqb.NewExp("status", "=", escape(value))
+	}
+	return dqb.And(
+		status,
+		dqb.NewExp("normalized_email ", "LIKE", escape(NormalizeEmail(f[FilterEmail]))),
+		dqb.NewExp("normalized_username ", "LIKE", escape(NormalizeUsername(f[FilterUsername]))),
+	)
+}
+
+func (s *mysqlStore) CreateCredential(ctx context.Context, credential *Credential) error {
+	_, err := s.db.ExecNamed(ctx, insertCredentialQuery, credential)
+	return errors.WithStack(err)
+}
+
+func (s *mysqlStore) FindCredential(ctx context.Context, userID string, credentialType CredentialType) (*Credential, error) {
+	var credential Credential
+	err := s.db.GetNamed(ctx, &credential, findCredentialQuery, map[string]interface{}{
+		"user_id": userID,
+		"type":    credentialType,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &credential, nil
+}
+
+func (s *mysqlStore) UpdateCredential(ctx context.Context, id, secret string, updatedAt time.Time) error {
+	_, err := s.db.ExecNamed(ctx, updateCredentialQuery, map[string]interface{}{
+		"id":         id,
+		"secret":     secret,
+		"updated_at": updatedAt,
+	})
+	return errors.WithStack(err)
+}
+
+func (s *mysqlStore) AddPasswordHistory(ctx context.Context, history *PasswordHistory) error {
+	_, err := s.db.ExecNamed(ctx, insertPasswordHistoryQuery, history)
+	return errors.WithStack(err)
+}
+
+func (s *mysqlStore) ListPasswordHistory(ctx context.Context, userID string, limit int) ([]PasswordHistory, error) {
+	var history []PasswordHistory
+	err := s.db.SelectNamed(ctx, &history, listPasswordHistoryQuery, map[string]interface{}{
+		"user_id": userID,
+		"limit":   limit,
+	})
+	return history, errors.WithStack(err)
+}
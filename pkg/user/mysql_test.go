@@ -0,0 +1,38 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/code-and-chill/auth-api/pkg/filter"
+)
+
+func TestListConditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter filter.Filter
+		want   string
+	}{
+		{
+			name:   "Excludes deleted users by default",
+			filter: filter.Filter{},
+			want:   "SELECT * FROM users WHERE ( status <> 'deleted')",
+		},
+		{
+			name:   "Combines the filters",
+			filter: filter.Filter{FilterStatus: "locked", FilterEmail: "Example", FilterUsername: "jane"},
+			want:   "SELECT * FROM users WHERE ( status='locked' AND normalized_email LIKE'%example%' AND normalized_username LIKE'%jane%')",
+		},
+		{
+			name:   "Escapes quotes and backslashes",
+			filter: filter.Filter{FilterEmail: `o'brien\`},
+			want:   `SELECT * FROM users WHERE ( status <> 'deleted' AND normalized_email LIKE'%o&#39;brien\\%')`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listConditions(tt.filter).BindSQL(listUsersQuery); got != tt.want {
+				t.Errorf("listConditions() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
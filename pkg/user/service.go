@@ -0,0 +1,527 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/code-and-chill/auth-api/pkg/filter"
+	"github.com/code-and-chill/auth-api/pkg/passwordpolicy"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// Listing page sizes used when a filter has no valid limit, or a larger one.
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// PasswordHasher hashes passwords before they are stored as credentials, and verifies them.
+// password.Hasher implements it.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password.
+	Hash(password string) (string, error)
+
+	// Verify checks password against an encoded hash, in constant time.
+	Verify(password, encoded string) (bool, error)
+
+	// NeedsRehash checks whether encoded should be replaced by a hash with current parameters.
+	NeedsRehash(encoded string) bool
+}
+
+// Registration holds what a new user registers with. Username is optional.
+type Registration struct {
+	Email    string
+	Username string
+	Name     string
+	Password string
+}
+
+// Service manages user accounts.
+type Service interface {
+	// Register creates a user and its password credential in one transaction. The password
+	// must follow the password policy, which returns a *passwordpolicy.Error otherwise.
+	Register(ctx context.Context, registration Registration) (*User, error)
+
+	// FindByID finds a user by ID.
+	FindByID(ctx context.Context, id string) (*User, error)
+
+	// FindByLogin finds a user by email or username, compared in their normalized form.
+	FindByLogin(ctx context.Context, login string) (*User, error)
+
+	// Authenticate finds the user identified by login, as FindByLogin, and verifies password.
+	// Unknown and deleted users take as long as other users and return ErrInvalidCredentials
+	// too, so responses do not reveal which logins exist. The status of the user is not checked.
+	Authenticate(ctx context.Context, login, password string) (*User, error)
+
+	// VerifyPassword checks password against the password credential of a user. Once verified,
+	// a hash with outdated parameters or a legacy algorithm is replaced by a current one.
+	VerifyPassword(ctx context.Context, userID, password string) (bool, error)
+
+	// ChangePassword replaces the password of a user once current is verified, or returns
+	// ErrInvalidCredentials. The new password must follow the password policy, which returns a
+	// *passwordpolicy.Error otherwise.
+	ChangePassword(ctx context.Context, userID, current, password string) error
+
+	// CheckPassword checks password against the password policy and the previous passwords of
+	// a user, as ChangePassword and ResetPassword would, without replacing anything.
+	CheckPassword(ctx context.Context, userID, password string) error
+
+	// ResetPassword replaces the password of a user without verifying the current one, once
+	// the user proved their identity otherwise, e.g. with a reset link sent by email. The new
+	// password must follow the password policy, which returns a *passwordpolicy.Error otherwise.
+	ResetPassword(ctx context.Context, userID, password string) error
+
+	// CheckEmail checks email is a valid address no user is registered with, as Register and
+	// ChangeEmail would, without changing anything.
+	CheckEmail(ctx context.Context, email string) error
+
+	// VerifyEmail records the email of a user as verified, once the user proved they own it,
+	// e.g. with a link sent to it. It returns ErrEmailChanged when email is not the email of
+	// the user anymore.
+	VerifyEmail(ctx context.Context, userID, email string) (*User, error)
+
+	// ChangeEmail replaces the email of a user by email, recorded as verified, so it must be an
+	// address the user proved they own. It returns ErrEmailTaken when another user is
+	// registered with it.
+	ChangeEmail(ctx context.Context, userID, email string) (*User, error)
+
+	// SetStatus moves a user to status, or returns ErrInvalidStatus when the lifecycle does
+	// not allow it.
+	SetStatus(ctx context.Context, id string, status Status) (*User, error)
+
+	// List returns a page of the users matching f. The limit and offset keys of f select the
+	// page.
+	List(ctx context.Context, f filter.Filter) (*Page, error)
+}
+
+type service struct {
+	store         Store
+	txProvider    transaction.Provider
+	hasher        PasswordHasher
+	timegen       timegenerator.TimeGenerator
+	initialStatus Status
+	policy        passwordpolicy.Checker
+
+	// dummyHash is verified for unknown users, so they take as long as others.
+	dummyHashOnce sync.Once
+	dummyHash     string
+	dummyHashErr  error
+}
+
+// ServiceOption configures optional behaviour of the Service.
+type ServiceOption func(*service)
+
+// WithInitialStatus sets the status of newly registered users, StatusActive by default. Use
+// StatusPending when users must be activated before they can authenticate.
+func WithInitialStatus(status Status) ServiceOption {
+	return func(s *service) {
+		s.initialStatus = status
+	}
+}
+
+// WithPasswordPolicy checks new passwords with policy. Without it, any password is accepted.
+func WithPasswordPolicy(policy passwordpolicy.Checker) ServiceOption {
+	return func(s *service) {
+		s.policy = policy
+	}
+}
+
+// NewService instantiates a new user Service.
+func NewService(store Store, txProvider transaction.Provider, hasher PasswordHasher,
+	timegen timegenerator.TimeGenerator, options ...ServiceOption) Service {
+	s := &service{
+		store:         store,
+		txProvider:    txProvider,
+		hasher:        hasher,
+		timegen:       timegen,
+		initialStatus: StatusActive,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func (s *service) Register(ctx context.Context, registration Registration) (*User, error) {
+	email := strings.TrimSpace(registration.Email)
+	if err := validateEmail(email); err != nil {
+		return nil, err
+	}
+	user := &User{
+		Email:           email,
+		NormalizedEmail: NormalizeEmail(email),
+		Username:        strings.TrimSpace(registration.Username),
+		Name:            strings.TrimSpace(registration.Name),
+		Status:          s.initialStatus,
+	}
+	if user.Username != "" {
+		normalized := NormalizeUsername(user.Username)
+		if err := validateUsername(normalized); err != nil {
+			return nil, err
+		}
+		user.NormalizedUsername = &normalized
+	}
+	if err := s.checkAvailable(ctx, user); err != nil {
+		return nil, err
+	}
+	if err := s.checkPassword(ctx, registration.Password, user, nil); err != nil {
+		return nil, err
+	}
+
+	secret, err := s.hasher.Hash(registration.Password)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	id, err := securetoken.NewID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	credentialID, err := securetoken.NewID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	now := s.timegen.Now().UTC()
+	user.ID = id
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	credential := &Credential{
+		ID:        credentialID,
+		UserID:    id,
+		Type:      CredentialTypePassword,
+		Secret:    secret,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	_, err = s.txProvider.WithTransaction(ctx, transaction.UnitOfWork{
+		Execute: func(ctx context.Context, data interface{}) (interface{}, error) {
+			return nil, s.store.Create(ctx, data.(*User))
+		},
+		Data: user,
+	}, transaction.UnitOfWork{
+		Execute: func(ctx context.Context, data interface{}) (interface{}, error) {
+			return nil, s.store.CreateCredential(ctx, data.(*Credential))
+		},
+		Data: credential,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return user, nil
+}
+
+// checkAvailable checks the identifiers of user are not registered yet. The store enforces
+// uniqueness too, this only reports the common case without a failed transaction.
+func (s *service) checkAvailable(ctx context.Context, user *User) error {
+	if _, err := s.store.FindByEmail(ctx, user.NormalizedEmail); err == nil {
+		return errors.WithStack(ErrEmailTaken)
+	} else if !errors.Is(err, ErrNotFound) {
+		return errors.WithStack(err)
+	}
+	if user.NormalizedUsername == nil {
+		return nil
+	}
+	if _, err := s.store.FindByUsername(ctx, *user.NormalizedUsername); err == nil {
+		return errors.WithStack(ErrUsernameTaken)
+	} else if !errors.Is(err, ErrNotFound) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (s *service) FindByID(ctx context.Context, id string) (*User, error) {
+	user, err := s.store.FindByID(ctx, id)
+	return user, errors.WithStack(err)
+}
+
+func (s *service) FindByLogin(ctx context.Context, login string) (*User, error) {
+	if strings.Contains(login, "@") {
+		user, err := s.store.FindByEmail(ctx, NormalizeEmail(login))
+		return user, errors.WithStack(err)
+	}
+	user, err := s.store.FindByUsername(ctx, NormalizeUsername(login))
+	return user, errors.WithStack(err)
+}
+
+func (s *service) Authenticate(ctx context.Context, login, password string) (*User, error) {
+	user, err := s.FindByLogin(ctx, login)
+	if errors.Is(err, ErrNotFound) || (err == nil && user.Status == StatusDeleted) {
+		if err := s.verifyDummy(password); err != nil {
+			return nil, err
+		}
+		return nil, errors.WithStack(ErrInvalidCredentials)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	ok, err := s.VerifyPassword(ctx, user.ID, password)
+	if errors.Is(err, ErrNotFound) {
+		// Users without a password, e.g. passkey-only ones, cannot log in with one. They take as
+		// long as others, so responses do not reveal which accounts have no password.
+		if err := s.verifyDummy(password); err != nil {
+			return nil, err
+		}
+		return nil, errors.WithStack(ErrInvalidCredentials)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !ok {
+		return nil, errors.WithStack(ErrInvalidCredentials)
+	}
+	return user, nil
+}
+
+// verifyDummy verifies password against a hash of a random password with the current
+// parameters, as long as the verification of an actual password takes.
+func (s *service) verifyDummy(password string) error {
+	s.dummyHashOnce.Do(func() {
+		random, err := securetoken.New(securetoken.DefaultSize)
+		if err != nil {
+			s.dummyHashErr = errors.WithStack(err)
+			return
+		}
+		s.dummyHash, s.dummyHashErr = s.hasher.Hash(random)
+	})
+	if s.dummyHashErr != nil {
+		return errors.WithStack(s.dummyHashErr)
+	}
+	_, err := s.hasher.Verify(password, s.dummyHash)
+	return errors.WithStack(err)
+}
+
+func (s *service) VerifyPassword(ctx context.Context, userID, password string) (bool, error) {
+	credential, err := s.store.FindCredential(ctx, userID, CredentialTypePassword)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	ok, err := s.hasher.Verify(password, credential.Secret)
+	if err != nil || !ok {
+		return false, errors.WithStack(err)
+	}
+	if !s.hasher.NeedsRehash(credential.Secret) {
+		return true, nil
+	}
+	secret, err := s.hasher.Hash(password)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if err := s.store.UpdateCredential(ctx, credential.ID, secret, s.timegen.Now().UTC()); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
+
+func (s *service) ChangePassword(ctx context.Context, userID, current, password string) error {
+	user, credential, err := s.findPassword(ctx, userID)
+	if err != nil {
+		return err
+	}
+	ok, err := s.hasher.Verify(current, credential.Secret)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !ok {
+		return errors.WithStack(ErrInvalidCredentials)
+	}
+	return s.replacePassword(ctx, user, credential, password)
+}
+
+func (s *service) CheckPassword(ctx context.Context, userID, password string) error {
+	user, credential, err := s.findPassword(ctx, userID)
+	if err != nil {
+		return err
+	}
+	history, err := s.passwordHistory(ctx, user, credential)
+	if err != nil {
+		return err
+	}
+	return s.checkPassword(ctx, password, user, history)
+}
+
+func (s *service) ResetPassword(ctx context.Context, userID, password string) error {
+	user, credential, err := s.findPassword(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.replacePassword(ctx, user, credential, password)
+}
+
+// findPassword finds a user and its password credential, or returns ErrInvalidCredentials
+// when the user has no password.
+func (s *service) findPassword(ctx context.Context, userID string) (*User, *Credential, error) {
+	user, err := s.store.FindByID(ctx, userID)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	credential, err := s.store.FindCredential(ctx, userID, CredentialTypePassword)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, errors.WithStack(ErrInvalidCredentials)
+	}
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return user, credential, nil
+}
+
+// passwordHistory returns the hashes of the current and previous passwords of user the policy
+// forbids reusing.
+func (s *service) passwordHistory(ctx context.Context, user *User, credential *Credential) ([]string, error) {
+	history := []string{credential.Secret}
+	if s.policy != nil && s.policy.HistorySize() > 1 {
+		previous, err := s.store.ListPasswordHistory(ctx, user.ID, s.policy.HistorySize()-1)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, entry := range previous {
+			history = append(history, entry.Secret)
+		}
+	}
+	return history, nil
+}
+
+// replacePassword checks password against the policy and the previous passwords of user, then
+// replaces the secret of credential, keeping the replaced hash in the history.
+func (s *service) replacePassword(ctx context.Context, user *User, credential *Credential, password string) error {
+	history, err := s.passwordHistory(ctx, user, credential)
+	if err != nil {
+		return err
+	}
+	if err := s.checkPassword(ctx, password, user, history); err != nil {
+		return err
+	}
+	secret, err := s.hasher.Hash(password)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	historyID, err := securetoken.NewID()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	now := s.timegen.Now().UTC()
+	_, err = s.txProvider.WithTransaction(ctx, transaction.UnitOfWork{
+		Execute: func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return nil, s.store.UpdateCredential(ctx, credential.ID, secret, now)
+		},
+	}, transaction.UnitOfWork{
+		Execute: func(ctx context.Context, data interface{}) (interface{}, error) {
+			return nil, s.store.AddPasswordHistory(ctx, data.(*PasswordHistory))
+		},
+		Data: &PasswordHistory{ID: historyID, UserID: user.ID, Secret: credential.Secret, CreatedAt: now},
+	})
+	return errors.WithStack(err)
+}
+
+// checkPassword checks password against the policy, for user whose previous password hashes
+// are history.
+func (s *service) checkPassword(ctx context.Context, password string, user *User, history []string) error {
+	if s.policy == nil {
+		return nil
+	}
+	return s.policy.Check(ctx, password, passwordpolicy.Subject{
+		Email:    user.Email,
+		Username: user.Username,
+		Name:     user.Name,
+		History:  history,
+	})
+}
+
+func (s *service) CheckEmail(ctx context.Context, email string) error {
+	email = strings.TrimSpace(email)
+	if err := validateEmail(email); err != nil {
+		return err
+	}
+	return s.checkAvailable(ctx, &User{NormalizedEmail: NormalizeEmail(email)})
+}
+
+func (s *service) VerifyEmail(ctx context.Context, userID, email string) (*User, error) {
+	user, err := s.store.FindByID(ctx, userID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if user.NormalizedEmail != NormalizeEmail(email) {
+		return nil, errors.WithStack(ErrEmailChanged)
+	}
+	if user.EmailVerified() {
+		return user, nil
+	}
+	return s.updateEmail(ctx, user, user.Email)
+}
+
+func (s *service) ChangeEmail(ctx context.Context, userID, email string) (*User, error) {
+	email = strings.TrimSpace(email)
+	if err := validateEmail(email); err != nil {
+		return nil, err
+	}
+	user, err := s.store.FindByID(ctx, userID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return s.updateEmail(ctx, user, email)
+}
+
+// updateEmail replaces the email of user by email, verified now.
+func (s *service) updateEmail(ctx context.Context, user *User, email string) (*User, error) {
+	now := s.timegen.Now().UTC()
+	normalized := NormalizeEmail(email)
+	if err := s.store.UpdateEmail(ctx, user.ID, email, normalized, &now, now); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	user.Email = email
+	user.NormalizedEmail = normalized
+	user.EmailVerifiedAt = &now
+	user.UpdatedAt = now
+	return user, nil
+}
+
+func (s *service) SetStatus(ctx context.Context, id string, status Status) (*User, error) {
+	user, err := s.store.FindByID(ctx, id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if user.Status == status {
+		return user, nil
+	}
+	if !user.Status.CanTransitionTo(status) {
+		return nil, errors.WithStack(ErrInvalidStatus)
+	}
+	now := s.timegen.Now().UTC()
+	if err := s.store.UpdateStatus(ctx, id, status, now); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	user.Status = status
+	user.UpdatedAt = now
+	return user, nil
+}
+
+func (s *service) List(ctx context.Context, f filter.Filter) (*Page, error) {
+	if status := f[FilterStatus]; status != "" && !Status(status).Valid() {
+		return nil, errors.WithStack(ErrInvalidStatus)
+	}
+	limit, offset := DefaultPageSize, 0
+	if value, ok := f.GetInt("limit").(int); ok && value > 0 {
+		limit = value
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	if value, ok := f.GetInt("offset").(int); ok && value > 0 {
+		offset = value
+	}
+	users, err := s.store.List(ctx, f, limit, offset)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	total, err := s.store.Count(ctx, f)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if users == nil {
+		users = []User{}
+	}
+	return &Page{Users: users, Total: total, Limit: limit, Offset: offset}, nil
+}
@@ -0,0 +1,430 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/filter"
+	"github.com/code-and-chill/auth-api/pkg/passwordpolicy"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// fakeHasher prefixes passwords with "hashed:", and rehashes the ones prefixed with "legacy:".
+type fakeHasher struct{}
+
+func (fakeHasher) Hash(password string) (string, error) {
+	return "hashed:" + password, nil
+}
+
+func (fakeHasher) Verify(password, encoded string) (bool, error) {
+	return encoded == "hashed:"+password || encoded == "legacy:"+password, nil
+}
+
+func (fakeHasher) NeedsRehash(encoded string) bool {
+	return strings.HasPrefix(encoded, "legacy:")
+}
+
+func newTestService(options ...ServiceOption) (Service, Store, *timegenerator.FakeTimeGenerator) {
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryStore()
+	return NewService(store, transaction.NewNoopProvider(), fakeHasher{}, timegen, options...), store, timegen
+}
+
+func register(t *testing.T, users Service, email, username string) *User {
+	t.Helper()
+	user, err := users.Register(context.Background(), Registration{Email: email, Username: username, Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	return user
+}
+
+func TestService_Register(t *testing.T) {
+	t.Run("Creates the user with its password credential", func(t *testing.T) {
+		users, store, timegen := newTestService()
+		user := register(t, users, " Jane.Doe@Example.com ", "Jane_Doe")
+		if user.ID == "" || user.Email != "Jane.Doe@Example.com" || user.NormalizedEmail != "jane.doe@example.com" {
+			t.Errorf("user = %+v, want the trimmed email and its normalized form", user)
+		}
+		if user.Username != "Jane_Doe" || user.NormalizedUsername == nil || *user.NormalizedUsername != "jane_doe" {
+			t.Errorf("user = %+v, want the username and its normalized form", user)
+		}
+		if user.Status != StatusActive || !user.CreatedAt.Equal(timegen.Now()) {
+			t.Errorf("user = %+v, want an active user created now", user)
+		}
+		credential, err := store.FindCredential(context.Background(), user.ID, CredentialTypePassword)
+		if err != nil {
+			t.Fatalf("FindCredential() error = %v", err)
+		}
+		if credential.Secret != "hashed:correct horse" {
+			t.Errorf("secret = %s, want the password hash", credential.Secret)
+		}
+	})
+
+	t.Run("Creates users with the configured initial status", func(t *testing.T) {
+		users, _, _ := newTestService(WithInitialStatus(StatusPending))
+		if user := register(t, users, "jane@example.com", ""); user.Status != StatusPending || user.NormalizedUsername != nil {
+			t.Errorf("user = %+v, want a pending user without username", user)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		email    string
+		username string
+		wantErr  error
+	}{
+		{name: "Rejects an email registered with another case", email: "JANE@example.com", wantErr: ErrEmailTaken},
+		{name: "Rejects a username registered with another case", email: "john@example.com", username: "JANE", wantErr: ErrUsernameTaken},
+		{name: "Rejects an invalid email", email: "Jane <jane@example.com>", wantErr: ErrInvalidEmail},
+		{name: "Rejects an invalid username", email: "john@example.com", username: "j@ne", wantErr: ErrInvalidUsername},
+		{name: "Rejects a short username", email: "john@example.com", username: "jd", wantErr: ErrInvalidUsername},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, _, _ := newTestService()
+			register(t, users, "jane@example.com", "jane")
+			_, err := users.Register(context.Background(), Registration{Email: tt.email, Username: tt.username, Password: "secret"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Register() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_FindByLogin(t *testing.T) {
+	users, _, _ := newTestService()
+	want := register(t, users, "jane@example.com", "jane")
+	for _, login := range []string{"Jane@Example.com", " JANE "} {
+		user, err := users.FindByLogin(context.Background(), login)
+		if err != nil || user.ID != want.ID {
+			t.Errorf("FindByLogin(%q) = %v, %v, want %s", login, user, err, want.ID)
+		}
+	}
+	if _, err := users.FindByLogin(context.Background(), "john"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindByLogin() error = %v, want ErrNotFound", err)
+	}
+}
+
+// countingHasher counts the passwords verified by fakeHasher.
+type countingHasher struct {
+	fakeHasher
+	verified int
+}
+
+func (h *countingHasher) Verify(password, encoded string) (bool, error) {
+	h.verified++
+	return h.fakeHasher.Verify(password, encoded)
+}
+
+func TestService_Authenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		wantErr  error
+	}{
+		{name: "Authenticates with the email", login: "JANE@example.com", password: "correct horse"},
+		{name: "Authenticates with the username", login: "Jane", password: "correct horse"},
+		{name: "Rejects a wrong password", login: "jane", password: "battery staple", wantErr: ErrInvalidCredentials},
+		{name: "Verifies a password for unknown users too", login: "john", password: "correct horse", wantErr: ErrInvalidCredentials},
+		{name: "Verifies a password for users without one too", login: "passkey@example.com", password: "correct horse", wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher := &countingHasher{}
+			timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+			store := NewMemoryStore()
+			users := NewService(store, transaction.NewNoopProvider(), hasher, timegen)
+			jane := register(t, users, "jane@example.com", "jane")
+			passkeyOnly := &User{ID: "passkey-only", Email: "passkey@example.com", NormalizedEmail: "passkey@example.com", Status: StatusActive}
+			if err := store.Create(context.Background(), passkeyOnly); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			user, err := users.Authenticate(context.Background(), tt.login, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && user.ID != jane.ID {
+				t.Errorf("Authenticate() = %+v, want jane", user)
+			}
+			if hasher.verified != 1 {
+				t.Errorf("verified %d passwords, want 1", hasher.verified)
+			}
+		})
+	}
+}
+
+func TestService_VerifyPassword(t *testing.T) {
+	t.Run("Verifies the password", func(t *testing.T) {
+		users, _, _ := newTestService()
+		user := register(t, users, "jane@example.com", "")
+		for password, want := range map[string]bool{"correct horse": true, "battery staple": false} {
+			if ok, err := users.VerifyPassword(context.Background(), user.ID, password); err != nil || ok != want {
+				t.Errorf("VerifyPassword(%q) = %t, %v, want %t", password, ok, err, want)
+			}
+		}
+	})
+
+	t.Run("Rehashes legacy hashes once verified", func(t *testing.T) {
+		users, store, _ := newTestService()
+		user := register(t, users, "jane@example.com", "")
+		credential, err := store.FindCredential(context.Background(), user.ID, CredentialTypePassword)
+		if err != nil {
+			t.Fatalf("FindCredential() error = %v", err)
+		}
+		if err := store.UpdateCredential(context.Background(), credential.ID, "legacy:correct horse", credential.UpdatedAt); err != nil {
+			t.Fatalf("UpdateCredential() error = %v", err)
+		}
+
+		if ok, err := users.VerifyPassword(context.Background(), user.ID, "battery staple"); err != nil || ok {
+			t.Fatalf("VerifyPassword() = %t, %v, want a mismatch", ok, err)
+		}
+		if credential, _ := store.FindCredential(context.Background(), user.ID, CredentialTypePassword); credential.Secret != "legacy:correct horse" {
+			t.Errorf("secret = %s, want the legacy hash kept after a mismatch", credential.Secret)
+		}
+		if ok, err := users.VerifyPassword(context.Background(), user.ID, "correct horse"); err != nil || !ok {
+			t.Fatalf("VerifyPassword() = %t, %v, want a match", ok, err)
+		}
+		if credential, _ := store.FindCredential(context.Background(), user.ID, CredentialTypePassword); credential.Secret != "hashed:correct horse" {
+			t.Errorf("secret = %s, want the rehashed password", credential.Secret)
+		}
+	})
+}
+
+// testPolicy rejects passwords shorter than 8 characters and the last 3 ones.
+var testPolicy = passwordpolicy.Policy{MinLength: 8, HistorySize: 3}
+
+func TestService_ChangePassword(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Replaces the password and keeps the previous one", func(t *testing.T) {
+		users, store, _ := newTestService(WithPasswordPolicy(passwordpolicy.NewChecker(testPolicy, fakeHasher{})))
+		user := register(t, users, "jane@example.com", "")
+		if err := users.ChangePassword(ctx, user.ID, "correct horse", "battery staple"); err != nil {
+			t.Fatalf("ChangePassword() error = %v", err)
+		}
+		if ok, _ := users.VerifyPassword(ctx, user.ID, "battery staple"); !ok {
+			t.Error("VerifyPassword() = false, want the new password verified")
+		}
+		history, err := store.ListPasswordHistory(ctx, user.ID, 10)
+		if err != nil || len(history) != 1 || history[0].Secret != "hashed:correct horse" {
+			t.Errorf("ListPasswordHistory() = %+v, %v, want the previous hash", history, err)
+		}
+	})
+
+	t.Run("Rejects a wrong current password", func(t *testing.T) {
+		users, _, _ := newTestService()
+		user := register(t, users, "jane@example.com", "")
+		if err := users.ChangePassword(ctx, user.ID, "wrong", "battery staple"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("ChangePassword() error = %v, want ErrInvalidCredentials", err)
+		}
+	})
+
+	t.Run("Rejects the last passwords", func(t *testing.T) {
+		users, _, timegen := newTestService(WithPasswordPolicy(passwordpolicy.NewChecker(testPolicy, fakeHasher{})))
+		user := register(t, users, "jane@example.com", "")
+		current := "correct horse"
+		for _, next := range []string{"password two", "password three", "password four"} {
+			timegen.Add(time.Minute)
+			if err := users.ChangePassword(ctx, user.ID, current, next); err != nil {
+				t.Fatalf("ChangePassword() error = %v", err)
+			}
+			current = next
+		}
+		for _, reused := range []string{"password four", "password three", "password two"} {
+			var policyErr *passwordpolicy.Error
+			err := users.ChangePassword(ctx, user.ID, current, reused)
+			if !errors.As(err, &policyErr) || policyErr.Violations[0].Code != passwordpolicy.CodeReused {
+				t.Errorf("ChangePassword(%q) error = %v, want a reused password", reused, err)
+			}
+		}
+		if err := users.ChangePassword(ctx, user.ID, current, "correct horse"); err != nil {
+			t.Errorf("ChangePassword() error = %v, want a password out of the history accepted", err)
+		}
+	})
+
+	t.Run("Rejects a registration with a weak password", func(t *testing.T) {
+		users, _, _ := newTestService(WithPasswordPolicy(passwordpolicy.NewChecker(testPolicy, fakeHasher{})))
+		_, err := users.Register(ctx, Registration{Email: "jane@example.com", Password: "short"})
+		var policyErr *passwordpolicy.Error
+		if !errors.As(err, &policyErr) {
+			t.Errorf("Register() error = %v, want a *passwordpolicy.Error", err)
+		}
+	})
+}
+
+func TestService_VerifyEmail(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Verifies the current email", func(t *testing.T) {
+		users, _, _ := newTestService()
+		user := register(t, users, "jane@example.com", "")
+		if user.EmailVerified() {
+			t.Fatal("EmailVerified() = true, want registered emails unverified")
+		}
+		if _, err := users.VerifyEmail(ctx, user.ID, "Jane@Example.com"); err != nil {
+			t.Fatalf("VerifyEmail() error = %v", err)
+		}
+		found, err := users.FindByID(ctx, user.ID)
+		if err != nil || !found.EmailVerified() {
+			t.Errorf("FindByID() = %+v, %v, want the email verified", found, err)
+		}
+	})
+
+	t.Run("Rejects a previous email", func(t *testing.T) {
+		users, _, _ := newTestService()
+		user := register(t, users, "jane@example.com", "")
+		if _, err := users.ChangeEmail(ctx, user.ID, "jane@example.org"); err != nil {
+			t.Fatalf("ChangeEmail() error = %v", err)
+		}
+		if _, err := users.VerifyEmail(ctx, user.ID, "jane@example.com"); !errors.Is(err, ErrEmailChanged) {
+			t.Errorf("VerifyEmail() error = %v, want ErrEmailChanged", err)
+		}
+	})
+}
+
+func TestService_ChangeEmail(t *testing.T) {
+	ctx := context.Background()
+	users, _, _ := newTestService()
+	jane := register(t, users, "jane@example.com", "")
+	register(t, users, "john@example.com", "")
+
+	if _, err := users.ChangeEmail(ctx, jane.ID, "John@Example.com"); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("ChangeEmail() error = %v, want ErrEmailTaken", err)
+	}
+	if _, err := users.ChangeEmail(ctx, jane.ID, "Jane <jane@example.org>"); !errors.Is(err, ErrInvalidEmail) {
+		t.Errorf("ChangeEmail() error = %v, want ErrInvalidEmail", err)
+	}
+	changed, err := users.ChangeEmail(ctx, jane.ID, " jane@example.org ")
+	if err != nil {
+		t.Fatalf("ChangeEmail() error = %v", err)
+	}
+	found, err := users.FindByLogin(ctx, "JANE@example.org")
+	if err != nil || found.ID != jane.ID || found.Email != "jane@example.org" || !changed.EmailVerified() {
+		t.Errorf("FindByLogin() = %+v, %v, want jane with her new verified email", found, err)
+	}
+	if err := users.CheckEmail(ctx, "jane@example.com"); err != nil {
+		t.Errorf("CheckEmail() error = %v, want the previous email available", err)
+	}
+}
+
+func TestClaimsProvider(t *testing.T) {
+	ctx := context.Background()
+	users, _, _ := newTestService()
+	user := register(t, users, "jane@example.com", "")
+	provider := NewClaimsProvider(users)
+
+	if claims, err := provider.SubjectClaims(ctx, user.ID); err != nil || claims[ClaimEmailVerified] != false {
+		t.Errorf("SubjectClaims() = %v, %v, want email_verified false", claims, err)
+	}
+	if _, err := users.VerifyEmail(ctx, user.ID, user.Email); err != nil {
+		t.Fatalf("VerifyEmail() error = %v", err)
+	}
+	if claims, err := provider.SubjectClaims(ctx, user.ID); err != nil || claims[ClaimEmailVerified] != true {
+		t.Errorf("SubjectClaims() = %v, %v, want email_verified true", claims, err)
+	}
+	if claims, err := provider.SubjectClaims(ctx, "client"); err != nil || claims != nil {
+		t.Errorf("SubjectClaims() = %v, %v, want no claims for other subjects", claims, err)
+	}
+}
+
+func TestService_SetStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    []Status
+		wantErr error
+	}{
+		{name: "Locks and unlocks a user", path: []Status{StatusLocked, StatusActive}},
+		{name: "Disables and deletes a user", path: []Status{StatusDisabled, StatusDeleted}},
+		{name: "Keeps the current status", path: []Status{StatusActive}},
+		{name: "Rejects restoring a deleted user", path: []Status{StatusDeleted, StatusActive}, wantErr: ErrInvalidStatus},
+		{name: "Rejects locking a disabled user", path: []Status{StatusDisabled, StatusLocked}, wantErr: ErrInvalidStatus},
+		{name: "Rejects an unknown status", path: []Status{Status("archived")}, wantErr: ErrInvalidStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, _, timegen := newTestService()
+			user := register(t, users, "jane@example.com", "")
+			var err error
+			for _, status := range tt.path {
+				timegen.Add(time.Minute)
+				if _, err = users.SetStatus(context.Background(), user.ID, status); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetStatus() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			stored, err := users.FindByID(context.Background(), user.ID)
+			if err != nil {
+				t.Fatalf("FindByID() error = %v", err)
+			}
+			if want := tt.path[len(tt.path)-1]; stored.Status != want {
+				t.Errorf("status = %s, want %s", stored.Status, want)
+			}
+		})
+	}
+}
+
+func TestService_List(t *testing.T) {
+	users, _, timegen := newTestService()
+	var ids []string
+	for _, email := range []string{"ann@example.com", "bob@example.com", "carol@example.org", "dan@example.org"} {
+		timegen.Add(time.Minute)
+		ids = append(ids, register(t, users, email, email[:3]+"-user").ID)
+	}
+	if _, err := users.SetStatus(context.Background(), ids[1], StatusLocked); err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	if _, err := users.SetStatus(context.Background(), ids[3], StatusDeleted); err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		filter    filter.Filter
+		wantIDs   []string
+		wantTotal int
+		wantLimit int
+	}{
+		{name: "Lists users but deleted ones", filter: filter.Filter{}, wantIDs: ids[:3], wantTotal: 3, wantLimit: DefaultPageSize},
+		{name: "Filters by status", filter: filter.Filter{FilterStatus: "deleted"}, wantIDs: ids[3:], wantTotal: 1, wantLimit: DefaultPageSize},
+		{name: "Filters by part of the email", filter: filter.Filter{FilterEmail: "Example.COM"}, wantIDs: ids[:2], wantTotal: 2, wantLimit: DefaultPageSize},
+		{name: "Filters by part of the username", filter: filter.Filter{FilterUsername: "car"}, wantIDs: ids[2:3], wantTotal: 1, wantLimit: DefaultPageSize},
+		{name: "Pages results", filter: filter.Filter{"limit": "1", "offset": "1"}, wantIDs: ids[1:2], wantTotal: 3, wantLimit: 1},
+		{name: "Caps the page size", filter: filter.Filter{"limit": "1000"}, wantIDs: ids[:3], wantTotal: 3, wantLimit: MaxPageSize},
+		{name: "Returns an empty page past the end", filter: filter.Filter{"offset": "10"}, wantIDs: []string{}, wantTotal: 3, wantLimit: DefaultPageSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := users.List(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			gotIDs := []string{}
+			for _, user := range page.Users {
+				gotIDs = append(gotIDs, user.ID)
+			}
+			if len(gotIDs) != len(tt.wantIDs) || page.Total != tt.wantTotal || page.Limit != tt.wantLimit {
+				t.Fatalf("page = %v total %d limit %d, want %v total %d limit %d", gotIDs, page.Total, page.Limit, tt.wantIDs, tt.wantTotal, tt.wantLimit)
+			}
+			for i := range gotIDs {
+				if gotIDs[i] != tt.wantIDs[i] {
+					t.Errorf("page = %v, want %v", gotIDs, tt.wantIDs)
+				}
+			}
+		})
+	}
+
+	if _, err := users.List(context.Background(), filter.Filter{FilterStatus: "archived"}); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("List() error = %v, want ErrInvalidStatus", err)
+	}
+}
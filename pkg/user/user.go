@@ -0,0 +1,215 @@
+// Package user manages end-user accounts: their registration, credentials and status lifecycle.
+package user
+
+import (
+	"context"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/filter"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound indicates the user or credential does not exist.
+	ErrNotFound = errors.New("user is not found")
+	// ErrEmailTaken indicates another user is registered with the same normalized email.
+	ErrEmailTaken = errors.New("email is already registered")
+	// ErrUsernameTaken indicates another user is registered with the same normalized username.
+	ErrUsernameTaken = errors.New("username is already registered")
+	// ErrInvalidEmail indicates the email is not a valid address.
+	ErrInvalidEmail = errors.New("email is invalid")
+	// ErrInvalidUsername indicates the username does not match the allowed format.
+	ErrInvalidUsername = errors.New("username is invalid")
+	// ErrInvalidCredentials indicates the login or the password is wrong, without telling which.
+	ErrInvalidCredentials = errors.New("credentials are invalid")
+	// ErrInvalidStatus indicates the status is unknown, or cannot follow the current status.
+	ErrInvalidStatus = errors.New("status transition is not allowed")
+	// ErrEmailChanged indicates the email of the user is not the one being verified anymore.
+	ErrEmailChanged = errors.New("email has changed")
+)
+
+// ClaimEmailVerified is the claim of tokens telling whether the email of their subject is
+// verified, as defined by OpenID Connect Core 5.1.
+const ClaimEmailVerified = "email_verified"
+
+// Status represents the lifecycle status of a user.
+type Status string
+
+const (
+	// StatusPending represents a registered user who has not been activated yet.
+	StatusPending = Status("pending")
+	// StatusActive represents a user who can authenticate.
+	StatusActive = Status("active")
+	// StatusLocked represents a user temporarily prevented from authenticating, e.g. after
+	// too many failed attempts.
+	StatusLocked = Status("locked")
+	// StatusDisabled represents a user prevented from authenticating by an administrator.
+	StatusDisabled = Status("disabled")
+	// StatusDeleted represents a removed user. Deleted users keep their row, so their ID and
+	// identifiers are never reused, but cannot come back.
+	StatusDeleted = Status("deleted")
+)
+
+// transitions lists the statuses each status can change to.
+var transitions = map[Status][]Status{
+	StatusPending:  {StatusActive, StatusDisabled, StatusDeleted},
+	StatusActive:   {StatusLocked, StatusDisabled, StatusDeleted},
+	StatusLocked:   {StatusActive, StatusDisabled, StatusDeleted},
+	StatusDisabled: {StatusActive, StatusDeleted},
+	StatusDeleted:  {},
+}
+
+// Valid checks whether s is a known status.
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransitionTo checks whether a user with status s can be moved to next.
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// User represents a stored user account. Email and username are unique once normalized.
+type User struct {
+	ID              string `db:"id" json:"id"`
+	Email           string `db:"email" json:"email"`
+	NormalizedEmail string `db:"normalized_email" json:"-"`
+	// EmailVerifiedAt is when the user proved they own Email, or nil until they do.
+	EmailVerifiedAt    *time.Time `db:"email_verified_at" json:"email_verified_at,omitempty"`
+	Username           string     `db:"username" json:"username,omitempty"`
+	NormalizedUsername *string    `db:"normalized_username" json:"-"`
+	Name               string     `db:"name" json:"name,omitempty"`
+	Status             Status     `db:"status" json:"status"`
+	CreatedAt          time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt          time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// EmailVerified checks whether the user proved they own their email.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// CredentialType represents the kind of secret a credential holds.
+type CredentialType string
+
+// CredentialTypePassword represents a password credential, whose secret is a password hash.
+const CredentialTypePassword = CredentialType("password")
+
+// Credential represents a secret a user authenticates with.
+type Credential struct {
+	ID        string         `db:"id"`
+	UserID    string         `db:"user_id"`
+	Type      CredentialType `db:"type"`
+	Secret    string         `db:"secret"`
+	CreatedAt time.Time      `db:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at"`
+}
+
+// PasswordHistory is a previous password hash of a user, kept to prevent its reuse.
+type PasswordHistory struct {
+	ID        string    `db:"id"`
+	UserID    string    `db:"user_id"`
+	Secret    string    `db:"secret"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Page is a page of users matching a listing filter.
+type Page struct {
+	Users  []User `json:"users"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// Store persists users and their credentials.
+type Store interface {
+	// Create stores a new user. It returns ErrEmailTaken or ErrUsernameTaken when another
+	// user has the same normalized identifier.
+	Create(ctx context.Context, user *User) error
+
+	// FindByID finds a user by ID.
+	FindByID(ctx context.Context, id string) (*User, error)
+
+	// FindByEmail finds a user by normalized email.
+	FindByEmail(ctx context.Context, normalizedEmail string) (*User, error)
+
+	// FindByUsername finds a user by normalized username.
+	FindByUsername(ctx context.Context, normalizedUsername string) (*User, error)
+
+	// UpdateStatus changes the status of a user.
+	UpdateStatus(ctx context.Context, id string, status Status, updatedAt time.Time) error
+
+	// UpdateEmail replaces the email of a user, verified at verifiedAt or unverified when nil.
+	// It returns ErrEmailTaken when another user has the same normalized email.
+	UpdateEmail(ctx context.Context, id, email, normalizedEmail string, verifiedAt *time.Time, updatedAt time.Time) error
+
+	// List finds at most limit users matching f, skipping the first offset ones.
+	List(ctx context.Context, f filter.Filter, limit, offset int) ([]User, error)
+
+	// Count counts the users matching f.
+	Count(ctx context.Context, f filter.Filter) (int, error)
+
+	// CreateCredential stores a new credential.
+	CreateCredential(ctx context.Context, credential *Credential) error
+
+	// FindCredential finds the credential of a user of the given type.
+	FindCredential(ctx context.Context, userID string, credentialType CredentialType) (*Credential, error)
+
+	// UpdateCredential replaces the secret of a credential.
+	UpdateCredential(ctx context.Context, id, secret string, updatedAt time.Time) error
+
+	// AddPasswordHistory stores a previous password hash.
+	AddPasswordHistory(ctx context.Context, history *PasswordHistory) error
+
+	// ListPasswordHistory finds the last limit previous password hashes of a user, newest
+	// first.
+	ListPasswordHistory(ctx context.Context, userID string, limit int) ([]PasswordHistory, error)
+}
+
+// Filter keys supported by user listings. FilterStatus matches exactly, while FilterEmail and
+// FilterUsername match part of the normalized identifier. Deleted users are only listed when
+// FilterStatus asks for them.
+const (
+	FilterStatus   = "status"
+	FilterEmail    = "email"
+	FilterUsername = "username"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,31}$`)
+
+// NormalizeEmail returns the form of email compared for uniqueness.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeUsername returns the form of username compared for uniqueness.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// validateEmail checks that email is a bare address, without a display name.
+func validateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return errors.WithStack(ErrInvalidEmail)
+	}
+	return nil
+}
+
+// validateUsername checks that the normalized username has 3 to 32 letters, digits, dots,
+// dashes or underscores, starting with a letter or digit.
+func validateUsername(normalizedUsername string) error {
+	if !usernamePattern.MatchString(normalizedUsername) {
+		return errors.WithStack(ErrInvalidUsername)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/asn1"
+)
+
+// Attestation statement formats this relying party verifies.
+const (
+	FormatNone    = "none"
+	FormatPacked  = "packed"
+	FormatFIDOU2F = "fido-u2f"
+)
+
+// Attestation types, recorded with credentials. AttestationTypeBasic statements are signed by
+// an attestation certificate, which is verified but not chained to a trust anchor, so they
+// tell which authenticator model the manufacturer claims, without proving it.
+const (
+	AttestationTypeNone  = "none"
+	AttestationTypeSelf  = "self"
+	AttestationTypeBasic = "basic"
+)
+
+// oidAAGUID is the extension of packed attestation certificates holding the AAGUID.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// verifyAttestation verifies the attestation statement of format over authData and
+// clientDataHash, for the credential parsed from authData with key. It returns the attestation
+// type.
+func verifyAttestation(format string, statement map[interface{}]interface{}, authData []byte,
+	parsed *authenticatorData, clientDataHash []byte, key *publicKey) (string, error) {
+	switch format {
+	case FormatNone:
+		if len(statement) != 0 {
+			return "", newVerificationError("none attestation has a statement")
+		}
+		return AttestationTypeNone, nil
+	case FormatPacked:
+		return verifyPacked(statement, authData, parsed, clientDataHash, key)
+	case FormatFIDOU2F:
+		return verifyFIDOU2F(statement, parsed, clientDataHash, key)
+	}
+	return "", newVerificationError("attestation format is not supported")
+}
+
+func verifyPacked(statement map[interface{}]interface{}, authData []byte, parsed *authenticatorData,
+	clientDataHash []byte, key *publicKey) (string, error) {
+	algorithm, ok := statement["alg"].(int64)
+	signature, hasSignature := statement["sig"].([]byte)
+	if !ok || !hasSignature {
+		return "", newVerificationError("packed attestation misses alg or sig")
+	}
+	signed := append(append([]byte(nil), authData...), clientDataHash...)
+
+	if _, ok := statement["x5c"]; !ok {
+		if algorithm != key.algorithm {
+			return "", newVerificationError("self attestation uses another algorithm than the credential")
+		}
+		if err := key.verify(signed, signature); err != nil {
+			return "", newVerificationError("self attestation signature is invalid")
+		}
+		return AttestationTypeSelf, nil
+	}
+
+	cert, err := attestationCertificate(statement)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyCertificateSignature(algorithm, cert, signed, signature); err != nil {
+		return "", newVerificationError("packed attestation signature is invalid")
+	}
+	// Requirements of WebAuthn §8.2.1 on packed attestation certificates.
+	if cert.Version != 3 || cert.IsCA || len(cert.Subject.OrganizationalUnit) != 1 ||
+		cert.Subject.OrganizationalUnit[0] != "Authenticator Attestation" ||
+		len(cert.Subject.Country) == 0 || len(cert.Subject.Organization) == 0 || cert.Subject.CommonName == "" {
+		return "", newVerificationError("packed attestation certificate does not meet the requirements")
+	}
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(oidAAGUID) {
+			continue
+		}
+		var aaguid []byte
+		if extension.Critical {
+			return "", newVerificationError("packed attestation AAGUID extension is critical")
+		}
+		if _, err := asn1.Unmarshal(extension.Value, &aaguid); err != nil || !bytes.Equal(aaguid, parsed.aaguid) {
+			return "", newVerificationError("packed attestation certificate is of another authenticator model")
+		}
+	}
+	return AttestationTypeBasic, nil
+}
+
+func verifyFIDOU2F(statement map[interface{}]interface{}, parsed *authenticatorData, clientDataHash []byte,
+	key *publicKey) (string, error) {
+	signature, ok := statement["sig"].([]byte)
+	chain, _ := statement["x5c"].([]interface{})
+	if !ok || len(chain) != 1 {
+		return "", newVerificationError("fido-u2f attestation needs sig and a single certificate")
+	}
+	cert, err := attestationCertificate(statement)
+	if err != nil {
+		return "", err
+	}
+	certKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || certKey.Curve != elliptic.P256() {
+		return "", newVerificationError("fido-u2f attestation certificate key is not P-256")
+	}
+	credentialKey, ok := key.key.(*ecdsa.PublicKey)
+	if !ok || key.algorithm != AlgorithmES256 {
+		return "", newVerificationError("fido-u2f credential key is not P-256")
+	}
+	// The public key in the uncompressed ANSI X9.62 form U2F signs.
+	publicKeyU2F := elliptic.Marshal(elliptic.P256(), credentialKey.X, credentialKey.Y)
+	var signed []byte
+	signed = append(signed, 0x00)
+	signed = append(signed, parsed.rpIDHash...)
+	signed = append(signed, clientDataHash...)
+	signed = append(signed, parsed.credentialID...)
+	signed = append(signed, publicKeyU2F...)
+	if err := verifySignature(AlgorithmES256, certKey, signed, signature); err != nil {
+		return "", newVerificationError("fido-u2f attestation signature is invalid")
+	}
+	return AttestationTypeBasic, nil
+}
+
+// attestationCertificate parses the attestation certificate, first of the x5c chain.
+func attestationCertificate(statement map[interface{}]interface{}) (*x509.Certificate, error) {
+	chain, _ := statement["x5c"].([]interface{})
+	if len(chain) == 0 {
+		return nil, newVerificationError("attestation certificate chain is empty")
+	}
+	der, ok := chain[0].([]byte)
+	if !ok {
+		return nil, newVerificationError("attestation certificate is malformed")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, newVerificationError("attestation certificate is malformed")
+	}
+	return cert, nil
+}
@@ -0,0 +1,127 @@
+package webauthn
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Flags of authenticator data.
+const (
+	FlagUserPresent    = byte(1 << 0)
+	FlagUserVerified   = byte(1 << 2)
+	FlagBackupEligible = byte(1 << 3)
+	FlagBackedUp       = byte(1 << 4)
+	FlagAttestedData   = byte(1 << 6)
+	FlagExtensionData  = byte(1 << 7)
+)
+
+// maxCredentialIDLength is the longest credential ID WebAuthn allows.
+const maxCredentialIDLength = 1023
+
+// ErrMalformedAuthenticatorData indicates authenticator data which cannot be parsed.
+var ErrMalformedAuthenticatorData = errors.New("authenticator data is malformed")
+
+// authenticatorData is parsed authenticator data.
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// aaguid, credentialID and publicKey are the attested credential data, set when
+	// FlagAttestedData is.
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData parses authenticator data. Extensions are skipped.
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.WithStack(ErrMalformedAuthenticatorData)
+	}
+	parsed := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+	if parsed.flags&FlagAttestedData != 0 {
+		if len(rest) < 18 {
+			return nil, errors.WithStack(ErrMalformedAuthenticatorData)
+		}
+		parsed.aaguid = rest[:16]
+		length := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if length == 0 || length > maxCredentialIDLength || len(rest) < length {
+			return nil, errors.WithStack(ErrMalformedAuthenticatorData)
+		}
+		parsed.credentialID, rest = rest[:length], rest[length:]
+		// The key is the CBOR item before the extensions, its end is only known by decoding it.
+		_, afterKey, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, errors.WithStack(ErrMalformedAuthenticatorData)
+		}
+		parsed.publicKey, rest = rest[:len(rest)-len(afterKey)], afterKey
+	}
+	if parsed.flags&FlagExtensionData != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, errors.WithStack(ErrMalformedAuthenticatorData)
+		}
+	}
+	if len(rest) != 0 {
+		return nil, errors.WithStack(ErrMalformedAuthenticatorData)
+	}
+	return parsed, nil
+}
+
+// Client data types of the ceremonies.
+const (
+	clientDataTypeCreate = "webauthn.create"
+	clientDataTypeGet    = "webauthn.get"
+)
+
+// clientData is the client data collected by the browser.
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// verifyClientData checks the client data of a ceremony of type for challenge, from one of
+// origins.
+func verifyClientData(data []byte, ceremonyType string, challenge []byte, origins []string) error {
+	var collected clientData
+	if err := json.Unmarshal(data, &collected); err != nil {
+		return newVerificationError("client data is malformed")
+	}
+	if collected.Type != ceremonyType {
+		return newVerificationError("client data is of another ceremony")
+	}
+	received, err := encoding.DecodeString(collected.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return newVerificationError("challenge does not match")
+	}
+	if collected.CrossOrigin {
+		return newVerificationError("cross-origin ceremonies are not allowed")
+	}
+	for _, origin := range origins {
+		if collected.Origin == origin {
+			return nil
+		}
+	}
+	return newVerificationError("origin is not allowed")
+}
+
+// verifyRPIDHash checks authenticator data is scoped to rpID.
+func verifyRPIDHash(data *authenticatorData, rpID string) error {
+	expected := sha256.Sum256([]byte(rpID))
+	if subtle.ConstantTimeCompare(data.rpIDHash, expected[:]) != 1 {
+		return newVerificationError("credential is scoped to another relying party")
+	}
+	return nil
+}
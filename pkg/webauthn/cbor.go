@@ -0,0 +1,144 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// ErrMalformedCBOR indicates CBOR data which cannot be decoded.
+var ErrMalformedCBOR = errors.New("cbor data is malformed")
+
+// maxCBORDepth bounds the nesting of decoded items, so crafted data cannot exhaust the stack.
+const maxCBORDepth = 16
+
+// CBOR major types of RFC 8949.
+const (
+	cborUnsigned = iota
+	cborNegative
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// decodeCBOR decodes the first item of data, the subset of CBOR WebAuthn uses: integers as
+// int64, byte strings as []byte, text strings as string, arrays as []interface{}, maps as
+// map[interface{}]interface{} with int64 or string keys, booleans and null. Indefinite
+// lengths, tags and floats are rejected. It returns the bytes following the item.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, nil, errors.WithStack(ErrMalformedCBOR)
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	if major == cborSimple {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22:
+			return nil, data[1:], nil
+		}
+		return nil, nil, errors.WithStack(ErrMalformedCBOR)
+	}
+	argument, data, err := decodeCBORArgument(info, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.WithStack(ErrMalformedCBOR)
+		}
+		return int64(argument), data, nil
+	case cborNegative:
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.WithStack(ErrMalformedCBOR)
+		}
+		return -1 - int64(argument), data, nil
+	case cborBytes, cborText:
+		if argument > uint64(len(data)) {
+			return nil, nil, errors.WithStack(ErrMalformedCBOR)
+		}
+		value := data[:argument]
+		if major == cborText {
+			return string(value), data[argument:], nil
+		}
+		return append([]byte(nil), value...), data[argument:], nil
+	case cborArray:
+		// Every item takes at least a byte, which bounds allocations by the size of data.
+		if argument > uint64(len(data)) {
+			return nil, nil, errors.WithStack(ErrMalformedCBOR)
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case cborMap:
+		if argument > uint64(len(data)) {
+			return nil, nil, errors.WithStack(ErrMalformedCBOR)
+		}
+		entries := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.WithStack(ErrMalformedCBOR)
+			}
+			if _, duplicate := entries[key]; duplicate {
+				return nil, nil, errors.WithStack(ErrMalformedCBOR)
+			}
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, data, nil
+	}
+	return nil, nil, errors.WithStack(ErrMalformedCBOR)
+}
+
+// decodeCBORArgument decodes the argument of an item from its additional information and the
+// bytes following its initial byte.
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	return 0, nil, errors.WithStack(ErrMalformedCBOR)
+}
+
+// cborMapOf returns item as a CBOR map.
+func cborMapOf(item interface{}) (map[interface{}]interface{}, error) {
+	entries, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.WithStack(ErrMalformedCBOR)
+	}
+	return entries, nil
+}
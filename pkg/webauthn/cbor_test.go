@@ -0,0 +1,57 @@
+package webauthn
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{name: "Decodes a negative integer", data: []byte{0x38, 0x63}, want: int64(-100)},
+		{name: "Decodes a 16 bit integer", data: []byte{0x19, 0x03, 0xe8}, want: int64(1000)},
+		{name: "Decodes a byte string", data: []byte{0x42, 0x01, 0x02}, want: []byte{1, 2}},
+		{name: "Decodes a text string", data: []byte{0x63, 'f', 'm', 't'}, want: "fmt"},
+		{name: "Decodes an array", data: []byte{0x82, 0xf5, 0xf6}, want: []interface{}{true, nil}},
+		{name: "Decodes a map", data: []byte{0xa2, 0x01, 0x02, 0x20, 0x61, 'x'},
+			want: map[interface{}]interface{}{int64(1): int64(2), int64(-1): "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rest, err := decodeCBOR(append(tt.data, 0xff))
+			if err != nil || !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(rest, []byte{0xff}) {
+				t.Errorf("decodeCBOR() = %#v, %v, %v, want %#v", got, rest, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBOR_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Rejects empty data", data: nil},
+		{name: "Rejects a truncated byte string", data: []byte{0x45, 0x01}},
+		{name: "Rejects an array longer than the data", data: []byte{0x9a, 0xff, 0xff, 0xff, 0xff}},
+		{name: "Rejects indefinite lengths", data: []byte{0x9f, 0x01, 0xff}},
+		{name: "Rejects tags", data: []byte{0xc1, 0x01}},
+		{name: "Rejects floats", data: []byte{0xf9, 0x3c, 0x00}},
+		{name: "Rejects byte string keys", data: []byte{0xa1, 0x41, 0x01, 0x01}},
+		{name: "Rejects duplicate keys", data: []byte{0xa2, 0x01, 0x01, 0x01, 0x02}},
+		{name: "Rejects deep nesting", data: []byte{
+			0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x01,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(tt.data); !errors.Is(err, ErrMalformedCBOR) {
+				t.Errorf("decodeCBOR() error = %v, want ErrMalformedCBOR", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// COSE algorithms of the credentials this relying party accepts.
+const (
+	AlgorithmES256 = int64(-7)
+	AlgorithmEdDSA = int64(-8)
+	AlgorithmRS256 = int64(-257)
+)
+
+// SupportedAlgorithms lists the accepted algorithms, in order of preference.
+var SupportedAlgorithms = []int64{AlgorithmES256, AlgorithmEdDSA, AlgorithmRS256}
+
+// COSE key parameters of RFC 9053.
+const (
+	coseKeyType      = int64(1)
+	coseKeyAlgorithm = int64(3)
+	coseKeyCurve     = int64(-1)
+	coseKeyX         = int64(-2)
+	coseKeyY         = int64(-3)
+	coseKeyModulus   = int64(-1)
+	coseKeyExponent  = int64(-2)
+
+	coseKeyTypeOKP = int64(1)
+	coseKeyTypeEC2 = int64(2)
+	coseKeyTypeRSA = int64(3)
+
+	coseCurveP256    = int64(1)
+	coseCurveEd25519 = int64(6)
+)
+
+// ErrUnsupportedKey indicates a credential public key of an unsupported type or algorithm.
+var ErrUnsupportedKey = errors.New("credential public key is not supported")
+
+// ErrInvalidSignature indicates a signature which does not verify.
+var ErrInvalidSignature = errors.New("signature is invalid")
+
+// publicKey is a decoded COSE key.
+type publicKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key.
+func parsePublicKey(data []byte) (*publicKey, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.WithStack(ErrMalformedCBOR)
+	}
+	return publicKeyOf(item)
+}
+
+// publicKeyOf converts a decoded COSE_Key.
+func publicKeyOf(item interface{}) (*publicKey, error) {
+	entries, err := cborMapOf(item)
+	if err != nil {
+		return nil, err
+	}
+	keyType, _ := entries[coseKeyType].(int64)
+	algorithm, _ := entries[coseKeyAlgorithm].(int64)
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgorithmES256:
+		curve, _ := entries[coseKeyCurve].(int64)
+		x, _ := entries[coseKeyX].([]byte)
+		y, _ := entries[coseKeyY].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.WithStack(ErrUnsupportedKey)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.WithStack(ErrUnsupportedKey)
+		}
+		return &publicKey{algorithm: algorithm, key: key}, nil
+	case keyType == coseKeyTypeOKP && algorithm == AlgorithmEdDSA:
+		curve, _ := entries[coseKeyCurve].(int64)
+		x, _ := entries[coseKeyX].([]byte)
+		if curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.WithStack(ErrUnsupportedKey)
+		}
+		return &publicKey{algorithm: algorithm, key: ed25519.PublicKey(x)}, nil
+	case keyType == coseKeyTypeRSA && algorithm == AlgorithmRS256:
+		modulus, _ := entries[coseKeyModulus].([]byte)
+		exponent, _ := entries[coseKeyExponent].([]byte)
+		if len(modulus) < 256 || len(exponent) == 0 || len(exponent) > 4 {
+			return nil, errors.WithStack(ErrUnsupportedKey)
+		}
+		e := 0
+		for _, b := range exponent {
+			e = e<<8 | int(b)
+		}
+		return &publicKey{algorithm: algorithm, key: &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: e}}, nil
+	}
+	return nil, errors.WithStack(ErrUnsupportedKey)
+}
+
+// verify verifies the signature of data by this key.
+func (k *publicKey) verify(data, signature []byte) error {
+	return verifySignature(k.algorithm, k.key, data, signature)
+}
+
+// verifySignature verifies a signature of data by key with a COSE algorithm. ECDSA signatures
+// are ASN.1 encoded, as WebAuthn requires.
+func verifySignature(algorithm int64, key crypto.PublicKey, data, signature []byte) error {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if algorithm != AlgorithmES256 {
+			break
+		}
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return errors.WithStack(ErrInvalidSignature)
+		}
+		return nil
+	case ed25519.PublicKey:
+		if algorithm != AlgorithmEdDSA {
+			break
+		}
+		if !ed25519.Verify(key, data, signature) {
+			return errors.WithStack(ErrInvalidSignature)
+		}
+		return nil
+	case *rsa.PublicKey:
+		if algorithm != AlgorithmRS256 {
+			break
+		}
+		digest := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.WithStack(ErrInvalidSignature)
+		}
+		return nil
+	}
+	return errors.WithStack(ErrUnsupportedKey)
+}
+
+// verifyCertificateSignature verifies a signature of data by the key of an attestation
+// certificate with a COSE algorithm.
+func verifyCertificateSignature(algorithm int64, cert *x509.Certificate, data, signature []byte) error {
+	return verifySignature(algorithm, cert.PublicKey, data, signature)
+}
@@ -0,0 +1,192 @@
+package webauthn
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/httperror"
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/oauth"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/user"
+	"github.com/pkg/errors"
+)
+
+// Error codes of the WebAuthn endpoints.
+const (
+	ErrorCodeInvalidRequest     = "invalid_request"
+	ErrorCodeInvalidSession     = "invalid_session"
+	ErrorCodeVerificationFailed = "verification_failed"
+	ErrorCodeCredentialExists   = "credential_exists"
+	ErrorCodeServerError        = "server_error"
+)
+
+// Error is an error response of the WebAuthn endpoints.
+type Error = httperror.Error
+
+// serviceErrors maps the errors of the RelyingParty to their responses.
+var serviceErrors = []httperror.Sentinel{
+	{Err: ErrInvalidSession, Response: httperror.New(http.StatusBadRequest, ErrorCodeInvalidSession, "session is invalid, expired or used")},
+	{Err: ErrCredentialExists, Response: httperror.New(http.StatusConflict, ErrorCodeCredentialExists, "credential is already registered")},
+}
+
+func writeError(w http.ResponseWriter, err error, log *logger.Logger) {
+	var verificationErr *VerificationError
+	if errors.As(err, &verificationErr) {
+		err = httperror.New(http.StatusBadRequest, ErrorCodeVerificationFailed, verificationErr.Reason)
+	}
+	httperror.Write(w, err, log, serviceErrors...)
+}
+
+// OptionsResponse is the response of the endpoints beginning a ceremony: PublicKey holds the
+// options of the browser API, and Session must be sent back with its result.
+type OptionsResponse struct {
+	PublicKey interface{} `json:"publicKey"`
+	Session   string      `json:"session"`
+}
+
+type registrationOptionsHandler struct {
+	relyingParty RelyingParty
+	users        user.Service
+	accessTokens oauth.AccessTokenVerifier
+	logger       *logger.Logger
+}
+
+// NewRegistrationOptionsHandler instantiates the endpoint beginning the registration of a
+// credential for the user authenticated by the access token of the request. It responds with
+// an OptionsResponse holding CreationOptions.
+func NewRegistrationOptionsHandler(relyingParty RelyingParty, users user.Service, accessTokens oauth.AccessTokenVerifier,
+	logger *logger.Logger) http.Handler {
+	return &registrationOptionsHandler{relyingParty: relyingParty, users: users, accessTokens: accessTokens, logger: logger}
+}
+
+func (h *registrationOptionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	claims, err := h.accessTokens.Verify(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	u, err := h.users.FindByID(r.Context(), claims.Subject)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	displayName := u.Name
+	if displayName == "" {
+		displayName = u.Email
+	}
+	options, session, err := h.relyingParty.BeginRegistration(r.Context(), User{ID: u.ID, Name: u.Email, DisplayName: displayName})
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusOK, OptionsResponse{PublicKey: options, Session: session})
+}
+
+// RegistrationRequest is the JSON body of the registration endpoint.
+type RegistrationRequest struct {
+	Session    string                `json:"session"`
+	Name       string                `json:"name"`
+	Credential *RegistrationResponse `json:"credential"`
+}
+
+// maxCredentialNameLength bounds the names users give their credentials.
+const maxCredentialNameLength = 255
+
+// StepUpMaxAge is how recently users with a second factor must have logged in with it to
+// register a credential.
+const StepUpMaxAge = 10 * time.Minute
+
+// stepUpMethods are the authentication method references of RFC 8176 of a second factor.
+var stepUpMethods = []string{"otp", "hwk"}
+
+// Factors tells whether users enrolled a second factor other than their credentials, e.g. a
+// totp.Service.
+type Factors interface {
+	Enrolled(ctx context.Context, userID string) (bool, error)
+}
+
+type registrationHandler struct {
+	relyingParty RelyingParty
+	credentials  Store
+	factors      Factors
+	accessTokens oauth.AccessTokenVerifier
+	timegen      timegenerator.TimeGenerator
+	logger       *logger.Logger
+}
+
+// NewRegistrationHandler instantiates the endpoint finishing the registration of a credential
+// for the user authenticated by the access token of the request. It responds 201 with the
+// Credential. Once the user has a second factor, enrolled in factors or registered in
+// credentials, the access token must be issued for a login with one less than StepUpMaxAge
+// ago, so a stolen access token cannot register a passkey bypassing it; the endpoint responds
+// with the insufficient_user_authentication challenge of RFC 9470 otherwise.
+func NewRegistrationHandler(relyingParty RelyingParty, credentials Store, factors Factors,
+	accessTokens oauth.AccessTokenVerifier, timegen timegenerator.TimeGenerator, logger *logger.Logger) http.Handler {
+	return &registrationHandler{
+		relyingParty: relyingParty,
+		credentials:  credentials,
+		factors:      factors,
+		accessTokens: accessTokens,
+		timegen:      timegen,
+		logger:       logger,
+	}
+}
+
+func (h *registrationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, httperror.New(http.StatusMethodNotAllowed, ErrorCodeInvalidRequest, "method must be POST"), h.logger)
+		return
+	}
+	claims, err := h.accessTokens.Verify(r)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	if err := h.requireStepUp(r, claims); err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	var request RegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Session == "" || request.Credential == nil {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "session and credential are required"), h.logger)
+		return
+	}
+	if len(request.Name) > maxCredentialNameLength {
+		writeError(w, httperror.New(http.StatusBadRequest, ErrorCodeInvalidRequest, "name is too long"), h.logger)
+		return
+	}
+	credential, err := h.relyingParty.FinishRegistration(r.Context(), claims.Subject, request.Session, request.Credential, request.Name)
+	if err != nil {
+		writeError(w, err, h.logger)
+		return
+	}
+	httperror.WriteJSON(w, http.StatusCreated, credential)
+}
+
+// requireStepUp checks the user of claims logged in with a second factor recently, when they
+// have one.
+func (h *registrationHandler) requireStepUp(r *http.Request, claims *jwt.Claims) error {
+	enrolled, err := h.factors.Enrolled(r.Context(), claims.Subject)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !enrolled {
+		credentials, err := h.credentials.ListByUserID(r.Context(), claims.Subject)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		enrolled = len(credentials) > 0
+	}
+	if !enrolled {
+		return nil
+	}
+	return oauth.RequireStepUp(r, claims, h.timegen.Now(), StepUpMaxAge, stepUpMethods...)
+}
@@ -0,0 +1,95 @@
+package webauthn_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/logger"
+	"github.com/code-and-chill/auth-api/pkg/oauth"
+	"github.com/code-and-chill/auth-api/pkg/webauthn"
+	"github.com/code-and-chill/auth-api/pkg/webauthn/webauthntest"
+)
+
+// factors reports the users in it as enrolled.
+type factors map[string]bool
+
+func (f factors) Enrolled(_ context.Context, userID string) (bool, error) {
+	return f[userID], nil
+}
+
+func TestRegistrationHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		totp       bool
+		passkey    bool
+		amr        []string
+		authAge    time.Duration
+		wantStatus int
+	}{
+		{name: "Registers a first factor after a password login", amr: []string{"pwd"}, wantStatus: http.StatusCreated},
+		{name: "Registers a credential after a recent second factor", totp: true, amr: []string{"pwd", "otp"},
+			wantStatus: http.StatusCreated},
+		{name: "Registers a credential after a recent passkey login", passkey: true, amr: []string{"hwk"},
+			wantStatus: http.StatusCreated},
+		{name: "Rejects a password login of a user with TOTP", totp: true, amr: []string{"pwd"},
+			wantStatus: http.StatusUnauthorized},
+		{name: "Rejects a password login of a user with a passkey", passkey: true, amr: []string{"pwd"},
+			wantStatus: http.StatusUnauthorized},
+		{name: "Rejects an old second factor", totp: true, amr: []string{"pwd", "otp"}, authAge: webauthn.StepUpMaxAge + time.Second,
+			wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp, store, timegen := newTestRelyingParty(t, testConfig)
+			if tt.passkey {
+				register(t, rp, webauthntest.NewAuthenticator(testOrigin))
+			}
+			accessTokens, err := jwttest.NewRS256(timegen, "https://auth.example.com", "api", time.Hour)
+			if err != nil {
+				t.Fatalf("jwttest.NewRS256() error = %v", err)
+			}
+			handler := webauthn.NewRegistrationHandler(rp, store, factors{jane.ID: tt.totp},
+				oauth.NewAccessTokenVerifier(accessTokens, nil), timegen, logger.NewNoopLogger())
+
+			token, _, err := accessTokens.SignClaims(context.Background(), &jwt.Claims{
+				Subject:  jane.ID,
+				AMR:      tt.amr,
+				AuthTime: timegen.Now().Add(-tt.authAge).Unix(),
+			})
+			if err != nil {
+				t.Fatalf("SignClaims() error = %v", err)
+			}
+			options, session, err := rp.BeginRegistration(context.Background(), jane)
+			if err != nil {
+				t.Fatalf("BeginRegistration() error = %v", err)
+			}
+			response, err := webauthntest.NewAuthenticator(testOrigin).Create(options)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			body, err := json.Marshal(webauthn.RegistrationRequest{Session: session, Name: "phone", Credential: response})
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/webauthn/registrations", bytes.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, body = %s, want %d", recorder.Code, recorder.Body, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized &&
+				!strings.Contains(recorder.Header().Get("WWW-Authenticate"), oauth.ErrorCodeInsufficientUserAuthentication) {
+				t.Errorf("WWW-Authenticate = %q, want a step-up challenge", recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
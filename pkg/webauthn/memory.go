@@ -0,0 +1,89 @@
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	mu          sync.Mutex
+	credentials map[string]*Credential
+}
+
+// NewMemoryStore instantiates a Store which keeps credentials in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{credentials: map[string]*Credential{}}
+}
+
+func (s *memoryStore) Create(_ context.Context, credential *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.credentials {
+		if bytes.Equal(existing.CredentialID, credential.CredentialID) {
+			return errors.WithStack(ErrCredentialExists)
+		}
+	}
+	stored := *credential
+	s.credentials[credential.ID] = &stored
+	return nil
+}
+
+func (s *memoryStore) FindByCredentialID(_ context.Context, credentialID []byte) (*Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, credential := range s.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			found := *credential
+			return &found, nil
+		}
+	}
+	return nil, errors.WithStack(ErrNotFound)
+}
+
+func (s *memoryStore) ListByUserID(_ context.Context, userID string) ([]Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var credentials []Credential
+	for _, credential := range s.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, *credential)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].CreatedAt.Before(credentials[j].CreatedAt)
+	})
+	return credentials, nil
+}
+
+func (s *memoryStore) UpdateUsage(_ context.Context, id string, previousSignCount, signCount uint32, backedUp bool,
+	usedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	credential, ok := s.credentials[id]
+	if !ok {
+		return false, errors.WithStack(ErrNotFound)
+	}
+	if credential.SignCount != previousSignCount {
+		return false, nil
+	}
+	credential.SignCount = signCount
+	credential.BackedUp = backedUp
+	credential.LastUsedAt = &usedAt
+	return true, nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	credential, ok := s.credentials[id]
+	if !ok || credential.UserID != userID {
+		return errors.WithStack(ErrNotFound)
+	}
+	delete(s.credentials, id)
+	return nil
+}
@@ -0,0 +1,97 @@
+package webauthn
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/mysql"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+const (
+	insertCredentialQuery = `INSERT INTO webauthn_credentials (id, user_id, credential_id, public_key, sign_count, aaguid,
+		attestation_type, discoverable, backup_eligible, backed_up, name, created_at, last_used_at)
+		VALUES (:id, :user_id, :credential_id, :public_key, :sign_count, :aaguid,
+		:attestation_type, :discoverable, :backup_eligible, :backed_up, :name, :created_at, :last_used_at)`
+	findCredentialQuery   = `SELECT * FROM webauthn_credentials WHERE credential_id = :credential_id`
+	listCredentialsQuery  = `SELECT * FROM webauthn_credentials WHERE user_id = :user_id ORDER BY created_at, id`
+	updateCredentialQuery = `UPDATE webauthn_credentials
+		SET sign_count = :sign_count, backed_up = :backed_up, last_used_at = :last_used_at
+		WHERE id = :id AND sign_count = :previous_sign_count`
+	deleteCredentialQuery = `DELETE FROM webauthn_credentials WHERE id = :id AND user_id = :user_id`
+)
+
+// errorCodeDuplicateEntry is the MySQL error raised when a unique key is violated.
+const errorCodeDuplicateEntry = 1062
+
+type mysqlStore struct {
+	db mysql.MySQL
+}
+
+// NewMySQLStore instantiates a Store backed by MySQL.
+func NewMySQLStore(db mysql.MySQL) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) Create(ctx context.Context, credential *Credential) error {
+	_, err := s.db.ExecNamed(ctx, insertCredentialQuery, credential)
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errorCodeDuplicateEntry {
+		return errors.WithStack(ErrCredentialExists)
+	}
+	return errors.WithStack(err)
+}
+
+func (s *mysqlStore) FindByCredentialID(ctx context.Context, credentialID []byte) (*Credential, error) {
+	var credential Credential
+	err := s.db.GetNamedForWrite(ctx, &credential, findCredentialQuery, map[string]interface{}{"credential_id": credentialID})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &credential, nil
+}
+
+func (s *mysqlStore) ListByUserID(ctx context.Context, userID string) ([]Credential, error) {
+	var credentials []Credential
+	err := s.db.SelectNamed(ctx, &credentials, listCredentialsQuery, map[string]interface{}{"user_id": userID})
+	return credentials, errors.WithStack(err)
+}
+
+func (s *mysqlStore) UpdateUsage(ctx context.Context, id string, previousSignCount, signCount uint32, backedUp bool,
+	usedAt time.Time) (bool, error) {
+	result, err := s.db.ExecNamed(ctx, updateCredentialQuery, map[string]interface{}{
+		"id":                  id,
+		"previous_sign_count": previousSignCount,
+		"sign_count":          signCount,
+		"backed_up":           backedUp,
+		"last_used_at":        usedAt,
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return affected == 1, nil
+}
+
+func (s *mysqlStore) Delete(ctx context.Context, userID, id string) error {
+	result, err := s.db.ExecNamed(ctx, deleteCredentialQuery, map[string]interface{}{"id": id, "user_id": userID})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if affected == 0 {
+		return errors.WithStack(ErrNotFound)
+	}
+	return nil
+}
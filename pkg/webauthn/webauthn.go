@@ -0,0 +1,593 @@
+// Package webauthn implements a WebAuthn relying party, so users can register passkeys and
+// security keys, and log in with them instead of a password. Ceremony challenges are kept in
+// signed session tokens rather than server-side, and each session is completed once.
+package webauthn
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/securetoken"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound indicates a credential is not registered.
+	ErrNotFound = errors.New("webauthn credential is not found")
+	// ErrCredentialExists indicates a credential ID is registered already.
+	ErrCredentialExists = errors.New("webauthn credential is already registered")
+	// ErrInvalidSession indicates a ceremony session which is invalid, expired, used or of
+	// another user.
+	ErrInvalidSession = errors.New("webauthn session is invalid")
+	// ErrSignCount indicates the sign count of a credential did not increase, which happens
+	// when an authenticator was cloned.
+	ErrSignCount = errors.New("webauthn sign count did not increase")
+)
+
+// VerificationError indicates a ceremony response failed verification.
+type VerificationError struct {
+	Reason string
+}
+
+// Error returns the error message.
+func (e *VerificationError) Error() string {
+	return "webauthn verification failed: " + e.Reason
+}
+
+func newVerificationError(reason string) error {
+	return errors.WithStack(&VerificationError{Reason: reason})
+}
+
+// encoding is the base64url encoding WebAuthn uses for binary values in JSON and client data.
+var encoding = base64.RawURLEncoding
+
+// Bytes is binary data encoded as unpadded base64url in JSON.
+type Bytes []byte
+
+// MarshalJSON encodes b as base64url.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(encoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes base64url, padded or not.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return errors.WithStack(err)
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(trimPadding(encoded))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	*b = decoded
+	return nil
+}
+
+func trimPadding(encoded string) string {
+	for len(encoded) > 0 && encoded[len(encoded)-1] == '=' {
+		encoded = encoded[:len(encoded)-1]
+	}
+	return encoded
+}
+
+// Requirements of user verification and discoverable credentials.
+const (
+	RequirementRequired    = "required"
+	RequirementPreferred   = "preferred"
+	RequirementDiscouraged = "discouraged"
+)
+
+// credentialType is the only type of WebAuthn credentials.
+const credentialType = "public-key"
+
+// challengeSize is the size of ceremony challenges, above the 16 bytes WebAuthn requires.
+const challengeSize = 32
+
+// Token uses of ceremony sessions, on top of their audience.
+const (
+	tokenUseRegistration = "webauthn_registration"
+	tokenUseLogin        = "webauthn_login"
+)
+
+// Config provides configs for the relying party.
+type Config struct {
+	// RPID is the domain credentials are scoped to, e.g. example.com.
+	RPID string
+	// RPName is shown by authenticators.
+	RPName string
+	// Origins are the origins ceremonies may run on, e.g. https://login.example.com.
+	Origins []string
+	// Timeout is how long clients let users complete a ceremony. It should not exceed the
+	// lifetime of session tokens.
+	Timeout time.Duration
+	// UserVerification is a Requirement, RequirementPreferred by default. When required,
+	// responses without user verification are rejected.
+	UserVerification string
+	// ResidentKey is a Requirement for discoverable credentials, RequirementRequired by
+	// default, so credentials are passkeys users can log in with without a username.
+	ResidentKey string
+	// Attestation is the attestation conveyance asked for, none by default. Use direct to
+	// record the authenticator models of registered credentials.
+	Attestation string
+}
+
+// withDefaults fills the zero values of c with their defaults.
+func (c Config) withDefaults() Config {
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Minute
+	}
+	if c.UserVerification == "" {
+		c.UserVerification = RequirementPreferred
+	}
+	if c.ResidentKey == "" {
+		c.ResidentKey = RequirementRequired
+	}
+	if c.Attestation == "" {
+		c.Attestation = "none"
+	}
+	return c
+}
+
+// Credential is a registered WebAuthn credential.
+type Credential struct {
+	ID           string `db:"id" json:"id"`
+	UserID       string `db:"user_id" json:"-"`
+	CredentialID Bytes  `db:"credential_id" json:"credential_id"`
+	// PublicKey is the COSE key of the credential.
+	PublicKey []byte `db:"public_key" json:"-"`
+	// SignCount is the last signature counter reported by the authenticator, 0 when it does
+	// not count.
+	SignCount       uint32 `db:"sign_count" json:"-"`
+	AAGUID          Bytes  `db:"aaguid" json:"aaguid"`
+	AttestationType string `db:"attestation_type" json:"attestation_type"`
+	// Discoverable tells the credential can be used without a username, i.e. is a passkey.
+	Discoverable bool `db:"discoverable" json:"discoverable"`
+	// BackupEligible and BackedUp tell whether the credential may be and is synced to other
+	// devices of the user.
+	BackupEligible bool       `db:"backup_eligible" json:"backup_eligible"`
+	BackedUp       bool       `db:"backed_up" json:"backed_up"`
+	Name           string     `db:"name" json:"name"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt     *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+}
+
+// Store persists credentials.
+type Store interface {
+	// Create stores a new credential, or returns ErrCredentialExists.
+	Create(ctx context.Context, credential *Credential) error
+
+	// FindByCredentialID finds a credential by the ID its authenticator gave it.
+	FindByCredentialID(ctx context.Context, credentialID []byte) (*Credential, error)
+
+	// ListByUserID finds the credentials of a user.
+	ListByUserID(ctx context.Context, userID string) ([]Credential, error)
+
+	// UpdateUsage records a use of credential id at usedAt, if its sign count is still
+	// previousSignCount. It returns false otherwise, so of two assertions with the same sign
+	// count, e.g. a cloned authenticator racing the genuine one, only the first logs in.
+	UpdateUsage(ctx context.Context, id string, previousSignCount, signCount uint32, backedUp bool, usedAt time.Time) (bool, error)
+
+	// Delete removes credential id of a user.
+	Delete(ctx context.Context, userID, id string) error
+}
+
+// RelyingPartyEntity identifies the relying party to authenticators.
+type RelyingPartyEntity struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// UserEntity identifies a user to authenticators.
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is a type of credential the relying party accepts.
+type CredentialParameter struct {
+	Type      string `json:"type"`
+	Algorithm int64  `json:"alg"`
+}
+
+// CredentialDescriptor identifies a credential.
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+// AuthenticatorSelection restricts the authenticators of a registration.
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions are the options of navigator.credentials.create, in their JSON form.
+type CreationOptions struct {
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Bytes                  `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+	Extensions             map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// RequestOptions are the options of navigator.credentials.get, in their JSON form.
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the credential created by navigator.credentials.create, in its JSON
+// form.
+type RegistrationResponse struct {
+	ID                     string                           `json:"id"`
+	RawID                  Bytes                            `json:"rawId"`
+	Type                   string                           `json:"type"`
+	Response               AuthenticatorAttestationResponse `json:"response"`
+	ClientExtensionResults ClientExtensionResults           `json:"clientExtensionResults"`
+}
+
+// AuthenticatorAttestationResponse is the response of an authenticator to a registration.
+type AuthenticatorAttestationResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AttestationObject Bytes `json:"attestationObject"`
+}
+
+// ClientExtensionResults are the outputs of the extensions the relying party asked for.
+type ClientExtensionResults struct {
+	CredProps *CredentialProperties `json:"credProps,omitempty"`
+}
+
+// CredentialProperties is the output of the credProps extension.
+type CredentialProperties struct {
+	// ResidentKey tells whether the credential is discoverable.
+	ResidentKey bool `json:"rk"`
+}
+
+// AuthenticationResponse is the assertion returned by navigator.credentials.get, in its JSON
+// form.
+type AuthenticationResponse struct {
+	ID       string                         `json:"id"`
+	RawID    Bytes                          `json:"rawId"`
+	Type     string                         `json:"type"`
+	Response AuthenticatorAssertionResponse `json:"response"`
+}
+
+// AuthenticatorAssertionResponse is the response of an authenticator to an authentication.
+type AuthenticatorAssertionResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+	// UserHandle is the ID of the user the credential was registered for, returned by
+	// discoverable credentials.
+	UserHandle Bytes `json:"userHandle,omitempty"`
+}
+
+// Assertion is a verified authentication.
+type Assertion struct {
+	Credential *Credential
+	// UserVerified tells the authenticator verified the user, e.g. by biometrics or PIN.
+	UserVerified bool
+}
+
+// User is who registers a credential.
+type User struct {
+	ID          string
+	Name        string
+	DisplayName string
+}
+
+// RelyingParty runs WebAuthn ceremonies.
+type RelyingParty interface {
+	// BeginRegistration returns the options of a registration for user, and the session
+	// token FinishRegistration expects.
+	BeginRegistration(ctx context.Context, user User) (*CreationOptions, string, error)
+
+	// FinishRegistration verifies the response of a registration for userID and stores its
+	// credential, named name.
+	FinishRegistration(ctx context.Context, userID, session string, response *RegistrationResponse, name string) (*Credential, error)
+
+	// BeginLogin returns the options of an authentication, and the session token FinishLogin
+	// expects. Without userID, any discoverable credential is accepted; otherwise only the
+	// credentials of userID are.
+	BeginLogin(ctx context.Context, userID string) (*RequestOptions, string, error)
+
+	// FinishLogin verifies the response of an authentication.
+	FinishLogin(ctx context.Context, session string, response *AuthenticationResponse) (*Assertion, error)
+}
+
+type relyingParty struct {
+	store        Store
+	sessions     jwt.JWT
+	usedSessions jwt.RevocationStore
+	timegen      timegenerator.TimeGenerator
+	config       Config
+}
+
+// NewRelyingParty instantiates a new RelyingParty. sessions signs the session tokens of
+// ceremonies; it must have an audience of its own, so they are not accepted as other tokens.
+// usedSessions records completed sessions.
+func NewRelyingParty(store Store, sessions jwt.JWT, usedSessions jwt.RevocationStore,
+	timegen timegenerator.TimeGenerator, config Config) RelyingParty {
+	return &relyingParty{
+		store:        store,
+		sessions:     sessions,
+		usedSessions: usedSessions,
+		timegen:      timegen,
+		config:       config.withDefaults(),
+	}
+}
+
+func (rp *relyingParty) BeginRegistration(ctx context.Context, user User) (*CreationOptions, string, error) {
+	existing, err := rp.store.ListByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	challenge, session, err := rp.newSession(ctx, user.ID, tokenUseRegistration)
+	if err != nil {
+		return nil, "", err
+	}
+	options := &CreationOptions{
+		RP:                 RelyingPartyEntity{ID: rp.config.RPID, Name: rp.config.RPName},
+		User:               UserEntity{ID: Bytes(user.ID), Name: user.Name, DisplayName: user.DisplayName},
+		Challenge:          challenge,
+		Timeout:            rp.config.Timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        rp.config.ResidentKey,
+			RequireResidentKey: rp.config.ResidentKey == RequirementRequired,
+			UserVerification:   rp.config.UserVerification,
+		},
+		Attestation: rp.config.Attestation,
+		Extensions:  map[string]interface{}{"credProps": true},
+	}
+	for _, algorithm := range SupportedAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, CredentialParameter{Type: credentialType, Algorithm: algorithm})
+	}
+	return options, session, nil
+}
+
+func (rp *relyingParty) FinishRegistration(ctx context.Context, userID, session string, response *RegistrationResponse,
+	name string) (*Credential, error) {
+	claims, challenge, err := rp.redeemSession(ctx, session, tokenUseRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject != userID {
+		return nil, errors.WithStack(ErrInvalidSession)
+	}
+	if response.Type != credentialType {
+		return nil, newVerificationError("credential is not a public key credential")
+	}
+	if err := verifyClientData(response.Response.ClientDataJSON, clientDataTypeCreate, challenge, rp.config.Origins); err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+
+	item, rest, err := decodeCBOR(response.Response.AttestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, newVerificationError("attestation object is malformed")
+	}
+	attestation, err := cborMapOf(item)
+	if err != nil {
+		return nil, newVerificationError("attestation object is malformed")
+	}
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+	authData, _ := attestation["authData"].([]byte)
+	parsed, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, newVerificationError("authenticator data is malformed")
+	}
+	if err := rp.verifyAuthenticatorData(parsed); err != nil {
+		return nil, err
+	}
+	if parsed.flags&FlagAttestedData == 0 {
+		return nil, newVerificationError("authenticator data has no credential")
+	}
+	key, err := parsePublicKey(parsed.publicKey)
+	if err != nil {
+		return nil, newVerificationError("credential public key is not supported")
+	}
+	attestationType, err := verifyAttestation(format, statement, authData, parsed, clientDataHash[:], key)
+	if err != nil {
+		return nil, err
+	}
+
+	discoverable := rp.config.ResidentKey == RequirementRequired
+	if credProps := response.ClientExtensionResults.CredProps; credProps != nil {
+		discoverable = credProps.ResidentKey
+	}
+	id, err := securetoken.NewID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	credential := &Credential{
+		ID:              id,
+		UserID:          userID,
+		CredentialID:    Bytes(parsed.credentialID),
+		PublicKey:       parsed.publicKey,
+		SignCount:       parsed.signCount,
+		AAGUID:          Bytes(parsed.aaguid),
+		AttestationType: attestationType,
+		Discoverable:    discoverable,
+		BackupEligible:  parsed.flags&FlagBackupEligible != 0,
+		BackedUp:        parsed.flags&FlagBackedUp != 0,
+		Name:            name,
+		CreatedAt:       rp.timegen.Now().UTC(),
+	}
+	if err := rp.store.Create(ctx, credential); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return credential, nil
+}
+
+func (rp *relyingParty) BeginLogin(ctx context.Context, userID string) (*RequestOptions, string, error) {
+	var allowed []CredentialDescriptor
+	if userID != "" {
+		credentials, err := rp.store.ListByUserID(ctx, userID)
+		if err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+		allowed = descriptors(credentials)
+	}
+	challenge, session, err := rp.newSession(ctx, userID, tokenUseLogin)
+	if err != nil {
+		return nil, "", err
+	}
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.config.Timeout.Milliseconds(),
+		RPID:             rp.config.RPID,
+		AllowCredentials: allowed,
+		UserVerification: rp.config.UserVerification,
+	}, session, nil
+}
+
+func (rp *relyingParty) FinishLogin(ctx context.Context, session string, response *AuthenticationResponse) (*Assertion, error) {
+	claims, challenge, err := rp.redeemSession(ctx, session, tokenUseLogin)
+	if err != nil {
+		return nil, err
+	}
+	if response.Type != credentialType {
+		return nil, newVerificationError("credential is not a public key credential")
+	}
+	credential, err := rp.store.FindByCredentialID(ctx, response.RawID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	switch {
+	case claims.Subject != "" && credential.UserID != claims.Subject:
+		return nil, newVerificationError("credential is not allowed")
+	case claims.Subject == "" && string(response.Response.UserHandle) != credential.UserID:
+		// Discoverable credentials return the user handle they were registered with.
+		return nil, newVerificationError("user handle does not match the credential")
+	case len(response.Response.UserHandle) != 0 && string(response.Response.UserHandle) != credential.UserID:
+		return nil, newVerificationError("user handle does not match the credential")
+	}
+	if err := verifyClientData(response.Response.ClientDataJSON, clientDataTypeGet, challenge, rp.config.Origins); err != nil {
+		return nil, err
+	}
+	parsed, err := parseAuthenticatorData(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, newVerificationError("authenticator data is malformed")
+	}
+	if err := rp.verifyAuthenticatorData(parsed); err != nil {
+		return nil, err
+	}
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), response.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := key.verify(signed, response.Response.Signature); err != nil {
+		return nil, newVerificationError("assertion signature is invalid")
+	}
+
+	// Authenticators which count signatures must report a greater count each time, or were
+	// cloned. Authenticators which do not count always report 0.
+	if (parsed.signCount != 0 || credential.SignCount != 0) && parsed.signCount <= credential.SignCount {
+		return nil, errors.WithStack(ErrSignCount)
+	}
+	now := rp.timegen.Now().UTC()
+	backedUp := parsed.flags&FlagBackedUp != 0
+	updated, err := rp.store.UpdateUsage(ctx, credential.ID, credential.SignCount, parsed.signCount, backedUp, now)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !updated {
+		return nil, errors.WithStack(ErrSignCount)
+	}
+	credential.SignCount = parsed.signCount
+	credential.BackedUp = backedUp
+	credential.LastUsedAt = &now
+	return &Assertion{Credential: credential, UserVerified: parsed.flags&FlagUserVerified != 0}, nil
+}
+
+// verifyAuthenticatorData checks the relying party and flags of authenticator data.
+func (rp *relyingParty) verifyAuthenticatorData(parsed *authenticatorData) error {
+	if err := verifyRPIDHash(parsed, rp.config.RPID); err != nil {
+		return err
+	}
+	if parsed.flags&FlagUserPresent == 0 {
+		return newVerificationError("user is not present")
+	}
+	if rp.config.UserVerification == RequirementRequired && parsed.flags&FlagUserVerified == 0 {
+		return newVerificationError("user is not verified")
+	}
+	if parsed.flags&FlagBackedUp != 0 && parsed.flags&FlagBackupEligible == 0 {
+		return newVerificationError("credential is backed up without being eligible")
+	}
+	return nil
+}
+
+// newSession returns a random challenge and a session token of a ceremony for subject.
+func (rp *relyingParty) newSession(ctx context.Context, subject, tokenUse string) (Bytes, string, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	session, _, err := rp.sessions.SignClaims(ctx, &jwt.Claims{
+		Subject: subject,
+		Extra: map[string]interface{}{
+			"token_use": tokenUse,
+			"challenge": encoding.EncodeToString(challenge),
+		},
+	})
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	return challenge, session, nil
+}
+
+// redeemSession parses a session token of tokenUse, records it as used and returns its
+// challenge.
+func (rp *relyingParty) redeemSession(ctx context.Context, session, tokenUse string) (*jwt.Claims, []byte, error) {
+	claims, err := rp.sessions.ParseClaims(ctx, session, false)
+	var tokenErr *jwt.TokenError
+	if errors.As(err, &tokenErr) {
+		return nil, nil, errors.WithStack(ErrInvalidSession)
+	}
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	encoded, _ := claims.Extra["challenge"].(string)
+	challenge, err := encoding.DecodeString(encoded)
+	if claims.Extra["token_use"] != tokenUse || claims.ID == "" || err != nil || len(challenge) != challengeSize {
+		return nil, nil, errors.WithStack(ErrInvalidSession)
+	}
+	// Sessions are used up by any response, verified or not, so a challenge is never signed
+	// twice. They are used up atomically, so of concurrent responses only one is verified.
+	unused, err := rp.usedSessions.RevokeOnce(ctx, claims.ID, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if !unused {
+		return nil, nil, errors.WithStack(ErrInvalidSession)
+	}
+	return claims, challenge, nil
+}
+
+// descriptors returns the descriptors of credentials.
+func descriptors(credentials []Credential) []CredentialDescriptor {
+	result := make([]CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		result = append(result, CredentialDescriptor{Type: credentialType, ID: credential.CredentialID})
+	}
+	return result
+}
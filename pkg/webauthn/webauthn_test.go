@@ -0,0 +1,380 @@
+package webauthn_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/jwt"
+	"github.com/code-and-chill/auth-api/pkg/jwt/jwttest"
+	"github.com/code-and-chill/auth-api/pkg/timegenerator"
+	"github.com/code-and-chill/auth-api/pkg/webauthn"
+	"github.com/code-and-chill/auth-api/pkg/webauthn/webauthntest"
+)
+
+const testOrigin = "https://login.example.com"
+
+var testConfig = webauthn.Config{
+	RPID:    "example.com",
+	RPName:  "Example",
+	Origins: []string{testOrigin},
+}
+
+var jane = webauthn.User{ID: "jane", Name: "jane@example.com", DisplayName: "Jane"}
+
+func newTestRelyingParty(t *testing.T, config webauthn.Config) (webauthn.RelyingParty, webauthn.Store, *timegenerator.FakeTimeGenerator) {
+	t.Helper()
+	timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	sessions, err := jwttest.NewRS256(timegen, "https://auth.example.com", "https://auth.example.com/webauthn", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("jwttest.NewRS256() error = %v", err)
+	}
+	store := webauthn.NewMemoryStore()
+	return webauthn.NewRelyingParty(store, sessions, jwt.NewMemoryRevocationStore(timegen), timegen, config), store, timegen
+}
+
+// racingRevocationStore never reports tokens as revoked, as before concurrent requests revoke
+// them.
+type racingRevocationStore struct {
+	jwt.RevocationStore
+}
+
+func (racingRevocationStore) IsRevoked(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+// register registers a credential of authenticator for jane.
+func register(t *testing.T, rp webauthn.RelyingParty, authenticator *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+	options, session, err := rp.BeginRegistration(context.Background(), jane)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	credential, err := rp.FinishRegistration(context.Background(), jane.ID, session, response, "laptop")
+	if err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+	return credential
+}
+
+// login authenticates with authenticator without naming the user.
+func login(t *testing.T, rp webauthn.RelyingParty, authenticator *webauthntest.Authenticator) (*webauthn.Assertion, error) {
+	t.Helper()
+	options, session, err := rp.BeginLogin(context.Background(), "")
+	if err != nil {
+		t.Fatalf("BeginLogin() error = %v", err)
+	}
+	response, err := authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	return rp.FinishLogin(context.Background(), session, response)
+}
+
+func TestRelyingParty_Registration(t *testing.T) {
+	tests := []struct {
+		name            string
+		format          string
+		selfAttestation bool
+		wantType        string
+	}{
+		{name: "Registers with none attestation", format: webauthn.FormatNone, wantType: webauthn.AttestationTypeNone},
+		{name: "Registers with packed attestation", format: webauthn.FormatPacked, wantType: webauthn.AttestationTypeBasic},
+		{name: "Registers with packed self attestation", format: webauthn.FormatPacked, selfAttestation: true,
+			wantType: webauthn.AttestationTypeSelf},
+		{name: "Registers with fido-u2f attestation", format: webauthn.FormatFIDOU2F, wantType: webauthn.AttestationTypeBasic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp, store, _ := newTestRelyingParty(t, testConfig)
+			authenticator := webauthntest.NewAuthenticator(testOrigin)
+			authenticator.Format = tt.format
+			authenticator.SelfAttestation = tt.selfAttestation
+			authenticator.BackupEligible = true
+
+			credential := register(t, rp, authenticator)
+			if credential.UserID != jane.ID || credential.AttestationType != tt.wantType || !credential.Discoverable ||
+				!credential.BackupEligible || credential.Name != "laptop" {
+				t.Errorf("FinishRegistration() = %+v", credential)
+			}
+			stored, err := store.ListByUserID(context.Background(), jane.ID)
+			if err != nil || len(stored) != 1 {
+				t.Fatalf("ListByUserID() = %v, %v, want the credential", stored, err)
+			}
+
+			assertion, err := login(t, rp, authenticator)
+			if err != nil {
+				t.Fatalf("FinishLogin() error = %v", err)
+			}
+			if assertion.Credential.ID != credential.ID || !assertion.UserVerified || assertion.Credential.SignCount != 1 ||
+				assertion.Credential.LastUsedAt == nil {
+				t.Errorf("FinishLogin() = %+v", assertion.Credential)
+			}
+		})
+	}
+}
+
+func TestRelyingParty_BeginRegistration(t *testing.T) {
+	rp, _, _ := newTestRelyingParty(t, testConfig)
+	credential := register(t, rp, webauthntest.NewAuthenticator(testOrigin))
+
+	options, _, err := rp.BeginRegistration(context.Background(), jane)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+	if len(options.Challenge) != 32 || string(options.User.ID) != jane.ID || options.RP.ID != testConfig.RPID {
+		t.Errorf("BeginRegistration() = %+v", options)
+	}
+	if len(options.ExcludeCredentials) != 1 || string(options.ExcludeCredentials[0].ID) != string(credential.CredentialID) {
+		t.Errorf("ExcludeCredentials = %+v, want the registered credential", options.ExcludeCredentials)
+	}
+	if !options.AuthenticatorSelection.RequireResidentKey || options.AuthenticatorSelection.UserVerification != "preferred" {
+		t.Errorf("AuthenticatorSelection = %+v, want the defaults", options.AuthenticatorSelection)
+	}
+}
+
+func TestRelyingParty_FinishRegistration(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  func(*webauthn.Config)
+		prepare func(*webauthntest.Authenticator)
+		userID  string
+		tamper  func(*webauthn.RegistrationResponse)
+		reuse   bool
+		wantErr error
+	}{
+		{
+			name:    "Rejects another origin",
+			prepare: func(a *webauthntest.Authenticator) { a.Origin = "https://evil.example.net" },
+		},
+		{
+			name:    "Rejects another relying party",
+			config:  func(c *webauthn.Config) { c.RPID = "other.example.com" },
+			prepare: func(a *webauthntest.Authenticator) {},
+		},
+		{
+			name:    "Rejects an unverified user when verification is required",
+			config:  func(c *webauthn.Config) { c.UserVerification = webauthn.RequirementRequired },
+			prepare: func(a *webauthntest.Authenticator) { a.UserVerified = false },
+		},
+		{
+			name:    "Rejects a backed up credential which is not eligible",
+			prepare: func(a *webauthntest.Authenticator) { a.BackedUp = true },
+		},
+		{
+			name:    "Rejects the session of another user",
+			userID:  "john",
+			wantErr: webauthn.ErrInvalidSession,
+		},
+		{
+			name:    "Rejects a used session",
+			reuse:   true,
+			wantErr: webauthn.ErrInvalidSession,
+		},
+		{
+			name: "Rejects a forged attestation",
+			prepare: func(a *webauthntest.Authenticator) {
+				a.Format = webauthn.FormatPacked
+				a.SelfAttestation = true
+			},
+			tamper: func(r *webauthn.RegistrationResponse) {
+				// Signatures cover the client data, which is no longer the one signed.
+				r.Response.ClientDataJSON = append(r.Response.ClientDataJSON[:len(r.Response.ClientDataJSON)-1], ' ', '}')
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig
+			if tt.config != nil {
+				tt.config(&config)
+			}
+			rp, _, _ := newTestRelyingParty(t, config)
+			authenticator := webauthntest.NewAuthenticator(testOrigin)
+			if tt.prepare != nil {
+				tt.prepare(authenticator)
+			}
+			options, session, err := rp.BeginRegistration(context.Background(), jane)
+			if err != nil {
+				t.Fatalf("BeginRegistration() error = %v", err)
+			}
+			// Authenticators scope credentials to the relying party of the client, not the one
+			// the options ask for.
+			options.RP.ID = testConfig.RPID
+			response, err := authenticator.Create(options)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(response)
+			}
+			userID := jane.ID
+			if tt.userID != "" {
+				userID = tt.userID
+			}
+			if tt.reuse {
+				if _, err := rp.FinishRegistration(context.Background(), userID, session, response, ""); err != nil {
+					t.Fatalf("FinishRegistration() error = %v", err)
+				}
+			}
+
+			_, err = rp.FinishRegistration(context.Background(), userID, session, response, "")
+			var verificationErr *webauthn.VerificationError
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("FinishRegistration() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !errors.As(err, &verificationErr) {
+				t.Errorf("FinishRegistration() error = %v, want a VerificationError", err)
+			}
+		})
+	}
+}
+
+func TestRelyingParty_FinishLogin(t *testing.T) {
+	t.Run("Accepts authenticators which do not count", func(t *testing.T) {
+		rp, _, _ := newTestRelyingParty(t, testConfig)
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		authenticator.Counter = false
+		register(t, rp, authenticator)
+		for i := 0; i < 2; i++ {
+			if _, err := login(t, rp, authenticator); err != nil {
+				t.Fatalf("FinishLogin() error = %v", err)
+			}
+		}
+	})
+
+	t.Run("Accepts a session once when responses race", func(t *testing.T) {
+		timegen := timegenerator.NewFakeTimeGenerator(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+		sessions, err := jwttest.NewRS256(timegen, "https://auth.example.com", "https://auth.example.com/webauthn", 5*time.Minute)
+		if err != nil {
+			t.Fatalf("jwttest.NewRS256() error = %v", err)
+		}
+		// Both responses find the session unused, as if they were verified concurrently.
+		usedSessions := racingRevocationStore{jwt.NewMemoryRevocationStore(timegen)}
+		rp := webauthn.NewRelyingParty(webauthn.NewMemoryStore(), sessions, usedSessions, timegen, testConfig)
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		authenticator.Counter = false
+		register(t, rp, authenticator)
+		options, session, err := rp.BeginLogin(context.Background(), "")
+		if err != nil {
+			t.Fatalf("BeginLogin() error = %v", err)
+		}
+		response, err := authenticator.Get(options)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if _, err := rp.FinishLogin(context.Background(), session, response); err != nil {
+			t.Fatalf("FinishLogin() error = %v", err)
+		}
+		if _, err := rp.FinishLogin(context.Background(), session, response); !errors.Is(err, webauthn.ErrInvalidSession) {
+			t.Errorf("FinishLogin() error = %v, want ErrInvalidSession", err)
+		}
+	})
+
+	t.Run("Rejects a cloned authenticator", func(t *testing.T) {
+		rp, _, _ := newTestRelyingParty(t, testConfig)
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		register(t, rp, authenticator)
+		clone := authenticator.Clone()
+		if _, err := login(t, rp, authenticator); err != nil {
+			t.Fatalf("FinishLogin() error = %v", err)
+		}
+		if _, err := login(t, rp, clone); !errors.Is(err, webauthn.ErrSignCount) {
+			t.Errorf("FinishLogin() error = %v, want ErrSignCount", err)
+		}
+	})
+
+	t.Run("Rejects an unknown credential", func(t *testing.T) {
+		rp, _, _ := newTestRelyingParty(t, testConfig)
+		other, _, _ := newTestRelyingParty(t, testConfig)
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		register(t, other, authenticator)
+		if _, err := login(t, rp, authenticator); !errors.Is(err, webauthn.ErrNotFound) {
+			t.Errorf("FinishLogin() error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Rejects an expired session", func(t *testing.T) {
+		rp, _, timegen := newTestRelyingParty(t, testConfig)
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		register(t, rp, authenticator)
+		options, session, err := rp.BeginLogin(context.Background(), "")
+		if err != nil {
+			t.Fatalf("BeginLogin() error = %v", err)
+		}
+		response, err := authenticator.Get(options)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		timegen.Add(10 * time.Minute)
+		if _, err := rp.FinishLogin(context.Background(), session, response); !errors.Is(err, webauthn.ErrInvalidSession) {
+			t.Errorf("FinishLogin() error = %v, want ErrInvalidSession", err)
+		}
+	})
+
+	t.Run("Rejects a registration session", func(t *testing.T) {
+		rp, _, _ := newTestRelyingParty(t, testConfig)
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		register(t, rp, authenticator)
+		options, _, err := rp.BeginLogin(context.Background(), "")
+		if err != nil {
+			t.Fatalf("BeginLogin() error = %v", err)
+		}
+		_, session, err := rp.BeginRegistration(context.Background(), jane)
+		if err != nil {
+			t.Fatalf("BeginRegistration() error = %v", err)
+		}
+		response, err := authenticator.Get(options)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if _, err := rp.FinishLogin(context.Background(), session, response); !errors.Is(err, webauthn.ErrInvalidSession) {
+			t.Errorf("FinishLogin() error = %v, want ErrInvalidSession", err)
+		}
+	})
+
+	t.Run("Rejects a tampered signature", func(t *testing.T) {
+		rp, _, _ := newTestRelyingParty(t, testConfig)
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		register(t, rp, authenticator)
+		options, session, err := rp.BeginLogin(context.Background(), "")
+		if err != nil {
+			t.Fatalf("BeginLogin() error = %v", err)
+		}
+		response, err := authenticator.Get(options)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		response.Response.AuthenticatorData[32] |= webauthn.FlagBackupEligible
+		var verificationErr *webauthn.VerificationError
+		if _, err := rp.FinishLogin(context.Background(), session, response); !errors.As(err, &verificationErr) {
+			t.Errorf("FinishLogin() error = %v, want a VerificationError", err)
+		}
+	})
+
+	t.Run("Rejects the credential of another user", func(t *testing.T) {
+		rp, _, _ := newTestRelyingParty(t, testConfig)
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		register(t, rp, authenticator)
+		options, session, err := rp.BeginLogin(context.Background(), "john")
+		if err != nil {
+			t.Fatalf("BeginLogin() error = %v", err)
+		}
+		if len(options.AllowCredentials) != 0 {
+			t.Fatalf("AllowCredentials = %+v, want none of john", options.AllowCredentials)
+		}
+		response, err := authenticator.Get(options)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		var verificationErr *webauthn.VerificationError
+		if _, err := rp.FinishLogin(context.Background(), session, response); !errors.As(err, &verificationErr) {
+			t.Errorf("FinishLogin() error = %v, want a VerificationError", err)
+		}
+	})
+}
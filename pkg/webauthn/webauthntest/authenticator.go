@@ -0,0 +1,319 @@
+// Package webauthntest provides a software authenticator, to test WebAuthn ceremonies without
+// a browser or a security key.
+package webauthntest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/code-and-chill/auth-api/pkg/webauthn"
+	"github.com/pkg/errors"
+)
+
+// ErrNoCredential indicates the authenticator holds none of the allowed credentials.
+var ErrNoCredential = errors.New("authenticator holds no allowed credential")
+
+// oidAAGUID is the extension of packed attestation certificates holding the AAGUID.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// Authenticator is a software authenticator which keeps ES256 credentials in memory. Its
+// fields shape the responses it makes, and may be changed between ceremonies.
+type Authenticator struct {
+	// Origin is the origin of the client data, as a browser would report it.
+	Origin string
+	// Format is the attestation statement format of registrations: webauthn.FormatNone,
+	// webauthn.FormatPacked or webauthn.FormatFIDOU2F.
+	Format string
+	// SelfAttestation signs packed statements with the credential key rather than an
+	// attestation certificate.
+	SelfAttestation bool
+	// AAGUID identifies the authenticator model.
+	AAGUID []byte
+	// UserVerified sets the UV flag, as if a PIN or biometrics were checked.
+	UserVerified bool
+	// BackupEligible and BackedUp set the BE and BS flags.
+	BackupEligible bool
+	BackedUp       bool
+	// ResidentKey is reported by the credProps extension.
+	ResidentKey bool
+	// Counter increments the sign count of credentials on each assertion; otherwise it stays 0.
+	Counter bool
+
+	credentials []*credential
+	attestation *attestationKey
+}
+
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+type attestationKey struct {
+	key         *ecdsa.PrivateKey
+	certificate []byte
+}
+
+// NewAuthenticator instantiates an Authenticator running ceremonies on origin, which verifies
+// users, counts signatures and makes discoverable credentials with none attestation.
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{
+		Origin:       origin,
+		Format:       webauthn.FormatNone,
+		AAGUID:       []byte("webauthntest\x00\x00\x00\x01"),
+		UserVerified: true,
+		ResidentKey:  true,
+		Counter:      true,
+	}
+}
+
+// Clone returns a copy of the authenticator holding copies of its credentials, as an attacker
+// who extracted its keys would.
+func (a *Authenticator) Clone() *Authenticator {
+	clone := *a
+	clone.credentials = nil
+	for _, c := range a.credentials {
+		copied := *c
+		clone.credentials = append(clone.credentials, &copied)
+	}
+	return &clone
+}
+
+// Create makes a credential for options and returns the response of the browser.
+func (a *Authenticator) Create(options *webauthn.CreationOptions) (*webauthn.RegistrationResponse, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c := &credential{id: id, key: key, rpID: options.RP.ID, userHandle: options.User.ID}
+
+	clientDataJSON, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	aaguid := a.AAGUID
+	if a.Format == webauthn.FormatFIDOU2F {
+		aaguid = make([]byte, 16)
+	}
+	coseKey := EncodeCBOR(map[interface{}]interface{}{
+		int64(1):  int64(2),
+		int64(3):  webauthn.AlgorithmES256,
+		int64(-1): int64(1),
+		int64(-2): pad32(key.X),
+		int64(-3): pad32(key.Y),
+	})
+	attested := append(append([]byte(nil), aaguid...), byte(len(id)>>8), byte(len(id)))
+	attested = append(append(attested, id...), coseKey...)
+	authData := a.authenticatorData(c.rpID, webauthn.FlagAttestedData, 0, attested)
+
+	statement, err := a.statement(c, authData, clientDataHash[:], coseKey)
+	if err != nil {
+		return nil, err
+	}
+	a.credentials = append(a.credentials, c)
+
+	response := &webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(id),
+		RawID: id,
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAttestationResponse{
+			ClientDataJSON: clientDataJSON,
+			AttestationObject: EncodeCBOR(map[interface{}]interface{}{
+				"fmt":      a.Format,
+				"attStmt":  statement,
+				"authData": authData,
+			}),
+		},
+		ClientExtensionResults: webauthn.ClientExtensionResults{
+			CredProps: &webauthn.CredentialProperties{ResidentKey: a.ResidentKey},
+		},
+	}
+	return response, nil
+}
+
+// Get asserts a credential for options and returns the response of the browser. It uses the
+// first allowed credential, or without allowed credentials the first one of the relying party.
+func (a *Authenticator) Get(options *webauthn.RequestOptions) (*webauthn.AuthenticationResponse, error) {
+	c := a.find(options)
+	if c == nil {
+		return nil, errors.WithStack(ErrNoCredential)
+	}
+	clientDataJSON, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	if a.Counter {
+		c.signCount++
+	}
+	authData := a.authenticatorData(c.rpID, 0, c.signCount, nil)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signature, err := sign(c.key, append(append([]byte(nil), authData...), clientDataHash[:]...))
+	if err != nil {
+		return nil, err
+	}
+	return &webauthn.AuthenticationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(c.id),
+		RawID: c.id,
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAssertionResponse{
+			ClientDataJSON:    clientDataJSON,
+			AuthenticatorData: authData,
+			Signature:         signature,
+			UserHandle:        c.userHandle,
+		},
+	}, nil
+}
+
+func (a *Authenticator) find(options *webauthn.RequestOptions) *credential {
+	for _, c := range a.credentials {
+		if c.rpID != options.RPID {
+			continue
+		}
+		if len(options.AllowCredentials) == 0 {
+			return c
+		}
+		for _, allowed := range options.AllowCredentials {
+			if bytes.Equal(allowed.ID, c.id) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(ceremonyType string, challenge []byte) ([]byte, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":        ceremonyType,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data, errors.WithStack(err)
+}
+
+// authenticatorData returns authenticator data for rpID with the flags of the authenticator
+// and extra, signCount and attested credential data.
+func (a *Authenticator) authenticatorData(rpID string, flags byte, signCount uint32, attested []byte) []byte {
+	flags |= webauthn.FlagUserPresent
+	if a.UserVerified {
+		flags |= webauthn.FlagUserVerified
+	}
+	if a.BackupEligible {
+		flags |= webauthn.FlagBackupEligible
+	}
+	if a.BackedUp {
+		flags |= webauthn.FlagBackedUp
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], signCount)
+	return append(data, attested...)
+}
+
+// statement returns the attestation statement of the format of the authenticator.
+func (a *Authenticator) statement(c *credential, authData, clientDataHash, coseKey []byte) (map[interface{}]interface{}, error) {
+	switch a.Format {
+	case webauthn.FormatNone:
+		return map[interface{}]interface{}{}, nil
+	case webauthn.FormatPacked:
+		signed := append(append([]byte(nil), authData...), clientDataHash...)
+		if a.SelfAttestation {
+			signature, err := sign(c.key, signed)
+			if err != nil {
+				return nil, err
+			}
+			return map[interface{}]interface{}{"alg": webauthn.AlgorithmES256, "sig": signature}, nil
+		}
+		attestation, err := a.attestationKey()
+		if err != nil {
+			return nil, err
+		}
+		signature, err := sign(attestation.key, signed)
+		if err != nil {
+			return nil, err
+		}
+		return map[interface{}]interface{}{
+			"alg": webauthn.AlgorithmES256,
+			"sig": signature,
+			"x5c": []interface{}{attestation.certificate},
+		}, nil
+	case webauthn.FormatFIDOU2F:
+		attestation, err := a.attestationKey()
+		if err != nil {
+			return nil, err
+		}
+		rpIDHash := sha256.Sum256([]byte(c.rpID))
+		signed := append([]byte{0x00}, rpIDHash[:]...)
+		signed = append(append(signed, clientDataHash...), c.id...)
+		signed = append(signed, elliptic.Marshal(elliptic.P256(), c.key.X, c.key.Y)...)
+		signature, err := sign(attestation.key, signed)
+		if err != nil {
+			return nil, err
+		}
+		return map[interface{}]interface{}{"sig": signature, "x5c": []interface{}{attestation.certificate}}, nil
+	}
+	return nil, errors.Errorf("attestation format %q is not supported", a.Format)
+}
+
+// attestationKey returns the attestation key of the authenticator, generating it and its
+// self-signed certificate once.
+func (a *Authenticator) attestationKey() (*attestationKey, error) {
+	if a.attestation != nil {
+		return a.attestation, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aaguid, err := asn1.Marshal(a.AAGUID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"webauthntest"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "webauthntest attestation",
+		},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidAAGUID, Value: aaguid}},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	a.attestation = &attestationKey{key: key, certificate: certificate}
+	return a.attestation, nil
+}
+
+func sign(key *ecdsa.PrivateKey, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	return signature, errors.WithStack(err)
+}
+
+// pad32 returns a coordinate as the 32 bytes COSE keys hold.
+func pad32(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, 32))
+}
@@ -0,0 +1,90 @@
+package webauthntest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// EncodeCBOR encodes the subset of CBOR WebAuthn uses: int and int64, []byte, string, bool,
+// nil, []interface{} and map[interface{}]interface{} with int64 or string keys. Map keys are
+// sorted in the canonical order of CTAP2.
+func EncodeCBOR(value interface{}) []byte {
+	var buffer bytes.Buffer
+	encodeCBOR(&buffer, value)
+	return buffer.Bytes()
+}
+
+func encodeCBOR(buffer *bytes.Buffer, value interface{}) {
+	switch value := value.(type) {
+	case int:
+		encodeCBOR(buffer, int64(value))
+	case int64:
+		if value >= 0 {
+			writeCBORHead(buffer, 0, uint64(value))
+		} else {
+			writeCBORHead(buffer, 1, uint64(-1-value))
+		}
+	case []byte:
+		writeCBORHead(buffer, 2, uint64(len(value)))
+		buffer.Write(value)
+	case string:
+		writeCBORHead(buffer, 3, uint64(len(value)))
+		buffer.WriteString(value)
+	case []interface{}:
+		writeCBORHead(buffer, 4, uint64(len(value)))
+		for _, item := range value {
+			encodeCBOR(buffer, item)
+		}
+	case map[interface{}]interface{}:
+		type entry struct {
+			key   []byte
+			value interface{}
+		}
+		entries := make([]entry, 0, len(value))
+		for key, item := range value {
+			entries = append(entries, entry{key: EncodeCBOR(key), value: item})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			if len(entries[i].key) != len(entries[j].key) {
+				return len(entries[i].key) < len(entries[j].key)
+			}
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		writeCBORHead(buffer, 5, uint64(len(entries)))
+		for _, entry := range entries {
+			buffer.Write(entry.key)
+			encodeCBOR(buffer, entry.value)
+		}
+	case bool:
+		if value {
+			buffer.WriteByte(0xf5)
+		} else {
+			buffer.WriteByte(0xf4)
+		}
+	case nil:
+		buffer.WriteByte(0xf6)
+	default:
+		panic(fmt.Sprintf("webauthntest: cannot encode %T as CBOR", value))
+	}
+}
+
+func writeCBORHead(buffer *bytes.Buffer, major byte, argument uint64) {
+	head := major << 5
+	switch {
+	case argument < 24:
+		buffer.WriteByte(head | byte(argument))
+	case argument <= 0xff:
+		buffer.Write([]byte{head | 24, byte(argument)})
+	case argument <= 0xffff:
+		buffer.WriteByte(head | 25)
+		_ = binary.Write(buffer, binary.BigEndian, uint16(argument))
+	case argument <= 0xffffffff:
+		buffer.WriteByte(head | 26)
+		_ = binary.Write(buffer, binary.BigEndian, uint32(argument))
+	default:
+		buffer.WriteByte(head | 27)
+		_ = binary.Write(buffer, binary.BigEndian, argument)
+	}
+}